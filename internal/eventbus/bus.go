@@ -0,0 +1,193 @@
+package eventbus
+
+import (
+	"fmt"
+	"goker/internal/tablerules"
+	"time"
+
+	"fyne.io/fyne/v2/canvas"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Everything the front end, game manager, game state, and network tell each other for one game
+// Each game gets its own bus, so more than one can run in a process
+type Bus struct {
+	// From the front end (GUI or terminal)
+	Actions Topic[ActionType]
+
+	// To the front end
+	Addresses      Topic[[]string]        // Host addresses - Set during init
+	Hand           Topic[[]*canvas.Image] // Current hand images
+	Board          Topic[[]*canvas.Image] // Current board images
+	Pot            Topic[float64]
+	PlayerInfo     Topic[PlayerInfo]
+	StartRound     Topic[struct{}]         // Move to the table
+	EndRound       Topic[struct{}]         // Move back to the lobby
+	ShowLoading    Topic[struct{}]         // Show the loading screen
+	MoveToLobby    Topic[bool]             // Move to lobby, bool is if host or not
+	TableRules     Topic[tablerules.Rules] // Rules from the host waiting for approval
+	LobbyMessage   Topic[string]           // Notices for the lobby, e.g. someone rejecting the rules
+	NumOfPlayers   Topic[int]              // From the network, updated when players join or leave the lobby
+	Cheating       Topic[string]           // Accusations from the audit after a hand
+	RoundAborted   Topic[RoundAbort]       // A deal was called off and refunded, also tells the game manager to redeal
+	PuzzleProgress Topic[PuzzleProgress]   // How far along solving the puzzle of someone who left is
+	Connection     Topic[ConnectionStatus] // Someone at the table dropped out, came back, or ran out of time to
+
+	// To the network
+	NetActionDone Topic[struct{}]   // The network is done setting up
+	RulesAnswer   Topic[ActionType] // The players answer to the hosts table rules
+
+	// Between the game state and game manager
+	PhaseCheck      Topic[struct{}] // Used when switching turns, will make gm check if there is a phase shift needed
+	PhaseSwitchDone Topic[struct{}] // For the GM to tell the GS to continue with the "Next Turn" as the phase has been switched
+	RoundOver       Topic[struct{}] // For state telling the game manager that this round is over and to reset and move to next round
+	PuzzleBroken    Topic[struct{}] // A time locked puzzle was broken
+
+	// Between the network and game manager
+	CommandFailed Topic[error] // A command the network ran on its own failed, the game manager aborts the round
+	Resynced      Topic[bool]  // We caught up on the hand after dropping out, true if it had already finished without us
+}
+
+func New() *Bus {
+	return new(Bus)
+}
+
+// Actions made by the user on the GUI
+type ActionType struct {
+	Action string
+
+	// Possible data needed for an action
+	DataF float64
+	DataS []string
+	Rules *tablerules.Rules
+}
+
+// Player info for the GUI to use - sent from the game manager
+type PlayerInfo struct {
+	Players            []string
+	Money              []float64
+	Me                 string
+	HighestBet         float64 // Highest bet by the users so far
+	WhosTurn           string  // the nickname
+	Dealer             string  // the nickname of who has the dealer button
+	MyBetsForThisPhase float64 // What I have bet so far
+	MinRaise           float64 // Smallest amount I can put in when raising
+	MaxRaise           float64 // Largest amount I can put in when raising
+}
+
+// Why a deal was called off, sent to everyone so they all refund the same one
+type RoundAbort struct {
+	Round    int     `json:"round"`
+	Attempt  int     `json:"attempt"`            // How many deals of this round were already aborted, so the same deal isn't aborted twice
+	Offender peer.ID `json:"offender,omitempty"` // Who caused it, if anyone
+	Nickname string  `json:"nickname,omitempty"`
+	Reason   string  `json:"reason"`
+
+	// Set when the failure was ours to see, never sent - an offender named by someone else is only their word for it
+	Witnessed bool `json:"-"`
+	// Who called the deal off, from the signature on their abort - whoever receives it sets this
+	Sender peer.ID `json:"-"`
+}
+
+func (a RoundAbort) String() string {
+	if a.Nickname != "" {
+		return fmt.Sprintf("Round %d was called off because of %s: %s", a.Round, a.Nickname, a.Reason)
+	}
+	return fmt.Sprintf("Round %d was called off: %s", a.Round, a.Reason)
+}
+
+// Solving the time locked puzzle of a player who left mid hand, their cards can't be shown until it's done
+type PuzzleProgress struct {
+	Nickname string
+	Percent  float64
+	ETA      time.Duration // 0 until the solver has an idea how fast it's going
+	Done     bool
+}
+
+func (pp PuzzleProgress) String() string {
+	if pp.Done {
+		return fmt.Sprintf("Unlocked the keys %s left behind", pp.Nickname)
+	}
+	if pp.ETA == 0 {
+		return fmt.Sprintf("Unlocking the keys %s left behind: %.0f%%", pp.Nickname, pp.Percent)
+	}
+	return fmt.Sprintf("Unlocking the keys %s left behind: %.0f%%, about %s to go", pp.Nickname, pp.Percent, pp.ETA.Round(time.Second))
+}
+
+// A player at the table losing their connection mid-hand, they have the tables reconnect window to come back
+type ConnectionStatus struct {
+	Nickname string
+	Window   time.Duration // How long they have, while they're away
+	Back     bool
+	Gone     bool // The window ran out, they've left the table
+}
+
+func (cs ConnectionStatus) String() string {
+	switch {
+	case cs.Back:
+		return fmt.Sprintf("%s reconnected", cs.Nickname)
+	case cs.Gone:
+		return fmt.Sprintf("%s didn't reconnect in time and has left the table", cs.Nickname)
+	}
+	return fmt.Sprintf("%s lost their connection, waiting up to %s for them to come back", cs.Nickname, cs.Window)
+}
+
+// Every subscription a front end needs, made in one go so nothing is missed before it starts listening
+type FrontEnd struct {
+	Addresses      *Subscription[[]string]
+	Hand           *Subscription[[]*canvas.Image]
+	Board          *Subscription[[]*canvas.Image]
+	Pot            *Subscription[float64]
+	PlayerInfo     *Subscription[PlayerInfo]
+	StartRound     *Subscription[struct{}]
+	EndRound       *Subscription[struct{}]
+	ShowLoading    *Subscription[struct{}]
+	MoveToLobby    *Subscription[bool]
+	TableRules     *Subscription[tablerules.Rules]
+	LobbyMessage   *Subscription[string]
+	NumOfPlayers   *Subscription[int]
+	Cheating       *Subscription[string]
+	RoundAborted   *Subscription[RoundAbort]
+	PuzzleProgress *Subscription[PuzzleProgress]
+	Connection     *Subscription[ConnectionStatus]
+}
+
+func (b *Bus) SubscribeFrontEnd() *FrontEnd {
+	return &FrontEnd{
+		Addresses:      b.Addresses.Subscribe(),
+		Hand:           b.Hand.Subscribe(),
+		Board:          b.Board.Subscribe(),
+		Pot:            b.Pot.Subscribe(),
+		PlayerInfo:     b.PlayerInfo.Subscribe(),
+		StartRound:     b.StartRound.Subscribe(),
+		EndRound:       b.EndRound.Subscribe(),
+		ShowLoading:    b.ShowLoading.Subscribe(),
+		MoveToLobby:    b.MoveToLobby.Subscribe(),
+		TableRules:     b.TableRules.Subscribe(),
+		LobbyMessage:   b.LobbyMessage.Subscribe(),
+		NumOfPlayers:   b.NumOfPlayers.Subscribe(),
+		Cheating:       b.Cheating.Subscribe(),
+		RoundAborted:   b.RoundAborted.Subscribe(),
+		PuzzleProgress: b.PuzzleProgress.Subscribe(),
+		Connection:     b.Connection.Subscribe(),
+	}
+}
+
+func (f *FrontEnd) Close() {
+	f.Addresses.Close()
+	f.Hand.Close()
+	f.Board.Close()
+	f.Pot.Close()
+	f.PlayerInfo.Close()
+	f.StartRound.Close()
+	f.EndRound.Close()
+	f.ShowLoading.Close()
+	f.MoveToLobby.Close()
+	f.TableRules.Close()
+	f.LobbyMessage.Close()
+	f.NumOfPlayers.Close()
+	f.Cheating.Close()
+	f.RoundAborted.Close()
+	f.PuzzleProgress.Close()
+	f.Connection.Close()
+}
@@ -0,0 +1,113 @@
+package eventbus
+
+import "sync"
+
+// A topic delivers every event published to it to all of its subscribers
+// The zero value is ready to use
+type Topic[T any] struct {
+	mu          sync.Mutex
+	subscribers []*Subscription[T]
+}
+
+// Subscribers only get events published after they subscribe
+func (t *Topic[T]) Subscribe() *Subscription[T] {
+	s := &Subscription[T]{
+		topic:  t,
+		notify: make(chan struct{}, 1),
+		out:    make(chan T),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+
+	t.mu.Lock()
+	t.subscribers = append(t.subscribers, s)
+	t.mu.Unlock()
+	return s
+}
+
+// Never blocks, each subscriber queues events until it's ready for them
+func (t *Topic[T]) Publish(event T) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, s := range t.subscribers {
+		s.push(event)
+	}
+}
+
+func (t *Topic[T]) unsubscribe(s *Subscription[T]) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, subscriber := range t.subscribers {
+		if subscriber == s {
+			t.subscribers = append(t.subscribers[:i], t.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Receives events from a topic in the order they were published
+type Subscription[T any] struct {
+	topic *Topic[T]
+
+	mu     sync.Mutex
+	queue  []T           // Unbounded, so publishers never wait on slow subscribers
+	notify chan struct{} // Wakes up the delivery loop when the queue was empty
+	out    chan T
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Channel the events arrive on, it's closed once the subscription is
+func (s *Subscription[T]) C() <-chan T {
+	return s.out
+}
+
+// Stops delivery, anything still queued is dropped
+func (s *Subscription[T]) Close() {
+	s.once.Do(func() {
+		s.topic.unsubscribe(s)
+		close(s.done)
+	})
+}
+
+func (s *Subscription[T]) push(event T) {
+	s.mu.Lock()
+	s.queue = append(s.queue, event)
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default: // Already woken up
+	}
+}
+
+// Hands queued events to the receiver one at a time
+func (s *Subscription[T]) run() {
+	defer close(s.out)
+
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			select {
+			case <-s.notify:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		event := s.queue[0]
+		var zero T
+		s.queue[0] = zero // Let the event be garbage collected once delivered
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		select {
+		case s.out <- event:
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package eventbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func receive[T any](t *testing.T, s *Subscription[T]) T {
+	t.Helper()
+	select {
+	case event := <-s.C():
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	var zero T
+	return zero
+}
+
+func TestPublishReachesEverySubscriber(t *testing.T) {
+	var topic Topic[int]
+	first := topic.Subscribe()
+	second := topic.Subscribe()
+	defer first.Close()
+	defer second.Close()
+
+	topic.Publish(7)
+
+	require.Equal(t, 7, receive(t, first))
+	require.Equal(t, 7, receive(t, second))
+}
+
+func TestPublishDoesNotBlock(t *testing.T) {
+	var topic Topic[int]
+	topic.Publish(1) // Nobody is listening yet, this is dropped
+
+	slow := topic.Subscribe()
+	defer slow.Close()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			topic.Publish(i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publishing blocked on a subscriber that isn't reading")
+	}
+
+	for i := 0; i < 1000; i++ {
+		require.Equal(t, i, receive(t, slow), "events should arrive in the order they were published")
+	}
+}
+
+func TestClose(t *testing.T) {
+	var topic Topic[string]
+	s := topic.Subscribe()
+	s.Close()
+	s.Close() // Closing twice is fine
+
+	topic.Publish("ignored")
+
+	_, open := <-s.C()
+	require.False(t, open)
+	require.Empty(t, topic.subscribers)
+}
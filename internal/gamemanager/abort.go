@@ -0,0 +1,88 @@
+package gamemanager
+
+import (
+	"goker/internal/eventbus"
+	"goker/internal/p2p"
+	"log"
+)
+
+// How many times a round can be dealt before giving up on it and going back to the lobby
+const maxDealsPerRound = 3
+
+// Calls off the current deal after a command failed, everyone refunds it and the host deals again
+// Nothing is called off once the showdown has started, see forfeitShowdown for what happens then
+func (gm *GameManager) AbortRound(err error) {
+	if gm.state.ShowdownStarted() {
+		log.Printf("Not aborting round %d, the showdown has started: %v", gm.state.Round, err)
+		return
+	}
+	log.Printf("Aborting round %d: %v", gm.state.Round, err)
+
+	abort := eventbus.RoundAbort{Round: gm.state.Round, Attempt: gm.state.Aborts, Reason: err.Error(), Sender: gm.state.Me}
+	if offender, ok := p2p.Offender(err); ok {
+		abort.Offender = offender
+		abort.Nickname = gm.state.GetNickname(offender)
+		abort.Witnessed = true
+	}
+
+	if err := gm.network.ExecuteCommand(&p2p.AbortRoundCommand{Abort: abort}); err != nil {
+		log.Printf("AbortRound: not everyone was told: %v", err) // They'll find out when the next deal reaches them
+	}
+	gm.bus.RoundAborted.Publish(abort) // Handled the same as one from anyone else
+}
+
+// Aborts the round when a command the network ran on its own failed, and handles every abort, ours or someone elses
+func (gm *GameManager) abortListener(failures *eventbus.Subscription[error], aborts *eventbus.Subscription[eventbus.RoundAbort]) {
+	for {
+		select {
+		case err := <-failures.C():
+			gm.AbortRound(err)
+		case abort := <-aborts.C():
+			gm.handleAbort(abort)
+		}
+	}
+}
+
+// Refunds the aborted deal, flags whoever caused it, and deals again
+// Whoever it names is only flagged if we saw it ourselves, otherwise whoever sent it is - an abort costs the whole table
+// a deal, so calling one off for something nobody else can check is held against the one who did.
+func (gm *GameManager) handleAbort(abort eventbus.RoundAbort) {
+	if abort.Round != gm.state.Round || abort.Attempt != gm.state.Aborts {
+		log.Printf("Ignoring abort for round %d deal %d, we're past it", abort.Round, abort.Attempt)
+		return
+	}
+	if gm.state.ShowdownStarted() {
+		log.Printf("Refusing abort from %s, the showdown of round %d has started", gm.state.GetNickname(abort.Sender), abort.Round)
+		return
+	}
+
+	gm.stopTurnTimerIfRunning()
+	gm.state.RefundRound()
+	gm.state.Aborts++
+	blamed := abort.Sender
+	if abort.Witnessed {
+		blamed = abort.Offender
+	}
+	flags := gm.state.FlagPlayer(blamed)
+	log.Printf("%s has been blamed for %d aborted deals", gm.state.GetNickname(blamed), flags)
+
+	if gm.state.Aborts >= maxDealsPerRound {
+		log.Printf("Round %d couldn't be dealt after %d tries, going back to the lobby", gm.state.Round, gm.state.Aborts)
+		gm.bus.PlayerInfo.Publish(gm.state.GetPlayerInfo())
+		gm.bus.EndRound.Publish(struct{}{})
+		return
+	}
+
+	gm.dealHand()
+}
+
+// Deals with a showdown that went wrong, it can't be dealt again since anyone might have seen the hands by now
+// Whoever didn't show a hand that checks out forfeits it and is flagged, the rest of the hands are evaluated as usual.
+func (gm *GameManager) forfeitShowdown(err error) {
+	log.Printf("Showdown of round %d: %v", gm.state.Round, err)
+	for _, offender := range p2p.Offenders(err) {
+		gm.state.PlayerFold(offender)
+		flags := gm.state.FlagPlayer(offender)
+		log.Printf("%s forfeits their hand, they've been blamed %d times", gm.state.GetNickname(offender), flags)
+	}
+}
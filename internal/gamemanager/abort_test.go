@@ -0,0 +1,146 @@
+package gamemanager
+
+import (
+	"errors"
+	"goker/internal/eventbus"
+	"goker/internal/gamestate"
+	"goker/internal/p2p"
+	"goker/internal/tablerules"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// A game manager sitting at a table the host runs, so redealing only clears the board and waits for the hosts deal
+func newTestManager(t *testing.T, others ...peer.ID) *GameManager {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir()) // Keeps the squaring speed the network measures out of the real cache
+
+	bus := eventbus.New()
+	state := gamestate.New(bus)
+	network := new(p2p.GokerPeer)
+	network.Init("me", false, "", state, bus)
+	t.Cleanup(func() { network.ThisHost.Close() })
+
+	for _, id := range others {
+		state.AddPeerToState(id, string(id))
+	}
+	state.SetTurnOrder(append(others, state.Me))
+	state.FreshState(tablerules.Default())
+	return &GameManager{state: state, network: network, bus: bus}
+}
+
+// Everyones money is back to what they started the round with, less the blinds of the deal after it
+func requireRefunded(t *testing.T, gm *GameManager) {
+	t.Helper()
+	for id, money := range gm.state.PlayersMoney {
+		require.Equal(t, gm.state.Rules.StartingCash, money+gm.state.BetHistory[id], "%s wasn't refunded", gm.state.GetNickname(id))
+	}
+}
+
+func TestAbortRound(t *testing.T) {
+	host, cheater := peer.ID("host"), peer.ID("cheater")
+	abortFrom := func(gm *GameManager, sender peer.ID) eventbus.RoundAbort {
+		return eventbus.RoundAbort{Round: gm.state.Round, Attempt: gm.state.Aborts, Reason: "testing", Sender: sender}
+	}
+
+	t.Run("refunds and deals again", func(t *testing.T) {
+		gm := newTestManager(t, host, cheater)
+		gm.state.PlayerBet(cheater, 200)
+		gm.state.PlayerFold(host)
+		gm.state.SetPhase("turn")
+
+		abort := abortFrom(gm, gm.state.Me)
+		abort.Offender, abort.Witnessed = cheater, true
+		gm.handleAbort(abort)
+
+		requireRefunded(t, gm)
+		require.Equal(t, 1, gm.state.Aborts)
+		require.Equal(t, "preflop", gm.state.GetPhase())
+		require.False(t, gm.state.FoldedPlayers[host], "the new deal starts with everyone in it")
+		require.Equal(t, map[peer.ID]int{cheater: 1}, gm.state.Flagged, "we saw them cause it")
+
+		gm.handleAbort(abort)
+		require.Equal(t, 1, gm.state.Aborts, "that deal was already called off")
+	})
+
+	t.Run("unwitnessed aborts are held against whoever sent them", func(t *testing.T) {
+		gm := newTestManager(t, host, cheater)
+		abort := abortFrom(gm, cheater)
+		abort.Offender = host // Only their word for it
+		gm.handleAbort(abort)
+
+		requireRefunded(t, gm)
+		require.Equal(t, map[peer.ID]int{cheater: 1}, gm.state.Flagged)
+
+		gm.AbortRound(errors.New("something only we saw")) // Everyone else holds our own against us too
+		require.Equal(t, 1, gm.state.Aborts, "our own abort is handled when it comes back around")
+		gm.handleAbort(abortFrom(gm, gm.state.Me))
+		require.Equal(t, map[peer.ID]int{cheater: 1, gm.state.Me: 1}, gm.state.Flagged)
+	})
+
+	t.Run("back to the lobby after too many", func(t *testing.T) {
+		gm := newTestManager(t, host, cheater)
+		backToLobby := gm.bus.EndRound.Subscribe()
+		defer backToLobby.Close()
+
+		for range maxDealsPerRound {
+			gm.handleAbort(abortFrom(gm, cheater))
+		}
+		select {
+		case <-backToLobby.C():
+		case <-time.After(time.Second):
+			t.Fatal("still dealing after too many aborts")
+		}
+		require.Equal(t, maxDealsPerRound, gm.state.Flagged[cheater])
+	})
+
+	t.Run("none once the showdown has started", func(t *testing.T) {
+		gm := newTestManager(t, host, cheater)
+		gm.state.PlayerBet(cheater, 200)
+		bet := gm.state.BetHistory[cheater]
+		gm.state.StartShowdown()
+
+		gm.handleAbort(abortFrom(gm, cheater))
+		gm.AbortRound(errors.New("losing"))
+		require.Zero(t, gm.state.Aborts)
+		require.Empty(t, gm.state.Flagged)
+		require.Equal(t, bet, gm.state.BetHistory[cheater], "their bet stays in the pot")
+
+		// Anyone whose hand doesn't check out forfeits it instead
+		bad := &p2p.PeerError{Command: "RequestOthersHand", Peer: cheater, Err: p2p.ErrBadProof}
+		gm.forfeitShowdown(errors.Join(bad, errors.New("something on our side")))
+		require.True(t, gm.state.FoldedPlayers[cheater])
+		require.Equal(t, map[peer.ID]int{cheater: 1}, gm.state.Flagged)
+
+		gm.state.Round++ // The next round can be called off again
+		require.False(t, gm.state.ShowdownStarted())
+	})
+}
+
+// Someone at the table calls off the deal, everyone refunds it, holds it against them, and plays the one the host deals next
+func TestAbortAtTable(t *testing.T) {
+	if testing.Short() {
+		t.Skip("deals hands with real keys, skipping in short mode")
+	}
+
+	const numOfPlayers = 3
+	rules := tablerules.Default()
+	rules.TurnTimer = 300 // Nobody is auto folded while the test waits on the others
+	tt := newTestTable(t, numOfPlayers, rules)
+	tt.callOrCheck() // Some money in besides the blinds
+
+	aborter := tt.players[1]
+	aborter.bus.CommandFailed.Publish(errors.New("something only they saw"))
+	tt.waitForDeal(1)
+
+	for _, gm := range tt.players {
+		requireRefunded(t, gm)
+		require.Equal(t, 1, gm.state.Snapshot().Aborts)
+		require.Equal(t, map[peer.ID]int{aborter.state.Me: 1}, gm.state.Flagged, "nobody else saw it, so it's on them")
+	}
+
+	tt.playHand()
+}
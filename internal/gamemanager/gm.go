@@ -2,78 +2,126 @@ package gamemanager
 
 import (
 	"fmt"
-	"goker/internal/channelmanager"
+	"goker/internal/eventbus"
 	"goker/internal/gamestate"
-	"goker/internal/gui"
+	"goker/internal/identity"
 	"goker/internal/p2p"
+	"goker/internal/sra"
+	"goker/internal/tablerules"
 	"log"
+	"strings"
 	"time"
 
 	"fyne.io/fyne/v2/canvas"
-	"github.com/libp2p/go-libp2p/core/peer"
 )
 
 type GameManager struct {
 	state   *gamestate.GameState // State built by the host and network
 	network *p2p.GokerPeer
 
+	identity    *identity.Identity // Long-term keys, nil plays with a throwaway identity
+	listenAddrs []string           // Where the network listens, its defaults when empty - tests keep to loopback
+
 	MyNickname string
 	MyHand     []*canvas.Image // Cards for the current player (images for the GUI to render)
 	Board      []*canvas.Image // Community cards on the board (images for the gui to render)
 
 	stopTurnTimer chan struct{}
+
+	// Everything the front end, state, and network tell each other for this game
+	bus *eventbus.Bus
+}
+
+// Game manager for a single game on the given bus, playing as the given identity (nil for a throwaway one)
+func New(bus *eventbus.Bus, id *identity.Identity) *GameManager {
+	return &GameManager{bus: bus, identity: id}
 }
 
-func (gm *GameManager) StartGame() {
-	// Init channels
-	channelmanager.Init()
+// Starts the game with the given front end (GUI or terminal), which runs until the player quits
+func (gm *GameManager) StartGame(frontEnd func(*eventbus.Bus)) {
+	// Subscribe before anything runs so no events are missed
+	actions := gm.bus.Actions.Subscribe()
+	phaseChecks := gm.bus.PhaseCheck.Subscribe()
+	roundsOver := gm.bus.RoundOver.Subscribe()
+	failures := gm.bus.CommandFailed.Subscribe()
+	aborts := gm.bus.RoundAborted.Subscribe()
+	resyncs := gm.bus.Resynced.Subscribe()
 
-	go gm.listenForActions()
+	go gm.listenForActions(actions)
 
-	go gm.phaseListener()
+	go gm.phaseListener(phaseChecks)
 
-	go gm.roundChanger()
+	go gm.roundChanger(roundsOver)
 
-	gui.Init()
+	go gm.abortListener(failures, aborts)
+
+	go gm.resyncListener(resyncs)
+
+	frontEnd(gm.bus)
 }
 
 // Listen for actions from the GUI (like button presses)
-func (gm *GameManager) listenForActions() {
+func (gm *GameManager) listenForActions(actions *eventbus.Subscription[eventbus.ActionType]) {
 	for {
 		select {
-		case givenAction := <-channelmanager.FGUI_ActionChan:
+		case givenAction := <-actions.C():
 			switch givenAction.Action {
 			case "Init": // Initialise everything
 				gm.initBoard()
 			case "hostOrConnectPressed": // Weather you are hosting or connecting this is called
 				// Setup network node
-				gm.network = new(p2p.GokerPeer)
+				gm.network = &p2p.GokerPeer{Identity: gm.identity, ListenAddrs: gm.listenAddrs}
 
 				// Setup gamestate
-				gm.state = new(gamestate.GameState)
-				gm.state.Players = make(map[peer.ID]string)
-				gm.state.PlayersMoney = make(map[peer.ID]float64)
-				gm.state.BetHistory = make(map[peer.ID]float64)
-				gm.state.PhaseBets = make(map[peer.ID]float64)
-				gm.state.TurnOrder = make(map[int]peer.ID)
-				gm.state.FoldedPlayers = make(map[peer.ID]bool)
-				gm.state.PlayedThisPhase = make(map[peer.ID]bool)
+				gm.state = gamestate.New(gm.bus)
 
+				netReady := gm.bus.NetActionDone.Subscribe()
 				if len(givenAction.DataS) == 1 {
-					go gm.network.Init(givenAction.DataS[0], true, "", gm.state) // Hosting
+					go gm.network.Init(givenAction.DataS[0], true, "", gm.state, gm.bus) // Hosting
 				} else {
-					go gm.network.Init(givenAction.DataS[0], false, givenAction.DataS[1], gm.state) // Connecting
+					go gm.network.Init(givenAction.DataS[0], false, givenAction.DataS[1], gm.state, gm.bus) // Connecting
+				}
+				<-netReady.C() // Wait for network to be done setting up
+				netReady.Close()
+
+				// Make primes for time locked puzzles while everyone waits in the lobby, so hands don't stall on them
+				sra.PregeneratePrimes(sra.TimeLockPrimeBits)
+
+				gm.bus.Addresses.Publish([]string{gm.network.ThisHostLBAddress, gm.network.ThisHostLNAddress}) // Tell the GUI the addresses we need
+				gm.bus.MoveToLobby.Publish(len(givenAction.DataS) == 1)
+			case "startRound": // Host pressed play with the table rules from the lobby
+				rules := tablerules.Default()
+				if givenAction.Rules != nil {
+					rules = *givenAction.Rules
+				}
+				if err := rules.Validate(); err != nil {
+					gm.bus.LobbyMessage.Publish(err.Error())
+					continue
+				}
+				if gm.state.GetNumberOfPlayers() > rules.MaxPlayers {
+					gm.bus.LobbyMessage.Publish(fmt.Sprintf("Too many players for this table, the max is %d", rules.MaxPlayers))
+					continue
+				}
+
+				gm.network.SetTurnOrderWithLobby() // Sets the turn order
+				gm.state.FreshState(rules)         // Initialize table settings after the lobby is populated
+
+				initTable := &p2p.InitTableCommand{}
+				if err := gm.network.ExecuteCommand(initTable); err != nil { // Tells others table rules and solidify the state
+					gm.bus.LobbyMessage.Publish(fmt.Sprintf("Couldn't start the table: %v", err))
+					continue
+				}
+				if len(initTable.Rejections) > 0 { // Everyone has to agree on the rules before we can play
+					var rejections []string
+					for id, reason := range initTable.Rejections {
+						rejections = append(rejections, fmt.Sprintf("%s rejected the rules: %s", gm.state.GetNickname(id), reason))
+					}
+					gm.bus.LobbyMessage.Publish(strings.Join(rejections, "\n"))
+					continue
 				}
-				<-channelmanager.FNET_NetActionDoneChan                                                                 // Wait for network to be done setting up
-				channelmanager.TGUI_AddressChan <- []string{gm.network.ThisHostLBAddress, gm.network.ThisHostLNAddress} // Tell the GUI the addresses we need
-				channelmanager.TGUI_MoveToLobby <- len(givenAction.DataS) == 1
-			case "startRound": // TODO: This action should gather table rules for the state
-				gm.network.SetTurnOrderWithLobby()                 // Sets the turn order
-				gm.state.FreshState(nil, nil)                      // Initialize table settings after the lobby is populated
-				gm.network.ExecuteCommand(&p2p.InitTableCommand{}) // Tells others table rules and solidify the state
 
 				// Fill cards in GUI
-				channelmanager.TGUI_PlayerInfo <- gm.state.GetPlayerInfo()
+				gm.bus.PlayerInfo.Publish(gm.state.GetPlayerInfo())
 
 				gm.RunProtocol()
 
@@ -82,26 +130,35 @@ func (gm *GameManager) listenForActions() {
 					gm.startTurnTimer()
 				}
 			case "Raise":
-				if !gm.state.IsMyTurn() {
-					fmt.Println("Not your turn yet!")
+				if !gm.canAct() {
 					continue // NO BREAKING
 				}
+
+				minRaise, maxRaise := gm.state.GetRaiseLimits(gm.state.Me)
+				if givenAction.DataF < minRaise || givenAction.DataF > maxRaise {
+					fmt.Printf("Raise must be between $%.0f and $%.0f!\n", minRaise, maxRaise)
+					continue
+				}
 				gm.stopTurnTimerIfRunning() // Stop the auto-fold timer
 
 				// Handle raise action
 				fmt.Println("Handling Raise action")
 				// Update state
-				gm.state.PlayerRaise(gm.state.Me, givenAction.DataF)
+				raised := gm.state.PlayerRaise(gm.state.Me, givenAction.DataF)
 				// Send to others
-				gm.network.ExecuteCommand(&p2p.RaiseCommand{})
+				if err := gm.network.ExecuteCommand(&p2p.RaiseCommand{Amount: raised}); err != nil {
+					gm.AbortRound(err)
+					continue
+				}
 
 				gm.state.NextTurn()
 				if gm.state.IsMyTurn() {
 					gm.startTurnTimer()
 				}
+			case "approveRules", "rejectRules": // Answer to the hosts table rules, the network is waiting on it
+				gm.bus.RulesAnswer.Publish(givenAction)
 			case "Call":
-				if !gm.state.IsMyTurn() {
-					fmt.Println("Not your turn yet!")
+				if !gm.canAct() {
 					continue
 				}
 				gm.stopTurnTimerIfRunning()
@@ -111,15 +168,22 @@ func (gm *GameManager) listenForActions() {
 				// Update state
 				gm.state.PlayerCall(gm.state.Me)
 				// Others
-				gm.network.ExecuteCommand(&p2p.CallCommand{})
+				if err := gm.network.ExecuteCommand(&p2p.CallCommand{}); err != nil {
+					gm.AbortRound(err)
+					continue
+				}
 
 				gm.state.NextTurn()
 				if gm.state.IsMyTurn() {
 					gm.startTurnTimer()
 				}
 			case "Check":
-				if !gm.state.IsMyTurn() {
-					fmt.Println("Not your turn yet!")
+				if !gm.canAct() {
+					continue
+				}
+
+				if err := gm.state.CheckMove(gm.state.Me, "Check", 0); err != nil { // Others would refuse it too
+					fmt.Println("Cannot check, there's a bet to call!")
 					continue
 				}
 				gm.stopTurnTimerIfRunning()
@@ -128,23 +192,28 @@ func (gm *GameManager) listenForActions() {
 				fmt.Println("Handling Check action")
 				gm.state.PlayerCheck(gm.state.Me)
 				// Others
-				gm.network.ExecuteCommand(&p2p.CheckCommand{})
+				if err := gm.network.ExecuteCommand(&p2p.CheckCommand{}); err != nil {
+					gm.AbortRound(err)
+					continue
+				}
 
 				gm.state.NextTurn() // Skip your turn for now
 				if gm.state.IsMyTurn() {
 					gm.startTurnTimer()
 				}
 			case "Fold":
-				if !gm.state.IsMyTurn() {
-					fmt.Println("Not your turn yet!")
+				if !gm.canAct() {
 					continue
 				}
 				gm.stopTurnTimerIfRunning()
 
 				// Handle fold action
 				fmt.Println("Handling Fold action")
-				gm.state.PlayerFold(gm.state.Me)              // I fold
-				gm.network.ExecuteCommand(&p2p.FoldCommand{}) // Tell others I have folded
+				gm.state.PlayerFold(gm.state.Me)                                      // I fold
+				if err := gm.network.ExecuteCommand(&p2p.FoldCommand{}); err != nil { // Tell others I have folded
+					gm.AbortRound(err)
+					continue
+				}
 
 				gm.state.NextTurn() // Move to next person
 				if gm.state.IsMyTurn() {
@@ -155,74 +224,94 @@ func (gm *GameManager) listenForActions() {
 	}
 }
 
-func (gm *GameManager) phaseListener() {
-	for {
-		<-channelmanager.TGM_PhaseCheck
-
-		gm.state.MyBet = 0
-
-		for id := range gm.state.PlayedThisPhase {
-			if !gm.state.FoldedPlayers[id] { // Skip any folded players
-				gm.state.PlayedThisPhase[id] = false
-			}
-		}
+// If it's our turn and the table can hear us, prints why not otherwise
+func (gm *GameManager) canAct() bool {
+	if !gm.state.IsMyTurn() {
+		fmt.Println("Not your turn yet!")
+		return false
+	}
+	if gm.network.Reconnecting() {
+		fmt.Println("Lost connection to the table, waiting to reconnect...")
+		return false
+	}
+	return true
+}
 
-		for id := range gm.state.PhaseBets {
-			gm.state.PhaseBets[id] = 0.0
-		}
+func (gm *GameManager) phaseListener(phaseChecks *eventbus.Subscription[struct{}]) {
+	for range phaseChecks.C() {
+		turnOrder := gm.state.GetTurnOrder()
+		isHost := len(turnOrder) > 0 && turnOrder[0] == gm.state.Me // The host updates the tags
 
-		switch gm.state.Phase {
+		var err error
+		switch gm.state.NextPhase() {
 		case "preflop":
-			gm.state.Phase = "flop"
-			gm.network.ExecuteCommand(&p2p.RequestFlop{}) // Reqeust flop from everyone
-			if gm.state.TurnOrder[0] == gm.state.Me {     // If I am the host, update the tags
-				gm.network.ExecuteCommand(&p2p.PushTagCommand{}) // Update tag for next phase
+			err = gm.network.ExecuteCommand(&p2p.RequestFlop{}) // Reqeust flop from everyone
+			if err == nil && isHost {
+				err = gm.network.ExecuteCommand(&p2p.PushTagCommand{}) // Update tag for next phase
 			}
-			fmt.Println("PHASE HAS CHANGED TO: " + gm.state.Phase)
+			fmt.Println("PHASE HAS CHANGED TO: " + gm.state.GetPhase())
 		case "flop":
-			gm.state.Phase = "turn"
-			gm.network.ExecuteCommand(&p2p.RequestTurn{})
-			if gm.state.TurnOrder[0] == gm.state.Me { // If I am the host, update the tags
-				gm.network.ExecuteCommand(&p2p.PushTagCommand{}) // Update tag for next phase
+			err = gm.network.ExecuteCommand(&p2p.RequestTurn{})
+			if err == nil && isHost {
+				err = gm.network.ExecuteCommand(&p2p.PushTagCommand{}) // Update tag for next phase
 			}
-			fmt.Println("PHASE HAS CHANGED TO: " + gm.state.Phase)
+			fmt.Println("PHASE HAS CHANGED TO: " + gm.state.GetPhase())
 		case "turn":
-			gm.state.Phase = "river"
-			gm.network.ExecuteCommand(&p2p.RequestRiver{})
-			if gm.state.TurnOrder[0] == gm.state.Me { // If I am the host, update the tags
-				gm.network.ExecuteCommand(&p2p.PushTagCommand{}) // Update tag for next phase
+			err = gm.network.ExecuteCommand(&p2p.RequestRiver{})
+			if err == nil && isHost {
+				err = gm.network.ExecuteCommand(&p2p.PushTagCommand{}) // Update tag for next phase
 			}
-			fmt.Println("PHASE HAS CHANGED TO: " + gm.state.Phase)
+			fmt.Println("PHASE HAS CHANGED TO: " + gm.state.GetPhase())
 		case "river":
 			log.Println("Round over! Determining winner and starting new round!")
-			gm.network.ExecuteCommand(&p2p.RequestOthersHands{})
+			if err := gm.network.ExecuteCommand(&p2p.RequestOthersHands{}); err != nil {
+				gm.forfeitShowdown(err) // Too late to deal again
+			}
 			gm.state.EndRound()
 		}
+		if err != nil { // The deal is redone, but the state is still waiting on this phase switch
+			gm.AbortRound(err)
+		}
 
-		channelmanager.TGS_PhaseSwitchDone <- struct{}{} // Continue with the next turn function in GS
+		gm.bus.PhaseSwitchDone.Publish(struct{}{}) // Continue with the next turn function in GS
 	}
 }
 
-func (gm *GameManager) roundChanger() {
-	for {
-		<-channelmanager.TGM_EndRound
+func (gm *GameManager) roundChanger(roundsOver *eventbus.Subscription[struct{}]) {
+	for range roundsOver.C() {
 		gm.stopTurnTimerIfRunning()
 		gm.EvaluateHands()
 	}
 }
 
+// Picks the hand back up once the network has caught up on what we missed while we were cut off
+func (gm *GameManager) resyncListener(resyncs *eventbus.Subscription[bool]) {
+	for newHand := range resyncs.C() {
+		gm.stopTurnTimerIfRunning()
+		if newHand { // The hand we dropped out of finished without us
+			gm.initBoard()
+		}
+		if gm.state.IsMyTurn() {
+			gm.startTurnTimer()
+		}
+	}
+}
+
 func (gm *GameManager) startTurnTimer() {
 	// Create a channel to stop the timer if the player makes a move
 	stopTimer := make(chan struct{})
 
 	go func() {
 		select {
-		case <-time.After(15 * time.Second):
-			if gm.state.IsMyTurn() {
+		case <-time.After(time.Duration(gm.state.Rules.TurnTimer) * time.Second):
+			if gm.state.IsMyTurn() && !gm.network.Reconnecting() { // Nobody to tell while we're cut off, the resync restarts the timer
 				fmt.Println("Time's up! Auto-folding...")
-				gm.state.PlayerFold(gm.state.Me)              // Fold the player
-				gm.network.ExecuteCommand(&p2p.FoldCommand{}) // Notify others
-				gm.state.NextTurn()                           // Move to the next player's turn
+				gm.state.PlayerFold(gm.state.Me)                                      // Fold the player
+				if err := gm.network.ExecuteCommand(&p2p.FoldCommand{}); err != nil { // Notify others
+					gm.AbortRound(err)
+					return
+				}
+				gm.state.NextTurn() // Move to the next player's turn
 			}
 		case <-stopTimer:
 			// The player made a move in time, so we stop the timer
@@ -2,7 +2,6 @@ package gamemanager
 
 import (
 	"fmt"
-	"goker/internal/channelmanager"
 	"goker/internal/p2p"
 	"log"
 	"strings"
@@ -22,33 +21,26 @@ func (gm *GameManager) initBoard() {
 		gm.Board = append(gm.Board, cardImage)
 	}
 
-	channelmanager.TGUI_BoardChan <- gm.Board
+	gm.bus.Board.Publish(gm.Board)
 }
 
 func (gm *GameManager) EvaluateHands() {
-	// If only one non-folded player remains, they win immediately
-	activePlayers := 0
-	var lastActivePlayer peer.ID
-
-	for id, folded := range gm.state.FoldedPlayers {
-		if !folded {
-			activePlayers++
-			lastActivePlayer = id
-		}
-	}
-
-	if activePlayers == 1 {
-		fmt.Printf("Only one player (%s) remains. They win the pot!\n", gm.state.Players[lastActivePlayer])
-		gm.state.Winner = lastActivePlayer
+	// If only one player remains in the hand, they win immediately
+	inHand := gm.state.GetPlayersInHand()
+	if len(inHand) == 1 {
+		fmt.Printf("Only one player (%s) remains. They win the pot!\n", gm.state.Players[inHand[0]])
+		gm.state.HandRanks = map[peer.ID]int32{inHand[0]: 0}
 		gm.RestartRound()
 		return
 	}
 
 	if gm.state.SomeoneLeft {
 		log.Println("Waiting for all necessary puzzles to be broken before evaluation...")
-		for gm.state.NumOfPuzzlesBroken < len(gm.state.Players) {
-			<-channelmanager.TGM_WaitForPuzzles
+		brokenPuzzles := gm.bus.PuzzleBroken.Subscribe()
+		for gm.network.PuzzlesPending() > 0 {
+			<-brokenPuzzles.C()
 		}
+		brokenPuzzles.Close()
 		log.Println("All puzzles decrypted, decrypting deck.")
 		for _, payload := range gm.network.Keyring.BrokenPuzzlePayloads {
 			gm.network.DecryptRoundDeckWithPayload(payload)
@@ -81,50 +73,43 @@ func (gm *GameManager) EvaluateHands() {
 		fmt.Println("river card didn't exist.")
 	}
 
-	var bestID peer.ID
-	var bestRank int32
-	bestRank = 10000 // Since the lower the rank the better the hand
+	ranks := make(map[peer.ID]int32) // The lower the rank the better the hand
 
-	IDs := gm.state.GetTurnOrder()
+	IDs := gm.state.GetPlayersInHand() // Folded players are not in the hand, so they won't be in our check
 	for _, id := range IDs {
-		if !gm.state.FoldedPlayers[id] { // Don't want to add folded players to our check
-			var hand []*p2p.CardInfo
-			if id == gm.network.ThisHost.ID() {
-				hand = gm.network.MyHand
-				if len(hand) != 2 {
-					log.Println("Error: No cards found for me!")
-					return
-				}
-			} else {
-				OthersHand, exists := gm.network.OthersHands[id]
-				if !exists || len(OthersHand) == 0 {
-					log.Printf("Error: No cards found for peer %s in OthersHands", id)
-					return
-				}
-				hand = OthersHand
+		var hand []*p2p.CardInfo
+		if id == gm.network.ThisHost.ID() {
+			hand = gm.network.MyHand
+			if len(hand) != 2 {
+				log.Println("Error: No cards found for me!")
+				return
 			}
-
-			// Calc best hand
-			cardOneName, exists := gm.network.Deck.GetCardFromRefDeck(hand[0].CardValue)
-			cardTwoName, exists1 := gm.network.Deck.GetCardFromRefDeck(hand[1].CardValue)
-			fullHand := []string{flopCardOne, flopCardTwo, flopCardThree, turnCard, riverCard, cardOneName, cardTwoName}
-			fmt.Println(fullHand)
-
-			if exists && exists1 {
-				currHand := convertMyCardStringsToLibrarys(fullHand)
-				rank := poker.Evaluate(currHand)
-				if rank < bestRank {
-					bestID = id
-					bestRank = rank
-				}
-				fmt.Println(gm.state.Players[id] + " got " + poker.RankString(rank))
-			} else {
-				fmt.Println(gm.state.Players[id] + " cards didn't exist.")
+		} else {
+			OthersHand, exists := gm.network.OthersHands[id]
+			if !exists || len(OthersHand) == 0 {
+				log.Printf("Error: No cards found for peer %s in OthersHands", id)
+				return
 			}
+			hand = OthersHand
+		}
+
+		// Calc best hand
+		cardOneName, exists := gm.network.Deck.GetCardFromRefDeck(hand[0].CardValue)
+		cardTwoName, exists1 := gm.network.Deck.GetCardFromRefDeck(hand[1].CardValue)
+		fullHand := []string{flopCardOne, flopCardTwo, flopCardThree, turnCard, riverCard, cardOneName, cardTwoName}
+		fmt.Println(fullHand)
+
+		if exists && exists1 {
+			currHand := convertMyCardStringsToLibrarys(fullHand)
+			rank := poker.Evaluate(currHand)
+			ranks[id] = rank
+			fmt.Println(gm.state.Players[id] + " got " + poker.RankString(rank))
+		} else {
+			fmt.Println(gm.state.Players[id] + " cards didn't exist.")
 		}
 	}
 
-	gm.state.Winner = bestID
+	gm.state.HandRanks = ranks
 	gm.RestartRound()
 }
 
@@ -159,22 +144,37 @@ func convertMyCardStringsToLibrarys(myCardStrings []string) []poker.Card {
 
 // Will distribute pot and reset phase bets and restart the protocol
 func (gm *GameManager) RestartRound() {
-	// Distribute the pot to the winner
-	pot := gm.state.GetCurrentPot()
-	if winner, exists := gm.state.PlayersMoney[gm.state.Winner]; exists {
-		gm.state.PlayersMoney[gm.state.Winner] = winner + pot
-		log.Printf("%s won the pot of %.2f!", gm.state.Players[gm.state.Winner], pot)
-	} else {
-		log.Println("Error: Winner not found in player money map")
+	gm.network.HandEvaluated(gm.state.Round) // Our keys for it can go to the host for the audit now
+	if gm.network.ThisHost.ID() == gm.state.TurnOrder[0] {
+		gm.AuditHand()
+	}
+
+	// Distribute the main pot and any side pots to their winners
+	winnings := gm.state.AwardPots(gm.state.HandRanks)
+	if len(winnings) == 0 {
+		log.Println("Error: No winners found for this rounds pots")
 	}
 
 	// Reset round state
 	for id := range gm.state.BetHistory {
 		gm.state.BetHistory[id] = 0.0
 	}
+
+	gm.state.Round++ // Blinds may go up with the schedule
+	gm.state.Aborts = 0
+	gm.state.MoveDealerButton()
+
+	gm.dealHand()
+}
+
+// Clears the last hand off the table and deals a new one, with the blinds posted by whoever sits next to the button
+func (gm *GameManager) dealHand() {
 	for id := range gm.state.FoldedPlayers {
 		gm.state.FoldedPlayers[id] = false
 	}
+	for id := range gm.state.AllInPlayers {
+		gm.state.AllInPlayers[id] = gm.state.PlayersMoney[id] <= 0 // Anyone with no money left sits out
+	}
 	for id := range gm.state.PlayedThisPhase {
 		gm.state.PlayedThisPhase[id] = false
 	}
@@ -184,17 +184,19 @@ func (gm *GameManager) RestartRound() {
 
 	gm.state.MyBet = 0.0
 	gm.state.Phase = "preflop"
-	gm.state.WhosTurn = 0
+	gm.network.StopSolvingPuzzles()                            // Anything still being solved was for the last hand
 	gm.network.OthersHands = make(map[peer.ID][]*p2p.CardInfo) // Need to reset this
+	// Blinds also decide who goes first
+	gm.state.PostBlinds()
 
 	// Reinitialize the board
 	gm.initBoard()
 
 	// Notify GUI to update
-	channelmanager.TGUI_PotChan <- 0.0
-	channelmanager.TGUI_PlayerInfo <- gm.state.GetPlayerInfo()
+	gm.bus.Pot.Publish(gm.state.GetCurrentPot()) // Just the blinds
+	gm.bus.PlayerInfo.Publish(gm.state.GetPlayerInfo())
 
-	gm.network.Deck.GenerateDecks("gokerdecksecretkeyforhashesversion1")
+	gm.network.Deck.GenerateDecks(p2p.DeckKey)
 
 	if gm.network.ThisHost.ID() == gm.state.TurnOrder[0] {
 		fmt.Println("I AM THE HOST, WAITING FOR ALL PLAYERS TO BE READY...")
@@ -202,32 +204,68 @@ func (gm *GameManager) RestartRound() {
 	}
 }
 
+// Everyone reveals their keys for the hand that just finished and checks each others work, accusations go to the front end
+// The hand is already over, so anything going wrong here is only logged and held against whoever caused it
+func (gm *GameManager) AuditHand() {
+	reveal := &p2p.RevealKeyringCommand{}
+	if err := gm.network.ExecuteCommand(reveal); err != nil {
+		gm.auditFailed(err)
+	}
+
+	audit := &p2p.AuditCommand{Round: gm.state.Round, Keyrings: reveal.Keyrings}
+	if err := gm.network.ExecuteCommand(audit); err != nil {
+		gm.auditFailed(err)
+	}
+	if len(audit.Accusations) == 0 {
+		log.Println("Audit: nobody cheated this hand")
+	}
+}
+
+func (gm *GameManager) auditFailed(err error) {
+	log.Printf("Audit: %v", err)
+	if offender, ok := p2p.Offender(err); ok {
+		gm.state.FlagPlayer(offender)
+	}
+}
+
 // Run through setting up keyring, shuffling deck, and dealing
+// If any step fails the deal is aborted, and it stops early if someone else aborted it first
 func (gm *GameManager) RunProtocol() {
-	channelmanager.TGUI_ShowLoadingChan <- struct{}{}
+	gm.network.WaitForDroppedPeers() // Nobody is dealt in while someone might still come back to the last hand
+	gm.bus.ShowLoading.Publish(struct{}{})
+	attempt := gm.state.Aborts
 
-	// Setup keyring for this round
-	gm.network.Keyring.GeneratePQ()
-	gm.network.Keyring.GenerateKeys()
+	steps := []p2p.PeerCommand{
+		// Setup keyring for this round
+		&p2p.NewKeysCommand{}, // Everyone makes their own keys in the group the table agreed on
 
-	// Setup deck
-	gm.network.ExecuteCommand(&p2p.SendPQCommand{})            // Send everyone the generated P and Q so they can setup their Keyring
-	gm.network.ExecuteCommand(&p2p.ProtocolFirstStepCommand{}) // Setting up deck pt.1
-	gm.network.ExecuteCommand(&p2p.BroadcastNewDeck{})
-	gm.network.ExecuteCommand(&p2p.ProtocolSecondStepCommand{}) // Setting up deck pt.2 & Sets everyones hands
-	gm.network.ExecuteCommand(&p2p.BroadcastDeck{})
+		// Setup deck
+		&p2p.ProtocolFirstStepCommand{}, // Setting up deck pt.1
+		&p2p.BroadcastNewDeck{},
+		&p2p.ProtocolSecondStepCommand{}, // Setting up deck pt.2 & Sets everyones hands
+		&p2p.BroadcastDeck{},
 
-	gm.network.ExecuteCommand(&p2p.PushTagCommand{}) // Init first tag for preflop phase
+		&p2p.PushTagCommand{}, // Init first tag for preflop phase
 
-	// Setup hands
-	gm.network.ExecuteCommand(&p2p.CanRequestHand{}) // Deals hands one player at at time
-	gm.network.ExecuteCommand(&p2p.RequestHandCommand{})
+		// Setup hands
+		&p2p.CanRequestHand{}, // Deals hands one player at at time
+		&p2p.RequestHandCommand{},
 
-	// Get Puzzle from everyone
-	gm.network.ExecuteCommand(&p2p.CanRequestPuzzle{})     // Tell everyone they can request their puzzle
-	gm.network.ExecuteCommand(&p2p.RequestPuzzleCommand{}) // Everyone sends each others timelocked payload to each other and they all begin to crack it
+		// Get Puzzle from everyone
+		&p2p.CanRequestPuzzle{},     // Tell everyone they can request their puzzle
+		&p2p.RequestPuzzleCommand{}, // Everyone sends each others timelocked payload to each other and they all begin to crack it
 
-	gm.network.ExecuteCommand(&p2p.MoveToTableCommand{}) // Tell everyone to move to the game table
+		&p2p.MoveToTableCommand{}, // Tell everyone to move to the game table
+	}
+	for _, step := range steps {
+		if gm.state.Aborts != attempt { // Someone else called off this deal, their abort deals again
+			return
+		}
+		if err := gm.network.ExecuteCommand(step); err != nil {
+			gm.AbortRound(err)
+			return
+		}
+	}
 }
 
 func (gm *GameManager) DecryptBoardIfNeeded() {
@@ -0,0 +1,146 @@
+package gamemanager
+
+import (
+	"goker/internal/eventbus"
+	"goker/internal/tablerules"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A whole hand played by the real game managers, from the deal through the showdown to the next deal
+func TestFullHand(t *testing.T) {
+	if testing.Short() {
+		t.Skip("plays a whole hand with real keys, skipping in short mode")
+	}
+
+	const numOfPlayers = 3
+	rules := tablerules.Default()
+	rules.TurnTimer = 300 // Nobody is auto folded while the test waits on the others
+	tt := newTestTable(t, numOfPlayers, rules)
+	hostRules, err := tt.host().state.GetTableRules()
+	require.NoError(t, err)
+	for _, gm := range tt.players {
+		require.Equal(t, tt.host().state.GetTurnOrder(), gm.state.GetTurnOrder(), "everyone should agree on the turn order")
+		theirRules, err := gm.state.GetTableRules()
+		require.NoError(t, err)
+		require.Equal(t, hostRules, theirRules)
+	}
+
+	tt.playHand()
+
+	// Everyone saw every hand at the showdown and ranked them the same
+	ranks := tt.host().state.HandRanks
+	require.Len(t, ranks, numOfPlayers)
+	for _, gm := range tt.players {
+		require.Equal(t, ranks, gm.state.HandRanks)
+	}
+
+	// So the pot went the same way for everyone, and the next hand was dealt with the button moved on
+	host := tt.host().state.Snapshot()
+	require.Equal(t, 1, host.Dealer)
+	total := 0.0
+	for id, money := range host.PlayersMoney {
+		total += money + host.BetHistory[id] // The blinds for the next hand are already in
+	}
+	require.Equal(t, rules.StartingCash*numOfPlayers, total, "no money should appear or disappear")
+	for _, gm := range tt.players {
+		s := gm.state.Snapshot()
+		require.Equal(t, host.PlayersMoney, s.PlayersMoney)
+		require.Equal(t, host.Dealer, s.Dealer)
+		require.Zero(t, s.Aborts)
+		require.Empty(t, gm.state.Flagged, "nobody did anything wrong")
+	}
+}
+
+// Someone who drops mid-hand comes back, catches up on what they missed, and plays the hand out with everyone else
+func TestReconnect(t *testing.T) {
+	if testing.Short() {
+		t.Skip("plays a whole hand with real keys, skipping in short mode")
+	}
+
+	rules := tablerules.Default()
+	rules.TurnTimer = 300 // Nobody is auto folded while the test waits on the others
+	tt := newTestTable(t, 3, rules)
+
+	// Whoever acts last preflop drops, so there's a move for them to miss
+	turnOrder := tt.host().state.GetTurnOrder()
+	var dropper *GameManager
+	var stayers []*GameManager
+	for _, gm := range tt.players {
+		if gm.state.Me == turnOrder[(tt.host().state.WhosTurn+2)%len(turnOrder)] {
+			dropper = gm
+		} else {
+			stayers = append(stayers, gm)
+		}
+	}
+	resynced := dropper.bus.Resynced.Subscribe()
+	defer resynced.Close()
+	var statuses []*eventbus.Subscription[eventbus.ConnectionStatus]
+	for _, stayer := range stayers {
+		sub := stayer.bus.Connection.Subscribe()
+		defer sub.Close()
+		statuses = append(statuses, sub)
+	}
+
+	for _, stayer := range stayers {
+		require.NoError(t, dropper.network.ThisHost.Network().ClosePeer(stayer.state.Me))
+	}
+	nickname := dropper.state.GetNickname(dropper.state.Me)
+	for _, back := range []bool{false, true} { // Everyone notices them go, then come back once they redial
+		for _, sub := range statuses {
+			select {
+			case status := <-sub.C():
+				require.Equal(t, eventbus.ConnectionStatus{Nickname: nickname, Window: status.Window, Back: back}, status)
+			case <-time.After(time.Minute):
+				t.Fatalf("nobody noticed %s drop or come back", nickname)
+			}
+		}
+		if !back {
+			tt.callOrCheck() // Goes on without them
+		}
+	}
+	select {
+	case newHand := <-resynced.C():
+		require.False(t, newHand, "they came back within the same hand")
+	case <-time.After(time.Minute):
+		t.Fatalf("%s never caught up on the hand", nickname)
+	}
+	require.False(t, dropper.network.Reconnecting())
+
+	tt.playHand()
+	ranks := tt.host().state.HandRanks
+	require.Len(t, ranks, 3, "they were at the showdown too")
+	host := tt.host().state.Snapshot()
+	for _, gm := range tt.players {
+		s := gm.state.Snapshot()
+		require.Equal(t, ranks, gm.state.HandRanks)
+		require.Equal(t, host.PlayersMoney, s.PlayersMoney)
+		require.Empty(t, gm.state.Flagged, "dropping out isn't cheating")
+	}
+}
+
+// Pressing check with a bet to call is refused before anyone else hears of it, so the player can still call
+func TestCheckWithBetToCall(t *testing.T) {
+	if testing.Short() {
+		t.Skip("deals a hand with real keys, skipping in short mode")
+	}
+
+	rules := tablerules.Default()
+	rules.TurnTimer = 300 // Nobody is auto folded while the test waits on the others
+	tt := newTestTable(t, 3, rules)
+
+	gm := tt.whosTurn()
+	require.Error(t, gm.state.CheckMove(gm.state.Me, "Check", 0), "first to act preflop faces the big blind")
+	gm.bus.Actions.Publish(eventbus.ActionType{Action: "Check"})
+	tt.callOrCheck() // Only works if the check didn't take their turn
+
+	_, bigBlind, _ := gm.state.GetBlinds()
+	for _, player := range tt.players {
+		s := player.state.Snapshot()
+		require.Equal(t, bigBlind, s.PhaseBets[gm.state.Me], "they called the big blind")
+		require.Zero(t, s.Aborts)
+		require.Empty(t, player.state.Flagged, "nobody saw the check")
+	}
+}
@@ -0,0 +1,176 @@
+package gamemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"goker/internal/eventbus"
+	"goker/internal/sra"
+	"goker/internal/tablerules"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Harness for playing whole hands with real game managers in one process over loopback
+// Each player gets its own bus, with a front end that only approves the table rules and acts when the test tells it to.
+type testTable struct {
+	t       *testing.T
+	players []*GameManager                     // Host first
+	atTable []*eventbus.Subscription[struct{}] // Each players StartRound, sent once a deal is done
+}
+
+// Starts a host and numOfPlayers-1 others that join it, then deals the first hand with the given rules
+func newTestTable(t *testing.T, numOfPlayers int, rules tablerules.Rules) *testTable {
+	t.Helper()
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	saveSquaringSpeed(t, cache) // One squaring a second, so puzzles break instantly
+	sra.UseTestPrimes(true)
+	sra.UseTestShuffleProofs(true)
+
+	tt := &testTable{t: t}
+	for i := 0; i < numOfPlayers; i++ {
+		bus := eventbus.New()
+		gm := New(bus, nil)
+		gm.listenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		lobby := bus.MoveToLobby.Subscribe()
+		tableRules := bus.TableRules.Subscribe()
+		t.Cleanup(tableRules.Close)
+		gm.StartGame(func(bus *eventbus.Bus) {
+			go func() {
+				for range tableRules.C() {
+					bus.Actions.Publish(eventbus.ActionType{Action: "approveRules"})
+				}
+			}()
+		})
+
+		connect := []string{fmt.Sprintf("player%d", i)}
+		if i > 0 {
+			connect = append(connect, tt.host().network.ThisHostLBAddress)
+		}
+		bus.Actions.Publish(eventbus.ActionType{Action: "hostOrConnectPressed", DataS: connect})
+		select {
+		case <-lobby.C():
+		case <-time.After(30 * time.Second):
+			t.Fatalf("player%d never made it to the lobby", i)
+		}
+		lobby.Close()
+		atTable := bus.StartRound.Subscribe()
+		t.Cleanup(atTable.Close)
+		tt.atTable = append(tt.atTable, atTable)
+		t.Cleanup(func() { gm.network.ThisHost.Close() })
+		tt.players = append(tt.players, gm)
+	}
+
+	require.Eventually(t, func() bool {
+		for _, gm := range tt.players {
+			if gm.state.GetNumberOfPlayers() != numOfPlayers {
+				return false
+			}
+		}
+		return true
+	}, 30*time.Second, 50*time.Millisecond, "players never finished joining the lobby")
+
+	tt.host().bus.Actions.Publish(eventbus.ActionType{Action: "startRound", Rules: &rules})
+	tt.waitForDeal(1)
+	return tt
+}
+
+// Saves a calibration the network picks up instead of measuring, as if this machine had measured it earlier
+func saveSquaringSpeed(t *testing.T, cache string) {
+	t.Helper()
+	calibration, err := json.Marshal(map[string]any{"speed": 1, "primeBits": sra.TimeLockPrimeBits, "arch": runtime.GOARCH, "measured": time.Now()})
+	require.NoError(t, err)
+	path := filepath.Join(cache, "goker", "calibration.json")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	require.NoError(t, os.WriteFile(path, calibration, 0o600))
+}
+
+func (tt *testTable) host() *GameManager {
+	return tt.players[0]
+}
+
+// Waits until the deal for the given round is done and everyone has their hand, and agrees on where it's at
+func (tt *testTable) waitForDeal(round int) {
+	tt.t.Helper()
+	for i, atTable := range tt.atTable {
+		select {
+		case <-atTable.C():
+		case <-time.After(time.Minute):
+			tt.t.Fatalf("player%d never moved to the table for round %d", i, round)
+		}
+	}
+	require.Eventually(tt.t, func() bool {
+		for _, gm := range tt.players {
+			if r, phase := gm.state.GetRoundAndPhase(); r != round || phase != "preflop" || len(gm.network.MyHand) != 2 {
+				return false
+			}
+			for _, card := range gm.network.MyHand {
+				if _, ok := gm.network.Deck.GetCardFromRefDeck(card.CardValue); !ok {
+					return false
+				}
+			}
+		}
+		return tt.agree()
+	}, time.Minute, 50*time.Millisecond, "not everyone got their hand for round %d", round)
+}
+
+// Whether everyone sees the hand the same way, and has the tag to act in it
+func (tt *testTable) agree() bool {
+	host := tt.host().state.Snapshot()
+	for _, gm := range tt.players[1:] {
+		s := gm.state.Snapshot()
+		if s.Round != host.Round || s.Phase != host.Phase || s.WhosTurn != host.WhosTurn || gm.state.GetCurrentPot() != tt.host().state.GetCurrentPot() || gm.network.Tag() != tt.host().network.Tag() {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the game manager of whoever's turn it is
+func (tt *testTable) whosTurn() *GameManager {
+	id := tt.host().state.GetWhosTurn()
+	for _, gm := range tt.players {
+		if gm.state.Me == id {
+			return gm
+		}
+	}
+	tt.t.Fatalf("no player %s at the table", id)
+	return nil
+}
+
+// Whoever's turn it is presses call, or check if there is nothing to call, then waits for everyone to catch up
+func (tt *testTable) callOrCheck() {
+	tt.t.Helper()
+	before, tag := tt.host().state.Snapshot(), tt.host().network.Tag()
+	gm := tt.whosTurn()
+	action := "Check"
+	if gm.state.CheckMove(gm.state.Me, "Check", 0) != nil {
+		action = "Call"
+	}
+	gm.bus.Actions.Publish(eventbus.ActionType{Action: action})
+
+	require.Eventually(tt.t, func() bool {
+		now := tt.host().state.Snapshot()
+		newPhase := now.Round == before.Round && now.Phase != before.Phase
+		if newPhase && tt.host().network.Tag() == tag { // The host pushes the tag for it once the cards are out, nobody can act before that
+			return false
+		}
+		moved := now.Round != before.Round || newPhase || now.WhosTurn != before.WhosTurn
+		return moved && tt.agree()
+	}, 30*time.Second, 10*time.Millisecond, "the table never moved on from %s's %s", gm.state.GetNickname(gm.state.Me), action)
+}
+
+// Everyone calls or checks until the hand is over and the next one is dealt
+func (tt *testTable) playHand() {
+	tt.t.Helper()
+	round, _ := tt.host().state.GetRoundAndPhase()
+	for r, _ := tt.host().state.GetRoundAndPhase(); r == round; r, _ = tt.host().state.GetRoundAndPhase() {
+		tt.callOrCheck()
+	}
+	tt.waitForDeal(round + 1)
+}
@@ -2,11 +2,10 @@ package gamestate
 
 import (
 	"fmt"
-	"goker/internal/channelmanager"
+	"goker/internal/eventbus"
+	"goker/internal/tablerules"
 	"log"
 	"sort"
-	"strconv"
-	"strings"
 	"sync"
 
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -29,65 +28,77 @@ type GameState struct {
 	// Turn order
 	TurnOrder map[int]peer.ID // Handled by network (based off of candidate list)
 	WhosTurn  int
+	Dealer    int // Turn order index of whoever has the dealer button, moves every round
 
 	// Round variables
 	// Holds who has folded this round
 	FoldedPlayers map[peer.ID]bool
+	// Holds who has no money left to bet this round - they stay in the hand but can't act
+	AllInPlayers map[peer.ID]bool
 	// Bets made during this phase - used for raising, call, and check
 	PhaseBets map[peer.ID]float64
 	MyBet     float64
 	// Holds weather a player has played this phase - Used to determine when the move to next phase
 	PlayedThisPhase map[peer.ID]bool
 
-	// Table rules (set by host)
-	StartingCash float64 // Starting cash for all players
-	MinBet       float64 // Minimum bet required for the round (again from table settings)
-	Phase        string  // Current phase of the game (e.g., "preflop", "flop", "turn", "river")
+	// Table rules (set by host and approved by everyone)
+	Rules tablerules.Rules
+	Round int    // Number of the current round, starting at 1 - used for the blind schedule
+	Phase string // Current phase of the game (e.g., "preflop", "flop", "turn", "river")
 
-	// Winning player of previous round
-	Winner             peer.ID
-	SomeoneLeft        bool // Boolean for if someone leaves and hasn't folded yet
-	NumOfPuzzlesBroken int  // this should go up by 1 with every time locked puzzle broken -
-}
+	// Hand ranks of the players that made it to the end of the previous round (lower is better)
+	HandRanks   map[peer.ID]int32
+	SomeoneLeft bool // Boolean for if someone leaves and hasn't folded yet
 
-// Refresh state for new possible rounds
-func (gs *GameState) FreshState(startingCash *float64, minBet *float64) {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
+	// Deals that were called off and refunded
+	Aborts   int             // How many deals of this round were aborted, reset when a round finishes
+	Flagged  map[peer.ID]int // How many aborts each player was blamed for this game
+	showdown int             // Round whose hands have started being shown, see StartShowdown
 
-	gs.Phase = "preflop"
+	// Where phase checks, round ends, and GUI updates are published
+	bus *eventbus.Bus
+}
 
-	gs.StartingCash = 100.0
-	if startingCash != nil {
-		gs.StartingCash = *startingCash
-	}
-	for id := range gs.Players {
-		gs.PlayersMoney[id] = gs.StartingCash
+// Empty state for a new game, it publishes to the given bus
+func New(bus *eventbus.Bus) *GameState {
+	return &GameState{
+		Players:         make(map[peer.ID]string),
+		PlayersMoney:    make(map[peer.ID]float64),
+		BetHistory:      make(map[peer.ID]float64),
+		PhaseBets:       make(map[peer.ID]float64),
+		TurnOrder:       make(map[int]peer.ID),
+		FoldedPlayers:   make(map[peer.ID]bool),
+		AllInPlayers:    make(map[peer.ID]bool),
+		PlayedThisPhase: make(map[peer.ID]bool),
+		Flagged:         make(map[peer.ID]int),
+		bus:             bus,
 	}
+}
+
+// Refresh state for new possible rounds, the first seat in turn order starts with the dealer button
+func (gs *GameState) FreshState(rules tablerules.Rules) {
+	gs.mu.Lock()
 
-	gs.MinBet = 1.0
-	if minBet != nil {
-		gs.MinBet = *minBet
+	gs.Rules = rules
+	for id := range gs.Players {
+		gs.PlayersMoney[id] = gs.Rules.StartingCash
+		gs.BetHistory[id] = 0.0
+		gs.PhaseBets[id] = 0.0
+		gs.FoldedPlayers[id] = false
+		gs.AllInPlayers[id] = false
+		gs.PlayedThisPhase[id] = false
 	}
 
+	gs.MyBet = 0.0
 	gs.Phase = "preflop"
+	gs.Round = 1
+	gs.Aborts = 0
+	gs.showdown = 0
 	gs.WhosTurn = 0
-}
-
-// Function used by network for setting table rules from host
-func (gs *GameState) FreshStateFromPayload(payload string) {
-	payloadSplit := strings.Split(payload, "\n")
-
-	startingCash, err := strconv.ParseFloat(payloadSplit[0], 64) // Parse as a 64 bit float
-	if err != nil {
-		log.Println(err)
-	}
-	minBet, err := strconv.ParseFloat(payloadSplit[1], 64)
-	if err != nil {
-		log.Println(err)
-	}
+	gs.Dealer = 0
+	gs.mu.Unlock()
 
-	gs.FreshState(&startingCash, &minBet)
+	gs.PostBlinds()
 }
 
 // For adding a new peer to the state
@@ -102,14 +113,61 @@ func (gs *GameState) AddPeerToState(peerID peer.ID, nickname string) {
 	gs.Players[peerID] = nickname
 	gs.BetHistory[peerID] = 0.0
 	gs.FoldedPlayers[peerID] = false
+	gs.AllInPlayers[peerID] = false
 	gs.PlayedThisPhase[peerID] = false
 }
 
+// Blames a player for an aborted deal, returns how many they've been blamed for so far
+func (gs *GameState) FlagPlayer(peerID peer.ID) int {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	gs.Flagged[peerID]++
+	return gs.Flagged[peerID]
+}
+
+// Marks the showdown of this round as started, from here on anyone could know everyones cards
+// A deal can't be called off after that, whoever is losing would abort it to get their chips back.
+func (gs *GameState) StartShowdown() {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	gs.showdown = gs.Round
+}
+
+// Whether anyone has asked for or given out hand keys this round
+func (gs *GameState) ShowdownStarted() bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	return gs.showdown != 0 && gs.showdown == gs.Round
+}
+
 // When a player leaves, the round will change
 func (gs *GameState) RemovePeerFromState(peerID peer.ID) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 
+	gs.removePeer(peerID)
+}
+
+// Takes a player who left for good out of the state, returns if they were still in the hand and if it was their turn
+// Someone leaving with their cards still in the hand ends the round once everyone else has acted, see NextTurn
+func (gs *GameState) PlayerLeft(peerID peer.ID) (inHand bool, theirTurn bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	inHand = !gs.FoldedPlayers[peerID]
+	if inHand {
+		gs.SomeoneLeft = true
+	}
+	theirTurn = gs.TurnOrder[gs.WhosTurn] == peerID
+	gs.removePeer(peerID)
+	return inHand, theirTurn
+}
+
+// The caller holds the lock
+func (gs *GameState) removePeer(peerID peer.ID) {
 	_, exists := gs.Players[peerID]
 	if !exists {
 		log.Println("RemovePeerFromState: Peer not in state")
@@ -119,27 +177,42 @@ func (gs *GameState) RemovePeerFromState(peerID peer.ID) {
 	delete(gs.Players, peerID)
 	delete(gs.BetHistory, peerID)
 	delete(gs.FoldedPlayers, peerID)
+	delete(gs.AllInPlayers, peerID)
 	delete(gs.PhaseBets, peerID)
 	delete(gs.PlayedThisPhase, peerID)
 	delete(gs.PlayersMoney, peerID)
 
 	// Remove peer from turn order and reindex turn order
+	removed := -1
 	newTurnOrder := make(map[int]peer.ID)
 	newIndex := 0
 	for i := 0; i < len(gs.TurnOrder); i++ {
 		if gs.TurnOrder[i] != peerID {
 			newTurnOrder[newIndex] = gs.TurnOrder[i]
 			newIndex++
+		} else {
+			removed = i
 		}
 	}
 	gs.TurnOrder = newTurnOrder
 
-	// Adjust current turn if necessary
+	// Everyone after the removed seat moved down one, so the turn and the button follow them
 	if len(gs.TurnOrder) > 0 {
-		gs.WhosTurn = gs.WhosTurn % len(gs.TurnOrder)
+		gs.WhosTurn = seatAfterRemoval(gs.WhosTurn, removed, len(gs.TurnOrder))
+		gs.Dealer = seatAfterRemoval(gs.Dealer, removed, len(gs.TurnOrder))
 	} else {
 		gs.WhosTurn = 0
+		gs.Dealer = 0
+	}
+}
+
+// Where a seat index points once the seat at removed is gone from a table of seats
+// Losing the seat itself points at the one before it, so the next turn or button move lands on whoever sat after them
+func seatAfterRemoval(seat int, removed int, seats int) int {
+	if removed >= 0 && removed <= seat {
+		seat--
 	}
+	return (seat%seats + seats) % seats
 }
 
 // Get the current pot from the rounds bet history
@@ -188,7 +261,7 @@ func (gs *GameState) GetNickname(id peer.ID) string {
 }
 
 // Formatted player info to be sent to the GUI
-func (gs *GameState) GetPlayerInfo() channelmanager.PlayerInfo {
+func (gs *GameState) GetPlayerInfo() eventbus.PlayerInfo {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 
@@ -196,6 +269,7 @@ func (gs *GameState) GetPlayerInfo() channelmanager.PlayerInfo {
 	var money []float64
 	var me string
 	var whosTurn string
+	var dealer string
 
 	for i := 0; i < len(gs.TurnOrder); i++ { // We disregard any 'exists' stuff as by this point we have already locked in everyone
 		peerID := gs.TurnOrder[i]
@@ -209,9 +283,14 @@ func (gs *GameState) GetPlayerInfo() channelmanager.PlayerInfo {
 		if i == gs.WhosTurn {
 			whosTurn = peerNickname
 		}
+		if i == gs.Dealer {
+			dealer = peerNickname
+		}
 	}
 
-	return channelmanager.PlayerInfo{Players: players, Money: money, Me: me, HighestBet: gs.GetHighestbetThisPhase(), WhosTurn: whosTurn, MyBetsForThisPhase: gs.MyBet}
+	minRaise, maxRaise := gs.raiseLimits(gs.Me)
+
+	return eventbus.PlayerInfo{Players: players, Money: money, Me: me, HighestBet: gs.GetHighestbetThisPhase(), WhosTurn: whosTurn, Dealer: dealer, MyBetsForThisPhase: gs.MyBet, MinRaise: minRaise, MaxRaise: maxRaise}
 }
 
 // GetHighestBetThisPhase will return either the highest someones bet this phase, or 0 if all bets are the same
@@ -243,15 +322,19 @@ func (gs *GameState) GetHighestbetThisPhase() float64 {
 }
 
 // Package up the table rules to be sent to others
-func (gs *GameState) GetTableRules() string {
+func (gs *GameState) GetTableRules() (string, error) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 
-	var tableRules string
-	tableRules += fmt.Sprintf("%.0f\n", gs.StartingCash)
-	tableRules += fmt.Sprintf("%.0f\n", gs.MinBet)
+	return gs.Rules.Encode()
+}
+
+// A copy of the rules the table is playing by
+func (gs *GameState) GetRules() tablerules.Rules {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
 
-	return tableRules
+	return gs.Rules
 }
 
 func (gs *GameState) GetTurnOrderIndex(peer peer.ID) *int {
@@ -266,6 +349,37 @@ func (gs *GameState) GetTurnOrderIndex(peer peer.ID) *int {
 	return nil
 }
 
+// Number and phase of the current round, read together so they're from the same moment
+func (gs *GameState) GetRoundAndPhase() (int, string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	return gs.Round, gs.Phase
+}
+
+// Phase of the current round
+func (gs *GameState) GetPhase() string {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	return gs.Phase
+}
+
+func (gs *GameState) SetPhase(phase string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	gs.Phase = phase
+}
+
+// Returns the player whose turn it is
+func (gs *GameState) GetWhosTurn() peer.ID {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	return gs.TurnOrder[gs.WhosTurn]
+}
+
 func (gs *GameState) GetNumberOfPlayers() int {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
@@ -278,6 +392,11 @@ func (gs *GameState) GetTurnOrder() []peer.ID {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 
+	return gs.turnOrder()
+}
+
+// The caller holds the lock
+func (gs *GameState) turnOrder() []peer.ID {
 	// Extract keys (turn positions)
 	positions := make([]int, 0, len(gs.TurnOrder))
 	for pos := range gs.TurnOrder {
@@ -296,16 +415,34 @@ func (gs *GameState) GetTurnOrder() []peer.ID {
 	return ids
 }
 
-func (gs *GameState) PlayerBet(peerID peer.ID, bet float64) {
+// Bets the given amount for a player, if they can't cover it they go all-in instead
+// Returns the amount actually bet
+func (gs *GameState) PlayerBet(peerID peer.ID, bet float64) float64 {
 	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	return gs.playerBet(peerID, bet)
+}
+
+// The caller holds the lock
+func (gs *GameState) playerBet(peerID peer.ID, bet float64) float64 {
+	if bet >= gs.PlayersMoney[peerID] {
+		bet = gs.PlayersMoney[peerID]
+		gs.AllInPlayers[peerID] = true
+	}
+
 	gs.PlayersMoney[peerID] -= bet // Lower players money
 	gs.BetHistory[peerID] += bet   // Update the bet history for the round (pot)
 	gs.PhaseBets[peerID] += bet    // Update the players bet for this phase
-	gs.mu.Unlock()
+	return bet
 }
 
-func (gs *GameState) PlayerRaise(peerID peer.ID, bet float64) {
-	gs.PlayerBet(peerID, bet)
+// Returns the amount actually raised, which may be less than asked if the player went all-in
+func (gs *GameState) PlayerRaise(peerID peer.ID, bet float64) float64 {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	bet = gs.playerBet(peerID, bet)
 	for id := range gs.PlayedThisPhase {
 		gs.PlayedThisPhase[id] = false // We need to make the others call, raise or fold again
 	}
@@ -314,27 +451,37 @@ func (gs *GameState) PlayerRaise(peerID peer.ID, bet float64) {
 		gs.MyBet += bet
 	}
 	gs.PlayedThisPhase[peerID] = true // Person who raised did in fact play this round
+	return bet
 }
 
 func (gs *GameState) PlayerCall(peerID peer.ID) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
 	highestBet := gs.GetHighestbetThisPhase()
 	currentBet := gs.PhaseBets[peerID] // Get the player's current bet
 	amountToCall := highestBet - currentBet
 
-	gs.PlayerBet(peerID, amountToCall) // Make them bet only the difference
-	if peerID == gs.Me {               // If it's me
-		gs.MyBet = highestBet
+	called := gs.playerBet(peerID, amountToCall) // Make them bet only the difference (or whatever they have left)
+	if peerID == gs.Me {                         // If it's me
+		gs.MyBet = currentBet + called
 	}
 	gs.PlayedThisPhase[peerID] = true
 }
 
 func (gs *GameState) PlayerFold(peerID peer.ID) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
 	gs.FoldedPlayers[peerID] = true
 	gs.PlayedThisPhase[peerID] = true
 }
 
 // Determines whos going and will check if the phases need to be switched
+// The lock is let go while the game manager switches phases, it needs the state to do that
 func (gs *GameState) NextTurn() {
+	gs.mu.Lock()
+
 	// Check if only one player is left at the table (ignore disconnected players)
 	nonFoldedCount := 0
 
@@ -345,8 +492,9 @@ func (gs *GameState) NextTurn() {
 	}
 
 	// If only one non-folded player remains, end the round immediately
-	if nonFoldedCount == 1 {
+	if nonFoldedCount == 1 || len(gs.playersInHand()) == 1 {
 		log.Println("Only one player remains, ending the round...")
+		gs.mu.Unlock()
 		gs.EndRound()
 		return
 	}
@@ -364,6 +512,7 @@ func (gs *GameState) NextTurn() {
 
 		if allNonFoldedPlayed {
 			log.Println("All remaining players have acted. Ending round due to player leaving...")
+			gs.mu.Unlock()
 			gs.EndRound()
 			return
 		} else {
@@ -371,12 +520,12 @@ func (gs *GameState) NextTurn() {
 		}
 	}
 
-	// From all players who haven't folded, if there are any
+	// From all players who haven't folded or gone all-in, if there are any
 	// that haven't played OR haven't matched the highest bet so far, don't switch the phase
 	phaseSwitch := true
 	highestBetThisPhase := gs.GetHighestbetThisPhase()
 	for id := range gs.PlayedThisPhase {
-		if !gs.FoldedPlayers[id] {
+		if !gs.FoldedPlayers[id] && !gs.AllInPlayers[id] {
 			if !gs.PlayedThisPhase[id] || gs.PhaseBets[id] < highestBetThisPhase {
 				phaseSwitch = false
 			}
@@ -385,11 +534,31 @@ func (gs *GameState) NextTurn() {
 	if phaseSwitch {
 		if gs.SomeoneLeft {
 			log.Println("Someone left, ending round instead of changing phase...")
+			gs.mu.Unlock()
 			gs.EndRound()
 			return
 		} else {
-			channelmanager.TGM_PhaseCheck <- struct{}{} // Tell gm to switch phases
-			<-channelmanager.TGS_PhaseSwitchDone
+			gs.mu.Unlock()
+			for {
+				endingPhase := gs.GetPhase()
+				done := gs.bus.PhaseSwitchDone.Subscribe() // Before asking, so the answer can't be missed
+				gs.bus.PhaseCheck.Publish(struct{}{})      // Tell gm to switch phases
+				<-done.C()
+				done.Close()
+
+				// If less than two players can still bet, no more betting can happen this round, so run out the board
+				if endingPhase == "river" { // The hand is over, the next deal decides who goes first
+					return
+				}
+				gs.mu.Lock()
+				ableToBet := gs.playersAbleToBet()
+				gs.mu.Unlock()
+				if ableToBet > 1 {
+					break
+				}
+				log.Println("Not enough players left who can bet, moving straight to the next phase...")
+			}
+			gs.mu.Lock()
 		}
 	}
 
@@ -402,32 +571,275 @@ func (gs *GameState) NextTurn() {
 
 		if nextValidPlayer == gs.WhosTurn {
 			log.Println("No active players to take next turn.")
+			gs.mu.Unlock()
 			return
 		}
 	}
 
 	gs.WhosTurn = nextValidPlayer
+	gs.mu.Unlock()
 
-	channelmanager.TGUI_PotChan <- gs.GetCurrentPot()    // Updates the pot
-	channelmanager.TGUI_PlayerInfo <- gs.GetPlayerInfo() // Updates the cards
+	gs.bus.Pot.Publish(gs.GetCurrentPot())        // Updates the pot
+	gs.bus.PlayerInfo.Publish(gs.GetPlayerInfo()) // Updates the cards
 }
 
 func (gs *GameState) isActivePlayer(playerID peer.ID) bool {
 	_, exists := gs.Players[playerID]
-	return exists && !gs.FoldedPlayers[playerID] && !gs.PlayedThisPhase[playerID]
+	return exists && !gs.FoldedPlayers[playerID] && !gs.AllInPlayers[playerID] && !gs.PlayedThisPhase[playerID]
+}
+
+// Number of players who haven't folded and can still put money in
+func (gs *GameState) playersAbleToBet() int {
+	count := 0
+	for id := range gs.Players {
+		if !gs.FoldedPlayers[id] && !gs.AllInPlayers[id] {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns the players still playing for the pot in turn order - players who started the round with no money are sat out
+func (gs *GameState) GetPlayersInHand() []peer.ID {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	return gs.playersInHand()
+}
+
+// The caller holds the lock
+func (gs *GameState) playersInHand() []peer.ID {
+	var inHand []peer.ID
+	for _, id := range gs.turnOrder() {
+		if _, exists := gs.Players[id]; !exists {
+			continue
+		}
+		if !gs.FoldedPlayers[id] && !(gs.AllInPlayers[id] && gs.BetHistory[id] == 0) {
+			inHand = append(inHand, id)
+		}
+	}
+	return inHand
+}
+
+// Sets whos turn it is to the first player (from the given turn order index) who can act
+func (gs *GameState) SetFirstToAct(from int) {
+	if len(gs.TurnOrder) == 0 {
+		return
+	}
+
+	for i := 0; i < len(gs.TurnOrder); i++ {
+		index := (from + i) % len(gs.TurnOrder)
+		if gs.isActivePlayer(gs.TurnOrder[index]) {
+			gs.WhosTurn = index
+			return
+		}
+	}
+	gs.WhosTurn = from % len(gs.TurnOrder)
+}
+
+// Blinds and ante for the current round, following the blind schedule in the table rules
+func (gs *GameState) GetBlinds() (smallBlind float64, bigBlind float64, ante float64) {
+	return gs.Rules.BlindsForRound(gs.Round)
+}
+
+// Puts a players ante in the pot - antes are dead money, so they don't count towards the phase bets
+func (gs *GameState) PlayerAnte(peerID peer.ID, ante float64) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	gs.playerAnte(peerID, ante)
+}
+
+// The caller holds the lock
+func (gs *GameState) playerAnte(peerID peer.ID, ante float64) {
+	if ante >= gs.PlayersMoney[peerID] {
+		ante = gs.PlayersMoney[peerID]
+		gs.AllInPlayers[peerID] = true
+	}
+
+	gs.PlayersMoney[peerID] -= ante
+	gs.BetHistory[peerID] += ante
+}
+
+// Returns the smallest and largest amount a player can put in when raising, based on the betting structure
+// Both include the amount needed to call, and are capped by what the player has left
+func (gs *GameState) GetRaiseLimits(peerID peer.ID) (minRaise float64, maxRaise float64) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	return gs.raiseLimits(peerID)
+}
+
+// The caller holds the lock
+func (gs *GameState) raiseLimits(peerID peer.ID) (minRaise float64, maxRaise float64) {
+	_, bigBlind, _ := gs.GetBlinds()
+	money := gs.PlayersMoney[peerID]
+	toCall := gs.GetHighestbetThisPhase() - gs.PhaseBets[peerID]
+	if toCall < 0 {
+		toCall = 0
+	}
+
+	switch gs.Rules.BettingStructure {
+	case tablerules.FixedLimit:
+		// Small bets for the first two phases, big bets for the last two
+		betSize := bigBlind
+		if gs.Phase == "turn" || gs.Phase == "river" {
+			betSize = bigBlind * 2
+		}
+		minRaise, maxRaise = toCall+betSize, toCall+betSize
+	case tablerules.PotLimit:
+		pot := 0.0
+		for _, b := range gs.BetHistory {
+			pot += b
+		}
+		// Call first, then raise by at most the size of the pot after the call
+		minRaise, maxRaise = toCall+bigBlind, toCall+pot+toCall
+	default:
+		minRaise, maxRaise = toCall+bigBlind, money
+	}
+
+	// Short stacks can always go all-in for less
+	if maxRaise > money {
+		maxRaise = money
+	}
+	if minRaise > maxRaise {
+		minRaise = maxRaise
+	}
+	return minRaise, maxRaise
+}
+
+// Checks a raise, call, check or fold from another player is theirs to make before it's applied, the amount only matters for raises
+func (gs *GameState) CheckMove(peerID peer.ID, move string, amount float64) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if gs.TurnOrder[gs.WhosTurn] != peerID {
+		return fmt.Errorf("%s out of turn", move)
+	}
+
+	switch move {
+	case "Raise":
+		minRaise, maxRaise := gs.raiseLimits(peerID)
+		if !(amount > 0 && amount >= minRaise && amount <= maxRaise) { // Also catches NaN
+			return fmt.Errorf("raise of $%.0f isn't between $%.0f and $%.0f", amount, minRaise, maxRaise)
+		}
+	case "Check":
+		if gs.GetHighestbetThisPhase() > gs.PhaseBets[peerID] {
+			return fmt.Errorf("check with a bet to call")
+		}
+	}
+	return nil
+}
+
+// Moves the dealer button to the next seat that still has money to play with
+func (gs *GameState) MoveDealerButton() {
+	gs.Dealer = gs.nextSeatWithMoney(gs.Dealer)
+}
+
+// Posts the antes, then the small and big blinds for the seats after the dealer and sets who acts first preflop
+// Heads up the dealer posts the small blind, so they act first preflop and last after the flop
+func (gs *GameState) PostBlinds() {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if len(gs.TurnOrder) < 2 {
+		return
+	}
+
+	seatsWithMoney := 0
+	for _, id := range gs.TurnOrder {
+		if gs.PlayersMoney[id] > 0 {
+			seatsWithMoney++
+		}
+	}
+	if seatsWithMoney < 2 {
+		log.Println("PostBlinds: Not enough players with money to post blinds")
+		return
+	}
+
+	smallBlind, bigBlind, ante := gs.GetBlinds()
+	if ante > 0 {
+		for _, id := range gs.TurnOrder {
+			if gs.PlayersMoney[id] > 0 {
+				gs.playerAnte(id, ante)
+			}
+		}
+	}
+
+	smallBlindSeat := gs.nextSeatWithMoney(gs.Dealer)
+	if seatsWithMoney == 2 {
+		smallBlindSeat = gs.Dealer
+	}
+	bigBlindSeat := gs.nextSeatWithMoney(smallBlindSeat)
+
+	gs.playerBet(gs.TurnOrder[smallBlindSeat], smallBlind)
+	gs.playerBet(gs.TurnOrder[bigBlindSeat], bigBlind)
+	gs.MyBet = gs.PhaseBets[gs.Me]
+
+	// Blinds haven't played yet, so the big blind still gets to check or raise if everyone calls
+	gs.SetFirstToAct(bigBlindSeat + 1)
+}
+
+// Returns the next seat after the given one whose player has money left
+func (gs *GameState) nextSeatWithMoney(from int) int {
+	numOfSeats := len(gs.TurnOrder)
+	for i := 1; i <= numOfSeats; i++ {
+		seat := (from + i) % numOfSeats
+		if gs.PlayersMoney[gs.TurnOrder[seat]] > 0 {
+			return seat
+		}
+	}
+	return from
 }
 
 func (gs *GameState) IsMyTurn() bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
 	return gs.Me == gs.TurnOrder[gs.WhosTurn]
 }
 
 func (gs *GameState) PlayerCheck(peerID peer.ID) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
 	gs.PlayedThisPhase[peerID] = true
 }
 
+// Clears the bets for the next betting phase and moves on to it, returns the phase that just ended
+// The river is the last one, what comes after it is up to whoever ends the round
+func (gs *GameState) NextPhase() string {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	gs.MyBet = 0
+	for id := range gs.PlayedThisPhase {
+		if !gs.FoldedPlayers[id] { // Skip any folded players
+			gs.PlayedThisPhase[id] = false
+		}
+	}
+	for id := range gs.PhaseBets {
+		gs.PhaseBets[id] = 0.0
+	}
+
+	// After the flop the first player left of the dealer goes first, NextTurn will move on from the dealer
+	gs.WhosTurn = gs.Dealer
+
+	ended := gs.Phase
+	switch ended {
+	case "preflop":
+		gs.Phase = "flop"
+	case "flop":
+		gs.Phase = "turn"
+	case "turn":
+		gs.Phase = "river"
+	}
+	return ended
+}
+
 // The gamestate will call this so the game manager can reset everything and begin the next round
 func (gs *GameState) EndRound() {
-	channelmanager.TGM_EndRound <- struct{}{}
+	gs.bus.RoundOver.Publish(struct{}{})
 }
 
 func (gs *GameState) Contains(slice []string, item string) bool {
@@ -0,0 +1,198 @@
+package gamestate
+
+import (
+	"goker/internal/tablerules"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostBlinds(t *testing.T) {
+	a, b, c, d := peer.ID("a"), peer.ID("b"), peer.ID("c"), peer.ID("d")
+
+	t.Run("blinds left of the dealer", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 100, d: 100}, a, b, c, d)
+		gs.PostBlinds()
+
+		require.Equal(t, 1.0, gs.PhaseBets[b])
+		require.Equal(t, 2.0, gs.PhaseBets[c])
+		require.Equal(t, 3, gs.WhosTurn, "first to act preflop is left of the big blind")
+		require.False(t, gs.PlayedThisPhase[c], "big blind still gets their option")
+	})
+
+	t.Run("heads up dealer is the small blind", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 100, b: 100}, a, b)
+		gs.PostBlinds()
+
+		require.Equal(t, 1.0, gs.PhaseBets[a])
+		require.Equal(t, 2.0, gs.PhaseBets[b])
+		require.Equal(t, 0, gs.WhosTurn, "heads up the dealer acts first preflop")
+	})
+
+	t.Run("short blind goes all-in", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 1}, a, b, c)
+		gs.Rules.SmallBlind, gs.Rules.BigBlind = 2, 4
+		gs.PostBlinds()
+
+		require.Equal(t, 1.0, gs.PhaseBets[c])
+		require.True(t, gs.AllInPlayers[c])
+		require.Equal(t, 0, gs.WhosTurn)
+	})
+}
+
+func TestMoveDealerButton(t *testing.T) {
+	a, b, c := peer.ID("a"), peer.ID("b"), peer.ID("c")
+	gs := newTestState(map[peer.ID]float64{a: 100, b: 0, c: 100}, a, b, c)
+
+	gs.MoveDealerButton()
+	require.Equal(t, 2, gs.Dealer, "players with no money are skipped")
+
+	gs.MoveDealerButton()
+	require.Equal(t, 0, gs.Dealer)
+
+	// Heads up, so the dealer is the small blind and the other player is the big blind
+	gs.PostBlinds()
+	require.Equal(t, 1.0, gs.PhaseBets[a])
+	require.Equal(t, 2.0, gs.PhaseBets[c])
+}
+
+func TestRemovePeer(t *testing.T) {
+	a, b, c, d := peer.ID("a"), peer.ID("b"), peer.ID("c"), peer.ID("d")
+	money := map[peer.ID]float64{a: 100, b: 100, c: 100, d: 100}
+
+	t.Run("seat below the dealer", func(t *testing.T) {
+		gs := newTestState(money, a, b, c, d)
+		gs.Dealer, gs.WhosTurn = 2, 3
+		gs.RemovePeerFromState(a)
+
+		require.Equal(t, c, gs.TurnOrder[gs.Dealer], "the button stays with the dealer")
+		require.Equal(t, d, gs.TurnOrder[gs.WhosTurn], "the turn stays with whoever had it")
+	})
+
+	t.Run("the dealers seat", func(t *testing.T) {
+		gs := newTestState(money, a, b, c, d)
+		gs.Dealer, gs.WhosTurn = 2, 2
+		gs.RemovePeerFromState(c)
+
+		require.Equal(t, b, gs.TurnOrder[gs.Dealer])
+		gs.MoveDealerButton()
+		require.Equal(t, d, gs.TurnOrder[gs.Dealer], "the button moves on to whoever sat after them")
+		require.Equal(t, b, gs.TurnOrder[gs.WhosTurn], "the turn moves on from the seat before theirs")
+	})
+
+	t.Run("seat above the dealer", func(t *testing.T) {
+		gs := newTestState(money, a, b, c, d)
+		gs.Dealer, gs.WhosTurn = 1, 1
+		gs.RemovePeerFromState(d)
+
+		require.Equal(t, b, gs.TurnOrder[gs.Dealer])
+		require.Equal(t, b, gs.TurnOrder[gs.WhosTurn])
+	})
+
+	t.Run("first seat wraps to the last", func(t *testing.T) {
+		gs := newTestState(money, a, b, c, d)
+		gs.Dealer, gs.WhosTurn = 0, 0
+		gs.RemovePeerFromState(a)
+
+		require.Equal(t, d, gs.TurnOrder[gs.Dealer])
+		require.Equal(t, d, gs.TurnOrder[gs.WhosTurn])
+	})
+}
+
+func TestPostBlindsWithAntes(t *testing.T) {
+	a, b, c := peer.ID("a"), peer.ID("b"), peer.ID("c")
+	gs := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 100}, a, b, c)
+	gs.Rules.Ante = 1
+	gs.PostBlinds()
+
+	require.Equal(t, 1.0, gs.BetHistory[a], "dealer only pays the ante")
+	require.Equal(t, 2.0, gs.BetHistory[b])
+	require.Equal(t, 3.0, gs.BetHistory[c])
+	require.Equal(t, 0.0, gs.PhaseBets[a], "antes don't count towards calling")
+	require.Equal(t, 2.0, gs.GetHighestbetThisPhase())
+}
+
+func TestBlindSchedule(t *testing.T) {
+	a, b := peer.ID("a"), peer.ID("b")
+	gs := newTestState(map[peer.ID]float64{a: 100, b: 100}, a, b)
+	gs.Rules.BlindSchedule = []tablerules.BlindLevel{{Round: 3, SmallBlind: 5, BigBlind: 10}}
+
+	gs.Round = 2
+	smallBlind, bigBlind, _ := gs.GetBlinds()
+	require.Equal(t, 1.0, smallBlind)
+	require.Equal(t, 2.0, bigBlind)
+
+	gs.Round = 3
+	gs.PostBlinds()
+	require.Equal(t, 5.0, gs.PhaseBets[a])
+	require.Equal(t, 10.0, gs.PhaseBets[b])
+}
+
+func TestGetRaiseLimits(t *testing.T) {
+	a, b, c := peer.ID("a"), peer.ID("b"), peer.ID("c")
+
+	t.Run("no limit", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 100}, a, b, c)
+		gs.PostBlinds()
+
+		minRaise, maxRaise := gs.GetRaiseLimits(a)
+		require.Equal(t, 4.0, minRaise, "call the big blind and raise by at least one more")
+		require.Equal(t, 100.0, maxRaise)
+	})
+
+	t.Run("pot limit", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 100}, a, b, c)
+		gs.Rules.BettingStructure = tablerules.PotLimit
+		gs.PostBlinds()
+
+		_, maxRaise := gs.GetRaiseLimits(a)
+		require.Equal(t, 7.0, maxRaise, "call 2 then raise by the 5 in the pot")
+	})
+
+	t.Run("fixed limit", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 100}, a, b, c)
+		gs.Rules.BettingStructure = tablerules.FixedLimit
+		gs.PostBlinds()
+
+		minRaise, maxRaise := gs.GetRaiseLimits(a)
+		require.Equal(t, 4.0, minRaise)
+		require.Equal(t, 4.0, maxRaise)
+
+		gs.Phase = "turn"
+		for id := range gs.PhaseBets {
+			gs.PhaseBets[id] = 0
+		}
+		minRaise, _ = gs.GetRaiseLimits(a)
+		require.Equal(t, 4.0, minRaise, "big bets on the turn and river")
+	})
+
+	t.Run("short stack", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 3, b: 100, c: 100}, a, b, c)
+		gs.PostBlinds()
+
+		minRaise, maxRaise := gs.GetRaiseLimits(a)
+		require.Equal(t, 3.0, minRaise, "can still go all-in for less")
+		require.Equal(t, 3.0, maxRaise)
+	})
+}
+
+func TestCheckMove(t *testing.T) {
+	a, b, c := peer.ID("a"), peer.ID("b"), peer.ID("c")
+	gs := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 100}, a, b, c)
+	gs.Rules.BettingStructure = tablerules.PotLimit
+	gs.PostBlinds()
+	first := gs.TurnOrder[gs.WhosTurn]
+
+	require.NoError(t, gs.CheckMove(first, "Raise", 7))
+	require.NoError(t, gs.CheckMove(first, "Call", 0))
+	require.Error(t, gs.CheckMove(first, "Raise", -50), "a negative raise would add to their stack")
+	require.Error(t, gs.CheckMove(first, "Raise", 8), "more than the pot allows")
+	require.Error(t, gs.CheckMove(first, "Check", 0), "the big blind is still to call")
+
+	for _, other := range []peer.ID{a, b, c} {
+		if other != first {
+			require.Error(t, gs.CheckMove(other, "Fold", 0), "not their turn")
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package gamestate
+
+import (
+	"log"
+	"math"
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// A main or side pot, and who can win it
+type Pot struct {
+	Amount   float64
+	Eligible []peer.ID // In turn order
+}
+
+// Splits this rounds bet history into the main pot and any side pots
+// Every all-in amount starts a new level, a player is only eligible for the levels they fully paid into
+func (gs *GameState) GetPots() []Pot {
+	turnOrder := gs.GetTurnOrder()
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	// Every distinct amount put in by a player who is still in the hand is a pot level
+	var levels []float64
+	seen := make(map[float64]bool)
+	for id, bet := range gs.BetHistory {
+		if gs.FoldedPlayers[id] || bet <= 0 || seen[bet] {
+			continue
+		}
+		seen[bet] = true
+		levels = append(levels, bet)
+	}
+	sort.Float64s(levels)
+
+	var pots []Pot
+	previousLevel := 0.0
+	for _, level := range levels {
+		pot := Pot{}
+		for _, bet := range gs.BetHistory { // Folded players money still goes in, they just can't win it
+			pot.Amount += min(bet, level) - min(bet, previousLevel)
+		}
+		for _, id := range turnOrder {
+			if !gs.FoldedPlayers[id] && gs.BetHistory[id] >= level {
+				pot.Eligible = append(pot.Eligible, id)
+			}
+		}
+		if pot.Amount > 0 {
+			pots = append(pots, pot)
+		}
+		previousLevel = level
+	}
+
+	// Anything a folded player put in above every remaining player goes in the last pot
+	leftover := 0.0
+	for _, bet := range gs.BetHistory {
+		if bet > previousLevel {
+			leftover += bet - previousLevel
+		}
+	}
+	if leftover > 0 {
+		if len(pots) == 0 {
+			pot := Pot{}
+			for _, id := range turnOrder {
+				if !gs.FoldedPlayers[id] {
+					pot.Eligible = append(pot.Eligible, id)
+				}
+			}
+			pots = append(pots, pot)
+		}
+		pots[len(pots)-1].Amount += leftover
+	}
+
+	return pots
+}
+
+// Gives each pot to the best ranked (lowest) eligible hand, returns how much each player won
+// Tied hands split the pot evenly, any odd chips go one at a time to the tied players closest to the left of the dealer
+func (gs *GameState) AwardPots(ranks map[peer.ID]int32) map[peer.ID]float64 {
+	pots := gs.GetPots()
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	winnings := make(map[peer.ID]float64)
+	for i, pot := range pots {
+		var winners []peer.ID
+		var bestRank int32 = -1
+		for _, id := range pot.Eligible {
+			rank, ranked := ranks[id]
+			if !ranked {
+				continue
+			}
+			if bestRank == -1 || rank < bestRank {
+				winners = []peer.ID{id}
+				bestRank = rank
+			} else if rank == bestRank {
+				winners = append(winners, id)
+			}
+		}
+		if len(winners) == 0 {
+			if len(pot.Eligible) == 0 {
+				log.Printf("AwardPots: No one is eligible for pot %d of %.2f\n", i, pot.Amount)
+				continue
+			}
+			winners = pot.Eligible[:1] // Shouldn't happen, but the money has to go somewhere
+		}
+
+		for id, amount := range gs.splitPot(pot.Amount, winners) {
+			gs.PlayersMoney[id] += amount
+			winnings[id] += amount
+			log.Printf("%s won %.2f from pot %d!", gs.Players[id], amount, i)
+		}
+	}
+
+	return winnings
+}
+
+// Gives everyone back what they bet this round, for when the deal is called off before anyone could win it
+func (gs *GameState) RefundRound() {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	for id, bet := range gs.BetHistory {
+		gs.PlayersMoney[id] += bet
+		gs.BetHistory[id] = 0.0
+	}
+	for id := range gs.PhaseBets {
+		gs.PhaseBets[id] = 0.0
+	}
+	gs.MyBet = 0.0
+}
+
+// Splits an amount evenly in whole chips between the given players
+// What can't be split evenly is handed out a chip at a time starting from the first seat left of the dealer
+func (gs *GameState) splitPot(amount float64, winners []peer.ID) map[peer.ID]float64 {
+	split := make(map[peer.ID]float64, len(winners))
+	if len(winners) == 1 {
+		split[winners[0]] = amount
+		return split
+	}
+
+	share := math.Floor(amount / float64(len(winners)))
+	for _, id := range winners {
+		split[id] = share
+	}
+
+	ordered := gs.orderFromDealer(winners)
+	remainder := amount - share*float64(len(winners))
+	for i := 0; remainder > 0; i = (i + 1) % len(ordered) {
+		chip := min(1.0, remainder) // Anything smaller than a chip goes to the next in line
+		split[ordered[i]] += chip
+		remainder -= chip
+	}
+
+	return split
+}
+
+// Sorts the given players by seat, starting from the first seat left of the dealer
+func (gs *GameState) orderFromDealer(ids []peer.ID) []peer.ID {
+	seats := make(map[peer.ID]int, len(gs.TurnOrder))
+	for index, id := range gs.TurnOrder {
+		seats[id] = index
+	}
+
+	numOfSeats := len(gs.TurnOrder)
+	distance := func(id peer.ID) int {
+		return (seats[id] - gs.Dealer - 1 + numOfSeats) % numOfSeats
+	}
+
+	ordered := append([]peer.ID{}, ids...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return distance(ordered[i]) < distance(ordered[j])
+	})
+	return ordered
+}
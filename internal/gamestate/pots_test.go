@@ -0,0 +1,187 @@
+package gamestate
+
+import (
+	"goker/internal/eventbus"
+	"goker/internal/tablerules"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// Builds a state with the given players (in turn order) each holding the given money
+func newTestState(money map[peer.ID]float64, order ...peer.ID) *GameState {
+	gs := New(eventbus.New())
+	gs.Rules = tablerules.Default()
+	gs.Round = 1
+	for _, id := range order {
+		gs.AddPeerToState(id, string(id))
+		gs.PlayersMoney[id] = money[id]
+	}
+	gs.SetTurnOrder(order)
+	return gs
+}
+
+func TestPlayerBetAllIn(t *testing.T) {
+	a, b := peer.ID("a"), peer.ID("b")
+	gs := newTestState(map[peer.ID]float64{a: 30, b: 100}, a, b)
+
+	bet := gs.PlayerBet(a, 50)
+	require.Equal(t, 30.0, bet, "bet should be capped at the players money")
+	require.Equal(t, 0.0, gs.PlayersMoney[a])
+	require.True(t, gs.AllInPlayers[a])
+
+	bet = gs.PlayerBet(b, 50)
+	require.Equal(t, 50.0, bet)
+	require.False(t, gs.AllInPlayers[b])
+}
+
+func TestGetPots(t *testing.T) {
+	a, b, c := peer.ID("a"), peer.ID("b"), peer.ID("c")
+
+	t.Run("no all-in is one pot", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 100}, a, b, c)
+		gs.PlayerBet(a, 20)
+		gs.PlayerBet(b, 20)
+		gs.PlayerBet(c, 20)
+
+		pots := gs.GetPots()
+		require.Len(t, pots, 1)
+		require.Equal(t, 60.0, pots[0].Amount)
+		require.Equal(t, []peer.ID{a, b, c}, pots[0].Eligible)
+	})
+
+	t.Run("short all-in makes a side pot", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 10, b: 100, c: 100}, a, b, c)
+		gs.PlayerBet(a, 50)
+		gs.PlayerBet(b, 50)
+		gs.PlayerBet(c, 50)
+
+		pots := gs.GetPots()
+		require.Len(t, pots, 2)
+		require.Equal(t, 30.0, pots[0].Amount)
+		require.Equal(t, []peer.ID{a, b, c}, pots[0].Eligible)
+		require.Equal(t, 80.0, pots[1].Amount)
+		require.Equal(t, []peer.ID{b, c}, pots[1].Eligible)
+	})
+
+	t.Run("folded money stays in the pot", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 10, b: 100, c: 100}, a, b, c)
+		gs.PlayerBet(a, 10)
+		gs.PlayerBet(b, 40)
+		gs.PlayerBet(c, 25)
+		gs.PlayerFold(c)
+
+		pots := gs.GetPots()
+		require.Len(t, pots, 2)
+		require.Equal(t, 30.0, pots[0].Amount)
+		require.Equal(t, []peer.ID{a, b}, pots[0].Eligible)
+		require.Equal(t, 45.0, pots[1].Amount)
+		require.Equal(t, []peer.ID{b}, pots[1].Eligible)
+	})
+}
+
+func TestAwardPots(t *testing.T) {
+	a, b, c := peer.ID("a"), peer.ID("b"), peer.ID("c")
+
+	t.Run("short stack wins main pot only", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 10, b: 100, c: 100}, a, b, c)
+		gs.PlayerBet(a, 50)
+		gs.PlayerBet(b, 50)
+		gs.PlayerBet(c, 50)
+
+		winnings := gs.AwardPots(map[peer.ID]int32{a: 1, b: 200, c: 300})
+		require.Equal(t, 30.0, winnings[a])
+		require.Equal(t, 80.0, winnings[b])
+		require.Equal(t, 30.0, gs.PlayersMoney[a])
+		require.Equal(t, 130.0, gs.PlayersMoney[b])
+		require.Equal(t, 50.0, gs.PlayersMoney[c])
+	})
+
+	t.Run("uncalled bet goes back", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 10, b: 100}, a, b)
+		gs.PlayerBet(a, 10)
+		gs.PlayerBet(b, 60)
+
+		gs.AwardPots(map[peer.ID]int32{a: 1, b: 2})
+		require.Equal(t, 20.0, gs.PlayersMoney[a])
+		require.Equal(t, 90.0, gs.PlayersMoney[b])
+	})
+
+	t.Run("total money is kept", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 15, b: 40, c: 100}, a, b, c)
+		gs.PlayerBet(a, 100)
+		gs.PlayerBet(b, 100)
+		gs.PlayerBet(c, 100)
+
+		gs.AwardPots(map[peer.ID]int32{a: 5, b: 3, c: 9})
+		total := gs.PlayersMoney[a] + gs.PlayersMoney[b] + gs.PlayersMoney[c]
+		require.Equal(t, 155.0, total)
+	})
+}
+
+func TestRefundRound(t *testing.T) {
+	a, b := peer.ID("a"), peer.ID("b")
+	gs := newTestState(map[peer.ID]float64{a: 30, b: 100}, a, b)
+	gs.PlayerBet(a, 50)
+	gs.PlayerBet(b, 20)
+
+	gs.RefundRound()
+	require.Equal(t, 30.0, gs.PlayersMoney[a])
+	require.Equal(t, 100.0, gs.PlayersMoney[b])
+	require.Zero(t, gs.GetCurrentPot())
+	require.Zero(t, gs.PhaseBets[a])
+}
+
+func TestSplitPots(t *testing.T) {
+	a, b, c := peer.ID("a"), peer.ID("b"), peer.ID("c")
+
+	t.Run("tie splits evenly", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 100}, a, b, c)
+		gs.PlayerBet(a, 20)
+		gs.PlayerBet(b, 20)
+		gs.PlayerBet(c, 20)
+
+		winnings := gs.AwardPots(map[peer.ID]int32{a: 10, b: 50, c: 10})
+		require.Equal(t, 30.0, winnings[a])
+		require.Equal(t, 30.0, winnings[c])
+		require.Zero(t, winnings[b])
+	})
+
+	t.Run("odd chip goes left of the dealer", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 100}, a, b, c)
+		gs.PlayerBet(a, 11)
+		gs.PlayerBet(b, 11)
+		gs.PlayerBet(c, 11)
+
+		// c is the dealer, so b is further from the dealers left than a
+		gs.Dealer = 2
+		winnings := gs.AwardPots(map[peer.ID]int32{a: 10, b: 10, c: 50})
+		require.Equal(t, 17.0, winnings[a])
+		require.Equal(t, 16.0, winnings[b])
+	})
+
+	t.Run("side pot ties split separately", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 10, b: 100, c: 100}, a, b, c)
+		gs.PlayerBet(a, 50)
+		gs.PlayerBet(b, 50)
+		gs.PlayerBet(c, 50)
+
+		// a has the best hand, b and c tie for the side pot
+		winnings := gs.AwardPots(map[peer.ID]int32{a: 1, b: 20, c: 20})
+		require.Equal(t, 30.0, winnings[a])
+		require.Equal(t, 40.0, winnings[b])
+		require.Equal(t, 40.0, winnings[c])
+	})
+
+	t.Run("split is the same for any order of winners", func(t *testing.T) {
+		gs := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 100}, a, b, c)
+		gs.Dealer = 2
+		first := gs.splitPot(10, []peer.ID{a, b, c})
+		second := gs.splitPot(10, []peer.ID{c, b, a})
+		require.Equal(t, first, second)
+		require.Equal(t, 4.0, first[a])
+		require.Equal(t, 3.0, first[b])
+		require.Equal(t, 3.0, first[c])
+	})
+}
@@ -0,0 +1,163 @@
+package gamestate
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Everything about the current hand a player needs to carry on with it, sent to someone who dropped out and came back
+// Nicknames aren't in it, everyone still at the table is someone they already know.
+type Snapshot struct {
+	Round  int    `json:"round"`
+	Aborts int    `json:"aborts"`
+	Phase  string `json:"phase"`
+
+	TurnOrder []peer.ID `json:"turnOrder"`
+	WhosTurn  int       `json:"whosTurn"`
+	Dealer    int       `json:"dealer"`
+
+	PlayersMoney    map[peer.ID]float64 `json:"playersMoney"`
+	BetHistory      map[peer.ID]float64 `json:"betHistory"`
+	PhaseBets       map[peer.ID]float64 `json:"phaseBets"`
+	FoldedPlayers   map[peer.ID]bool    `json:"foldedPlayers"`
+	AllInPlayers    map[peer.ID]bool    `json:"allInPlayers"`
+	PlayedThisPhase map[peer.ID]bool    `json:"playedThisPhase"`
+	SomeoneLeft     bool                `json:"someoneLeft"`
+}
+
+// A copy of the hand as we see it
+func (gs *GameState) Snapshot() Snapshot {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	turnOrder := make([]peer.ID, len(gs.TurnOrder))
+	for i := range turnOrder {
+		turnOrder[i] = gs.TurnOrder[i]
+	}
+
+	return Snapshot{
+		Round:           gs.Round,
+		Aborts:          gs.Aborts,
+		Phase:           gs.Phase,
+		TurnOrder:       turnOrder,
+		WhosTurn:        gs.WhosTurn,
+		Dealer:          gs.Dealer,
+		PlayersMoney:    maps.Clone(gs.PlayersMoney),
+		BetHistory:      maps.Clone(gs.BetHistory),
+		PhaseBets:       maps.Clone(gs.PhaseBets),
+		FoldedPlayers:   maps.Clone(gs.FoldedPlayers),
+		AllInPlayers:    maps.Clone(gs.AllInPlayers),
+		PlayedThisPhase: maps.Clone(gs.PlayedThisPhase),
+		SomeoneLeft:     gs.SomeoneLeft,
+	}
+}
+
+// Phases in the order a hand goes through them
+var handPhases = []string{"preflop", "flop", "turn", "river"}
+
+// How far apart two chip counts can be and still be the same, they're sums of floats
+const chipTolerance = 1e-6
+
+// Checks a snapshot could be the hand we were in, before Restore takes it over
+// Players can leave while we're away but nobody new sits down, chips only move between players (or off the table with
+// whoever leaves), and the table can't deal another hand without us. In the same hand nobody's chips and bets change
+// their total, and a fold can't be taken back.
+func (gs *GameState) CheckSnapshot(s Snapshot) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	seated := make(map[peer.ID]bool, len(s.TurnOrder))
+	for _, id := range s.TurnOrder {
+		if _, known := gs.Players[id]; !known || seated[id] {
+			return fmt.Errorf("%s isn't a player we know, or is seated twice", id)
+		}
+		seated[id] = true
+	}
+	if !seated[gs.Me] {
+		return fmt.Errorf("we aren't seated in it")
+	}
+	if s.WhosTurn < 0 || s.WhosTurn >= len(s.TurnOrder) || s.Dealer < 0 || s.Dealer >= len(s.TurnOrder) {
+		return fmt.Errorf("turn %d or dealer %d isn't a seat at a table of %d", s.WhosTurn, s.Dealer, len(s.TurnOrder))
+	}
+	if s.Round < gs.Round || s.Round > gs.Round+1 {
+		return fmt.Errorf("round %d, we're in round %d", s.Round, gs.Round)
+	}
+	if !slices.Contains(handPhases, s.Phase) {
+		return fmt.Errorf("phase %q isn't one a hand goes through", s.Phase)
+	}
+
+	sameHand := s.Round == gs.Round && s.Aborts == gs.Aborts
+	if sameHand && slices.Index(handPhases, s.Phase) < slices.Index(handPhases, gs.Phase) {
+		return fmt.Errorf("it's back in the %s, we were on the %s", s.Phase, gs.Phase)
+	}
+
+	ours, theirs := 0.0, 0.0
+	for id, money := range gs.PlayersMoney {
+		ours += money + gs.BetHistory[id]
+	}
+	for id := range seated {
+		money, bet, phaseBet := s.PlayersMoney[id], s.BetHistory[id], s.PhaseBets[id]
+		if money < 0 || phaseBet < 0 || phaseBet > bet+chipTolerance {
+			return fmt.Errorf("%s has %.2f in chips and %.2f bet this phase out of %.2f", id, money, phaseBet, bet)
+		}
+		if sameHand {
+			had := gs.PlayersMoney[id] + gs.BetHistory[id]
+			if math.Abs(money+bet-had) > chipTolerance {
+				return fmt.Errorf("%s has %.2f in chips and bets, they had %.2f this hand", id, money+bet, had)
+			}
+			if gs.FoldedPlayers[id] && !s.FoldedPlayers[id] {
+				return fmt.Errorf("%s folded, it has them back in the hand", id)
+			}
+		}
+		theirs += money + bet
+	}
+	if theirs > ours+chipTolerance || len(seated) == len(gs.TurnOrder) && theirs < ours-chipTolerance {
+		return fmt.Errorf("%.2f in chips at the table, we had %.2f", theirs, ours)
+	}
+	return nil
+}
+
+// Replaces the hand with someone elses snapshot of it, anyone who isn't in its turn order has left the table
+// Nothing in it is checked, see CheckSnapshot
+func (gs *GameState) Restore(s Snapshot) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	seated := make(map[peer.ID]bool, len(s.TurnOrder))
+	gs.TurnOrder = make(map[int]peer.ID, len(s.TurnOrder))
+	for i, id := range s.TurnOrder {
+		gs.TurnOrder[i] = id
+		seated[id] = true
+	}
+	for id := range gs.Players {
+		if !seated[id] {
+			delete(gs.Players, id)
+		}
+	}
+
+	gs.Round = s.Round
+	gs.Aborts = s.Aborts
+	gs.Phase = s.Phase
+	gs.WhosTurn = s.WhosTurn
+	gs.Dealer = s.Dealer
+	gs.PlayersMoney = cloneOrEmpty(s.PlayersMoney)
+	gs.BetHistory = cloneOrEmpty(s.BetHistory)
+	gs.PhaseBets = cloneOrEmpty(s.PhaseBets)
+	gs.FoldedPlayers = cloneOrEmpty(s.FoldedPlayers)
+	gs.AllInPlayers = cloneOrEmpty(s.AllInPlayers)
+	gs.PlayedThisPhase = cloneOrEmpty(s.PlayedThisPhase)
+	gs.SomeoneLeft = s.SomeoneLeft
+	gs.MyBet = gs.PhaseBets[gs.Me]
+}
+
+// Decoded snapshots have nil maps where nobody had an entry
+func cloneOrEmpty[V any](m map[peer.ID]V) map[peer.ID]V {
+	if m == nil {
+		return make(map[peer.ID]V)
+	}
+	return maps.Clone(m)
+}
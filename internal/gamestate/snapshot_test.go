@@ -0,0 +1,115 @@
+package gamestate
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	a, b, c := realPeerID(t), realPeerID(t), realPeerID(t) // Only real IDs make it through JSON
+	gs := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 100}, a, b, c)
+	gs.Me = a
+	gs.PostBlinds()
+	gs.PlayerCall(a)
+	gs.PlayerFold(b)
+	gs.NextTurn()
+
+	// Sent over the network as JSON
+	payload, err := json.Marshal(gs.Snapshot())
+	require.NoError(t, err)
+	var snapshot Snapshot
+	require.NoError(t, json.Unmarshal(payload, &snapshot))
+
+	// Whoever dropped missed the call and the fold
+	behind := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 100}, a, b, c)
+	behind.Me = a
+	behind.PostBlinds()
+	behind.Restore(snapshot)
+
+	require.Equal(t, gs.GetTurnOrder(), behind.GetTurnOrder())
+	require.Equal(t, gs.WhosTurn, behind.WhosTurn)
+	require.Equal(t, gs.PlayersMoney, behind.PlayersMoney)
+	require.Equal(t, gs.GetCurrentPot(), behind.GetCurrentPot())
+	require.True(t, behind.FoldedPlayers[b])
+	require.Equal(t, 2.0, behind.MyBet, "our bet this phase comes from the snapshot too")
+
+	// Anyone the table has moved on without has left
+	snapshot.TurnOrder = []peer.ID{a, c}
+	behind.Restore(snapshot)
+	require.False(t, behind.PlayerExists(b))
+	require.Equal(t, 2, behind.GetNumberOfPlayers())
+}
+
+// A snapshot from the hand we were in is only taken if it could have come from it
+func TestCheckSnapshot(t *testing.T) {
+	a, b, c := realPeerID(t), realPeerID(t), realPeerID(t)
+	newState := func() *GameState {
+		gs := newTestState(map[peer.ID]float64{a: 100, b: 100, c: 100}, a, b, c)
+		gs.Me = a
+		gs.Phase = "preflop"
+		gs.PostBlinds()
+		return gs
+	}
+	behind := newState()
+
+	// The table played on without us
+	table := newState()
+	table.PlayerCall(a)
+	table.PlayerFold(b)
+	table.NextTurn()
+	require.NoError(t, behind.CheckSnapshot(table.Snapshot()))
+
+	// And finished the hand, moving the chips around
+	next := table.Snapshot()
+	next.Round++
+	for id, bet := range next.BetHistory {
+		next.PlayersMoney[c] += bet
+		next.BetHistory[id], next.PhaseBets[id] = 0, 0
+	}
+	require.NoError(t, behind.CheckSnapshot(next))
+
+	// Someone left, taking their chips with them
+	left := newState()
+	left.RemovePeerFromState(b)
+	require.NoError(t, behind.CheckSnapshot(left.Snapshot()))
+
+	for name, change := range map[string]func(s *Snapshot){
+		"chips from nowhere":     func(s *Snapshot) { s.PlayersMoney[c] += 50 },
+		"chips moved mid hand":   func(s *Snapshot) { s.PlayersMoney[c] += 10; s.PlayersMoney[a] -= 10 },
+		"chips gone":             func(s *Snapshot) { s.Round++; s.PlayersMoney[c] -= 10 },
+		"negative chips":         func(s *Snapshot) { s.PlayersMoney[a], s.BetHistory[a] = -10, s.BetHistory[a]+110 },
+		"phase bet over the pot": func(s *Snapshot) { s.PhaseBets[c] = s.BetHistory[c] + 1 },
+		"stranger seated":        func(s *Snapshot) { s.TurnOrder = append(s.TurnOrder, realPeerID(t)) },
+		"seated twice":           func(s *Snapshot) { s.TurnOrder = []peer.ID{a, c, c} },
+		"us left out":            func(s *Snapshot) { s.TurnOrder = []peer.ID{b, c} },
+		"turn out of range":      func(s *Snapshot) { s.WhosTurn = 3 },
+		"negative turn":          func(s *Snapshot) { s.WhosTurn = -1 },
+		"dealer out of range":    func(s *Snapshot) { s.Dealer = 5 },
+		"round behind":           func(s *Snapshot) { s.Round-- },
+		"rounds ahead":           func(s *Snapshot) { s.Round += 2 },
+		"unknown phase":          func(s *Snapshot) { s.Phase = "showdown" },
+	} {
+		snapshot := table.Snapshot()
+		change(&snapshot)
+		require.Error(t, behind.CheckSnapshot(snapshot), name)
+	}
+
+	// Nor can a fold we saw be taken back, or the hand go back a phase
+	behind.PlayerFold(b)
+	require.Error(t, behind.CheckSnapshot(newState().Snapshot()), "fold taken back")
+	behind.Phase = "flop"
+	require.Error(t, behind.CheckSnapshot(table.Snapshot()), "back a phase")
+}
+
+func realPeerID(t *testing.T) peer.ID {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	return id
+}
@@ -2,7 +2,9 @@ package gui
 
 import (
 	"fmt"
-	"goker/internal/channelmanager"
+	"goker/internal/eventbus"
+	"goker/internal/tablerules"
+	"strconv"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -15,6 +17,22 @@ var (
 	loopbackAddress string
 	lanAddress      string
 	isHost          bool
+	lobbyMessage    = widget.NewLabel("")
+
+	// Table rules (host only)
+	startingCashEntry     = widget.NewEntry()
+	smallBlindEntry       = widget.NewEntry()
+	bigBlindEntry         = widget.NewEntry()
+	anteEntry             = widget.NewEntry()
+	blindScheduleEntry    = widget.NewMultiLineEntry()
+	turnTimerEntry        = widget.NewEntry()
+	maxPlayersEntry       = widget.NewEntry()
+	timeLockMarginEntry   = widget.NewEntry()
+	reconnectWindowEntry  = widget.NewEntry()
+	shuffleProofsEntry    = widget.NewEntry()
+	bettingStructureEntry = widget.NewSelect([]string{tablerules.NoLimit, tablerules.PotLimit, tablerules.FixedLimit}, nil)
+	cipherEntry           = widget.NewSelect([]string{tablerules.PohligHellman, tablerules.Secp256k1}, nil)
+	primeBitsEntry        = widget.NewSelect(nil, nil)
 
 	// Game
 	boardSize   = fyne.NewSize((234*5)/2, 333/2)   // 234x333 per card
@@ -31,9 +49,13 @@ var (
 	myMoney            = 0.0
 	highestBet         = 0.0
 	myBetsForThisPhase = 0.0
+	minRaise           = 0.0
+	maxRaise           = 0.0
 	valueLabel         = widget.NewLabel(fmt.Sprintf("$%.0f", 0.0))
 	betSlider          = widget.NewSlider(0, 100)
 	potLabel           = widget.NewLabel(fmt.Sprintf("Pot: $%.0f", 0.0))
+	puzzleLabel        = widget.NewLabel("") // Shown while the keys of someone who left are being unlocked
+	connectionLabel    = widget.NewLabel("") // Shown while someone who dropped out has time to reconnect
 )
 
 func initElements() {
@@ -43,23 +65,102 @@ func initElements() {
 	}
 
 	foldButton = widget.NewButton("Fold", func() {
-		channelmanager.FGUI_ActionChan <- channelmanager.ActionType{Action: "Fold"}
+		bus.Actions.Publish(eventbus.ActionType{Action: "Fold"})
 	})
 	raiseButton = widget.NewButton("Raise", func() {
-		if (betSlider.Value <= myMoney) && ((betSlider.Value+myBetsForThisPhase > highestBet) || (highestBet == 0)) {
-			channelmanager.FGUI_ActionChan <- channelmanager.ActionType{Action: "Raise", DataF: betSlider.Value}
+		if betSlider.Value >= minRaise && betSlider.Value <= maxRaise && maxRaise > 0 { // Limits come from the betting structure
+			bus.Actions.Publish(eventbus.ActionType{Action: "Raise", DataF: betSlider.Value})
 		}
 	})
 	callButton = widget.NewButton("Call", func() {
-		if (highestBet-myBetsForThisPhase <= myMoney) && (highestBet != 0) { // If the current highest bet is less than my money, we can call
+		if highestBet != 0 { // If we can't cover the highest bet, calling puts us all-in
 			highestBet = 0 // In case no one raises after us, we obv don't want to be able to call again
-			channelmanager.FGUI_ActionChan <- channelmanager.ActionType{Action: "Call"}
+			bus.Actions.Publish(eventbus.ActionType{Action: "Call"})
 		}
 	})
 	checkButton = widget.NewButton("Check", func() {
 		// Just check.. however I will need to make sure no ones raised yet
 		if highestBet == 0 {
-			channelmanager.FGUI_ActionChan <- channelmanager.ActionType{Action: "Check"}
+			bus.Actions.Publish(eventbus.ActionType{Action: "Check"})
 		}
 	})
+
+	// Fill the rules form with the defaults
+	defaultRules := tablerules.Default()
+	startingCashEntry.SetText(fmt.Sprintf("%g", defaultRules.StartingCash))
+	smallBlindEntry.SetText(fmt.Sprintf("%g", defaultRules.SmallBlind))
+	bigBlindEntry.SetText(fmt.Sprintf("%g", defaultRules.BigBlind))
+	anteEntry.SetText(fmt.Sprintf("%g", defaultRules.Ante))
+	blindScheduleEntry.SetPlaceHolder("round smallBlind bigBlind [ante]")
+	turnTimerEntry.SetText(strconv.Itoa(defaultRules.TurnTimer))
+	maxPlayersEntry.SetText(strconv.Itoa(defaultRules.MaxPlayers))
+	timeLockMarginEntry.SetText(strconv.Itoa(defaultRules.TimeLockMargin))
+	reconnectWindowEntry.SetText(strconv.Itoa(defaultRules.ReconnectWindow))
+	shuffleProofsEntry.SetText(strconv.Itoa(defaultRules.ShuffleProofRounds))
+	bettingStructureEntry.SetSelected(defaultRules.BettingStructure)
+	for _, bits := range tablerules.PrimeSizes {
+		primeBitsEntry.Options = append(primeBitsEntry.Options, strconv.Itoa(bits))
+	}
+	primeBitsEntry.SetSelected(strconv.Itoa(defaultRules.PrimeBits))
+	cipherEntry.OnChanged = func(cipher string) { // The prime size only means something for Pohlig-Hellman
+		if cipher == tablerules.PohligHellman {
+			primeBitsEntry.Enable()
+		} else {
+			primeBitsEntry.Disable()
+		}
+	}
+	cipherEntry.SetSelected(defaultRules.Cipher)
+}
+
+// Builds the table rules from the hosts rules form
+func gatherTableRules() (tablerules.Rules, error) {
+	rules := tablerules.Default()
+
+	floats := []struct {
+		name  string
+		entry *widget.Entry
+		value *float64
+	}{
+		{"starting cash", startingCashEntry, &rules.StartingCash},
+		{"small blind", smallBlindEntry, &rules.SmallBlind},
+		{"big blind", bigBlindEntry, &rules.BigBlind},
+		{"ante", anteEntry, &rules.Ante},
+	}
+	for _, f := range floats {
+		value, err := strconv.ParseFloat(f.entry.Text, 64)
+		if err != nil {
+			return rules, fmt.Errorf("invalid %s: %q", f.name, f.entry.Text)
+		}
+		*f.value = value
+	}
+
+	ints := []struct {
+		name  string
+		entry *widget.Entry
+		value *int
+	}{
+		{"turn timer", turnTimerEntry, &rules.TurnTimer},
+		{"max players", maxPlayersEntry, &rules.MaxPlayers},
+		{"time lock margin", timeLockMarginEntry, &rules.TimeLockMargin},
+		{"reconnect window", reconnectWindowEntry, &rules.ReconnectWindow},
+		{"shuffle proof rounds", shuffleProofsEntry, &rules.ShuffleProofRounds},
+	}
+	for _, i := range ints {
+		value, err := strconv.Atoi(i.entry.Text)
+		if err != nil {
+			return rules, fmt.Errorf("invalid %s: %q", i.name, i.entry.Text)
+		}
+		*i.value = value
+	}
+
+	schedule, err := tablerules.ParseBlindSchedule(blindScheduleEntry.Text)
+	if err != nil {
+		return rules, err
+	}
+	rules.BlindSchedule = schedule
+	rules.BettingStructure = bettingStructureEntry.Selected
+	rules.Cipher = cipherEntry.Selected
+	rules.PrimeBits, _ = strconv.Atoi(primeBitsEntry.Selected) // Only ever one of the options, Validate catches nothing being selected
+
+	return rules, rules.Validate()
 }
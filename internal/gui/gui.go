@@ -2,13 +2,14 @@ package gui
 
 import (
 	"fmt"
-	"goker/internal/channelmanager"
+	"goker/internal/eventbus"
 	"image/color"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 )
 
 var (
@@ -18,10 +19,15 @@ var (
 
 	// Colors
 	BLUE = color.NRGBA{R: 0, G: 173, B: 216, A: 255}
+
+	// Bus of the game this window is for - Set during init
+	bus *eventbus.Bus
 )
 
 // Runner for gui
-func Init() {
+func Init(givenBus *eventbus.Bus) {
+	bus = givenBus
+
 	// Setup GUI
 	myApp := app.New()
 	mainWindow := myApp.NewWindow("Goker")
@@ -29,11 +35,11 @@ func Init() {
 	// Init all scene elements
 	initElements()
 
-	// Listen for updated from GameManager
-	go gmListener(mainWindow)
+	// Listen for updated from GameManager, subscribing first so nothing sent after Init is missed
+	go gmListener(mainWindow, bus.SubscribeFrontEnd())
 
 	// Init everything on the GM side
-	channelmanager.FGUI_ActionChan <- channelmanager.ActionType{Action: "Init"}
+	bus.Actions.Publish(eventbus.ActionType{Action: "Init"})
 
 	// Run the first scene
 	showMenuUI(mainWindow)
@@ -44,33 +50,45 @@ func Init() {
 }
 
 // Since we want to keep logic out of the GUI, this will listen for any updates from specific parts of the state
-func gmListener(window fyne.Window) {
+func gmListener(window fyne.Window, events *eventbus.FrontEnd) {
 	for {
 		select {
-		case hand := <-channelmanager.TGUI_HandChan: // Hand = whatever is coming in from the handChannel
+		case hand := <-events.Hand.C(): // Hand = whatever is coming in from the handChannel
 			updateHandImages(hand)
-		case board := <-channelmanager.TGUI_BoardChan:
+		case board := <-events.Board.C():
 			updateBoardImages(board)
-		case pot := <-channelmanager.TGUI_PotChan:
+		case pot := <-events.Pot.C():
 			updatePot(pot)
-		case numOfPlayers := <-channelmanager.FNET_NumOfPlayersChan: // Gets it straight from the network - This is updated when new players join the lobby
+		case numOfPlayers := <-events.NumOfPlayers.C(): // Gets it straight from the network - This is updated when new players join the lobby
 			updateNumOfPlayers(numOfPlayers)
-		case address := <-channelmanager.TGUI_AddressChan:
+		case address := <-events.Addresses.C():
 			updateAddress(address)
-		case playerInfo := <-channelmanager.TGUI_PlayerInfo:
+		case playerInfo := <-events.PlayerInfo.C():
 			window.SetTitle("Goker - " + playerInfo.Me)
 			updateCards(playerInfo)
-		case <-channelmanager.TGUI_StartRound:
+		case <-events.StartRound.C():
 			showGameScreen(window)
-		case <-channelmanager.TGUI_EndRound:
+		case <-events.EndRound.C():
 			if isHost {
 				showHostUI(window)
 			} else {
 				showConnectedUI(window)
 			}
-		case <-channelmanager.TGUI_ShowLoadingChan:
+		case <-events.ShowLoading.C():
 			showLoadingScreen(window)
-		case host := <-channelmanager.TGUI_MoveToLobby:
+		case rules := <-events.TableRules.C():
+			showRulesApproval(window, rules)
+		case message := <-events.LobbyMessage.C():
+			lobbyMessage.SetText(message)
+		case accusation := <-events.Cheating.C():
+			dialog.ShowInformation("Cheating detected", accusation, window)
+		case abort := <-events.RoundAborted.C():
+			dialog.ShowInformation("Round aborted", abort.String()+"\nBets were refunded.", window)
+		case progress := <-events.PuzzleProgress.C():
+			updatePuzzleProgress(progress)
+		case status := <-events.Connection.C():
+			updateConnectionStatus(status)
+		case host := <-events.MoveToLobby.C():
 			if host {
 				showHostUI(window)
 			} else {
@@ -103,6 +121,24 @@ func updatePot(pot float64) {
 	potLabel.Refresh()
 }
 
+func updatePuzzleProgress(progress eventbus.PuzzleProgress) {
+	if progress.Done {
+		puzzleLabel.SetText("")
+	} else {
+		puzzleLabel.SetText(progress.String())
+	}
+	puzzleLabel.Refresh()
+}
+
+func updateConnectionStatus(status eventbus.ConnectionStatus) {
+	if status.Back {
+		connectionLabel.SetText("")
+	} else {
+		connectionLabel.SetText(status.String())
+	}
+	connectionLabel.Refresh()
+}
+
 func updateNumOfPlayers(players int) {
 	numOfPlayers.SetText(fmt.Sprintf("# of players: %d", players))
 	numOfPlayers.Refresh()
@@ -113,7 +149,7 @@ func updateAddress(addresses []string) {
 	lanAddress = addresses[1]
 }
 
-func updateCards(playerInfo channelmanager.PlayerInfo) {
+func updateCards(playerInfo eventbus.PlayerInfo) {
 	playerCards.Objects = nil
 	highestBet = playerInfo.HighestBet
 
@@ -135,18 +171,25 @@ func updateCards(playerInfo channelmanager.PlayerInfo) {
 	}
 
 	myBetsForThisPhase = playerInfo.MyBetsForThisPhase
+	minRaise = playerInfo.MinRaise
+	maxRaise = playerInfo.MaxRaise
 
 	for playerIndex, playerNickname := range playerInfo.Players {
 		if playerNickname == playerInfo.Me {
 			myMoney = playerInfo.Money[playerIndex]
 		}
 
+		title := playerNickname
+		if playerNickname == playerInfo.Dealer {
+			title += " (D)"
+		}
+
 		var titleText *canvas.Text
 		if playerNickname == playerInfo.WhosTurn {
-			titleText = canvas.NewText(playerNickname, BLUE)
+			titleText = canvas.NewText(title, BLUE)
 
 		} else {
-			titleText = canvas.NewText(playerNickname, color.White)
+			titleText = canvas.NewText(title, color.White)
 		}
 		titleText.TextSize = 18
 		titleText.TextStyle.Bold = true
@@ -163,4 +206,9 @@ func updateCards(playerInfo channelmanager.PlayerInfo) {
 		playerCards.Add(card)
 	}
 	playerCards.Refresh()
+
+	// Raises are limited by the betting structure, and can never be more than we have left
+	betSlider.Min = minRaise
+	betSlider.Max = maxRaise
+	betSlider.Refresh()
 }
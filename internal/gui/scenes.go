@@ -1,7 +1,8 @@
 package gui
 
 import (
-	"goker/internal/channelmanager"
+	"goker/internal/eventbus"
+	"goker/internal/tablerules"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
@@ -31,7 +32,7 @@ func showMenuUI(givenWindow fyne.Window) {
 
 	host := widget.NewButton("Host", func() {
 		if nickname.Text != "" {
-			channelmanager.FGUI_ActionChan <- channelmanager.ActionType{Action: "hostOrConnectPressed", DataS: []string{nickname.Text}}
+			bus.Actions.Publish(eventbus.ActionType{Action: "hostOrConnectPressed", DataS: []string{nickname.Text}})
 			isHost = true
 			showLoadingScreen(givenWindow)
 		}
@@ -40,7 +41,7 @@ func showMenuUI(givenWindow fyne.Window) {
 	connect := widget.NewButton("Connect", func() {
 		if nickname.Text != "" {
 			if inputedAddress.Text != "" {
-				channelmanager.FGUI_ActionChan <- channelmanager.ActionType{Action: "hostOrConnectPressed", DataS: []string{nickname.Text, inputedAddress.Text}}
+				bus.Actions.Publish(eventbus.ActionType{Action: "hostOrConnectPressed", DataS: []string{nickname.Text, inputedAddress.Text}})
 				showLoadingScreen(givenWindow)
 			}
 		}
@@ -53,10 +54,18 @@ func showMenuUI(givenWindow fyne.Window) {
 				container.NewVBox(banner, nickname, host, container.NewGridWithColumns(2, connect, inputedAddress)))))
 }
 
-// Host UI is the same as connectedUI but without settings
+// Host UI is the same as connectedUI but with the table rules settings
 func showHostUI(givenWindow fyne.Window) {
 	playButton := widget.NewButton("Play", func() {
-		channelmanager.FGUI_ActionChan <- channelmanager.ActionType{Action: "startRound"}
+		rules, err := gatherTableRules()
+		if err != nil {
+			lobbyMessage.SetText(err.Error())
+			return
+		}
+		lobbyMessage.SetText("Waiting for everyone to approve the rules...")
+		go func() { // Others take their time approving, so don't hold up the GUI
+			bus.Actions.Publish(eventbus.ActionType{Action: "startRound", Rules: &rules})
+		}()
 	})
 	copyLBAddrButton := widget.NewButton("Copy LB address", func() {
 		givenWindow.Clipboard().SetContent(loopbackAddress)
@@ -65,11 +74,29 @@ func showHostUI(givenWindow fyne.Window) {
 		givenWindow.Clipboard().SetContent(lanAddress)
 	})
 
+	rulesForm := widget.NewForm(
+		widget.NewFormItem("Starting cash", startingCashEntry),
+		widget.NewFormItem("Small blind", smallBlindEntry),
+		widget.NewFormItem("Big blind", bigBlindEntry),
+		widget.NewFormItem("Ante", anteEntry),
+		widget.NewFormItem("Blind schedule", blindScheduleEntry),
+		widget.NewFormItem("Betting", bettingStructureEntry),
+		widget.NewFormItem("Turn timer (s)", turnTimerEntry),
+		widget.NewFormItem("Max players", maxPlayersEntry),
+		widget.NewFormItem("Time lock margin (s)", timeLockMarginEntry),
+		widget.NewFormItem("Reconnect window (s)", reconnectWindowEntry),
+		widget.NewFormItem("Shuffle proof rounds", shuffleProofsEntry),
+		widget.NewFormItem("Card cipher", cipherEntry),
+		widget.NewFormItem("Prime size (bits)", primeBitsEntry),
+	)
+
 	setWindowContent(givenWindow,
 		container.NewCenter(
 			container.NewVBox(
 				numOfPlayers,
 				container.NewHBox(copyLBAddrButton, copyLNAddrButton),
+				container.NewVScroll(rulesForm),
+				lobbyMessage,
 				playButton)))
 }
 
@@ -81,6 +108,29 @@ func showConnectedUI(givenWindow fyne.Window) {
 			container.NewVBox(numOfPlayers, waiting)))
 }
 
+// Shows the hosts table rules to a connected player, play can't start until they approve
+func showRulesApproval(givenWindow fyne.Window, rules tablerules.Rules) {
+	title := widget.NewLabel("The host wants to play with these rules:")
+	rulesLabel := widget.NewLabel(rules.String())
+
+	approveButton := widget.NewButton("Approve", func() {
+		bus.Actions.Publish(eventbus.ActionType{Action: "approveRules"})
+		showConnectedUI(givenWindow)
+	})
+	rejectButton := widget.NewButton("Reject", func() {
+		bus.Actions.Publish(eventbus.ActionType{Action: "rejectRules"})
+		showConnectedUI(givenWindow)
+	})
+
+	setWindowContent(givenWindow,
+		container.NewCenter(
+			container.NewVBox(
+				numOfPlayers,
+				title,
+				rulesLabel,
+				container.NewHBox(approveButton, rejectButton))))
+}
+
 // Main game screen
 func showGameScreen(givenWindow fyne.Window) {
 	setWindowContent(givenWindow,
@@ -91,6 +141,8 @@ func showGameScreen(givenWindow fyne.Window) {
 			container.NewCenter(
 				container.NewVBox(
 					container.NewCenter(potLabel),
+					container.NewCenter(puzzleLabel),
+					container.NewCenter(connectionLabel),
 					boardGrid,
 					container.NewCenter(
 						container.NewHBox(
@@ -0,0 +1,190 @@
+// Package identity keeps a players long-term keys between games, and remembers who they've played with before
+package identity
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Files kept in the profile directory
+const (
+	keystoreFile     = "keystore.json"
+	knownPlayersFile = "known_players.json"
+)
+
+// Version of the keystore format, bump it when the layout or key derivation changes
+const keystoreVersion = 1
+
+// scrypt cost, about a tenth of a second and 32MB to try each passphrase
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Returned when the keystore can't be opened, a wrong passphrase and a modified file look the same
+var ErrWrongPassphrase = errors.New("wrong passphrase, or the keystore has been modified")
+
+// A players long-term keys, the same every time they start the game
+type Identity struct {
+	HostKey crypto.PrivKey // libp2p key, the peer ID is derived from it and commands are signed with it
+
+	Known *KnownPlayers // Everyone played with from this profile
+}
+
+// The keystore as it is on disk, only the key derivation parameters are in the clear
+type keystore struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Sealed  []byte `json:"sealed"` // AES-GCM over the marshalled keys
+}
+
+// What's sealed inside the keystore
+type storedKeys struct {
+	HostKey []byte `json:"hostKey"` // libp2p protobuf encoding
+}
+
+// Opens the identity in the profile directory, making a new one the first time
+// The passphrase can be empty, the keys are then only as safe as the files permissions.
+func Open(dir, passphrase string) (*Identity, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create profile directory: %w", err)
+	}
+
+	path := filepath.Join(dir, keystoreFile)
+	id, err := load(path, passphrase)
+	if errors.Is(err, fs.ErrNotExist) {
+		if id, err = Generate(); err == nil {
+			err = id.save(path, passphrase)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id.Known, err = loadKnownPlayers(filepath.Join(dir, knownPlayersFile))
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// New keys that only last as long as the program, for playing without a profile
+func Generate() (*Identity, error) {
+	hostKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate host key: %w", err)
+	}
+	return &Identity{HostKey: hostKey}, nil
+}
+
+func load(path, passphrase string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var store keystore
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("keystore %s is corrupt: %w", path, err)
+	}
+	if store.Version != keystoreVersion {
+		return nil, fmt.Errorf("unsupported keystore version %d (expected %d)", store.Version, keystoreVersion)
+	}
+
+	aead, err := newAEAD(passphrase, store.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(store.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("keystore %s is corrupt: bad nonce", path)
+	}
+	plaintext, err := aead.Open(nil, store.Nonce, store.Sealed, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	var keys storedKeys
+	if err := json.Unmarshal(plaintext, &keys); err != nil {
+		return nil, fmt.Errorf("keystore %s is corrupt: %w", path, err)
+	}
+	hostKey, err := crypto.UnmarshalPrivateKey(keys.HostKey)
+	if err != nil {
+		return nil, fmt.Errorf("keystore host key: %w", err)
+	}
+	return &Identity{HostKey: hostKey}, nil
+}
+
+// Seals the keys with a fresh salt and nonce and writes them readable by the owner only
+func (id *Identity) save(path, passphrase string) error {
+	hostKey, err := crypto.MarshalPrivateKey(id.HostKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal host key: %w", err)
+	}
+	plaintext, err := json.Marshal(storedKeys{HostKey: hostKey})
+	if err != nil {
+		return err
+	}
+
+	store := keystore{Version: keystoreVersion, Salt: make([]byte, 16)}
+	if _, err := rand.Read(store.Salt); err != nil {
+		return err
+	}
+	aead, err := newAEAD(passphrase, store.Salt)
+	if err != nil {
+		return err
+	}
+	store.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(store.Nonce); err != nil {
+		return err
+	}
+	store.Sealed = aead.Seal(nil, store.Nonce, plaintext, nil)
+
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data)
+}
+
+// AES-256-GCM keyed from the passphrase
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive keystore key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Writes to a temporary file first so a crash never leaves half a file behind
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path) // CreateTemp already made it 0600
+}
@@ -0,0 +1,106 @@
+package identity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "profile")
+
+	first, err := Open(dir, "hunter2")
+	require.NoError(t, err)
+	info, err := os.Stat(filepath.Join(dir, keystoreFile))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "only the owner should read the keystore")
+
+	// The same keys come back every time
+	again, err := Open(dir, "hunter2")
+	require.NoError(t, err)
+	require.True(t, first.HostKey.Equals(again.HostKey))
+	firstID, err := peer.IDFromPrivateKey(first.HostKey)
+	require.NoError(t, err)
+	againID, err := peer.IDFromPrivateKey(again.HostKey)
+	require.NoError(t, err)
+	require.Equal(t, firstID, againID)
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		_, err := Open(dir, "*******")
+		require.ErrorIs(t, err, ErrWrongPassphrase)
+	})
+
+	t.Run("keys aren't stored in the clear", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(dir, keystoreFile))
+		require.NoError(t, err)
+		require.NotContains(t, string(data), "hostKey")
+	})
+
+	t.Run("tampered keystore", func(t *testing.T) {
+		path := filepath.Join(dir, keystoreFile)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		store := append([]byte(nil), data...)
+		store[len(store)/2] ^= 'A' ^ 'B' // Flips a bit in the sealed keys without breaking the base64
+		require.NoError(t, os.WriteFile(path, store, 0o600))
+		t.Cleanup(func() { os.WriteFile(path, data, 0o600) })
+
+		_, err = Open(dir, "hunter2")
+		require.Error(t, err)
+	})
+}
+
+func TestRecognize(t *testing.T) {
+	dir := t.TempDir()
+	id, err := Open(dir, "")
+	require.NoError(t, err)
+
+	alice, bob, mallory := newPeerID(t), newPeerID(t), newPeerID(t)
+
+	recognition, _, err := id.Known.Recognize(alice, "alice")
+	require.NoError(t, err)
+	require.Equal(t, FirstMeeting, recognition)
+	recognition, _, err = id.Known.Recognize(bob, "bob")
+	require.NoError(t, err)
+	require.Equal(t, FirstMeeting, recognition)
+
+	recognition, _, err = id.Known.Recognize(alice, "alice")
+	require.NoError(t, err)
+	require.Equal(t, Recognized, recognition)
+
+	recognition, previous, err := id.Known.Recognize(mallory, "alice")
+	require.NoError(t, err)
+	require.Equal(t, KeyChanged, recognition)
+	require.Equal(t, alice, previous)
+
+	// Someone we know taking another known players name is warned about, and keeps their own
+	recognition, previous, err = id.Known.Recognize(bob, "alice")
+	require.NoError(t, err)
+	require.Equal(t, KeyChanged, recognition)
+	require.Equal(t, alice, previous)
+
+	// Remembered next time, and neither key change overwrote alice
+	reopened, err := Open(dir, "")
+	require.NoError(t, err)
+	recognition, _, err = reopened.Known.Recognize(alice, "alice")
+	require.NoError(t, err)
+	require.Equal(t, Recognized, recognition)
+	recognition, _, err = reopened.Known.Recognize(mallory, "alice")
+	require.NoError(t, err)
+	require.Equal(t, KeyChanged, recognition)
+	recognition, _, err = reopened.Known.Recognize(bob, "bob")
+	require.NoError(t, err)
+	require.Equal(t, Recognized, recognition)
+}
+
+func newPeerID(t *testing.T) peer.ID {
+	t.Helper()
+	id, err := Generate()
+	require.NoError(t, err)
+	peerID, err := peer.IDFromPrivateKey(id.HostKey)
+	require.NoError(t, err)
+	return peerID
+}
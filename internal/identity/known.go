@@ -0,0 +1,87 @@
+package identity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// What a player is to us, from the first time their peer ID and nickname were seen together
+type Recognition int
+
+const (
+	FirstMeeting Recognition = iota // Never seen this peer ID or nickname before, they're trusted from now on
+	Recognized                      // Same peer ID as last time, so the same keys
+	KeyChanged                      // The nickname was last used with a different peer ID, could be someone else
+)
+
+// Players seen before, trust on first use like SSH's known_hosts
+// A key change is only ever warned about, the stored ID is kept until the entry is removed from the file by hand.
+type KnownPlayers struct {
+	mutex   sync.Mutex
+	path    string
+	players map[string]knownPlayer // By peer ID
+}
+
+type knownPlayer struct {
+	Nickname  string    `json:"nickname"`
+	FirstSeen time.Time `json:"firstSeen"`
+}
+
+func loadKnownPlayers(path string) (*KnownPlayers, error) {
+	known := &KnownPlayers{path: path, players: make(map[string]knownPlayer)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return known, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &known.players); err != nil {
+		return nil, fmt.Errorf("known players file %s is corrupt: %w", path, err)
+	}
+	return known, nil
+}
+
+// Checks a player against everyone seen before, a new player is remembered
+// For KeyChanged the peer ID the nickname was last seen with is returned too.
+func (k *KnownPlayers) Recognize(id peer.ID, nickname string) (Recognition, peer.ID, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	for knownID, player := range k.players {
+		if player.Nickname == nickname && knownID != id.String() { // Even someone we know can't take another players name
+			previous, err := peer.Decode(knownID)
+			if err != nil {
+				return KeyChanged, "", fmt.Errorf("known players file has a bad peer ID %q: %w", knownID, err)
+			}
+			return KeyChanged, previous, nil
+		}
+	}
+
+	if player, ok := k.players[id.String()]; ok {
+		if player.Nickname != nickname { // Same keys under a name nobody else has is still them
+			player.Nickname = nickname
+			k.players[id.String()] = player
+			return Recognized, id, k.save()
+		}
+		return Recognized, id, nil
+	}
+
+	k.players[id.String()] = knownPlayer{Nickname: nickname, FirstSeen: time.Now().UTC()}
+	return FirstMeeting, id, k.save()
+}
+
+func (k *KnownPlayers) save() error {
+	data, err := json.MarshalIndent(k.players, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(k.path, data)
+}
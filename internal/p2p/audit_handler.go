@@ -0,0 +1,353 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"fmt"
+	"goker/internal/sra"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// A signed claim that someone cheated during a hand, so it can be passed around and checked by anyone
+type Accusation struct {
+	Accuser   peer.ID `json:"accuser"`
+	Accused   peer.ID `json:"accused"`
+	Round     int     `json:"round"`
+	Reason    string  `json:"reason"`
+	Signature string  `json:"signature"`
+}
+
+func (a *Accusation) signingData() string {
+	return fmt.Sprintf("accusation\n%s\n%s\n%d\n%s", a.Accuser, a.Accused, a.Round, a.Reason)
+}
+
+// For the front end, with nicknames in place of peer IDs
+func (p *GokerPeer) describeAccusation(a Accusation) string {
+	name := func(id peer.ID) string {
+		if nickname, ok := p.gameState.Players[id]; ok {
+			return nickname
+		}
+		return id.String()
+	}
+	return fmt.Sprintf("%s accuses %s of cheating in round %d: %s", name(a.Accuser), name(a.Accused), a.Round, a.Reason)
+}
+
+// A keyring revealed for the audit, signed by its owner so the host can't swap in another when passing it on
+type SignedKeyring struct {
+	Owner     peer.ID `json:"owner"`
+	Table     string  `json:"table"`
+	Round     int     `json:"round"`
+	Keyring   string  `json:"keyring"` // Their KeyringPayload for the hand
+	Signature string  `json:"signature"`
+
+	// Opens the commitment they made to their shuffle in the first step
+	Permutation []int  `json:"permutation"`
+	ShuffleSalt string `json:"shuffleSalt"`
+}
+
+func (k *SignedKeyring) signingData() string {
+	return fmt.Sprintf("keyring\n%s\n%s\n%d\n%s\n%v\n%s", k.Table, k.Owner, k.Round, k.Keyring, k.Permutation, k.ShuffleSalt)
+}
+
+// What the host broadcasts after a hand so everyone can audit it
+type auditPayload struct {
+	Round    int                       `json:"round"`
+	Keyrings map[peer.ID]SignedKeyring `json:"keyrings"` // Everyone the host could reach, signed by each of them
+}
+
+func (p *GokerPeer) signKeyring(round int, keyring string) (SignedKeyring, error) {
+	permutation, salt := p.Deck.PermutationOpening()
+	k := SignedKeyring{
+		Owner:       p.ThisHost.ID(),
+		Table:       p.currentTable(),
+		Round:       round,
+		Keyring:     keyring,
+		Permutation: permutation,
+		ShuffleSalt: hex.EncodeToString(salt),
+	}
+	signature, err := p.Keyring.SignMessage(k.signingData())
+	if err != nil {
+		return SignedKeyring{}, fmt.Errorf("failed to sign keyring: %w", err)
+	}
+	k.Signature = signature
+	return k, nil
+}
+
+// Checks a revealed keyring was signed by its owner, for this table and round
+func (p *GokerPeer) verifyKeyring(owner peer.ID, round int, k SignedKeyring) error {
+	if k.Owner != owner {
+		return fmt.Errorf("keyring for %s claims to be from %s", owner, k.Owner)
+	}
+	if k.Table != p.currentTable() || k.Round != round {
+		return fmt.Errorf("keyring from %s is for round %d at another table, not round %d here", owner, k.Round, round)
+	}
+	if !p.Keyring.VerifySignature(owner, k.signingData(), k.Signature) {
+		return fmt.Errorf("keyring from %s has a bad signature", owner)
+	}
+	return nil
+}
+
+// How long to wait on our own evaluation of the hand when the host asks for our keyring, they may have got there first
+var evaluationWait = 30 * time.Second
+
+// New keys are for a new hand, nothing about them can be revealed until it's been evaluated
+func (p *GokerPeer) handStarted() {
+	p.evaluationMutex.Lock()
+	defer p.evaluationMutex.Unlock()
+	p.evaluatedRound = 0
+	p.evaluated = make(chan struct{})
+}
+
+// Called by the game manager once it has evaluated the hand for a round, our keyring for it can be revealed from here on
+func (p *GokerPeer) HandEvaluated(round int) {
+	p.evaluationMutex.Lock()
+	defer p.evaluationMutex.Unlock()
+	if p.evaluated == nil {
+		p.evaluated = make(chan struct{})
+	}
+	if p.evaluatedRound == 0 {
+		p.evaluatedRound = round
+		close(p.evaluated)
+	}
+}
+
+// Round of the hand we last evaluated, 0 if we're in the middle of one
+func (p *GokerPeer) lastEvaluated() int {
+	p.evaluationMutex.Lock()
+	defer p.evaluationMutex.Unlock()
+	return p.evaluatedRound
+}
+
+// Checks we can reveal our keyring to whoever asked for it, the keys would give away everyones cards before the hand is over
+func (p *GokerPeer) checkRevealRequest(from peer.ID, round int) error {
+	if from != p.tableHost() {
+		return fmt.Errorf("only the host can ask for keyrings")
+	}
+
+	p.evaluationMutex.Lock()
+	if p.evaluated == nil {
+		p.evaluated = make(chan struct{})
+	}
+	evaluated := p.evaluated
+	p.evaluationMutex.Unlock()
+
+	select {
+	case <-evaluated:
+	case <-time.After(evaluationWait):
+		return fmt.Errorf("the hand for round %d isn't over", round)
+	}
+
+	p.evaluationMutex.Lock()
+	defer p.evaluationMutex.Unlock()
+	if round != p.evaluatedRound { // New keys may have been made while we waited
+		return fmt.Errorf("asked for round %d, the last hand we evaluated was round %d", round, p.evaluatedRound)
+	}
+	return nil
+}
+
+// Audits the hand and signs an accusation against anyone whose keys don't match what they did
+// A keyring its owner didn't sign is on whoever passed it on, the owner is left out of the audit like anyone who wasn't reached
+func (p *GokerPeer) accuse(from peer.ID, round int, keyrings map[peer.ID]SignedKeyring) ([]Accusation, error) {
+	checked := make(map[peer.ID]SignedKeyring, len(keyrings))
+	var forged []string
+	for owner, k := range keyrings {
+		if err := p.verifyKeyring(owner, round, k); err != nil {
+			forged = append(forged, err.Error())
+			continue
+		}
+		checked[owner] = k
+	}
+
+	findings := p.auditHand(checked)
+	if len(forged) > 0 {
+		findings[from] = "passed on keyrings their owners didn't sign: " + strings.Join(forged, ", ")
+	}
+
+	var accusations []Accusation
+	for accused, reason := range findings {
+		a, err := p.signAccusation(accused, round, reason)
+		if err != nil {
+			return nil, err
+		}
+		accusations = append(accusations, a)
+	}
+	return accusations, nil
+}
+
+func (p *GokerPeer) signAccusation(accused peer.ID, round int, reason string) (Accusation, error) {
+	a := Accusation{Accuser: p.ThisHost.ID(), Accused: accused, Round: round, Reason: reason}
+	signature, err := p.Keyring.SignMessage(a.signingData())
+	if err != nil {
+		return Accusation{}, fmt.Errorf("failed to sign accusation: %w", err)
+	}
+	a.Signature = signature
+	return a, nil
+}
+
+// Someone sent a key that doesn't match their commitment
+func (p *GokerPeer) reportBadKey(sender peer.ID, err error) {
+	p.reportCheat(sender, "sent a bad key: "+err.Error())
+}
+
+// Same as reportBadKey, for a raise, call, check or fold that wasn't theirs to make
+func (p *GokerPeer) reportIllegalMove(sender peer.ID, err error) {
+	p.reportCheat(sender, "made an illegal move: "+err.Error())
+}
+
+// Signs an accusation against whoever cheated and lets the front end know who
+func (p *GokerPeer) reportCheat(sender peer.ID, reason string) {
+	a, signErr := p.signAccusation(sender, p.gameState.Round, reason)
+	if signErr != nil {
+		log.Printf("reportCheat: %v", signErr)
+		return
+	}
+
+	description := p.describeAccusation(a)
+	log.Println(description)
+	p.bus.Cheating.Publish(description)
+}
+
+// Checks an accusation was really made by who it says, about this round
+func (p *GokerPeer) verifyAccusation(from peer.ID, round int, a Accusation) error {
+	if a.Accuser != from {
+		return fmt.Errorf("accusation from %s claims to be from %s", from, a.Accuser)
+	}
+	if a.Round != round {
+		return fmt.Errorf("accusation from %s is for round %d, not %d", from, a.Round, round)
+	}
+	if !p.Keyring.VerifySignature(a.Accuser, a.signingData(), a.Signature) {
+		return fmt.Errorf("accusation from %s has a bad signature", from)
+	}
+	return nil
+}
+
+// Replays this hands deck with everyones revealed keyring, returning why each peer that doesn't add up should be blamed
+// Each step is checked against the keyring of whoever made it, so a bad key points straight at its owner
+// Shuffles are checked against the permutation each peer committed to before the hand, card for card
+// After that the whole deck is decrypted with everyones keys, every card has to be in the reference deck exactly once
+func (p *GokerPeer) auditHand(keyrings map[peer.ID]SignedKeyring) map[peer.ID]string {
+	findings := make(map[peer.ID]string)
+	if p.shuffleTranscript == nil || p.variationTranscript == nil {
+		return findings // Nothing was dealt
+	}
+
+	revealed := make(map[peer.ID]*sra.RevealedKeyring)
+	payloads := make(map[peer.ID]string)
+	for _, step := range p.shuffleTranscript.Steps {
+		k, ok := keyrings[step.Peer]
+		if !ok { // Couldn't be reached to reveal it, nothing they did can be checked
+			continue
+		}
+		keyring, err := p.Keyring.ParseKeyringPayload(k.Keyring)
+		if err == nil {
+			err = p.checkRevealedKeyring(p.variationTranscript, step.Peer, k.Keyring)
+		}
+		if err != nil {
+			findings[step.Peer] = err.Error()
+			continue
+		}
+		revealed[step.Peer] = keyring
+		payloads[step.Peer] = k.Keyring
+	}
+
+	checkSteps := func(t *deckTranscript, check func(provenDeck, *sra.RevealedKeyring, []*big.Int, []*big.Int) error) {
+		in := t.Start
+		for _, step := range t.Steps {
+			keyring, ok := revealed[step.Peer]
+			if ok && findings[step.Peer] == "" {
+				if err := p.checkRevealedStep(step, keyring, in, check); err != nil {
+					findings[step.Peer] = err.Error()
+				}
+			}
+			in = step.Deck
+		}
+	}
+	checkSteps(p.shuffleTranscript, func(step provenDeck, keyring *sra.RevealedKeyring, in, out []*big.Int) error {
+		return p.checkRevealedPermutation(step, keyrings[step.Peer], keyring, in, out)
+	})
+	checkSteps(p.variationTranscript, func(_ provenDeck, keyring *sra.RevealedKeyring, in, out []*big.Int) error {
+		return p.Keyring.CheckRevealedVariations(keyring, in, out)
+	})
+
+	if len(findings) == 0 && len(revealed) == len(p.shuffleTranscript.Steps) { // The deck needs every key to come apart
+		if err := p.checkRederivedDeck(payloads); err != nil {
+			// Every step added up, so the deck the host started everyone from was wrong
+			findings[p.gameState.TurnOrder[0]] = err.Error()
+		}
+	}
+	return findings
+}
+
+func (p *GokerPeer) checkRevealedStep(step provenDeck, keyring *sra.RevealedKeyring, in string, check func(provenDeck, *sra.RevealedKeyring, []*big.Int, []*big.Int) error) error {
+	inDeck, err := parseDeckPayload(in)
+	if err != nil {
+		return err
+	}
+	outDeck, err := parseDeckPayload(step.Deck)
+	if err != nil {
+		return err
+	}
+	return check(step, keyring, inDeck, outDeck)
+}
+
+// Opens the commitment a peer made to their shuffle and checks every card went where the permutation says
+func (p *GokerPeer) checkRevealedPermutation(step provenDeck, k SignedKeyring, keyring *sra.RevealedKeyring, in, out []*big.Int) error {
+	commitment, err := hex.DecodeString(step.PermutationCommitment)
+	if err != nil {
+		return fmt.Errorf("bad permutation commitment: %w", err)
+	}
+	salt, err := hex.DecodeString(k.ShuffleSalt)
+	if err != nil {
+		return fmt.Errorf("bad shuffle salt: %w", err)
+	}
+	if !VerifyPermutationCommitment(commitment, k.Permutation, salt) {
+		return fmt.Errorf("revealed a shuffle that isn't the one they committed to")
+	}
+	return p.Keyring.CheckRevealedPermutation(keyring, in, out, k.Permutation)
+}
+
+// Takes every peers keys off the final deck, which should leave each reference card exactly once
+func (p *GokerPeer) checkRederivedDeck(keyrings map[peer.ID]string) error {
+	deck, err := parseDeckPayload(p.variationTranscript.final())
+	if err != nil {
+		return err
+	}
+
+	// Keys commute, so each card only needs decrypting once with the product of everyones key for it
+	combined := make([]*big.Int, len(deck))
+	for i := range combined {
+		combined[i] = big.NewInt(1)
+	}
+	for id, payload := range keyrings {
+		keys, err := p.Keyring.GetKeysFromPayload(payload)
+		if err != nil {
+			return fmt.Errorf("keyring from %s: %w", id, err)
+		}
+		if len(keys) < len(deck) {
+			return fmt.Errorf("keyring from %s has %d keys for %d cards", id, len(keys), len(deck))
+		}
+		for i := range deck {
+			combined[i].Mul(combined[i], keys[i])
+		}
+	}
+	for i := range deck {
+		p.Keyring.DecryptWithKey(deck[i], combined[i])
+	}
+
+	seen := make(map[string]bool, len(deck))
+	for i, card := range deck {
+		name, ok := p.Deck.GetCardFromRefDeck(card)
+		if !ok {
+			return fmt.Errorf("card %d doesn't decrypt to a card in the reference deck", i)
+		}
+		if seen[name] {
+			return fmt.Errorf("%s is in the deck more than once", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
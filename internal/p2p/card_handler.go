@@ -1,13 +1,14 @@
 package p2p
 
 import (
+	"bytes"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
-	"goker/internal/channelmanager"
 	"log"
 	"math/big"
-	"math/rand"
 	"strings"
 
 	"fyne.io/fyne/v2/canvas"
@@ -20,6 +21,13 @@ type deckInfo struct {
 	ReferenceDeck map[string]*big.Int
 	// Holds hash's that will be encrypted and shuffled per round
 	RoundDeck []CardInfo
+
+	// Turns a cards hash into a card in the group of the hands cipher, set along with the keys
+	encode func(hash *big.Int) *big.Int
+
+	// My last shuffle, kept secret until the hand is over so it can be audited
+	Permutation []int  // Permutation[i] is the position the card at i came from
+	shuffleSalt []byte // Random salt so the commitment can't be brute forced
 }
 
 // Holds individual card info
@@ -30,6 +38,10 @@ type CardInfo struct {
 	CardKeys       []string // This will hold the keys used to decrypt this card..
 }
 
+// Key every peer hashes the card names with, so everyone's reference deck is the same
+// TODO: Make this decided at runtime? - Should do this more securely in the future
+const DeckKey = "gokerdecksecretkeyforhashesversion1"
+
 var ranks = [...]string{"ace", "2", "3", "4", "5", "6", "7", "8", "9", "10", "jack", "queen", "king"}
 var suits = [...]string{"hearts", "diamonds", "clubs", "spades"}
 
@@ -53,6 +65,9 @@ func (d *deckInfo) GenerateDecks(key string) {
 		for _, rank := range ranks {
 			cardName := suit + "_" + rank
 			cardHash := generateCardHash(cardName, key)
+			if d.encode != nil {
+				cardHash = d.encode(cardHash)
+			}
 
 			// we are setting a copy to the round and ref deck, so later we won't edit the ref deck on accident
 			newRefDeck[cardName] = new(big.Int).Set(cardHash)
@@ -81,11 +96,87 @@ func (d *deckInfo) verifyUniqueHashes() bool {
 	return true
 }
 
-// Shuffle the round deck
+// Shuffle the round deck with a Fisher-Yates shuffle driven by crypto/rand, so nobody can predict our permutation
 func (d *deckInfo) ShuffleRoundDeck() {
-	rand.Shuffle(len(d.RoundDeck), func(i, j int) {
+	d.Permutation = make([]int, len(d.RoundDeck))
+	for i := range d.Permutation {
+		d.Permutation[i] = i
+	}
+
+	for i := len(d.RoundDeck) - 1; i > 0; i-- {
+		j := secureIndex(i + 1)
 		d.RoundDeck[i], d.RoundDeck[j] = d.RoundDeck[j], d.RoundDeck[i]
-	})
+		d.Permutation[i], d.Permutation[j] = d.Permutation[j], d.Permutation[i]
+	}
+
+	d.shuffleSalt = make([]byte, 32)
+	if _, err := rand.Read(d.shuffleSalt); err != nil {
+		log.Fatalf("ShuffleRoundDeck: failed to read random salt: %v", err)
+	}
+}
+
+// Uniform random number in [0, n)
+func secureIndex(n int) int {
+	j, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		log.Fatalf("ShuffleRoundDeck: failed to read random index: %v", err)
+	}
+	return int(j.Int64())
+}
+
+// Commitment to my last shuffle, can be shared before the hand and checked against the revealed permutation after it
+func (d *deckInfo) PermutationCommitment() []byte {
+	return commitToPermutation(d.Permutation, d.shuffleSalt)
+}
+
+// What to reveal after the hand so others can check the commitment
+func (d *deckInfo) PermutationOpening() (permutation []int, salt []byte) {
+	return d.Permutation, d.shuffleSalt
+}
+
+// Checks a revealed permutation and salt against the commitment made before the hand
+func VerifyPermutationCommitment(commitment []byte, permutation []int, salt []byte) bool {
+	seen := make([]bool, len(permutation))
+	for _, from := range permutation {
+		if from < 0 || from >= len(permutation) || seen[from] {
+			return false // Not a permutation
+		}
+		seen[from] = true
+	}
+	return bytes.Equal(commitment, commitToPermutation(permutation, salt))
+}
+
+func commitToPermutation(permutation []int, salt []byte) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	for _, from := range permutation {
+		binary.Write(h, binary.BigEndian, uint32(from))
+	}
+	return h.Sum(nil)
+}
+
+// Copies of the card values in deck order
+func (d *deckInfo) cardValues() []*big.Int {
+	values := make([]*big.Int, len(d.RoundDeck))
+	for i, card := range d.RoundDeck {
+		values[i] = new(big.Int).Set(card.CardValue)
+	}
+	return values
+}
+
+// True if the given cards are exactly the reference deck, in any order
+func (d *deckInfo) isReferenceDeck(cards []*big.Int) bool {
+	if len(cards) != len(d.ReferenceDeck) {
+		return false
+	}
+	seen := make(map[string]bool, len(cards))
+	for _, card := range cards {
+		if _, ok := d.GetCardFromRefDeck(card); !ok || seen[card.String()] {
+			return false
+		}
+		seen[card.String()] = true
+	}
+	return true
 }
 
 // Returns the string of a given hash in the reference deck, ok determines if it's there or not
@@ -151,35 +242,37 @@ func (d *deckInfo) SetDeckInPlace(payload string) {
 	}
 }
 
+// The round decks card values themselves (not copies), so encrypting them changes the deck
+func (d *deckInfo) roundDeckValues() []*big.Int {
+	values := make([]*big.Int, len(d.RoundDeck))
+	for i, card := range d.RoundDeck {
+		values[i] = card.CardValue
+	}
+	return values
+}
+
 // Decrypt round deck with global keys
 func (p *GokerPeer) DecryptAllWithGlobalKeys() {
-	for i := range p.Deck.RoundDeck {
-		p.Keyring.DecryptWithGlobalKeys(p.Deck.RoundDeck[i].CardValue)
-	}
+	p.Keyring.DecryptAllWithGlobalKeys(p.Deck.roundDeckValues())
 }
 
 // Encrypt round deck with global keys
 func (p *GokerPeer) EncryptAllWithGlobalKeys() {
-	for i := range p.Deck.RoundDeck {
-		p.Keyring.EncryptWithGlobalKeys(p.Deck.RoundDeck[i].CardValue)
-	}
+	p.Keyring.EncryptAllWithGlobalKeys(p.Deck.roundDeckValues())
 }
 
 // Encrypt deck with variation numbers
 func (p *GokerPeer) EncryptAllWithVariation() {
+	if err := p.Keyring.EncryptAllWithVariations(p.Deck.roundDeckValues()); err != nil {
+		log.Println(err)
+	}
 	for i := range p.Deck.RoundDeck {
-		err := p.Keyring.EncryptWithVariation(p.Deck.RoundDeck[i].CardValue, i)
-		if err != nil {
-			log.Println(err)
-		}
 		p.Deck.RoundDeck[i].VariationIndex = i
 	}
 }
 
 // Gets the key payload for a players specific cards
-func (p *GokerPeer) GetKeyPayloadForPlayersHand(peerID peer.ID) string {
-	var keys []string
-
+func (p *GokerPeer) GetKeyPayloadForPlayersHand(peerID peer.ID) (string, error) {
 	IDs := p.gameState.GetTurnOrder()
 
 	for i := range IDs {
@@ -188,29 +281,22 @@ func (p *GokerPeer) GetKeyPayloadForPlayersHand(peerID peer.ID) string {
 			cardOneKey := p.Keyring.GetVariationKeyForCard(p.Deck.GetCardFromRoundDeck(i).VariationIndex)
 			cardTwoKey := p.Keyring.GetVariationKeyForCard(p.Deck.GetCardFromRoundDeck(len(IDs) + i).VariationIndex)
 			if cardOneKey == nil || cardTwoKey == nil {
-				log.Fatalf("error: Could not retrieve key for one or both cards")
-				continue
+				return "", fmt.Errorf("could not retrieve key for one or both cards of %s", peerID)
 			}
-			keys = append(keys, cardOneKey.String(), cardTwoKey.String())
-			break
+			return cardOneKey.String() + "\n" + cardTwoKey.String(), nil
 		}
 	}
-
-	if len(keys) != 2 {
-		log.Println("warning: No matching hand found for peer")
-	}
-
-	return strings.Join(keys, "\n")
+	return "", fmt.Errorf("no hand was dealt to %s", peerID)
 }
 
-func (p *GokerPeer) SetHands() {
+func (p *GokerPeer) SetHands() error {
 	IDs := p.gameState.GetTurnOrder()
 
 	for i, id := range IDs {
 		cardOne := p.Deck.GetCardFromRoundDeck(i)
 		cardTwo := p.Deck.GetCardFromRoundDeck(len(IDs) + i)
 		if cardOne.CardValue == nil || cardTwo.CardValue == nil {
-			log.Fatalf("error: Could not set my hand, missing cardOne or cardTwo\n")
+			return fmt.Errorf("could not set hands, missing cards for %s", id)
 		}
 		if id == p.ThisHost.ID() { // put this host in another place
 			p.MyHand = []*CardInfo{cardOne, cardTwo}
@@ -218,6 +304,7 @@ func (p *GokerPeer) SetHands() {
 			p.OthersHands[id] = []*CardInfo{cardOne, cardTwo}
 		}
 	}
+	return nil
 }
 
 // Decrypts my hand in the hands array given to key strings
@@ -263,10 +350,10 @@ func (p *GokerPeer) sendHandToGUI(cardOneName, cardTwoName string) {
 	newHand := make([]*canvas.Image, 0, 2)
 	newHand = append(newHand, cardOne)
 	newHand = append(newHand, cardTwo)
-	channelmanager.TGUI_HandChan <- newHand
+	p.bus.Hand.Publish(newHand)
 }
 
-func (p *GokerPeer) SetBoard() {
+func (p *GokerPeer) SetBoard() error {
 	numOfPlayers := p.gameState.GetNumberOfPlayers()
 
 	cardOne := p.Deck.GetCardFromRoundDeck((numOfPlayers * 2) + 1) // all players hands + burn + first card
@@ -278,12 +365,13 @@ func (p *GokerPeer) SetBoard() {
 	cardFive := p.Deck.GetCardFromRoundDeck((numOfPlayers * 2) + 5)
 
 	if cardOne.CardValue == nil || cardTwo.CardValue == nil || cardThree.CardValue == nil || cardFour.CardValue == nil || cardFive.CardValue == nil {
-		log.Fatalf("error: Could not set board, missing cards \n")
+		return fmt.Errorf("could not set board, missing cards")
 	}
 
 	p.Flop = []*CardInfo{cardOne, cardTwo, cardThree}
 	p.Turn = cardFour
 	p.River = cardFive
+	return nil
 }
 
 func (p *GokerPeer) DecryptFlop(cardOneKeys, cardTwoKeys, cardThreeKeys []string) {
@@ -397,12 +485,10 @@ func (p *GokerPeer) sendBoardToGUI(cardOneName, cardTwoName, cardThreeName, card
 
 	newBoard := make([]*canvas.Image, 0, 5)
 	newBoard = append(newBoard, cardOne, cardTwo, cardThree, cardFour, cardFive)
-	channelmanager.TGUI_BoardChan <- newBoard
+	p.bus.Board.Publish(newBoard)
 }
 
-func (p *GokerPeer) GetKeyPayloadForFlop() string {
-	var keys []string
-
+func (p *GokerPeer) GetKeyPayloadForFlop() (string, error) {
 	numOfPlayers := p.gameState.GetNumberOfPlayers()
 
 	cardOneKey := p.Keyring.GetVariationKeyForCard(p.Deck.GetCardFromRoundDeck((numOfPlayers * 2) + 1).VariationIndex)
@@ -410,39 +496,33 @@ func (p *GokerPeer) GetKeyPayloadForFlop() string {
 	cardThreeKey := p.Keyring.GetVariationKeyForCard(p.Deck.GetCardFromRoundDeck((numOfPlayers * 2) + 3).VariationIndex)
 
 	if cardOneKey == nil || cardTwoKey == nil || cardThreeKey == nil {
-		log.Fatalf("error: Could not retrieve key for cards")
+		return "", fmt.Errorf("could not retrieve keys for the flop")
 	}
-	keys = append(keys, cardOneKey.String(), cardTwoKey.String(), cardThreeKey.String())
-
-	if len(keys) != 3 {
-		log.Println("warning: No matching hand found for peer")
-	}
-
-	return strings.Join(keys, "\n")
+	return strings.Join([]string{cardOneKey.String(), cardTwoKey.String(), cardThreeKey.String()}, "\n"), nil
 }
 
-func (p *GokerPeer) GetKeyPayloadForTurn() string {
+func (p *GokerPeer) GetKeyPayloadForTurn() (string, error) {
 	numOfPlayers := p.gameState.GetNumberOfPlayers()
 
 	turnKey := p.Keyring.GetVariationKeyForCard(p.Deck.GetCardFromRoundDeck((numOfPlayers * 2) + 4).VariationIndex)
 
 	if turnKey == nil {
-		log.Fatalf("error: Could not retrieve key for cards")
+		return "", fmt.Errorf("could not retrieve key for the turn")
 	}
 
-	return turnKey.String()
+	return turnKey.String(), nil
 }
 
-func (p *GokerPeer) GetKeyPayloadForRiver() string {
+func (p *GokerPeer) GetKeyPayloadForRiver() (string, error) {
 	numOfPlayers := p.gameState.GetNumberOfPlayers()
 
 	riverKey := p.Keyring.GetVariationKeyForCard(p.Deck.GetCardFromRoundDeck((numOfPlayers * 2) + 5).VariationIndex)
 
 	if riverKey == nil {
-		log.Fatalf("error: Could not retrieve key for cards")
+		return "", fmt.Errorf("could not retrieve key for the river")
 	}
 
-	return riverKey.String()
+	return riverKey.String(), nil
 }
 
 func (p *GokerPeer) DecryptTurn(turnKeys []string) {
@@ -464,7 +544,12 @@ func (p *GokerPeer) DecryptTurn(turnKeys []string) {
 	if err != nil {
 		return
 	}
-	p.Turn.CardKeys = append(p.Turn.CardKeys, p.GetKeyPayloadForTurn())
+	myKey, err := p.GetKeyPayloadForTurn()
+	if err != nil {
+		log.Printf("DecryptTurn: %v", err)
+		return
+	}
+	p.Turn.CardKeys = append(p.Turn.CardKeys, myKey)
 }
 
 func (p *GokerPeer) DecryptRiver(riverKeys []string) {
@@ -536,24 +621,32 @@ func (p *GokerPeer) DecryptOthersHand(peerID peer.ID, keys []string) {
 	p.OthersHands[peerID][1].CardKeys = cardTwoKeys
 }
 
-func (p *GokerPeer) GetKeyPayloadForMyHand() string {
+func (p *GokerPeer) GetKeyPayloadForMyHand() (string, error) {
 	var allKeys []string
 
-	if p.MyHand[0].CardKeys == nil || p.MyHand[1].CardKeys == nil {
-		log.Fatalf("error: Could not retrieve keys for cards")
+	if len(p.MyHand) != 2 || p.MyHand[0].CardKeys == nil || p.MyHand[1].CardKeys == nil {
+		return "", fmt.Errorf("could not retrieve keys for my hand, it hasn't been decrypted")
 	}
 
 	allKeys = append(allKeys, p.MyHand[0].CardKeys...)
 	allKeys = append(allKeys, p.MyHand[1].CardKeys...)
 
-	return strings.Join(allKeys, "\n")
+	return strings.Join(allKeys, "\n"), nil
 }
 
 // Given a keyring payload, generated in variations.go, we can decrypt the round deck
 // (if a given key has yet to be used on that card)
 func (p *GokerPeer) DecryptRoundDeckWithPayload(payload string) {
 	fmt.Println("Decrypting Round deck with payload, as someone may have folded!")
-	pKeys := p.Keyring.GetKeysFromPayload(payload)
+	pKeys, err := p.Keyring.GetKeysFromPayload(payload)
+	if err != nil {
+		log.Printf("DecryptRoundDeckWithPayload: %v", err)
+		return
+	}
+	if len(pKeys) < len(p.Deck.RoundDeck) {
+		log.Printf("DecryptRoundDeckWithPayload: payload only has %d keys", len(pKeys))
+		return
+	}
 
 	for i := range p.Deck.RoundDeck {
 		wasFlopCard := false
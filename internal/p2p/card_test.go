@@ -1,6 +1,7 @@
 package p2p
 
 import (
+	"math"
 	"math/big"
 	"strings"
 	"testing"
@@ -29,6 +30,93 @@ func TestShuffleRoundDeck(t *testing.T) {
 	}
 }
 
+// Every card should be equally likely to end up in every position. Over all 52x52 (card, position) cells the
+// chi-square statistic has (52-1)^2 degrees of freedom, fail only if it's far past what a fair shuffle would give
+func TestShuffleRoundDeckIsUniform(t *testing.T) {
+	const trials = 10000
+	deck := &deckInfo{}
+	deck.GenerateDecks("testkey")
+	size := len(deck.RoundDeck)
+
+	counts := make([][]int, size) // counts[card][position]
+	for i := range counts {
+		counts[i] = make([]int, size)
+	}
+
+	original := append([]CardInfo(nil), deck.RoundDeck...)
+	for trial := 0; trial < trials; trial++ {
+		copy(deck.RoundDeck, original) // Always shuffle from the same order
+		deck.ShuffleRoundDeck()
+		for position, card := range deck.RoundDeck {
+			counts[card.index][position]++
+		}
+	}
+
+	expected := float64(trials) / float64(size)
+	chiSquare := 0.0
+	for _, row := range counts {
+		for _, observed := range row {
+			diff := float64(observed) - expected
+			chiSquare += diff * diff / expected
+		}
+	}
+
+	degreesOfFreedom := float64((size - 1) * (size - 1))
+	limit := degreesOfFreedom + 6*math.Sqrt(2*degreesOfFreedom) // Roughly normal at this many degrees of freedom
+	if chiSquare > limit {
+		t.Errorf("Shuffle is biased: chi-square %.1f is above %.1f", chiSquare, limit)
+	}
+}
+
+func TestSecureIndexIsUniform(t *testing.T) {
+	const trials, n = 30000, 3
+	counts := make([]int, n)
+	for i := 0; i < trials; i++ {
+		counts[secureIndex(n)]++
+	}
+
+	expected := float64(trials) / n
+	chiSquare := 0.0
+	for _, observed := range counts {
+		diff := float64(observed) - expected
+		chiSquare += diff * diff / expected
+	}
+	if chiSquare > 20 { // p < 0.0001 with 2 degrees of freedom
+		t.Errorf("secureIndex is biased: counts %v", counts)
+	}
+}
+
+func TestPermutationCommitment(t *testing.T) {
+	deck := &deckInfo{}
+	deck.GenerateDecks("testkey")
+	deck.ShuffleRoundDeck()
+
+	commitment := deck.PermutationCommitment()
+	permutation, salt := deck.PermutationOpening()
+
+	for i, card := range deck.RoundDeck {
+		if card.index != permutation[i] {
+			t.Fatalf("Permutation says position %d came from %d, but the card came from %d", i, permutation[i], card.index)
+		}
+	}
+
+	if !VerifyPermutationCommitment(commitment, permutation, salt) {
+		t.Errorf("Commitment did not verify against its own opening")
+	}
+
+	swapped := append([]int(nil), permutation...)
+	swapped[0], swapped[1] = swapped[1], swapped[0]
+	if VerifyPermutationCommitment(commitment, swapped, salt) {
+		t.Errorf("Commitment verified against a different permutation")
+	}
+
+	duplicated := append([]int(nil), permutation...)
+	duplicated[0] = duplicated[1]
+	if VerifyPermutationCommitment(commitToPermutation(duplicated, salt), duplicated, salt) {
+		t.Errorf("A list with a repeated position was accepted as a permutation")
+	}
+}
+
 func TestGenerateDeckPayload(t *testing.T) {
 	deck := &deckInfo{}
 	deck.GenerateDecks("testkey")
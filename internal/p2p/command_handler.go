@@ -1,10 +1,17 @@
 package p2p
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"goker/internal/channelmanager"
+	"goker/internal/eventbus"
+	"goker/internal/gamestate"
+	"goker/internal/identity"
+	"goker/internal/sra"
+	"goker/internal/tablerules"
+	"io"
 	"log"
 	"strings"
 	"sync"
@@ -15,18 +22,20 @@ import (
 
 // Uses Command Design Pattern
 type PeerCommand interface {
-	Execute(peer *GokerPeer)
-	Respond(peer *GokerPeer, sendingStream network.Stream)
+	Execute(peer *GokerPeer) error
+	Respond(peer *GokerPeer, sendingStream network.Stream) error
 }
 
-// Execute a command
-func (p *GokerPeer) ExecuteCommand(pCmd PeerCommand) {
-	pCmd.Execute(p)
+// Execute a command, errors say which peer is to blame when there is one (see Offender)
+func (p *GokerPeer) ExecuteCommand(pCmd PeerCommand) error {
+	return pCmd.Execute(p)
 }
 
-// Respond to a command
+// Respond to a command, if this fails whoever sent it finds out when their response never comes
 func (p *GokerPeer) RespondToCommand(pCmd PeerCommand, stream network.Stream) {
-	pCmd.Respond(p, stream)
+	if err := pCmd.Respond(p, stream); err != nil {
+		log.Printf("Failed to respond to %s: %v", stream.Conn().RemotePeer(), err)
+	}
 }
 
 // Structure for messages to be sent over the network
@@ -35,10 +44,18 @@ type NetworkCommand struct {
 	Payload   any     `json:"payload"`
 	Signature string  `json:"signature"`
 	Tag       *uint64 `json:"tag,omitempty"` // omitempty makes the tag field 'optional' (i.e. it won't even show up if there is nothing there)
+	Envelope          // Filled in when signing, see envelope_handler.go
 }
 
-// Send a network command to a specific stream
+// Send a network command to a specific stream, in whichever protocol was negotiated for it
 func sendCommand(stream network.Stream, nCmd NetworkCommand) error {
+	if stream.Protocol() == binaryProtocolID {
+		if err := writeCommand(stream, nCmd); err != nil {
+			return fmt.Errorf("failed to send command: %w", err)
+		}
+		return nil
+	}
+
 	encoder := json.NewEncoder(stream)
 	if err := encoder.Encode(nCmd); err != nil {
 		return fmt.Errorf("failed to send command: %w", err)
@@ -46,30 +63,66 @@ func sendCommand(stream network.Stream, nCmd NetworkCommand) error {
 	return nil
 }
 
-// Recieve a network command from a specifc stream
-func receiveResponse(stream network.Stream) (NetworkCommand, error) {
+// Recieve a network command from a specifc stream, in whichever protocol was negotiated for it
+// Nothing bigger than limit is read, and the payload has to be one the command can carry
+func receiveResponse(stream network.Stream, limit int) (NetworkCommand, error) {
 	var cmd NetworkCommand
-	decoder := json.NewDecoder(stream)
-
-	if err := decoder.Decode(&cmd); err != nil {
+	var err error
+	if stream.Protocol() == binaryProtocolID {
+		cmd, err = readCommand(stream, limit)
+	} else {
+		decoder := json.NewDecoder(io.LimitReader(stream, int64(limit)*jsonFrameFactor))
+		err = decoder.Decode(&cmd)
+	}
+	if err == nil {
+		err = checkPayload(cmd)
+	}
+	if err != nil {
 		return NetworkCommand{}, fmt.Errorf("recieveResponse: failed to decode command: %w", err)
 	}
 
 	return cmd, nil
 }
 
-func (p *GokerPeer) signCommand(nCmd *NetworkCommand) {
-	signingData := nCmd.Command
-	if nCmd.Payload != nil {
-		payloadJSON, _ := json.Marshal(nCmd.Payload)
-		signingData += string(payloadJSON)
+// Typed payloads come back out of JSON as what was sent, instead of a map
+func (nCmd *NetworkCommand) UnmarshalJSON(b []byte) error {
+	type fields NetworkCommand // Without this method, so it doesn't call itself
+	var raw struct {
+		fields
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
 	}
+	*nCmd = NetworkCommand(raw.fields)
 
-	if nCmd.Tag != nil { // Add tag if present
-		signingData += fmt.Sprintf("%d", *nCmd.Tag)
+	payload := bytes.TrimSpace(raw.Payload)
+	switch {
+	case len(payload) == 0:
+	case payload[0] == '{':
+		message := newCommandMessage(nCmd.Command)
+		if message == nil {
+			return fmt.Errorf("%s can't carry a message", nCmd.Command)
+		}
+		if err := json.Unmarshal(payload, message); err != nil {
+			return err
+		}
+		nCmd.Payload = message
+	default: // Strings, amounts or null, anything else is refused by checkPayload
+		return json.Unmarshal(payload, &nCmd.Payload)
 	}
+	return nil
+}
+
+// Biggest command a peer has reason to send us with the table rules as they are
+func (p *GokerPeer) frameLimit() int {
+	return maxFrameSize(p.gameState.Rules)
+}
 
-	signature, err := p.Keyring.SignMessage(signingData)
+// Seals a command in an envelope and signs the lot
+func (p *GokerPeer) signCommand(nCmd *NetworkCommand) {
+	p.seal(nCmd)
+	signature, err := p.Keyring.SignMessage(nCmd.signingData())
 	if err != nil {
 		log.Fatalf("signCommand: failed to sign request: %v", err)
 	}
@@ -77,119 +130,354 @@ func (p *GokerPeer) signCommand(nCmd *NetworkCommand) {
 	nCmd.Signature = signature
 }
 
-func (p *GokerPeer) verifyCommand(from peer.ID, nCmd *NetworkCommand) {
-	signingData := nCmd.Command
-	if nCmd.Payload != nil {
-		payloadJSON, _ := json.Marshal(nCmd.Payload)
-		signingData += string(payloadJSON)
+// Checks a command came from who it says, hasn't been seen before, and is for the round and phase we're in
+func (p *GokerPeer) verifyCommand(from peer.ID, nCmd *NetworkCommand) error {
+	if err := p.checkSignature(from, nCmd); err != nil {
+		return err
+	}
+
+	// Ensure game commands always have a tag
+	if isGameCommand(nCmd.Command) {
+		if nCmd.Tag == nil {
+			return peerErr(nCmd.Command, from, ErrBadTag, "missing tag for game command")
+		}
+		if *nCmd.Tag != p.tag.Load() {
+			return peerErr(nCmd.Command, from, ErrBadTag, "invalid tag %d (expected %d)", *nCmd.Tag, p.tag.Load())
+		}
 	}
-	if nCmd.Tag != nil { // Add tag if present
-		signingData += fmt.Sprintf("%d", *nCmd.Tag)
+	if err := p.openEnvelope(from, nCmd); err != nil {
+		return err
+	}
+	return p.checkTiming(from, nCmd)
+}
+
+// Same as verifyCommand for the answer to one of our own commands, minus the tag
+// The answer is to whatever phase we asked in, and the host may have pushed the next tag to either of us since
+func (p *GokerPeer) verifyResponse(from peer.ID, nCmd *NetworkCommand) error {
+	if err := p.checkSignature(from, nCmd); err != nil {
+		return err
 	}
+	return p.openEnvelope(from, nCmd)
+}
 
-	if !p.Keyring.VerifySignature(from, signingData, nCmd.Signature) {
-		log.Println(nCmd.Command)
-		log.Fatalf("verifyCommand: invalid signature in response from %s\n", from)
+// Checks a command is signed by who it came from
+func (p *GokerPeer) checkSignature(from peer.ID, nCmd *NetworkCommand) error {
+	if nCmd.Sender != from {
+		return peerErr(nCmd.Command, from, ErrBadEnvelope, "signed as sent by %s", nCmd.Sender)
 	}
+	if !p.Keyring.VerifySignature(from, nCmd.signingData(), nCmd.Signature) {
+		return peerErr(nCmd.Command, from, ErrBadSignature, "invalid signature")
+	}
+	return nil
+}
 
-	// Ensure game commands always have a tag
-	gameCommands := []string{"Raise", "Check", "Call", "Fold"}
-	for _, cmd := range gameCommands {
-		if strings.EqualFold(nCmd.Command, cmd) {
-			if nCmd.Tag == nil {
-				log.Fatalf("verifyCommand: missing tag for game command: %s\n", nCmd.Command)
-			}
-			if *nCmd.Tag != p.tag {
-				log.Fatalf("verifyCommand: invalid tag %d for game command: %s (expected %d)\n", *nCmd.Tag, nCmd.Command, p.tag)
-			}
+// Opens a stream to a peer and sends them a command, the caller closes the stream when done with it
+// The binary protocol is used if they speak it, otherwise JSON
+func (p *GokerPeer) sendTo(peerID peer.ID, nCmd NetworkCommand) (network.Stream, error) {
+	// Never dials, someone who dropped only comes back through the redialling in reconnect_handler.go and catches up from there
+	ctx := network.WithNoDial(context.Background(), "commands only go to connected peers")
+	stream, err := p.ThisHost.NewStream(ctx, peerID, binaryProtocolID, protocolID)
+	if err != nil {
+		return nil, peerErr(nCmd.Command, peerID, ErrUnreachable, "failed to create stream: %v", err)
+	}
+	if err := sendCommand(stream, nCmd); err != nil {
+		stream.Reset()
+		return nil, peerErr(nCmd.Command, peerID, ErrUnreachable, "%v", err)
+	}
+	return stream, nil
+}
+
+// Sends a command that doesn't get a response
+func (p *GokerPeer) notify(peerID peer.ID, nCmd NetworkCommand) error {
+	stream, err := p.sendTo(peerID, nCmd)
+	if err != nil {
+		return err
+	}
+	stream.Close()
+	return nil
+}
+
+// Sends a command and waits for the peers signed response
+func (p *GokerPeer) request(peerID peer.ID, nCmd NetworkCommand) (NetworkCommand, error) {
+	stream, err := p.sendTo(peerID, nCmd)
+	if err != nil {
+		return NetworkCommand{}, err
+	}
+	defer stream.Close()
+
+	response, err := receiveResponse(stream, p.frameLimit())
+	if err != nil && answeredNothing(err) {
+		return NetworkCommand{}, peerErr(nCmd.Command, peerID, ErrUnreachable, "%v", err)
+	}
+	if err != nil {
+		return NetworkCommand{}, peerErr(nCmd.Command, peerID, ErrBadResponse, "%v", err)
+	}
+	if err := p.verifyResponse(peerID, &response); err != nil {
+		return NetworkCommand{}, err
+	}
+	return response, nil
+}
+
+// Nothing came back at all, they went away or hung up on us - anything they did send that can't be read is on them
+func answeredNothing(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, network.ErrReset)
+}
+
+// Same as request, for the commands that are answered with a string
+func (p *GokerPeer) requestString(peerID peer.ID, nCmd NetworkCommand) (string, error) {
+	response, err := p.request(peerID, nCmd)
+	if err != nil {
+		return "", err
+	}
+	payload, ok := response.Payload.(string)
+	if !ok {
+		return "", peerErr(nCmd.Command, peerID, ErrBadResponse, "expected string, got %T", response.Payload)
+	}
+	return payload, nil
+}
+
+// Same as requestString, for commands the peer has to answer with want ("DONE" or "APPROVED"), anything else is them rejecting it
+func (p *GokerPeer) requestApproval(peerID peer.ID, nCmd NetworkCommand, want string) error {
+	answer, err := p.requestString(peerID, nCmd)
+	if err != nil {
+		return err
+	}
+	if answer != want {
+		return peerErr(nCmd.Command, peerID, ErrRejected, "%s", strings.TrimPrefix(answer, "REJECTED: "))
+	}
+	return nil
+}
+
+// Signs a response and sends it back down the stream its command came in on
+func (p *GokerPeer) respond(stream network.Stream, response NetworkCommand) error {
+	p.signCommand(&response)
+	if err := sendCommand(stream, response); err != nil {
+		return fmt.Errorf("%s: %w", response.Command, err)
+	}
+	return nil
+}
+
+// Everyone in the peer list but us - The caller holds the peer list lock
+func (p *GokerPeer) otherPeers() []peer.ID {
+	var IDs []peer.ID
+	for _, peerInfo := range p.peerList {
+		if peerInfo.ID != p.ThisHost.ID() {
+			IDs = append(IDs, peerInfo.ID)
 		}
 	}
+	return IDs
+}
+
+// Runs fn for every peer at once and waits for all of them, returning all their errors joined together
+func forEachConcurrently(peerIDs []peer.ID, fn func(peerID peer.ID) error) error {
+	var wg sync.WaitGroup
+	var errsMutex sync.Mutex
+	var errs []error
+
+	for _, peerID := range peerIDs {
+		wg.Add(1)
+
+		go func(peerID peer.ID) {
+			defer wg.Done()
+			if err := fn(peerID); err != nil {
+				errsMutex.Lock()
+				errs = append(errs, err)
+				errsMutex.Unlock()
+			}
+		}(peerID)
+	}
+
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Once a hand is dealt, a peer we can't reach has most likely left - they're taken out of the state when their reconnect window runs out and their puzzle stands in for their keys
+// So commands during the hand skip them instead of failing
+func skipUnreachable(err error) bool {
+	if errors.Is(err, ErrUnreachable) {
+		log.Printf("%v, skipping them", err)
+		return true
+	}
+	return false
+}
+
+// Commands that set up the table, deal, or move everyone on, only the host runs those
+var hostCommands = map[string]bool{
+	"InitTable":        true,
+	"NewKeys":          true,
+	"ProtocolFS":       true,
+	"BroadcastNewDeck": true,
+	"ProtocolSS":       true,
+	"BroadcastDeck":    true,
+	"PushTag":          true,
+	"CanRequestHand":   true,
+	"MoveToTable":      true,
+	"CanRequestPuzzle": true,
 }
 
 // Handle incoming streams (should be commands only)
 func (p *GokerPeer) handleStream(stream network.Stream) {
 	defer stream.Close()
 
-	// Create a JSON decoder for reading the incoming command
-	decoder := json.NewDecoder(stream)
-	var nCmd NetworkCommand
-
 	// Decode the command
-	if err := decoder.Decode(&nCmd); err != nil {
+	nCmd, err := receiveResponse(stream, p.frameLimit())
+	if err != nil {
 		log.Printf("Failed to decode incoming network command: %v", err)
 		return
 	}
 
-	// If it's PushTag, update p.tag first before verifying
-	if nCmd.Command == "PushTag" && nCmd.Tag != nil {
-		p.tag = *nCmd.Tag
-	}
+	p.awaitResync() // Anything sent to us after we got back is about the hand as it is now
 
-	if nCmd.Command != "GetPeers" && nCmd.Command != "PubKeyExchange" {
-		p.verifyCommand(stream.Conn().RemotePeer(), &nCmd)
+	if err := p.verifyCommand(stream.Conn().RemotePeer(), &nCmd); err != nil {
+		log.Printf("Dropping command: %v", err)
+		return
 	}
 
 	log.Println("Handling response to: " + nCmd.Command)
 
+	// Most commands carry a string, the rest are picked out in their case
+	// receiveResponse already refused any payload the command can't carry, so this is only empty for those and for no payload at all
+	payload, _ := nCmd.Payload.(string)
+
+	// A good signature proves who sent a command, not that it was theirs to send
+	if hostCommands[nCmd.Command] && stream.Conn().RemotePeer() != p.tableHost() {
+		log.Printf("%s: ignoring it from %s, they aren't the host", nCmd.Command, stream.Conn().RemotePeer())
+		return
+	}
+
 	// Process the command based on the message
 	// These commands are in order for which they should be called
 	switch nCmd.Command {
 	case "GetPeers":
 		p.RespondToCommand(&GetPeerListCommand{}, stream)
-	case "PubKeyExchange":
-		p.Keyring.SetPeerPublicKey(stream.Conn().RemotePeer(), nCmd.Payload.(string))
-		p.RespondToCommand(&PubKeyExchangeCommand{}, stream)
 	case "NicknameRequest":
 		p.RespondToCommand(&NicknameRequestCommand{}, stream)
 	case "InitTable":
-		p.SetTurnOrderWithLobby()
-		p.gameState.FreshStateFromPayload(nCmd.Payload.(string))
-		channelmanager.TGUI_PlayerInfo <- p.gameState.GetPlayerInfo()
-		p.RespondToCommand(&InitTableCommand{}, stream) // Respond with DONE
-	case "SendPQ":
-		channelmanager.TGUI_ShowLoadingChan <- struct{}{}
-		pq := strings.Split(string(nCmd.Payload.(string)), "\n")
-		p.Keyring.SetPQ(pq[0], pq[1])
-		p.Keyring.GenerateKeys()
-		p.RespondToCommand(&SendPQCommand{}, stream) // Respond with DONE
+		initTable := &InitTableCommand{}
+		rules, err := tablerules.Decode(payload)
+		if err == nil && p.gameState.GetNumberOfPlayers() > rules.MaxPlayers {
+			err = fmt.Errorf("table has more than %d players", rules.MaxPlayers)
+		}
+
+		if err != nil {
+			log.Printf("InitTable: rejecting table rules: %v", err)
+			initTable.Rejection = err.Error()
+		} else {
+			answers := p.bus.RulesAnswer.Subscribe()
+			p.bus.TableRules.Publish(rules) // Let the player look over the rules before playing
+			answer := <-answers.C()
+			answers.Close()
+			if answer.Action == "approveRules" {
+				p.SetTurnOrderWithLobby()
+				p.gameState.FreshState(rules)
+				p.bus.PlayerInfo.Publish(p.gameState.GetPlayerInfo())
+			} else {
+				initTable.Rejection = "rules were declined"
+			}
+		}
+		p.RespondToCommand(initTable, stream) // Respond with DONE or why we won't play
+	case "NewKeys":
+		newKeys := &NewKeysCommand{}
+		cipher, err := p.tableCipher()
+		if err != nil {
+			newKeys.Rejection = err.Error()
+		} else if payload != cipher.Name() {
+			newKeys.Rejection = fmt.Sprintf("asked for %s, the table rules say %s", payload, cipher.Name())
+		} else {
+			p.bus.ShowLoading.Publish(struct{}{})
+			if err := p.newKeys(cipher); err != nil {
+				newKeys.Rejection = err.Error()
+			}
+		}
+		if newKeys.Rejection != "" {
+			log.Printf("NewKeys: rejecting: %s", newKeys.Rejection)
+		}
+		p.RespondToCommand(newKeys, stream) // Respond with DONE
 	case "ProtocolFS": // First step of Protocol
-		p.Deck.SetNewDeck(nCmd.Payload.(string))
+		p.Deck.SetNewDeck(payload)
 		p.RespondToCommand(&ProtocolFirstStepCommand{}, stream)
 	case "BroadcastNewDeck": // First shuffled deck (will be shuffled so needs to be set as a new deck)
-		p.Deck.SetNewDeck(nCmd.Payload.(string))
-		p.RespondToCommand(&BroadcastNewDeck{}, stream)
+		broadcast := &BroadcastNewDeck{}
+		transcript, _ := nCmd.Payload.(*deckTranscript) // Nil if they sent nothing, which is refused
+		if err := p.acceptShuffleTranscript(transcript); err != nil {
+			log.Printf("BroadcastNewDeck: rejecting deck: %v", err)
+			broadcast.Rejection = err.Error()
+		}
+		p.RespondToCommand(broadcast, stream)
 	case "ProtocolSS": // Second step of Protocol
-		p.Deck.SetDeckInPlace(nCmd.Payload.(string))
+		p.Deck.SetDeckInPlace(payload)
 		p.RespondToCommand(&ProtocolSecondStepCommand{}, stream)
 	case "BroadcastDeck": // Final shuffled deck
-		p.Deck.SetDeckInPlace(nCmd.Payload.(string))
-		p.SetHands()
-		p.SetBoard()
-		p.RespondToCommand(&BroadcastDeck{}, stream)
-	case "PushTag":
-		return
+		broadcast := &BroadcastDeck{}
+		transcript, _ := nCmd.Payload.(*deckTranscript)
+		if err := p.acceptVariationTranscript(transcript); err != nil {
+			log.Printf("BroadcastDeck: rejecting deck: %v", err)
+			broadcast.Rejection = err.Error()
+		} else if err := errors.Join(p.SetHands(), p.SetBoard()); err != nil {
+			log.Printf("BroadcastDeck: %v", err)
+			broadcast.Rejection = err.Error()
+		}
+		p.RespondToCommand(broadcast, stream)
+	case "PushTag": // Only the host moves the table on to the next phase
+		if nCmd.Tag == nil {
+			log.Printf("PushTag: no tag from %s", stream.Conn().RemotePeer())
+			return
+		}
+		p.tag.Store(*nCmd.Tag)
 	case "CanRequestHand":
-		p.ExecuteCommand(&RequestHandCommand{})
+		if err := p.ExecuteCommand(&RequestHandCommand{}); err != nil {
+			p.bus.CommandFailed.Publish(err)
+		}
 	case "RequestHand": // Someone is requesting the keys to their hand
 		p.RespondToCommand(&RequestHandCommand{}, stream)
 	case "MoveToTable":
-		channelmanager.TGUI_StartRound <- struct{}{} // Tell GUI to move to the table UI
+		p.bus.StartRound.Publish(struct{}{}) // Tell GUI to move to the table UI
 	case "Raise":
-		p.gameState.PlayerRaise(stream.Conn().RemotePeer(), nCmd.Payload.(float64))
-		p.RespondToCommand(&RaiseCommand{}, stream)
+		amount, ok := nCmd.Payload.(float64)
+		if !ok {
+			log.Printf("Raise: expected an amount, got %T", nCmd.Payload)
+			return
+		}
+		raise := &RaiseCommand{}
+		if raise.Rejection = p.checkMove(stream.Conn().RemotePeer(), "Raise", amount); raise.Rejection != "" {
+			p.RespondToCommand(raise, stream)
+			return
+		}
+		p.gameState.PlayerRaise(stream.Conn().RemotePeer(), amount)
+		p.RespondToCommand(raise, stream)
 		p.gameState.NextTurn()
 	case "Fold":
-		p.DecryptRoundDeckWithPayload(nCmd.Payload.(string))
+		fold := &FoldCommand{}
+		if fold.Rejection = p.checkMove(stream.Conn().RemotePeer(), "Fold", 0); fold.Rejection != "" {
+			p.RespondToCommand(fold, stream)
+			return
+		}
+		if err := p.checkRevealedKeyring(p.variationTranscript, stream.Conn().RemotePeer(), payload); err != nil {
+			p.reportBadKey(stream.Conn().RemotePeer(), err)
+		} else {
+			p.DecryptRoundDeckWithPayload(payload)
+			p.keyringRevealed(stream.Conn().RemotePeer(), payload)
+		}
 		p.gameState.PlayerFold(stream.Conn().RemotePeer())
-		p.RespondToCommand(&FoldCommand{}, stream)
+		p.RespondToCommand(fold, stream)
 		p.gameState.NextTurn()
 	case "Call":
+		call := &CallCommand{}
+		if call.Rejection = p.checkMove(stream.Conn().RemotePeer(), "Call", 0); call.Rejection != "" {
+			p.RespondToCommand(call, stream)
+			return
+		}
 		p.gameState.PlayerCall(stream.Conn().RemotePeer())
-		p.RespondToCommand(&CallCommand{}, stream)
+		p.RespondToCommand(call, stream)
 		p.gameState.NextTurn()
 	case "Check":
+		check := &CheckCommand{}
+		if check.Rejection = p.checkMove(stream.Conn().RemotePeer(), "Check", 0); check.Rejection != "" {
+			p.RespondToCommand(check, stream)
+			return
+		}
 		p.gameState.PlayerCheck(stream.Conn().RemotePeer())
-		p.RespondToCommand(&CheckCommand{}, stream)
+		p.RespondToCommand(check, stream)
 		p.gameState.NextTurn()
 	case "RequestFlop":
 		p.RespondToCommand(&RequestFlop{}, stream)
@@ -199,10 +487,53 @@ func (p *GokerPeer) handleStream(stream network.Stream) {
 		p.RespondToCommand(&RequestRiver{}, stream)
 	case "RequestOthersHand":
 		p.RespondToCommand(&RequestOthersHands{}, stream)
+	case "RevealKeyring":
+		reveal := &RevealKeyringCommand{Round: nCmd.Round}
+		if err := p.checkRevealRequest(stream.Conn().RemotePeer(), nCmd.Round); err != nil {
+			log.Printf("RevealKeyring: refusing %s: %v", stream.Conn().RemotePeer(), err)
+			reveal.Rejection = err.Error()
+		}
+		p.RespondToCommand(reveal, stream)
+	case "Audit":
+		audit := &AuditCommand{}
+		if keyrings, ok := nCmd.Payload.(*auditPayload); !ok {
+			log.Printf("Audit: expected keyrings, got %T", nCmd.Payload)
+		} else if accusations, err := p.accuse(stream.Conn().RemotePeer(), keyrings.Round, keyrings.Keyrings); err != nil {
+			log.Printf("Audit: %v", err)
+		} else {
+			audit.Accusations = accusations
+			for _, accusation := range accusations {
+				p.bus.Cheating.Publish(p.describeAccusation(accusation))
+			}
+		}
+		p.RespondToCommand(audit, stream) // Respond with our signed accusations, if any
+	case "AbortRound": // Someone couldn't carry on with this deal
+		var abort eventbus.RoundAbort
+		if err := json.Unmarshal([]byte(payload), &abort); err != nil {
+			log.Printf("AbortRound: failed to decode abort: %v", err)
+			return
+		}
+		abort.Sender = stream.Conn().RemotePeer() // The envelope checked out, so they can't deny calling it off
+		p.bus.RoundAborted.Publish(abort)
 	case "CanRequestPuzzle":
-		p.ExecuteCommand(&RequestPuzzleCommand{})
+		if err := p.ExecuteCommand(&RequestPuzzleCommand{}); err != nil {
+			p.bus.CommandFailed.Publish(err)
+		}
 	case "PuzzleExchange":
 		p.RespondToCommand(&RequestPuzzleCommand{}, stream)
+	case "Resync": // Someone who dropped out is catching up on the hand
+		p.RespondToCommand(&ResyncCommand{}, stream)
+	case "PuzzleSolution": // Someone solved the puzzle of a player who left
+		var solved puzzleSolutionPayload
+		if err := json.Unmarshal([]byte(payload), &solved); err != nil || solved.Solution == nil {
+			log.Printf("PuzzleSolution: failed to decode solution: %v", err)
+			return
+		}
+		if err := p.acceptSolution(stream.Conn().RemotePeer(), solved.Owner, solved.Solution); errors.Is(err, ErrBadProof) {
+			p.reportBadKey(stream.Conn().RemotePeer(), err)
+		} else if err != nil {
+			log.Printf("PuzzleSolution: %v", err)
+		}
 	default:
 		log.Printf("Unknown Command Recieved: %s\n", nCmd.Command)
 	}
@@ -213,469 +544,380 @@ func (p *GokerPeer) handleStream(stream network.Stream) {
 // Sent to host to get the most up to date peer list
 type GetPeerListCommand struct{}
 
-func (gpl *GetPeerListCommand) Execute(p *GokerPeer) {
-	stream, err := p.ThisHost.NewStream(context.Background(), p.sessionHost.ID, protocolID)
-	if err != nil {
-		log.Printf("GetPeerListCommand: failed to create stream to host %s: %v\n", p.sessionHost, err)
-		return
-	}
-	defer stream.Close()
-
+func (gpl *GetPeerListCommand) Execute(p *GokerPeer) error {
 	// Create and send the command
 	request := NetworkCommand{
 		Command: "GetPeers",
 		Payload: nil, // No payload needed for this request
 	}
 
-	if err := sendCommand(stream, request); err != nil {
-		log.Fatalf("GetPeerListCommand: failed to send getpeers command: %v", err)
-	}
+	p.signCommand(&request)
 
-	// Receive and decode the response
-	response, err := receiveResponse(stream)
+	response, err := p.request(p.sessionHost.ID, request)
 	if err != nil {
-		log.Fatalf("GetPeerListCommand: failed to receive peer list: %v", err)
+		return err
 	}
 
 	// Ensure the response payload is a string
 	peerList, ok := response.Payload.(string)
 	if !ok {
-		log.Fatalf("GetPeerListCommand: invalid response format: expected string, got %T", response.Payload)
+		return peerErr(request.Command, p.sessionHost.ID, ErrBadResponse, "expected string, got %T", response.Payload)
 	}
 
-	p.setPeerListAndConnect(peerList)
+	if err := p.setPeerListAndConnect(peerList); err != nil {
+		return peerErr(request.Command, p.sessionHost.ID, ErrBadResponse, "%v", err)
+	}
 	log.Println("GetPeerListCommand: received and set peerlist.")
+	return nil
 }
 
-func (gpl *GetPeerListCommand) Respond(p *GokerPeer, sendingStream network.Stream) {
+func (gpl *GetPeerListCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
 	defer sendingStream.Close()
 
-	response := NetworkCommand{
+	return p.respond(sendingStream, NetworkCommand{
 		Command: "GetPeers",
 		Payload: p.getPeerList(),
-	}
+	})
+}
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Printf("GetPeerListCommand: failed to send peer list: %v", err)
-	}
+// Sent to everyone new joining to add to state
+type NicknameRequestCommand struct{}
+
+// What a peer answers a NicknameRequest with, their squaring speed goes with it so puzzles can be locked for the fastest player
+type nicknamePayload struct {
+	Nickname      string `json:"nickname"`
+	SquaringSpeed int64  `json:"squaringSpeed"` // Squarings a second
 }
 
-// Get signature data
-type PubKeyExchangeCommand struct{}
+// Reads a peers answer to a NicknameRequest, nobody joins without a squaring speed their puzzles can be held to
+func readNickname(command string, peerID peer.ID, payload any) (*nicknamePayload, error) {
+	answer, ok := payload.(*nicknamePayload)
+	if !ok {
+		return nil, peerErr(command, peerID, ErrBadResponse, "expected a nickname, got %T", payload)
+	}
+	if answer.SquaringSpeed <= 0 {
+		return nil, peerErr(command, peerID, ErrBadResponse, "%s sent no squaring speed", answer.Nickname)
+	}
+	return answer, nil
+}
 
-func (pke *PubKeyExchangeCommand) Execute(p *GokerPeer) {
+func (nr *NicknameRequestCommand) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
-	key, err := p.Keyring.ExportPublicKey()
-	if err != nil {
-		log.Fatalf("%v\n", err)
-	}
-
-	request := NetworkCommand{
-		Command: "PubKeyExchange",
-		Payload: key,
+	// Create command
+	command := NetworkCommand{
+		Command: "NicknameRequest",
+		Payload: nil,
 	}
+	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
-		if _, ok := p.Keyring.Otherskeys[peerInfo.ID]; ok { // If their pub key already exists, skip
+	var errs []error
+	for _, peerID := range p.otherPeers() {
+		// if the player already exists, then obviously we don't need their nickname
+		if p.gameState.PlayerExists(peerID) {
 			continue
 		}
 
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
+		response, err := p.request(peerID, command)
 		if err != nil {
-			log.Printf("PubKeyExchangeCommand: failed to create stream to peer %s: %v\n", peerInfo.ID, err)
-			return
-		}
-		defer stream.Close()
-
-		if err := sendCommand(stream, request); err != nil {
-			log.Printf("PubKeyExchangeCommand: failed to send request: %v", err)
+			errs = append(errs, err)
+			continue
 		}
-
-		response, err := receiveResponse(stream)
+		answer, err := readNickname(command.Command, peerID, response.Payload)
 		if err != nil {
-			log.Fatalf("PubKeyExchangeCommand: failed to read response from peer %s: %v\n", peerInfo.ID, err)
-		}
-
-		keyPayload, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("PubKeyExchangeCommand: keys not a string from peer: %v\n", peerInfo.ID)
+			errs = append(errs, err)
+			continue
 		}
 
-		p.Keyring.SetPeerPublicKey(peerInfo.ID, keyPayload)
+		log.Printf("NicknameRequest: Received response from peer: %s -- Nickname: %s\n", peerID, answer.Nickname)
+		p.gameState.AddPeerToState(peerID, answer.Nickname) // Finally add peer to gamestate
+		p.recognize(peerID, answer.Nickname)
+		p.recordSquaringSpeed(peerID, answer.SquaringSpeed)
 	}
+	return errors.Join(errs...)
 }
 
-func (pke *PubKeyExchangeCommand) Respond(p *GokerPeer, sendingStream network.Stream) {
-	defer sendingStream.Close()
+// Checks a new player against everyone played with before, warning the lobby if their nickname comes with a new key
+func (p *GokerPeer) recognize(peerID peer.ID, nickname string) {
+	if p.Identity == nil || p.Identity.Known == nil { // Throwaway identities don't remember anyone
+		return
+	}
 
-	key, err := p.Keyring.ExportPublicKey()
+	recognition, previous, err := p.Identity.Known.Recognize(peerID, nickname)
 	if err != nil {
-		log.Fatalf("%v\n", err)
+		log.Printf("recognize: %v", err)
 	}
-
-	// Respond with the peer's public key
-	response := NetworkCommand{
-		Command: "PubKeyExchange",
-		Payload: key,
+	switch recognition {
+	case identity.FirstMeeting:
+		log.Printf("recognize: first time playing with %s (%s)", nickname, peerID)
+	case identity.Recognized:
+		log.Printf("recognize: %s (%s) is back", nickname, peerID)
+	case identity.KeyChanged:
+		log.Printf("recognize: %s was last seen as %s, now %s", nickname, previous, peerID)
+		p.bus.LobbyMessage.Publish(fmt.Sprintf("Warning: %s has a different key than last time, they may not be who you played with before", nickname))
 	}
+}
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Printf("RequestPublicKeyCommand: failed to send public key: %v", err)
-	}
+func (nr *NicknameRequestCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	defer sendingStream.Close()
+
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "NicknameRequest",
+		Payload: &nicknamePayload{Nickname: p.gameState.GetNickname(p.ThisHost.ID()), SquaringSpeed: p.Keyring.SquaringSpeed()},
+	})
 }
 
-// Sent to everyone new joining to add to state
-type NicknameRequestCommand struct{}
+//////////////////////////////////////////// INIT TABLE COMMAND /////////////////////////////////////////////////////
 
-func (nr *NicknameRequestCommand) Execute(p *GokerPeer) {
-	p.peerListMutex.Lock()
-	defer p.peerListMutex.Unlock()
+// Sent to everyone to set the table rules for the game, each peer validates them and has to approve before play
+type InitTableCommand struct {
+	Rejection  string             // Set when responding, why this peer won't play with these rules
+	Rejections map[peer.ID]string // Set after executing, peers who didn't approve the rules and why
+}
 
-	for _, peerInfo := range p.peerList {
-		// If it's us
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
-		// if the player already exists, then obviously we don't need their nickname
-		if p.gameState.PlayerExists(peerInfo.ID) {
-			continue
-		}
+func (it *InitTableCommand) Execute(p *GokerPeer) error {
+	var rejectionsMutex sync.Mutex
+	it.Rejections = make(map[peer.ID]string)
 
-		// Create a new stream to the peer
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-		if err != nil {
-			log.Printf("NicknameRequest: failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
-		}
-		defer stream.Close()
+	tableRules, err := p.gameState.GetTableRules()
+	if err != nil {
+		return localErr("InitTable", err)
+	}
 
-		// Create command
-		command := NetworkCommand{
-			Command: "NicknameRequest",
-			Payload: nil,
-		}
-		p.signCommand(&command)
+	command := NetworkCommand{
+		Command: "InitTable",
+		Payload: tableRules,
+	}
+	p.signCommand(&command)
 
-		// Send command through stream
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("NicknameRequest: failed to send NicknameRequest command: %v", err)
-		}
+	// Don't hold the peer list while everyone reads the rules
+	p.peerListMutex.Lock()
+	peers := p.otherPeers()
+	p.peerListMutex.Unlock()
 
-		// Receive and decode the response
-		response, err := receiveResponse(stream)
+	err = forEachConcurrently(peers, func(peerID peer.ID) error {
+		answer, err := p.requestString(peerID, command)
 		if err != nil {
-			log.Fatalf("NicknameRequest: failed to read response from host %s: %v\n", peerInfo.ID, err)
+			return err
 		}
-		p.verifyCommand(peerInfo.ID, &response)
 
-		// Ensure the response payload is a string
-		nickname, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("NicknameRequest: nickname not a string from peer: %v\n", peerInfo.ID)
+		if answer != "DONE" { // Not playing with these rules isn't a failure, the host just can't start yet
+			log.Printf("InitTableCommand: peer %s rejected the table rules: %s", peerID, answer)
+			rejectionsMutex.Lock()
+			it.Rejections[peerID] = strings.TrimPrefix(answer, "REJECTED: ")
+			rejectionsMutex.Unlock()
 		}
-
-		peerNickname := strings.Split(nickname, "\n")
-		log.Printf("NicknameRequest: Received response from peer: %s -- Nickname: %s\n", peerInfo.ID, peerNickname[0])
-		p.gameState.AddPeerToState(peerInfo.ID, peerNickname[0]) // Finally add peer to gamestate
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-}
 
-func (nr *NicknameRequestCommand) Respond(p *GokerPeer, sendingStream network.Stream) {
-	defer sendingStream.Close()
+	log.Println("InitTableCommand: All available peers responded, proceeding...")
+	return nil
+}
 
-	response := NetworkCommand{
-		Command: "NicknameRequest",
-		Payload: p.gameState.GetNickname(p.ThisHost.ID()),
+func (it *InitTableCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	payload := "DONE"
+	if it.Rejection != "" {
+		payload = "REJECTED: " + it.Rejection
 	}
-	p.signCommand(&response)
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Printf("NicknameRequestCommand: failed to send nickname: %v", err)
-	}
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "InitTable",
+		Payload: payload,
+	})
 }
 
-//////////////////////////////////////////// INIT TABLE COMMAND /////////////////////////////////////////////////////
+////////////////////////////////////////// KEYRING //////////////////////////////////////////////////////
 
-// Sent to everyone to set the table rules for the game
-type InitTableCommand struct{}
+// Everyone (us included) makes fresh keys for this rounds keyring, in the group the table rules agreed on
+// Only the size of the group is sent, each peers keys never leave them until the audit
+type NewKeysCommand struct {
+	Rejection string // Set when responding, why this peer couldn't make its keys
+}
 
-func (it *InitTableCommand) Execute(p *GokerPeer) {
-	var wg sync.WaitGroup
+func (nk *NewKeysCommand) Execute(p *GokerPeer) error {
+	cipher, err := p.tableCipher()
+	if err != nil {
+		return localErr("NewKeys", err)
+	}
+	if err := p.newKeys(cipher); err != nil {
+		return localErr("NewKeys", err)
+	}
 
 	p.peerListMutex.Lock()
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
-
-		wg.Add(1)
-
-		go func(peerID peer.ID) {
-			defer wg.Done()
-			// Create a new stream to the peer
-			stream, err := p.ThisHost.NewStream(context.Background(), peerID, protocolID)
-			if err != nil {
-				log.Printf("InitTableCommand: Failed to create stream to peer %s: %v\n", peerID, err)
-			}
-			defer stream.Close()
-
-			command := NetworkCommand{
-				Command: "InitTable",
-				Payload: p.gameState.GetTableRules(),
-			}
-			p.signCommand(&command)
-
-			if err := sendCommand(stream, command); err != nil {
-				log.Fatalf("InitTableCommand: failed to send getpeers command: %v", err)
-			}
-
-			response, err := receiveResponse(stream)
-			if err != nil {
-				log.Fatalf("InitTableCommand: failed to recieve response from peer: %v", err)
-			}
-			p.verifyCommand(peerID, &response)
+	defer p.peerListMutex.Unlock()
 
-			doneResponse, ok := response.Payload.(string)
-			if !ok {
-				log.Fatalf("InitTableCommand: invalid response format: expected string, got %T", response.Payload)
-			}
+	command := NetworkCommand{
+		Command: "NewKeys",
+		Payload: cipher.Name(),
+	}
+	p.signCommand(&command)
 
-			if doneResponse != "DONE" {
-				log.Fatalf("InitTableCommand: response from peer was not 'DONE', got %s", doneResponse)
-			}
-		}(peerInfo.ID)
+	err = forEachConcurrently(p.otherPeers(), func(peerID peer.ID) error {
+		return p.requestApproval(peerID, command, "DONE")
+	})
+	if err != nil {
+		return err
 	}
-	p.peerListMutex.Unlock()
 
-	wg.Wait()
-	log.Println("InitTableCommand: All available peers responded, proceeding...")
+	log.Println("NewKeysCommand: All available peers responded, proceeding...")
+	return nil
 }
 
-func (it *InitTableCommand) Respond(p *GokerPeer, sendingStream network.Stream) {
-	response := NetworkCommand{
-		Command: "InitTable",
-		Payload: "DONE",
+func (nk *NewKeysCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	payload := "DONE"
+	if nk.Rejection != "" {
+		payload = "REJECTED: " + nk.Rejection
 	}
-	p.signCommand(&response)
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("InitTableCommand: failed to send 'DONE': %v", err)
-	}
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "NewKeys",
+		Payload: payload,
+	})
 }
 
-////////////////////////////////////////// KEYRING //////////////////////////////////////////////////////
-
-// Send P and Q to everyone for this rounds keyring
-type SendPQCommand struct{}
-
-func (pq *SendPQCommand) Execute(p *GokerPeer) {
-	var wg sync.WaitGroup
-
-	p.peerListMutex.Lock()
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
-
-		wg.Add(1)
-
-		go func(peerID peer.ID) {
-			defer wg.Done()
-			// Create a new stream to the peer
-			stream, err := p.ThisHost.NewStream(context.Background(), peerID, protocolID)
-			if err != nil {
-				log.Printf("SendPQCommand: Failed to create stream to peer %s: %v\n", peerID, err)
-			}
-			defer stream.Close()
-
-			command := NetworkCommand{
-				Command: "SendPQ",
-				Payload: p.Keyring.GetPQString(),
-			}
-			p.signCommand(&command)
-
-			if err := sendCommand(stream, command); err != nil {
-				log.Fatalf("SendPQCommand: failed to send SendPQ command: %v", err)
-			}
-
-			response, err := receiveResponse(stream)
-			if err != nil {
-				log.Fatalf("SendPQCommand: failed to recieve response from peer: %v", err)
-			}
-			p.verifyCommand(peerID, &response)
-
-			doneResponse, ok := response.Payload.(string)
-			if !ok {
-				log.Fatalf("SendPQCommand: invalid response format: expected string, got %T", response.Payload)
-			}
-
-			if doneResponse != "DONE" {
-				log.Fatalf("SendPQCommand: response from peer was not 'DONE', got %s", doneResponse)
-			}
-		}(peerInfo.ID)
-	}
-	p.peerListMutex.Unlock()
-
-	wg.Wait()
-	log.Println("SendPQCommand: All available peers responded, proceeding...")
+// The card cipher the table rules pick, every peer makes the same one
+func (p *GokerPeer) tableCipher() (sra.CardCipher, error) {
+	rules := p.gameState.GetRules()
+	return sra.NewCardCipher(rules.Cipher, rules.PrimeBits)
 }
 
-func (pq *SendPQCommand) Respond(p *GokerPeer, sendingStream network.Stream) {
-	response := NetworkCommand{
-		Command: "SendPQ",
-		Payload: "DONE",
-	}
-	p.signCommand(&response)
-
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("SendPQCommand: failed to send 'DONE': %v", err)
-	}
+// Makes this peers keys for the hand with the given cipher, and rebuilds the deck out of cards in its group
+func (p *GokerPeer) newKeys(cipher sra.CardCipher) error {
+	p.handStarted()
+	p.Keyring.SetCipher(cipher)
+	p.Deck.encode = cipher.EncodeCard
+	p.Deck.GenerateDecks(DeckKey)
+	return p.Keyring.GenerateKeys()
 }
 
 ///////////////////////////////////////////// PROTOCOL FIRST STEP ///////////////////////////////////////////////////
 
 type ProtocolFirstStepCommand struct{}
 
-// Send deck to every peer, allow them to shuffle and encrypt the deck
-func (sp *ProtocolFirstStepCommand) Execute(p *GokerPeer) {
-	p.EncryptAllWithGlobalKeys()
-	p.Deck.ShuffleRoundDeck()
+// Send deck to every peer, allow them to shuffle and encrypt the deck - every shuffle comes back with a proof we check before passing it on
+func (sp *ProtocolFirstStepCommand) Execute(p *GokerPeer) error {
+	transcript := &deckTranscript{Start: p.Deck.GenerateDeckPayload()}
+	myStep, err := p.shuffleAndProve()
+	if err != nil {
+		return localErr("ProtocolFS", err)
+	}
+	transcript.Steps = append(transcript.Steps, myStep)
 
 	// So I don't have to set the deck each turn
 	command := NetworkCommand{
 		Command: "ProtocolFS",
-		Payload: p.Deck.GenerateDeckPayload(),
+		Payload: myStep.Deck,
 	}
 	p.signCommand(&command)
 
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 	// Get each peer to shuffle and encrypt deck
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
-
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-		if err != nil {
-			log.Printf("ProtocolFirstStep: Failed to create stream to peer %s: %v\n", peerInfo.ID, err)
-			continue
-		}
-		defer stream.Close()
-
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("ProtocolFirstStepCommand: failed to send deck: %v", err)
-		}
-
-		response, err := receiveResponse(stream)
+	for _, peerID := range p.otherPeers() {
+		step, err := p.requestProvenDeck(peerID, command)
 		if err != nil {
-			log.Fatalf("ProtocolFirstStepCommand: failed to recieve deck from peer: %v", err)
+			return err
 		}
-		p.verifyCommand(peerInfo.ID, &response)
-
-		newDeck, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("ProtocolFirstStep: invalid response format: expected string, got %T", response.Payload)
+		if err := p.verifyStepFrom(peerID, command, step); err != nil {
+			return err
 		}
+		transcript.Steps = append(transcript.Steps, step)
 
-		command.Payload = newDeck // So the next peer has an up to date deck
+		command.Payload = step.Deck // So the next peer has an up to date deck
 		p.signCommand(&command)
 	}
 
+	p.shuffleTranscript = transcript            // Everyone checks this when it's broadcast
 	p.Deck.SetNewDeck(command.Payload.(string)) // Set the final deck for host
 	log.Println("ProtocolFirstStepCommand: All peers have contributed, continueing...")
+	return nil
 }
 
-// Respond to a protocol's first command - Encrypt with global keys, shuffle, then send back - when this is called a new deck should be set already
-func (sp *ProtocolFirstStepCommand) Respond(p *GokerPeer, sendingStream network.Stream) {
-	// Encrypt the deck with your global keys, shuffle it, then create a new payload to send back
-	p.EncryptAllWithGlobalKeys()
-	p.Deck.ShuffleRoundDeck()
+// Same as request, for the protocol steps answered with a deck and its proof
+func (p *GokerPeer) requestProvenDeck(peerID peer.ID, nCmd NetworkCommand) (provenDeck, error) {
+	response, err := p.request(peerID, nCmd)
+	if err != nil {
+		return provenDeck{}, err
+	}
+	step, ok := response.Payload.(*provenDeck)
+	if !ok {
+		return provenDeck{}, peerErr(nCmd.Command, peerID, ErrBadResponse, "expected a deck, got %T", response.Payload)
+	}
+	step.Peer = peerID
+	return *step, nil
+}
 
-	response := NetworkCommand{
-		Command: "ProtocolFirstStep",
-		Payload: p.Deck.GenerateDeckPayload(),
+// Checks the step a peer answered a protocol command with, made from the deck the command sent them
+func (p *GokerPeer) verifyStepFrom(peerID peer.ID, command NetworkCommand, step provenDeck) error {
+	what := "encryption"
+	if step.ShuffleProof != nil {
+		what = "shuffle"
 	}
-	p.signCommand(&response)
+	if err := p.verifyProvenDeck(command.Payload.(string), step); err != nil {
+		return peerErr(command.Command, peerID, ErrBadProof, "bad %s, %v", what, err)
+	}
+	return nil
+}
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("ProtocolFirstStep: failed to send 'DONE' back to peer: %v", err)
+// Respond to a protocol's first command - Encrypt with global keys, shuffle, then send back - when this is called a new deck should be set already
+func (sp *ProtocolFirstStepCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	// Encrypt the deck with your global keys, shuffle it, then send it back with the proof
+	step, err := p.shuffleAndProve()
+	if err != nil {
+		return localErr("ProtocolFirstStep", err)
 	}
+
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "ProtocolFirstStep",
+		Payload: &step,
+	})
 }
 
 // Send the new deck to everyone - Everyone will need to set the deck as if it was new
-type BroadcastNewDeck struct{}
+// The whole transcript is sent so everyone can check every shuffle, not just the host
+type BroadcastNewDeck struct {
+	Rejection string // Set when responding, why this peer didn't accept the deck
+}
 
-func (b *BroadcastNewDeck) Execute(p *GokerPeer) {
+func (b *BroadcastNewDeck) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock() // Since this is called RIGHT after the first round of the protocol is done, it can unlock the peerlist
 
-	var wg sync.WaitGroup
-
+	if p.shuffleTranscript == nil {
+		return localErr("BroadcastNewDeck", fmt.Errorf("no transcript to send"))
+	}
 	command := NetworkCommand{
 		Command: "BroadcastNewDeck",
-		Payload: p.Deck.GenerateDeckPayload(),
+		Payload: p.shuffleTranscript,
 	}
 	p.signCommand(&command)
 
 	// After all peers have processed, broadcast the final deck to everyone - This is where they will validate signatures?
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
-
-		wg.Add(1)
-
-		go func(peerID peer.ID) {
-			defer wg.Done()
-
-			stream, err := p.ThisHost.NewStream(context.Background(), peerID, protocolID)
-			if err != nil {
-				log.Fatalf("BroadcastNewDeck: failed to setup stream to peer %s: %v\n", peerID, err)
-			}
-			defer stream.Close()
-
-			if err := sendCommand(stream, command); err != nil {
-				log.Fatalf("BroadcastNewDeck: failed to send deck: %v", err)
-			}
-
-			response, err := receiveResponse(stream)
-			if err != nil {
-				log.Fatalf("BroadcastNewDeck: failed to recieve response from peer: %v", err)
-			}
-			p.verifyCommand(peerID, &response)
-
-			doneResponse, ok := response.Payload.(string)
-			if !ok {
-				log.Fatalf("BroadcastNewDeck: invalid response format: expected string, got %T", response.Payload)
-			}
-
-			if doneResponse != "DONE" {
-				log.Fatalf("BroadcastNewDeck: response from peer was not 'DONE', got %s", doneResponse)
-			}
-
-		}(peerInfo.ID)
+	err := forEachConcurrently(p.otherPeers(), func(peerID peer.ID) error {
+		return p.requestApproval(peerID, command, "DONE")
+	})
+	if err != nil {
+		return err
 	}
 
-	wg.Wait()
 	log.Println("BroadcastNewDeck: All peers have recieved deck, continueing...")
+	return nil
 }
 
-func (b *BroadcastNewDeck) Respond(p *GokerPeer, sendingStream network.Stream) {
-	response := NetworkCommand{
-		Command: "BroadcastNewDeck",
-		Payload: "DONE",
+func (b *BroadcastNewDeck) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	payload := "DONE"
+	if b.Rejection != "" {
+		payload = "REJECTED: " + b.Rejection
 	}
-	p.signCommand(&response)
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("BroadcastNewDeck: failed to send 'DONE' back to peer: %v", err)
-	}
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "BroadcastNewDeck",
+		Payload: payload,
+	})
 }
 
 ///////////////////////////////////////////// PROTOCOL SECOND STEP ///////////////////////////////////////////////////
@@ -684,141 +926,100 @@ func (b *BroadcastNewDeck) Respond(p *GokerPeer, sendingStream network.Stream) {
 type ProtocolSecondStepCommand struct{}
 
 // For getting others to encrypt with their variation keys
-func (sp *ProtocolSecondStepCommand) Execute(p *GokerPeer) {
+func (sp *ProtocolSecondStepCommand) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock() // Same thing as first step, the broadcast will unlock the mutex
 	defer p.peerListMutex.Unlock()
 
-	// First, the host of the game (the one initialing the protocols steps) will encrypt with variations
-	p.DecryptAllWithGlobalKeys() // Decrypt global keys
-	p.EncryptAllWithVariation()  // Add encryption to every card
+	// First, the host of the game (the one initialing the protocols steps) will decrypt global keys and encrypt with variations
+	transcript := &deckTranscript{Start: p.Deck.GenerateDeckPayload()}
+	myStep, err := p.encryptVariationsAndProve()
+	if err != nil {
+		return localErr("ProtocolSS", err)
+	}
+	transcript.Steps = append(transcript.Steps, myStep)
 
 	command := NetworkCommand{
 		Command: "ProtocolSS",
-		Payload: p.Deck.GenerateDeckPayload(),
+		Payload: myStep.Deck,
 	}
 	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
-
-		// Create a new stream to the peer
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-		if err != nil {
-			log.Printf("ProtocolSecondStep: Failed to create stream to peer %s: %v\n", peerInfo.ID, err)
-			continue
-		}
-		defer stream.Close()
-
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("ProtocolSecondStep: failed to send deck to peer")
-		}
-
-		response, err := receiveResponse(stream)
+	for _, peerID := range p.otherPeers() {
+		step, err := p.requestProvenDeck(peerID, command)
 		if err != nil {
-			log.Fatalf("ProtocolSecondStep: failed to receive a response from peer %s: %v", peerInfo.ID, err)
+			return err
 		}
-		p.verifyCommand(peerInfo.ID, &response)
-
-		newDeck, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("ProtocolSecondStep: invalid response format: expected string, got %T", response.Payload)
+		if err := p.verifyStepFrom(peerID, command, step); err != nil {
+			return err
 		}
+		transcript.Steps = append(transcript.Steps, step)
 
-		command.Payload = newDeck
+		command.Payload = step.Deck
 		p.signCommand(&command)
 	}
 
+	p.variationTranscript = transcript
 	p.Deck.SetDeckInPlace(command.Payload.(string))
-	p.SetHands() // Time to set my own hand
-	p.SetBoard()
+	p.prepareHandPuzzle()
+	if err := errors.Join(p.SetHands(), p.SetBoard()); err != nil { // Time to set my own hand
+		return localErr(command.Command, err)
+	}
 	log.Println("ProtocolSecondStepCommand: All peers have contributed, continueing...")
+	return nil
 }
 
 // Respond to the protocols second command - Decrypt global keys, encrypt with variation, then send back- when this is called a new deck should be set already
-func (sp *ProtocolSecondStepCommand) Respond(p *GokerPeer, sendingStream network.Stream) {
-	p.DecryptAllWithGlobalKeys()
-	p.EncryptAllWithVariation()
-
-	response := NetworkCommand{
-		Command: "ProtocolSecondStep",
-		Payload: p.Deck.GenerateDeckPayload(),
+func (sp *ProtocolSecondStepCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	step, err := p.encryptVariationsAndProve()
+	if err != nil {
+		return localErr("ProtocolSecondStep", err)
 	}
-	p.signCommand(&response)
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("ProtocolSecondStep: failed to send deck back to peer: %v", err)
-	}
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "ProtocolSecondStep",
+		Payload: &step,
+	})
 }
 
-// Send the unchanged (no shuffling) deck to everyone
-type BroadcastDeck struct{}
+// Send the unchanged (no shuffling) deck to everyone, with the transcript so everyone can check every peers encryption
+type BroadcastDeck struct {
+	Rejection string // Set when responding, why this peer didn't accept the deck
+}
 
-func (b *BroadcastDeck) Execute(p *GokerPeer) {
+func (b *BroadcastDeck) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
-	var wg sync.WaitGroup
-
+	if p.variationTranscript == nil {
+		return localErr("BroadcastDeck", fmt.Errorf("no transcript to send"))
+	}
 	command := NetworkCommand{
 		Command: "BroadcastDeck",
-		Payload: p.Deck.GenerateDeckPayload(),
+		Payload: p.variationTranscript,
 	}
 	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
-
-		wg.Add(1)
-
-		go func(peerID peer.ID) {
-			defer wg.Done()
-
-			stream, err := p.ThisHost.NewStream(context.Background(), peerID, protocolID)
-			if err != nil {
-				log.Fatalf("BroadcastDeck: failed to setup stream to peer %s: %v\n", peerID, err)
-			}
-			defer stream.Close()
-
-			if err := sendCommand(stream, command); err != nil {
-				log.Fatalf("BroadcastDeck: failed to send deck: %v", err)
-			}
-
-			response, err := receiveResponse(stream)
-			if err != nil {
-				log.Fatalf("BroadcastDeck: failed to recieve response from peer: %v", err)
-			}
-			p.verifyCommand(peerID, &response)
-
-			doneResponse, ok := response.Payload.(string)
-			if !ok {
-				log.Fatalf("BroadcastDeck: invalid response format: expected string, got %T", response.Payload)
-			}
-
-			if doneResponse != "DONE" {
-				log.Fatalf("BroadcastDeck: response from peer was not 'DONE', got %s", doneResponse)
-			}
-
-		}(peerInfo.ID)
+	err := forEachConcurrently(p.otherPeers(), func(peerID peer.ID) error {
+		return p.requestApproval(peerID, command, "DONE")
+	})
+	if err != nil {
+		return err
 	}
 
-	wg.Wait()
 	log.Println("BroadcastDeck: All peers have recieved final deck, continueing...")
+	return nil
 }
 
-func (b *BroadcastDeck) Respond(p *GokerPeer, sendingStream network.Stream) {
-	response := NetworkCommand{
-		Command: "BroadcastDeck",
-		Payload: "DONE",
+func (b *BroadcastDeck) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	payload := "DONE"
+	if b.Rejection != "" {
+		payload = "REJECTED: " + b.Rejection
 	}
-	p.signCommand(&response)
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("BroadcastDeck: failed to send 'DONE' back to peer: %v", err)
-	}
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "BroadcastDeck",
+		Payload: payload,
+	})
 }
 
 //////////////////////////////////////////// DEALING COMMAND /////////////////////////////////////////////////////
@@ -826,7 +1027,7 @@ func (b *BroadcastDeck) Respond(p *GokerPeer, sendingStream network.Stream) {
 // To alert everyone they can request their hand
 type CanRequestHand struct{}
 
-func (c *CanRequestHand) Execute(p *GokerPeer) {
+func (c *CanRequestHand) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
@@ -836,29 +1037,21 @@ func (c *CanRequestHand) Execute(p *GokerPeer) {
 	}
 	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-
-		if err != nil {
-			log.Printf("CanRequestHand: failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
-		}
-		defer stream.Close()
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("CanRequestHand: failed to send command to peer %s: %v", peerInfo.ID, err)
+	var errs []error
+	for _, peerID := range p.otherPeers() {
+		if err := p.notify(peerID, command); err != nil {
+			errs = append(errs, err)
 		}
 	}
+	return errors.Join(errs...)
 }
 
-func (c *CanRequestHand) Respond(p *GokerPeer, sendingStream network.Stream) {}
+func (c *CanRequestHand) Respond(p *GokerPeer, sendingStream network.Stream) error { return nil }
 
 // Used if it's your turn to request your hand
 type RequestHandCommand struct{}
 
-func (rh *RequestHandCommand) Execute(p *GokerPeer) {
+func (rh *RequestHandCommand) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
@@ -871,34 +1064,17 @@ func (rh *RequestHandCommand) Execute(p *GokerPeer) {
 	}
 	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
-
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-		if err != nil {
-			log.Printf("RequestHand: Failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
-		}
-		defer stream.Close()
-
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("RequestHand: failed to send command to peer %s: %v", peerInfo.ID, err)
-		}
-
-		response, err := receiveResponse(stream)
+	for _, peerID := range p.otherPeers() {
+		keyPayload, err := p.requestString(peerID, command)
 		if err != nil {
-			log.Fatalf("RequestHand: failed to recieve response from peer: %s", peerInfo.ID)
-		}
-		p.verifyCommand(peerInfo.ID, &response)
-
-		keyPayload, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("RequestHand: invalid response format: expected string, got %T", response.Payload)
+			return err
 		}
 
 		keys := strings.Split(keyPayload, "\n")
+		if err := p.checkRevealedKeys(peerID, p.MyHand, keys); err != nil {
+			p.reportBadKey(peerID, err)
+			return peerErr(command.Command, peerID, ErrBadProof, "%v", err)
+		}
 		cardOneKeys = append(cardOneKeys, keys[0])
 		cardTwoKeys = append(cardTwoKeys, keys[1])
 	}
@@ -910,31 +1086,30 @@ func (rh *RequestHandCommand) Execute(p *GokerPeer) {
 	cardOneName, exists := p.Deck.GetCardFromRefDeck(p.MyHand[0].CardValue) // Should be the hash
 	cardTwoName, exists2 := p.Deck.GetCardFromRefDeck(p.MyHand[1].CardValue)
 
-	if exists && exists2 {
-		p.sendHandToGUI(cardOneName, cardTwoName)
-		return
+	if !exists || !exists2 {
+		return localErr(command.Command, errors.New("hand didn't decrypt to cards in the reference deck"))
 	}
-
-	log.Fatalf("RequestHand: could not retrieve keys, aborting.")
+	p.sendHandToGUI(cardOneName, cardTwoName)
+	return nil
 }
 
-func (rh *RequestHandCommand) Respond(p *GokerPeer, sendingStream network.Stream) {
-	response := NetworkCommand{
-		Command: "RequestHand",
-		Payload: p.GetKeyPayloadForPlayersHand(sendingStream.Conn().RemotePeer()),
+func (rh *RequestHandCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	keys, err := p.GetKeyPayloadForPlayersHand(sendingStream.Conn().RemotePeer())
+	if err != nil {
+		return localErr("RequestHand", err)
 	}
-	p.signCommand(&response)
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("RequestHand: failed to send keys back to peer: %v", err)
-	}
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "RequestHand",
+		Payload: keys,
+	})
 }
 
 //////////////////////////////////////////// ROUND COMMANDS /////////////////////////////////////////////////////
 
 type PushTagCommand struct{}
 
-func (pt *PushTagCommand) Execute(p *GokerPeer) {
+func (pt *PushTagCommand) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
@@ -943,33 +1118,24 @@ func (pt *PushTagCommand) Execute(p *GokerPeer) {
 	command := NetworkCommand{
 		Command: "PushTag",
 		Payload: nil,
-		Tag:     &p.tag,
+		Tag:     p.currentTag(),
 	}
 	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
-
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-		if err != nil {
-			log.Printf("MoveToTable: Failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
-		}
-		defer stream.Close()
-
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("MoveToTable: failed to send command to peer %s: %v", peerInfo.ID, err)
+	var errs []error
+	for _, peerID := range p.otherPeers() {
+		if err := p.notify(peerID, command); err != nil && !skipUnreachable(err) { // Whoever dropped gets the tag when they resync
+			errs = append(errs, err)
 		}
 	}
+	return errors.Join(errs...)
 }
 
-func (pt *PushTagCommand) Respond(p *GokerPeer, sendingStream network.Stream) {}
+func (pt *PushTagCommand) Respond(p *GokerPeer, sendingStream network.Stream) error { return nil }
 
 type MoveToTableCommand struct{}
 
-func (mtt *MoveToTableCommand) Execute(p *GokerPeer) {
+func (mtt *MoveToTableCommand) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
@@ -979,552 +1145,551 @@ func (mtt *MoveToTableCommand) Execute(p *GokerPeer) {
 	}
 	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
+	var errs []error
+	for _, peerID := range p.otherPeers() {
+		if err := p.notify(peerID, command); err != nil {
+			errs = append(errs, err)
 		}
+	}
 
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-		if err != nil {
-			log.Printf("MoveToTable: Failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
-		}
-		defer stream.Close()
+	p.bus.StartRound.Publish(struct{}{}) // Tell GUI to move to the table UI
+	return errors.Join(errs...)
+}
+
+func (mtt *MoveToTableCommand) Respond(p *GokerPeer, sendingStream network.Stream) error { return nil }
+
+// Sends a betting action to everyone, each of them has to approve it
+func (p *GokerPeer) broadcastAction(command NetworkCommand) error {
+	p.signCommand(&command)
 
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("MoveToTable: failed to send command to peer %s: %v", peerInfo.ID, err)
+	for _, peerID := range p.otherPeers() {
+		if err := p.requestApproval(peerID, command, "APPROVED"); err != nil && !skipUnreachable(err) {
+			return err
 		}
 	}
+	return nil
+}
 
-	channelmanager.TGUI_StartRound <- struct{}{} // Tell GUI to move to the table UI
+// Refuses a move from another player that isn't theirs to make and holds it against them, empty if it's fine
+func (p *GokerPeer) checkMove(sender peer.ID, move string, amount float64) string {
+	if err := p.gameState.CheckMove(sender, move, amount); err != nil {
+		p.reportIllegalMove(sender, err)
+		return err.Error()
+	}
+	return ""
 }
 
-func (mtt *MoveToTableCommand) Respond(p *GokerPeer, sendingStream network.Stream) {}
+// What we answer a raise, fold, call or check with
+func moveAnswer(rejection string) string {
+	if rejection != "" {
+		return "REJECTED: " + rejection
+	}
+	return "APPROVED"
+}
 
-type RaiseCommand struct{}
+type RaiseCommand struct {
+	Amount    float64 // How much was added to the bet with this raise
+	Rejection string  // Set when responding, why the move wasn't theirs to make
+}
 
-func (r *RaiseCommand) Execute(p *GokerPeer) {
+func (r *RaiseCommand) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
-	command := NetworkCommand{
+	return p.broadcastAction(NetworkCommand{
 		Command: "Raise",
-		Payload: p.gameState.MyBet,
-		Tag:     &p.tag,
-	}
-	p.signCommand(&command)
+		Payload: r.Amount,
+		Tag:     p.currentTag(),
+	})
+}
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
+func (r *RaiseCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "Raise",
+		Payload: moveAnswer(r.Rejection),
+		Tag:     p.currentTag(),
+	})
+}
 
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-		if err != nil {
-			log.Printf("Raise: failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
-		}
-		defer stream.Close()
+type FoldCommand struct {
+	Rejection string // Set when responding, why the move wasn't theirs to make
+}
 
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("Raise: failed to send command to peer %s: %v", peerInfo.ID, err)
-		}
+func (f *FoldCommand) Execute(p *GokerPeer) error {
+	p.peerListMutex.Lock()
+	defer p.peerListMutex.Unlock()
 
-		response, err := receiveResponse(stream)
-		if err != nil {
-			log.Fatalf("Raise: failed to recieve a response from peer: %s: %v", peerInfo.ID, err)
-		}
-		p.verifyCommand(peerInfo.ID, &response)
+	p.keyringRevealed(p.ThisHost.ID(), p.Keyring.KeyringPayload) // For anyone who drops out before it reaches them
 
-		approved, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("Raise: invalid response format: expected string, got %T", response.Payload)
-		}
+	return p.broadcastAction(NetworkCommand{
+		Command: "Fold",
+		Payload: p.Keyring.KeyringPayload,
+		Tag:     p.currentTag(),
+	})
+}
 
-		if approved != "APPROVED" {
-			log.Fatalf("Raise: Raise was not APPROVED, got %s", approved)
-		}
+func (f *FoldCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "Fold",
+		Payload: moveAnswer(f.Rejection),
+		Tag:     p.currentTag(),
+	})
+}
 
-	}
+type CallCommand struct {
+	Rejection string // Set when responding, why the move wasn't theirs to make
 }
 
-func (r *RaiseCommand) Respond(p *GokerPeer, sendingStream network.Stream) {
-	response := NetworkCommand{
-		Command: "Raise",
-		Payload: "APPROVED",
-		Tag:     &p.tag,
-	}
-	p.signCommand(&response)
+func (c *CallCommand) Execute(p *GokerPeer) error {
+	p.peerListMutex.Lock()
+	defer p.peerListMutex.Unlock()
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("Raise: failed to send 'APPROVED': %v", err)
-	}
+	return p.broadcastAction(NetworkCommand{
+		Command: "Call",
+		Payload: nil,
+		Tag:     p.currentTag(),
+	})
+}
+
+func (c *CallCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "Call",
+		Payload: moveAnswer(c.Rejection),
+		Tag:     p.currentTag(),
+	})
 }
 
-type FoldCommand struct{}
+type CheckCommand struct {
+	Rejection string // Set when responding, why the move wasn't theirs to make
+}
 
-func (f *FoldCommand) Execute(p *GokerPeer) {
+func (c *CheckCommand) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
-	command := NetworkCommand{
-		Command: "Fold",
-		Payload: p.Keyring.KeyringPayload,
-		Tag:     &p.tag,
-	}
+	return p.broadcastAction(NetworkCommand{
+		Command: "Check",
+		Payload: nil,
+		Tag:     p.currentTag(),
+	})
+}
+
+func (c *CheckCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "Check",
+		Payload: moveAnswer(c.Rejection),
+		Tag:     p.currentTag(),
+	})
+}
+
+//////////////////////////////////////////// PHASE COMMANDS /////////////////////////////////////////////////////
+
+// Asks everyone still in the hand for their keys to some cards, checking each key against what its owner committed to
+// Returns the keys for each card in order, and if anyone was skipped because they couldn't be reached
+func (p *GokerPeer) requestCardKeys(command NetworkCommand, cards []*CardInfo) ([][]string, bool, error) {
 	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
+	cardKeys := make([][]string, len(cards))
+	skipped := false
+	for _, peerID := range p.otherPeers() {
+		if p.gameState.FoldedPlayers[peerID] { // Folded players already gave us their keys
 			continue
 		}
 
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
+		keyPayload, err := p.requestString(peerID, command)
+		if errors.Is(err, ErrUnreachable) && p.awaitReturn(peerID) { // They dropped but made it back in time
+			p.signCommand(&command) // They may have seen the first one
+			keyPayload, err = p.requestString(peerID, command)
+		}
+		if skipUnreachable(err) {
+			skipped = true
+			continue
+		}
 		if err != nil {
-			log.Printf("Fold: failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
+			return nil, skipped, err
 		}
-		defer stream.Close()
 
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("Fold: failed to send command to peer %s: %v", peerInfo.ID, err)
-		}
-
-		response, err := receiveResponse(stream)
-		if err != nil {
-			log.Fatalf("Fold: failed to recieve a response from peer: %s: %v", peerInfo.ID, err)
-		}
-		p.verifyCommand(peerInfo.ID, &response)
-
-		approved, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("Fold: invalid response format: expected string, got %T", response.Payload)
+		keys := strings.Split(keyPayload, "\n")
+		if err := p.checkRevealedKeys(peerID, cards, keys); err != nil {
+			p.reportBadKey(peerID, err)
+			return nil, skipped, peerErr(command.Command, peerID, ErrBadProof, "%v", err)
 		}
-
-		if approved != "APPROVED" {
-			log.Fatalf("Fold: Fold was not APPROVED, got %s", approved)
+		for i := range cards {
+			cardKeys[i] = append(cardKeys[i], keys[i])
 		}
 	}
+	return cardKeys, skipped, nil
 }
 
-func (f *FoldCommand) Respond(p *GokerPeer, sendingStream network.Stream) {
-	response := NetworkCommand{
-		Command: "Fold",
-		Payload: "APPROVED",
-		Tag:     &p.tag,
-	}
-	p.signCommand(&response)
-
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("Fold: failed to send 'APPROVED': %v", err)
+// A board card that didn't decrypt is only our problem if everyone gave us their keys, otherwise the puzzles will fill in the rest
+func undecryptedBoard(command string, skipped bool) error {
+	if skipped {
+		log.Printf("%s: missing keys from someone who left, waiting on their puzzle", command)
+		return nil
 	}
+	return localErr(command, errors.New("board didn't decrypt to cards in the reference deck"))
 }
 
-type CallCommand struct{}
+type RequestFlop struct{}
 
-func (c *CallCommand) Execute(p *GokerPeer) {
+func (rf *RequestFlop) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
-	command := NetworkCommand{
-		Command: "Call",
-		Payload: nil,
-		Tag:     &p.tag,
+	keys, skipped, err := p.requestCardKeys(NetworkCommand{Command: "RequestFlop", Payload: nil}, p.Flop)
+	if err != nil {
+		return err
 	}
-	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
-
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-		if err != nil {
-			log.Printf("Call: failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
-		}
-		defer stream.Close()
-
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("Call: failed to send command to peer %s: %v", peerInfo.ID, err)
-		}
-
-		response, err := receiveResponse(stream)
-		if err != nil {
-			log.Fatalf("Call: failed to recieve a response from peer: %s: %v", peerInfo.ID, err)
-		}
-		p.verifyCommand(peerInfo.ID, &response)
+	p.DecryptFlop(keys[0], keys[1], keys[2])
 
-		approved, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("Call: invalid response format: expected string, got %T", response.Payload)
-		}
+	cardOneName, exists := p.Deck.GetCardFromRefDeck(p.Flop[0].CardValue)
+	cardTwoName, exists2 := p.Deck.GetCardFromRefDeck(p.Flop[1].CardValue)
+	cardThreeName, exists3 := p.Deck.GetCardFromRefDeck(p.Flop[2].CardValue)
 
-		if approved != "APPROVED" {
-			log.Fatalf("Call: Call was not APPROVED, got %s", approved)
-		}
+	if exists && exists2 && exists3 {
+		p.sendBoardToGUI(&cardOneName, &cardTwoName, &cardThreeName, nil, nil)
+		return nil
 	}
+	return undecryptedBoard("RequestFlop", skipped)
 }
 
-func (c *CallCommand) Respond(p *GokerPeer, sendingStream network.Stream) {
-	response := NetworkCommand{
-		Command: "Call",
-		Payload: "APPROVED",
-		Tag:     &p.tag,
+func (rf *RequestFlop) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	keys, err := p.GetKeyPayloadForFlop()
+	if err != nil {
+		return localErr("RequestFlop", err)
 	}
-	p.signCommand(&response)
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("Call: failed to send 'APPROVED': %v", err)
-	}
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "RequestFlop",
+		Payload: keys,
+	})
 }
 
-type CheckCommand struct{}
+type RequestTurn struct{}
 
-func (c *CheckCommand) Execute(p *GokerPeer) {
+func (rt *RequestTurn) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
-	command := NetworkCommand{
-		Command: "Check",
-		Payload: nil,
-		Tag:     &p.tag,
+	keys, skipped, err := p.requestCardKeys(NetworkCommand{Command: "RequestTurn", Payload: nil}, []*CardInfo{p.Turn})
+	if err != nil {
+		return err
 	}
-	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
+	p.DecryptTurn(keys[0])
 
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-		if err != nil {
-			log.Printf("Check: failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
-		}
-		defer stream.Close()
+	cardOneName, exists := p.Deck.GetCardFromRefDeck(p.Flop[0].CardValue)
+	cardTwoName, exists1 := p.Deck.GetCardFromRefDeck(p.Flop[1].CardValue)
+	cardThreeName, exists2 := p.Deck.GetCardFromRefDeck(p.Flop[2].CardValue)
+	cardFourName, exists3 := p.Deck.GetCardFromRefDeck(p.Turn.CardValue)
 
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("Check: failed to send command to peer %s: %v", peerInfo.ID, err)
-		}
+	if exists && exists1 && exists2 && exists3 {
+		p.sendBoardToGUI(&cardOneName, &cardTwoName, &cardThreeName, &cardFourName, nil)
+		return nil
+	}
+	return undecryptedBoard("RequestTurn", skipped)
+}
 
-		response, err := receiveResponse(stream)
-		if err != nil {
-			log.Fatalf("Check: failed to recieve a response from peer: %s: %v", peerInfo.ID, err)
-		}
-		p.verifyCommand(peerInfo.ID, &response)
+func (rt *RequestTurn) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	keys, err := p.GetKeyPayloadForTurn()
+	if err != nil {
+		return localErr("RequestTurn", err)
+	}
 
-		approved, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("Check: invalid response format: expected string, got %T", response.Payload)
-		}
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "RequestTurn",
+		Payload: keys,
+	})
+}
 
-		if approved != "APPROVED" {
-			log.Fatalf("Check: Check was not APPROVED, got %s", approved)
-		}
+type RequestRiver struct{}
+
+func (rr *RequestRiver) Execute(p *GokerPeer) error {
+	p.peerListMutex.Lock()
+	defer p.peerListMutex.Unlock()
+
+	keys, skipped, err := p.requestCardKeys(NetworkCommand{Command: "RequestRiver", Payload: nil}, []*CardInfo{p.River})
+	if err != nil {
+		return err
 	}
-}
 
-func (c *CheckCommand) Respond(p *GokerPeer, sendingStream network.Stream) {
-	response := NetworkCommand{
-		Command: "Check",
-		Payload: "APPROVED",
-		Tag:     &p.tag,
+	p.DecryptRiver(keys[0])
+
+	cardOneName, exists := p.Deck.GetCardFromRefDeck(p.Flop[0].CardValue)
+	cardTwoName, exists1 := p.Deck.GetCardFromRefDeck(p.Flop[1].CardValue)
+	cardThreeName, exists2 := p.Deck.GetCardFromRefDeck(p.Flop[2].CardValue)
+	cardFourName, exists3 := p.Deck.GetCardFromRefDeck(p.Turn.CardValue)
+	cardFiveName, exists4 := p.Deck.GetCardFromRefDeck(p.River.CardValue)
+
+	if exists && exists1 && exists2 && exists3 && exists4 {
+		p.sendBoardToGUI(&cardOneName, &cardTwoName, &cardThreeName, &cardFourName, &cardFiveName)
+		return nil
 	}
-	p.signCommand(&response)
+	return undecryptedBoard("RequestRiver", skipped)
+}
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("Check: failed to send 'APPROVED': %v", err)
+func (rr *RequestRiver) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	keys, err := p.GetKeyPayloadForRiver()
+	if err != nil {
+		return localErr("RequestRiver", err)
 	}
 
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "RequestRiver",
+		Payload: keys,
+	})
 }
 
-//////////////////////////////////////////// PHASE COMMANDS /////////////////////////////////////////////////////
+//////////////////////////////////////////// END ROUND COMMANDS /////////////////////////////////////////////////////
 
-type RequestFlop struct{}
+type RequestOthersHands struct{}
 
-func (rf *RequestFlop) Execute(p *GokerPeer) {
+// Goes through everyone still in the hand even if someone fails, so the hands that did check out can be shown
+func (r *RequestOthersHands) Execute(p *GokerPeer) error {
+	p.gameState.StartShowdown() // Once we've seen anyones cards this deal can't be called off
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
-	var cardOneKeys []string
-	var cardTwoKeys []string
-	var cardThreeKeys []string
-
 	command := NetworkCommand{
-		Command: "RequestFlop",
+		Command: "RequestOthersHand",
 		Payload: nil,
 	}
 	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() || p.gameState.FoldedPlayers[peerInfo.ID] { // If it's us or the person has folded
+	var errs []error
+	for _, peerID := range p.otherPeers() {
+		if p.gameState.FoldedPlayers[peerID] {
 			continue
 		}
 
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-		if err != nil {
-			log.Printf("RequestFlop: Failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
+		keyPayload, err := p.requestString(peerID, command)
+		if errors.Is(err, ErrUnreachable) && p.awaitReturn(peerID) { // They dropped but made it back in time
+			p.signCommand(&command) // They may have seen the first one
+			keyPayload, err = p.requestString(peerID, command)
 		}
-		defer stream.Close()
-
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("RequestFlop: failed to send command to peer %s: %v", peerInfo.ID, err)
+		if skipUnreachable(err) {
+			continue
 		}
-
-		response, err := receiveResponse(stream)
 		if err != nil {
-			log.Fatalf("RequestFlop: failed to recieve response from peer: %s", peerInfo.ID)
-		}
-		p.verifyCommand(peerInfo.ID, &response)
-
-		keyPayload, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("RequestFlop: invalid response format: expected string, got %T", response.Payload)
+			errs = append(errs, err)
+			continue
 		}
 
 		keys := strings.Split(keyPayload, "\n")
-		cardOneKeys = append(cardOneKeys, keys[0])
-		cardTwoKeys = append(cardTwoKeys, keys[1])
-		cardThreeKeys = append(cardThreeKeys, keys[2])
+		if err := p.checkRevealedHandKeys(p.OthersHands[peerID], keys); err != nil {
+			p.reportBadKey(peerID, err)
+			errs = append(errs, peerErr(command.Command, peerID, ErrBadProof, "%v", err))
+			continue
+		}
+		p.DecryptOthersHand(peerID, keys)
 	}
+	return errors.Join(errs...)
+}
 
-	p.DecryptFlop(cardOneKeys, cardTwoKeys, cardThreeKeys)
-
-	cardOneName, exists := p.Deck.GetCardFromRefDeck(p.Flop[0].CardValue)
-	cardTwoName, exists2 := p.Deck.GetCardFromRefDeck(p.Flop[1].CardValue)
-	cardThreeName, exists3 := p.Deck.GetCardFromRefDeck(p.Flop[2].CardValue)
-
-	if exists && exists2 && exists3 {
-		p.sendBoardToGUI(&cardOneName, &cardTwoName, &cardThreeName, nil, nil)
-		return
+func (rh *RequestOthersHands) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	p.gameState.StartShowdown() // Whoever asked can see our cards now
+	keys, err := p.GetKeyPayloadForMyHand()
+	if err != nil {
+		return localErr("RequestOthersHand", err)
 	}
 
-	log.Fatalf("RequestFlop: could not retrieve keys, aborting.")
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "RequestOthersHand",
+		Payload: keys,
+	})
 }
 
-func (rf *RequestFlop) Respond(p *GokerPeer, sendingStream network.Stream) {
-	payload := p.GetKeyPayloadForFlop()
-	response := NetworkCommand{
-		Command: "RequestFlop",
-		Payload: payload,
-	}
-	p.signCommand(&response)
+// Everyone reveals their keyring for the hand that just finished, so it can be audited
+// Only the host can ask, and only once the peer has evaluated the hand itself
+type RevealKeyringCommand struct {
+	Keyrings map[peer.ID]SignedKeyring // Everyones keyring, including ours
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("RequestFlop: failed to send keys back to peer: %v", err)
-	}
+	Round     int    // Set when responding, the round the host asked for
+	Rejection string // Set when responding, why the keyring wasn't revealed
 }
 
-type RequestTurn struct{}
-
-func (rt *RequestTurn) Execute(p *GokerPeer) {
+func (r *RevealKeyringCommand) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
-	var turnKeys []string
+	round := p.gameState.Round
+	mine, err := p.signKeyring(round, p.Keyring.KeyringPayload)
+	if err != nil {
+		return localErr("RevealKeyring", err)
+	}
+	r.Keyrings = map[peer.ID]SignedKeyring{p.ThisHost.ID(): mine}
 
 	command := NetworkCommand{
-		Command: "RequestTurn",
+		Command: "RevealKeyring",
 		Payload: nil,
 	}
 	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() || p.gameState.FoldedPlayers[peerInfo.ID] {
+	var errs []error
+	for _, peerID := range p.otherPeers() {
+		response, err := p.request(peerID, command)
+		if skipUnreachable(err) { // They won't be in the audit
 			continue
 		}
-
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
 		if err != nil {
-			log.Printf("RequestTurn: Failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
+			errs = append(errs, err)
+			continue
 		}
-		defer stream.Close()
 
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("RequestTurn: failed to send command to peer %s: %v", peerInfo.ID, err)
+		switch answer := response.Payload.(type) {
+		case *SignedKeyring:
+			if err := p.verifyKeyring(peerID, round, *answer); err != nil {
+				errs = append(errs, peerErr(command.Command, peerID, ErrBadResponse, "%v", err))
+				continue
+			}
+			r.Keyrings[peerID] = *answer
+		case string: // Text is only ever them refusing
+			errs = append(errs, peerErr(command.Command, peerID, ErrRejected, "%s", strings.TrimPrefix(answer, "REJECTED: ")))
+		default:
+			errs = append(errs, peerErr(command.Command, peerID, ErrBadResponse, "expected a keyring, got %T", response.Payload))
 		}
+	}
+	return errors.Join(errs...)
+}
 
-		response, err := receiveResponse(stream)
+func (r *RevealKeyringCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	var payload any = "REJECTED: " + r.Rejection
+	if r.Rejection == "" {
+		keyring, err := p.signKeyring(r.Round, p.Keyring.KeyringPayload)
 		if err != nil {
-			log.Fatalf("RequestTurn: failed to recieve response from peer: %s", peerInfo.ID)
+			return localErr("RevealKeyring", err)
 		}
-		p.verifyCommand(peerInfo.ID, &response)
-
-		keyPayload, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("RequestTurn: invalid response format: expected string, got %T", response.Payload)
-		}
-
-		turnKeys = append(turnKeys, keyPayload) // since it should only be one key at at ime
+		payload = &keyring
 	}
 
-	p.DecryptTurn(turnKeys)
-
-	cardOneName, exists := p.Deck.GetCardFromRefDeck(p.Flop[0].CardValue)
-	cardTwoName, exists1 := p.Deck.GetCardFromRefDeck(p.Flop[1].CardValue)
-	cardThreeName, exists2 := p.Deck.GetCardFromRefDeck(p.Flop[2].CardValue)
-	cardFourName, exists3 := p.Deck.GetCardFromRefDeck(p.Turn.CardValue)
-
-	if exists && exists1 && exists2 && exists3 {
-		p.sendBoardToGUI(&cardOneName, &cardTwoName, &cardThreeName, &cardFourName, nil)
-		return
-	}
-
-	log.Fatalf("RequestTurn: could not retrieve keys, aborting.")
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "RevealKeyring",
+		Payload: payload,
+	})
 }
 
-func (rt *RequestTurn) Respond(p *GokerPeer, sendingStream network.Stream) {
-	payload := p.GetKeyPayloadForTurn()
-	response := NetworkCommand{
-		Command: "RequestTurn",
-		Payload: payload,
-	}
-	p.signCommand(&response)
+// Send everyone the revealed keyrings, each peer audits the hand and answers with their signed accusations
+type AuditCommand struct {
+	Round    int
+	Keyrings map[peer.ID]SignedKeyring
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("RequestTurn: failed to send keys back to peer: %v", err)
-	}
+	Accusations []Accusation // Everyones accusations once executed, or ours when responding
 }
 
-type RequestRiver struct{}
-
-func (rr *RequestRiver) Execute(p *GokerPeer) {
+func (a *AuditCommand) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
-	var riverKeys []string
+	var accusationsMutex sync.Mutex
 
 	command := NetworkCommand{
-		Command: "RequestRiver",
-		Payload: nil,
+		Command: "Audit",
+		Payload: &auditPayload{Round: a.Round, Keyrings: a.Keyrings},
 	}
 	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() || p.gameState.FoldedPlayers[peerInfo.ID] {
-			continue
+	var auditors []peer.ID
+	for _, peerID := range p.otherPeers() {
+		if _, revealed := a.Keyrings[peerID]; revealed { // Couldn't reach them to reveal, so they can't audit either
+			auditors = append(auditors, peerID)
 		}
+	}
 
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-		if err != nil {
-			log.Printf("RequestRiver: Failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
-		}
-		defer stream.Close()
+	// Do our own audit while everyone else does theirs
+	var mine []Accusation
+	var mineErr error
+	audited := make(chan struct{})
+	go func() {
+		defer close(audited)
+		mine, mineErr = p.accuse(p.ThisHost.ID(), a.Round, a.Keyrings)
+	}()
 
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("RequestRiver: failed to send command to peer %s: %v", peerInfo.ID, err)
+	err := forEachConcurrently(auditors, func(peerID peer.ID) error {
+		accusationsPayload, err := p.requestString(peerID, command)
+		if err != nil {
+			return err
 		}
 
-		response, err := receiveResponse(stream)
-		if err != nil {
-			log.Fatalf("RequestRiver: failed to recieve response from peer: %s", peerInfo.ID)
+		var accusations []Accusation
+		if err := json.Unmarshal([]byte(accusationsPayload), &accusations); err != nil {
+			return peerErr(command.Command, peerID, ErrBadResponse, "failed to decode accusations: %v", err)
 		}
-		p.verifyCommand(peerInfo.ID, &response)
 
-		keyPayload, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("RequestRiver: invalid response format: expected string, got %T", response.Payload)
+		accusationsMutex.Lock()
+		defer accusationsMutex.Unlock()
+		for _, accusation := range accusations {
+			if err := p.verifyAccusation(peerID, a.Round, accusation); err != nil {
+				log.Printf("Audit: ignoring accusation: %v", err)
+				continue
+			}
+			a.Accusations = append(a.Accusations, accusation)
 		}
+		return nil
+	})
 
-		riverKeys = append(riverKeys, keyPayload)
+	<-audited
+	if mineErr != nil {
+		err = errors.Join(err, localErr("Audit", mineErr))
 	}
+	a.Accusations = append(a.Accusations, mine...)
 
-	p.DecryptRiver(riverKeys)
-
-	cardOneName, exists := p.Deck.GetCardFromRefDeck(p.Flop[0].CardValue)
-	cardTwoName, exists1 := p.Deck.GetCardFromRefDeck(p.Flop[1].CardValue)
-	cardThreeName, exists2 := p.Deck.GetCardFromRefDeck(p.Flop[2].CardValue)
-	cardFourName, exists3 := p.Deck.GetCardFromRefDeck(p.Turn.CardValue)
-	cardFiveName, exists4 := p.Deck.GetCardFromRefDeck(p.River.CardValue)
-
-	if exists && exists1 && exists2 && exists3 && exists4 {
-		p.sendBoardToGUI(&cardOneName, &cardTwoName, &cardThreeName, &cardFourName, &cardFiveName)
-		return
+	for _, accusation := range a.Accusations {
+		description := p.describeAccusation(accusation)
+		log.Printf("Audit: %s", description)
+		p.bus.Cheating.Publish(description)
 	}
-
-	log.Fatalf("RequestRiver: could not retrieve keys, aborting.")
+	log.Println("Audit: All peers have audited the hand, continueing...")
+	return err
 }
 
-func (rr *RequestRiver) Respond(p *GokerPeer, sendingStream network.Stream) {
-	payload := p.GetKeyPayloadForRiver()
-	response := NetworkCommand{
-		Command: "RequestRiver",
-		Payload: payload,
+func (a *AuditCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	payload, err := json.Marshal(a.Accusations)
+	if err != nil {
+		return localErr("Audit", err)
 	}
-	p.signCommand(&response)
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("RequestRiver: failed to send keys back to peer: %v", err)
-	}
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "Audit",
+		Payload: string(payload),
+	})
 }
 
-//////////////////////////////////////////// END ROUND COMMANDS /////////////////////////////////////////////////////
-
-type RequestOthersHands struct{}
+// Tell everyone this deal can't go on, they refund it and the host deals again
+type AbortRoundCommand struct {
+	Abort eventbus.RoundAbort
+}
 
-func (r *RequestOthersHands) Execute(p *GokerPeer) {
+func (a *AbortRoundCommand) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
+	payload, err := json.Marshal(a.Abort)
+	if err != nil {
+		return localErr("AbortRound", err)
+	}
 	command := NetworkCommand{
-		Command: "RequestOthersHand",
-		Payload: nil,
+		Command: "AbortRound",
+		Payload: string(payload),
 	}
 	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() || p.gameState.FoldedPlayers[peerInfo.ID] {
-			continue
-		}
-
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-		if err != nil {
-			log.Printf("RequestHand: Failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
+	var errs []error
+	for _, peerID := range p.otherPeers() {
+		if err := p.notify(peerID, command); err != nil {
+			errs = append(errs, err)
 		}
-		defer stream.Close()
-
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("RequestHand: failed to send command to peer %s: %v", peerInfo.ID, err)
-		}
-
-		response, err := receiveResponse(stream)
-		if err != nil {
-			log.Fatalf("RequestHand: failed to recieve response from peer: %s", peerInfo.ID)
-		}
-		p.verifyCommand(peerInfo.ID, &response)
-
-		keyPayload, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("RequestHand: invalid response format: expected string, got %T", response.Payload)
-		}
-
-		p.DecryptOthersHand(peerInfo.ID, strings.Split(keyPayload, "\n"))
 	}
+	return errors.Join(errs...)
 }
 
-func (rh *RequestOthersHands) Respond(p *GokerPeer, sendingStream network.Stream) {
-	payload := p.GetKeyPayloadForMyHand()
-	response := NetworkCommand{
-		Command: "RequestOthersHand",
-		Payload: payload,
-	}
-	p.signCommand(&response)
-
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("RequestOthersHand: failed to send keys back to peer: %v", err)
-	}
-}
+func (a *AbortRoundCommand) Respond(p *GokerPeer, sendingStream network.Stream) error { return nil }
 
 //////////////////////////////////////////// TLP COMMANDS /////////////////////////////////////////////////////
 
 type CanRequestPuzzle struct{}
 
-func (c *CanRequestPuzzle) Execute(p *GokerPeer) {
+func (c *CanRequestPuzzle) Execute(p *GokerPeer) error {
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
@@ -1534,28 +1699,25 @@ func (c *CanRequestPuzzle) Execute(p *GokerPeer) {
 	}
 	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() {
-			continue
-		}
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
-
-		if err != nil {
-			log.Printf("CanRequstPuzzle: failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
-		}
-		defer stream.Close()
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("CanRequestPuzzle: failed to send command to peer %s: %v", peerInfo.ID, err)
+	var errs []error
+	for _, peerID := range p.otherPeers() {
+		if err := p.notify(peerID, command); err != nil {
+			errs = append(errs, err)
 		}
 	}
+	return errors.Join(errs...)
 }
 
-func (c *CanRequestPuzzle) Respond(p *GokerPeer, sendingStream network.Stream) {}
+func (c *CanRequestPuzzle) Respond(p *GokerPeer, sendingStream network.Stream) error { return nil }
 
 type RequestPuzzleCommand struct{}
 
-func (tlp *RequestPuzzleCommand) Execute(p *GokerPeer) {
+func (tlp *RequestPuzzleCommand) Execute(p *GokerPeer) error {
+	// Without our own speed there's nothing to check anyones puzzle against, the deal can't go on
+	if _, err := p.fastestSquaringSpeed(); err != nil {
+		return localErr("PuzzleExchange", err)
+	}
+
 	p.peerListMutex.Lock()
 	defer p.peerListMutex.Unlock()
 
@@ -1565,54 +1727,134 @@ func (tlp *RequestPuzzleCommand) Execute(p *GokerPeer) {
 	}
 	p.signCommand(&command)
 
-	for _, peerInfo := range p.peerList {
-		if peerInfo.ID == p.ThisHost.ID() || p.gameState.FoldedPlayers[peerInfo.ID] {
+	for _, peerID := range p.otherPeers() {
+		if p.gameState.FoldedPlayers[peerID] {
 			continue
 		}
 
-		stream, err := p.ThisHost.NewStream(context.Background(), peerInfo.ID, protocolID)
+		puzzlePayload, err := p.requestString(peerID, command)
 		if err != nil {
-			log.Printf("PuzzleExchange: Failed to create stream to host %s: %v\n", peerInfo.ID, err)
-			return
+			return err
 		}
-		defer stream.Close()
 
-		if err := sendCommand(stream, command); err != nil {
-			log.Fatalf("PuzzleExchange: failed to send command to peer %s: %v", peerInfo.ID, err)
+		if err := p.storePuzzle(peerID, puzzlePayload); errors.Is(err, sra.ErrBadPuzzle) {
+			return peerErr(command.Command, peerID, ErrBadProof, "%v", err)
+		} else if errors.Is(err, sra.ErrNoSpeed) {
+			return localErr(command.Command, err)
+		} else if err != nil {
+			return peerErr(command.Command, peerID, ErrBadResponse, "%v", err)
 		}
+	}
+	return nil
+}
 
-		response, err := receiveResponse(stream)
-		if err != nil {
-			log.Fatalf("PuzzleExchange: failed to recieve response from peer: %s", peerInfo.ID)
-		}
-		p.verifyCommand(peerInfo.ID, &response)
+func (tlp *RequestPuzzleCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	puzzle, err := p.handPuzzle()
+	if err != nil {
+		return localErr("PuzzleExchange", fmt.Errorf("failed to build time-locked puzzle: %w", err))
+	}
 
-		puzzlePayload, ok := response.Payload.(string)
-		if !ok {
-			log.Fatalf("PuzzleExchange: invalid response format: expected string, got %T", response.Payload)
-		}
+	payload, err := json.Marshal(puzzle)
+	if err != nil {
+		return localErr("PuzzleExchange", fmt.Errorf("failed to serialize time-locked puzzle: %w", err))
+	}
+
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "PuzzleExchange",
+		Payload: string(payload),
+	})
+}
+
+// Sent by whoever solved the puzzle of a player who left, everyone else checks the proof instead of solving it too
+type PuzzleSolutionCommand struct {
+	Owner    peer.ID
+	Solution *sra.Solution
+}
+
+type puzzleSolutionPayload struct {
+	Owner    peer.ID       `json:"owner"`
+	Solution *sra.Solution `json:"solution"`
+}
+
+func (ps *PuzzleSolutionCommand) Execute(p *GokerPeer) error {
+	p.peerListMutex.Lock()
+	defer p.peerListMutex.Unlock()
+
+	payload, err := json.Marshal(puzzleSolutionPayload{Owner: ps.Owner, Solution: ps.Solution})
+	if err != nil {
+		return localErr("PuzzleSolution", err)
+	}
+	command := NetworkCommand{
+		Command: "PuzzleSolution",
+		Payload: string(payload),
+	}
+	p.signCommand(&command)
 
-		go p.BreakTimeLockedPuzzle(peerInfo.ID, []byte(puzzlePayload))
+	var errs []error
+	for _, peerID := range p.otherPeers() {
+		if err := p.notify(peerID, command); err != nil && !skipUnreachable(err) {
+			errs = append(errs, err)
+		}
 	}
+	return errors.Join(errs...)
+}
+
+func (ps *PuzzleSolutionCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	return nil
+}
+
+//////////////////////////////////////////// RECONNECT COMMANDS /////////////////////////////////////////////////////
+
+// Asks someone who stayed at the table for the hand as it is now, after we dropped out and made it back in time
+type ResyncCommand struct {
+	From peer.ID
 }
 
-func (tlp *RequestPuzzleCommand) Respond(p *GokerPeer, sendingStream network.Stream) {
-	numOfPlayers := p.gameState.GetNumberOfPlayers()
-	numOfPhases := 4                                                            // Accounts for Preflop, Flop, Turn, and River
-	p.Keyring.GenerateTimeLockedPuzzle(int64(15*numOfPlayers*numOfPhases + 30)) // + 30 to account for threshold
+// The hand as the peer we resync from sees it
+type resyncPayload struct {
+	State    gamestate.Snapshot `json:"state"`
+	Tag      uint64             `json:"tag"`
+	Keyrings map[peer.ID]string `json:"keyrings"` // Revealed by everyone who folded, including anyone who folded while we were away
+}
 
-	payload, err := json.Marshal(p.Keyring.TLP)
+func (r *ResyncCommand) Execute(p *GokerPeer) error {
+	// No peer list lock, catching up on the board asks everyone for their keys
+	resync, err := p.fetchResync(r.From)
 	if err != nil {
-		log.Fatalf("failed to serialize time-locked puzzle")
+		return err
 	}
+	if resync, err = p.confirmResync(r.From, resync); err != nil {
+		return err
+	}
+	return p.applyResync(r.From, resync)
+}
 
-	response := NetworkCommand{
-		Command: "PuzzleExchange",
-		Payload: string(payload),
+// Asks one peer for the hand as they see it
+func (p *GokerPeer) fetchResync(from peer.ID) (resyncPayload, error) {
+	command := NetworkCommand{
+		Command: "Resync",
+		Payload: nil,
 	}
-	p.signCommand(&response)
+	p.signCommand(&command)
 
-	if err := sendCommand(sendingStream, response); err != nil {
-		log.Fatalf("RequestOthersHand: failed to send keys back to peer: %v", err)
+	var resync resyncPayload
+	payload, err := p.requestString(from, command)
+	if err != nil {
+		return resync, err
 	}
+	if err := json.Unmarshal([]byte(payload), &resync); err != nil {
+		return resync, peerErr("Resync", from, ErrBadResponse, "%v", err)
+	}
+	return resync, nil
+}
+
+func (r *ResyncCommand) Respond(p *GokerPeer, sendingStream network.Stream) error {
+	payload, err := json.Marshal(resyncPayload{State: p.gameState.Snapshot(), Tag: p.tag.Load(), Keyrings: p.revealedKeyrings()})
+	if err != nil {
+		return localErr("Resync", err)
+	}
+	return p.respond(sendingStream, NetworkCommand{
+		Command: "Resync",
+		Payload: string(payload),
+	})
 }
@@ -2,7 +2,6 @@ package p2p
 
 import (
 	"context"
-	"goker/internal/channelmanager"
 	"log"
 	"time"
 
@@ -32,6 +31,9 @@ func (p *GokerPeer) connectToHost(peerAddr string) {
 		return
 	}
 
+	// Set sessionHost, before connecting as they greet us as soon as we do
+	p.sessionHost = peerInfo{ID: pinfo.ID, Addr: addr}
+
 	// Connect to the host
 	if err := p.ThisHost.Connect(ctx, *pinfo); err != nil {
 		log.Printf("connectToHost: %v\n", err)
@@ -39,13 +41,16 @@ func (p *GokerPeer) connectToHost(peerAddr string) {
 	}
 
 	log.Printf("Connected to host: %s\n", pinfo.ID)
-	// Set sessionHost
-	p.sessionHost = peerInfo{ID: pinfo.ID, Addr: addr}
 
-	p.ExecuteCommand(&GetPeerListCommand{})
+	if err := p.ExecuteCommand(&GetPeerListCommand{}); err != nil {
+		log.Printf("connectToHost: %v\n", err)
+		return
+	}
 
-	p.ExecuteCommand(&NicknameRequestCommand{})
+	if err := p.ExecuteCommand(&NicknameRequestCommand{}); err != nil {
+		log.Printf("connectToHost: %v\n", err) // Whoever didn't answer is asked again when they connect to us
+	}
 
 	// Tell GUI to change the number of players
-	channelmanager.FNET_NumOfPlayersChan <- len(p.peerList)
+	p.bus.NumOfPlayers.Publish(len(p.peerList))
 }
@@ -0,0 +1,225 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Where and when a command was sent, signed along with it so it can't be replayed anywhere else
+type Envelope struct {
+	Table   string  `json:"table"`   // Made by the host, joiners learn it from the peer list
+	Round   int     `json:"round"`   // The senders round when they signed it
+	Phase   string  `json:"phase"`   // The senders phase when they signed it
+	Session uint64  `json:"session"` // When the sender started, a newer one means they restarted and Seq counts from 1 again
+	Seq     uint64  `json:"seq"`     // Counts up from 1 for every command and response the sender signs
+	Sender  peer.ID `json:"sender"`  // Has to be who the command actually came from
+}
+
+// How long a command from a round or phase we haven't got to yet waits for us to catch up
+// The host can start dealing the next hand while we're still evaluating the last, and everyone switches phase on their own
+var envelopeWait = 30 * time.Second
+
+// Commands that aren't part of the hand we're in, or are checked against the hand they're about in their own handlers
+var roundlessCommands = map[string]bool{
+	"GetPeers":        true,
+	"NicknameRequest": true,
+	"InitTable":       true,
+	"NewKeys":         true, // Starts the next hand, we may still be finishing the last one
+	"RevealKeyring":   true, // Only for the hand we last evaluated
+	"Audit":           true,
+	"Resync":          true, // From someone who doesn't know what round it is any more
+}
+
+// The phase whose keys each request asks for, it can't be sent or answered before the hand gets there
+var keyPhases = map[string]string{
+	"RequestFlop":       "flop",
+	"RequestTurn":       "turn",
+	"RequestRiver":      "river",
+	"RequestOthersHand": "river",
+}
+
+// Phases in the order a hand goes through them
+var handPhases = []string{"preflop", "flop", "turn", "river"}
+
+// How far behind the newest command from a sender another one can arrive and still be accepted
+// Every command goes on its own stream, so two sent close together can be handled in either order
+const replayWindow = 64
+
+// Sequence numbers already accepted from one sender
+type sequenceWindow struct {
+	session uint64 // Of the sender, these are only the sequence numbers they signed since they started
+	highest uint64
+	seen    uint64 // Bit i is set once highest-i has been accepted
+}
+
+// Records a sequence number, false if it's been seen before or is too old to tell
+func (w *sequenceWindow) accept(seq uint64) bool {
+	switch {
+	case seq == 0: // Never signed by us
+		return false
+	case seq > w.highest:
+		if shift := seq - w.highest; shift < replayWindow {
+			w.seen = w.seen<<shift | 1
+		} else {
+			w.seen = 1
+		}
+		w.highest = seq
+		return true
+	case w.highest-seq >= replayWindow:
+		return false
+	default:
+		bit := uint64(1) << (w.highest - seq)
+		if w.seen&bit != 0 {
+			return false
+		}
+		w.seen |= bit
+		return true
+	}
+}
+
+// A random ID for a table we're hosting
+func newTableID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Fatalf("newTableID: %v", err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// The table we're at, empty until a joiner has heard from the host
+func (p *GokerPeer) currentTable() string {
+	p.envelopeMutex.Lock()
+	defer p.envelopeMutex.Unlock()
+	return p.tableID
+}
+
+// A session for a peer starting now, later than any it could have started before
+func newSession() uint64 {
+	return uint64(time.Now().UnixNano())
+}
+
+// Fills in the envelope for a command we're about to sign
+func (p *GokerPeer) seal(nCmd *NetworkCommand) {
+	round, phase := p.gameState.GetRoundAndPhase()
+	nCmd.Envelope = Envelope{
+		Table:   p.currentTable(),
+		Round:   round,
+		Phase:   phase,
+		Session: p.session,
+		Seq:     p.seq.Add(1),
+		Sender:  p.ThisHost.ID(),
+	}
+}
+
+// Checks the envelope of a command with a good signature, and records its sequence number
+func (p *GokerPeer) openEnvelope(from peer.ID, nCmd *NetworkCommand) error {
+	if round, _ := p.gameState.GetRoundAndPhase(); isGameCommand(nCmd.Command) && nCmd.Round != round {
+		return peerErr(nCmd.Command, from, ErrBadEnvelope, "sent in round %d, we're in round %d", nCmd.Round, round)
+	}
+
+	p.envelopeMutex.Lock()
+	defer p.envelopeMutex.Unlock()
+
+	// A joiner takes the table from the host they dialled, whichever of its peer list or greeting comes first
+	if p.tableID == "" && from == p.sessionHost.ID {
+		p.tableID = nCmd.Table
+	}
+	// Joiners asking for the peer list don't know the table yet
+	if nCmd.Command != "GetPeers" && nCmd.Table != p.tableID {
+		return peerErr(nCmd.Command, from, ErrBadEnvelope, "sent for table %q, we're at %q", nCmd.Table, p.tableID)
+	}
+
+	if p.sequences == nil {
+		p.sequences = make(map[peer.ID]*sequenceWindow)
+	}
+	window, ok := p.sequences[from]
+	if !ok || nCmd.Session > window.session { // New to us, or they restarted and count from 1 again
+		window = &sequenceWindow{session: nCmd.Session}
+		p.sequences[from] = window
+	}
+	if nCmd.Session < window.session {
+		return peerErr(nCmd.Command, from, ErrReplay, "signed before they last restarted")
+	}
+	if !window.accept(nCmd.Seq) {
+		return peerErr(nCmd.Command, from, ErrReplay, "sequence number %d already seen or too old (newest is %d)", nCmd.Seq, window.highest)
+	}
+	return nil
+}
+
+// Checks a command about the hand is from the round we're in, and any keys it asks for are from a phase we've both reached
+// A command from further on waits a while for us to catch up, keys for the hand we last evaluated stay ours to give out until the next one starts
+func (p *GokerPeer) checkTiming(from peer.ID, nCmd *NetworkCommand) error {
+	if roundlessCommands[nCmd.Command] || isGameCommand(nCmd.Command) { // Betting was checked with its envelope, it can't wait
+		return nil
+	}
+
+	if nCmd.Command == "RequestHand" && nCmd.Phase != "preflop" {
+		return peerErr(nCmd.Command, from, ErrBadEnvelope, "hands are only dealt preflop, sent in %q", nCmd.Phase)
+	}
+	phase, asksForKeys := keyPhases[nCmd.Command]
+	if asksForKeys && !phaseReached(nCmd.Phase, phase) {
+		return peerErr(nCmd.Command, from, ErrBadEnvelope, "asked for the %s keys in %q", phase, nCmd.Phase)
+	}
+	if evaluated := p.lastEvaluated(); asksForKeys && evaluated != 0 && nCmd.Round == evaluated {
+		return nil
+	}
+
+	deadline := time.Now().Add(envelopeWait)
+	for {
+		round, current := p.gameState.GetRoundAndPhase()
+		if nCmd.Round < round {
+			return peerErr(nCmd.Command, from, ErrBadEnvelope, "sent in round %d, we're in round %d", nCmd.Round, round)
+		}
+		if nCmd.Round == round && (!asksForKeys || phaseReached(current, phase)) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return peerErr(nCmd.Command, from, ErrBadEnvelope, "sent in round %d %s, we're still in round %d %s", nCmd.Round, nCmd.Phase, round, current)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Whether a hand in the current phase has got as far as the given one
+// Anything after the river counts, nothing does before the hand has started
+func phaseReached(current, phase string) bool {
+	i := slices.Index(handPhases, phase)
+	return current != "" && i >= 0 && !slices.Contains(handPhases[:i], current)
+}
+
+// Everything a signature covers, the envelope first
+func (nCmd *NetworkCommand) signingData() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s\n%d\n%d\n%s\n%d\n%s\n", nCmd.Table, nCmd.Sender, nCmd.Session, nCmd.Round, nCmd.Phase, nCmd.Seq, nCmd.Command)
+	switch payload := nCmd.Payload.(type) {
+	case nil:
+	case wireMessage: // Its wire encoding, which only comes out one way - checkPayload refuses any that won't encode
+		msg, _ := payload.appendWire(nil)
+		b.WriteString("message\n")
+		b.Write(msg)
+	default:
+		payloadJSON, _ := json.Marshal(nCmd.Payload)
+		b.Write(payloadJSON)
+	}
+	if nCmd.Tag != nil { // Add tag if present
+		fmt.Fprintf(&b, "\n%d", *nCmd.Tag)
+	}
+	return b.String()
+}
+
+// Betting commands, only valid in the phase (tag) and round they were made in
+func isGameCommand(command string) bool {
+	switch command {
+	case "Raise", "Check", "Call", "Fold":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequenceWindow(t *testing.T) {
+	var w sequenceWindow
+	require.False(t, w.accept(0), "nothing is signed with 0")
+
+	for _, seq := range []uint64{1, 3, 2, 5} {
+		require.True(t, w.accept(seq), "%d is new", seq)
+	}
+	for _, seq := range []uint64{1, 2, 3, 5} {
+		require.False(t, w.accept(seq), "%d was already seen", seq)
+	}
+	require.True(t, w.accept(4), "late but inside the window")
+
+	// Jumping ahead forgets what's fallen out of the window, those are refused outright
+	require.True(t, w.accept(5+replayWindow))
+	require.False(t, w.accept(5), "too old to tell if it's a replay")
+	require.True(t, w.accept(6), "still inside the window")
+	require.False(t, w.accept(6))
+
+	// Jumping further than the window clears everything
+	require.True(t, w.accept(1000))
+	require.True(t, w.accept(1000-replayWindow+1))
+	require.False(t, w.accept(1000-replayWindow))
+}
@@ -0,0 +1,70 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Kinds of failure a command can run into, check for them with errors.Is
+var (
+	ErrUnreachable  = errors.New("peer unreachable") // Couldn't open a stream, send a command, or read the response
+	ErrBadSignature = errors.New("bad signature")    // Command or response wasn't signed by who sent it
+	ErrBadTag       = errors.New("bad tag")          // Game command with a missing or out of date tag
+	ErrBadEnvelope  = errors.New("bad envelope")     // Command signed for another table, round or sender
+	ErrReplay       = errors.New("replayed")         // Command with a sequence number we've already seen, or one too old to check
+	ErrBadResponse  = errors.New("bad response")     // Response wasn't what the command expects
+	ErrRejected     = errors.New("rejected")         // Peer refused what we sent, e.g. a deck that didn't check out
+	ErrBadProof     = errors.New("bad proof")        // Peer sent a deck or key that doesn't match their proofs or commitments
+	ErrLocal        = errors.New("local failure")    // Something on our side went wrong, nobody else is to blame
+)
+
+// A command failed because of something a specific peer did (or didn't do)
+type PeerError struct {
+	Command string
+	Peer    peer.ID
+	Err     error
+}
+
+func (e *PeerError) Error() string {
+	return fmt.Sprintf("%s: peer %s: %v", e.Command, e.Peer, e.Err)
+}
+
+func (e *PeerError) Unwrap() error {
+	return e.Err
+}
+
+// Builds a PeerError of the given kind, with the details formatted after it
+func peerErr(command string, id peer.ID, kind error, format string, args ...any) error {
+	return &PeerError{Command: command, Peer: id, Err: fmt.Errorf("%w: %s", kind, fmt.Sprintf(format, args...))}
+}
+
+// Something went wrong on our side of a command
+func localErr(command string, err error) error {
+	return fmt.Errorf("%s: %w: %w", command, ErrLocal, err)
+}
+
+// Returns the peer to blame for an error, if there is one
+func Offender(err error) (peer.ID, bool) {
+	var pe *PeerError
+	if errors.As(err, &pe) {
+		return pe.Peer, true
+	}
+	return "", false
+}
+
+// Returns every peer to blame for an error, for errors joined together from several peers
+func Offenders(err error) []peer.ID {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var offenders []peer.ID
+		for _, err := range joined.Unwrap() {
+			offenders = append(offenders, Offenders(err)...)
+		}
+		return offenders
+	}
+	if offender, ok := Offender(err); ok {
+		return []peer.ID{offender}
+	}
+	return nil
+}
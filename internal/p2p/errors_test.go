@@ -0,0 +1,353 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"goker/internal/tablerules"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// Failed commands come back as errors naming the peer at fault, instead of taking the program down
+func TestCommandErrors(t *testing.T) {
+	tt := newTestTable(t, 2)
+	host, other := tt.host(), tt.peers[1]
+	otherID := other.ThisHost.ID()
+	for _, p := range tt.peers { // Commands about the hand are bound to its round and phase
+		p.gameState.Round = 1
+		p.gameState.SetPhase("preflop")
+	}
+
+	t.Run("rejected", func(t *testing.T) {
+		command := NetworkCommand{Command: "NewKeys", Payload: "rot13"}
+		host.signCommand(&command)
+		requireOffender(t, host.requestApproval(otherID, command, "DONE"), ErrRejected, otherID)
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		command := NetworkCommand{Command: "RequestFlop", Payload: "1"}
+		other.signCommand(&command)
+		command.Payload = "2" // Changed after it was signed
+		requireOffender(t, host.verifyCommand(otherID, &command), ErrBadSignature, otherID)
+	})
+
+	t.Run("wrong sender", func(t *testing.T) {
+		command := NetworkCommand{Command: "RequestFlop"}
+		host.signCommand(&command) // Not signed by who it claims to be from
+		requireOffender(t, host.verifyCommand(otherID, &command), ErrBadEnvelope, otherID)
+	})
+
+	t.Run("wrong table", func(t *testing.T) {
+		tableID := other.tableID
+		other.tableID = "somewhere else"
+		command := NetworkCommand{Command: "RequestFlop"}
+		other.signCommand(&command)
+		other.tableID = tableID
+		requireOffender(t, host.verifyCommand(otherID, &command), ErrBadEnvelope, otherID)
+	})
+
+	t.Run("wrong round", func(t *testing.T) {
+		command := NetworkCommand{Command: "Call", Tag: host.currentTag()}
+		other.signCommand(&command)
+		host.gameState.Round++
+		defer func() { host.gameState.Round-- }()
+		requireOffender(t, host.verifyCommand(otherID, &command), ErrBadEnvelope, otherID)
+
+		// Not just betting, anything about the hand
+		command = NetworkCommand{Command: "ProtocolFS", Payload: "1"}
+		other.signCommand(&command)
+		requireOffender(t, host.verifyCommand(otherID, &command), ErrBadEnvelope, otherID)
+	})
+
+	t.Run("wrong phase", func(t *testing.T) {
+		command := NetworkCommand{Command: "RequestRiver"} // Before the river has been dealt
+		other.signCommand(&command)
+		requireOffender(t, host.verifyCommand(otherID, &command), ErrBadEnvelope, otherID)
+
+		other.gameState.SetPhase("flop")
+		defer func() { other.gameState.SetPhase("preflop") }()
+		command = NetworkCommand{Command: "RequestHand"} // After the hands were dealt
+		other.signCommand(&command)
+		requireOffender(t, host.verifyCommand(otherID, &command), ErrBadEnvelope, otherID)
+	})
+
+	t.Run("ahead of us", func(t *testing.T) {
+		wait := envelopeWait
+		envelopeWait = 100 * time.Millisecond
+		defer func() { envelopeWait = wait }()
+
+		other.gameState.SetPhase("flop")
+		defer func() { other.gameState.SetPhase("preflop") }()
+		command := NetworkCommand{Command: "RequestFlop"}
+		other.signCommand(&command)
+		requireOffender(t, host.verifyCommand(otherID, &command), ErrBadEnvelope, otherID)
+
+		// Fine once we get there in time
+		command = NetworkCommand{Command: "RequestFlop"}
+		other.signCommand(&command)
+		envelopeWait = 5 * time.Second
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			host.gameState.SetPhase("flop")
+		}()
+		require.NoError(t, host.verifyCommand(otherID, &command))
+		host.gameState.SetPhase("preflop")
+	})
+
+	t.Run("keys for the last hand", func(t *testing.T) {
+		other.gameState.SetPhase("river")
+		defer func() { other.gameState.SetPhase("preflop") }()
+		signed := func() *NetworkCommand {
+			command := NetworkCommand{Command: "RequestOthersHand"}
+			other.signCommand(&command)
+			return &command
+		}
+
+		// We've evaluated the hand and moved on, our keys for it haven't changed yet
+		host.HandEvaluated(host.gameState.Round)
+		host.gameState.Round++
+		defer func() { host.gameState.Round-- }()
+		require.NoError(t, host.verifyCommand(otherID, signed()))
+
+		// Until the next hand starts
+		host.handStarted()
+		requireOffender(t, host.verifyCommand(otherID, signed()), ErrBadEnvelope, otherID)
+	})
+
+	t.Run("replayed", func(t *testing.T) {
+		first := NetworkCommand{Command: "RequestHand"}
+		other.signCommand(&first)
+		second := NetworkCommand{Command: "RequestHand"}
+		other.signCommand(&second)
+
+		// Out of order is fine, seeing either of them again isn't
+		require.NoError(t, host.verifyCommand(otherID, &second))
+		require.NoError(t, host.verifyCommand(otherID, &first))
+		requireOffender(t, host.verifyCommand(otherID, &first), ErrReplay, otherID)
+		requireOffender(t, host.verifyCommand(otherID, &second), ErrReplay, otherID)
+	})
+
+	t.Run("restarted", func(t *testing.T) {
+		before := NetworkCommand{Command: "RequestHand"}
+		other.signCommand(&before)
+		require.NoError(t, host.verifyCommand(otherID, &before))
+
+		// Someone who restarts the game counts from 1 again in a newer session
+		restarted := NetworkCommand{Command: "RequestHand"}
+		other.signCommand(&restarted)
+		restarted.Session, restarted.Seq = newSession(), 1
+		signature, err := other.Keyring.SignMessage(restarted.signingData())
+		require.NoError(t, err)
+		restarted.Signature = signature
+		require.NoError(t, host.verifyCommand(otherID, &restarted))
+		requireOffender(t, host.verifyCommand(otherID, &restarted), ErrReplay, otherID)
+
+		// And nothing signed before that is taken any more
+		stale := NetworkCommand{Command: "RequestHand"}
+		other.signCommand(&stale)
+		requireOffender(t, host.verifyCommand(otherID, &stale), ErrReplay, otherID)
+
+		// They didn't really restart, the rest of the test carries on in their real session
+		host.envelopeMutex.Lock()
+		delete(host.sequences, otherID)
+		host.envelopeMutex.Unlock()
+	})
+
+	t.Run("tag pushed by someone other than the host", func(t *testing.T) {
+		tag := host.tag.Load() + 1
+		command := NetworkCommand{Command: "PushTag", Tag: &tag}
+		other.signCommand(&command)
+		require.NoError(t, other.notify(host.ThisHost.ID(), command))
+		require.Never(t, func() bool { return host.tag.Load() == tag }, 200*time.Millisecond, 10*time.Millisecond)
+	})
+
+	t.Run("bad tag", func(t *testing.T) {
+		staleTag := host.tag.Load() + 1
+		command := NetworkCommand{Command: "Call", Tag: &staleTag}
+		other.signCommand(&command)
+		requireOffender(t, host.verifyCommand(otherID, &command), ErrBadTag, otherID)
+
+		// Answers are for the phase we asked in, the next tag may have reached them first
+		response := NetworkCommand{Command: "Call", Payload: "APPROVED", Tag: &staleTag}
+		other.signCommand(&response)
+		require.NoError(t, host.verifyResponse(otherID, &response))
+	})
+
+	t.Run("nothing to answer with", func(t *testing.T) {
+		// No hand has been dealt, asking for its keys used to take the peer down
+		for _, p := range tt.peers {
+			p.gameState.SetPhase("river")
+		}
+		defer func() {
+			for _, p := range tt.peers {
+				p.gameState.SetPhase("preflop")
+			}
+		}()
+		command := NetworkCommand{Command: "RequestOthersHand"}
+		host.signCommand(&command)
+		_, err := host.requestString(otherID, command)
+		require.Error(t, err)
+
+		command = NetworkCommand{Command: "NicknameRequest"}
+		host.signCommand(&command)
+		_, err = host.request(otherID, command)
+		require.NoError(t, err, "they're still there")
+	})
+
+	t.Run("garbage answer", func(t *testing.T) {
+		defer other.ThisHost.SetStreamHandler(binaryProtocolID, other.handleStream)
+		for name, answer := range map[string][]byte{
+			"not a command": {3, 0xff, 0xff, 0xff},
+			"cut short":     {10, 1, 2},
+		} {
+			other.ThisHost.SetStreamHandler(binaryProtocolID, func(stream network.Stream) {
+				defer stream.Close()
+				readCommand(stream, baseFrameSize)
+				stream.Write(answer)
+			})
+			command := NetworkCommand{Command: "RequestFlop"}
+			host.signCommand(&command)
+			_, err := host.requestString(otherID, command)
+			requireOffender(t, err, ErrBadResponse, otherID)
+			require.False(t, skipUnreachable(err), name)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		nobody := peer.ID("nobody")
+		_, err := host.requestString(nobody, NetworkCommand{Command: "RequestFlop"})
+		requireOffender(t, err, ErrUnreachable, nobody)
+	})
+
+	t.Run("local", func(t *testing.T) {
+		err := localErr("ProtocolFS", errors.New("out of randomness"))
+		require.ErrorIs(t, err, ErrLocal)
+		_, ok := Offender(err)
+		require.False(t, ok, "nobody else is to blame for our own failures")
+	})
+
+	t.Run("several peers", func(t *testing.T) {
+		nobody := peer.ID("nobody")
+		err := errors.Join(
+			peerErr("RequestOthersHand", otherID, ErrBadProof, "bad key"),
+			localErr("RequestOthersHand", errors.New("out of memory")),
+			fmt.Errorf("wrapped: %w", peerErr("RequestOthersHand", nobody, ErrUnreachable, "gone")),
+		)
+		require.Equal(t, []peer.ID{otherID, nobody}, Offenders(err))
+		require.Empty(t, Offenders(localErr("ProtocolFS", errors.New("out of randomness"))))
+	})
+}
+
+// Moves that aren't the senders to make are refused by everyone before they touch the state
+func TestIllegalMoves(t *testing.T) {
+	tt := newTestTable(t, 2)
+	tt.initTable(tablerules.Default())
+	for _, p := range tt.peers {
+		p.gameState.PostBlinds()
+	}
+
+	actor, waiting := tt.host(), tt.peers[1] // Whoever isn't first to act is out of turn
+	if !actor.gameState.IsMyTurn() {
+		actor, waiting = waiting, actor
+	}
+
+	t.Run("negative raise", func(t *testing.T) {
+		cheating := waiting.bus.Cheating.Subscribe()
+		defer cheating.Close()
+		money := maps.Clone(waiting.gameState.PlayersMoney)
+
+		requireOffender(t, actor.ExecuteCommand(&RaiseCommand{Amount: -50}), ErrRejected, waiting.ThisHost.ID())
+		require.Equal(t, money, waiting.gameState.PlayersMoney, "a negative raise would have added to their stack")
+		require.Len(t, drain(cheating.C()), 1, "and they're accused of trying")
+	})
+
+	t.Run("out of turn", func(t *testing.T) {
+		cheating := actor.bus.Cheating.Subscribe()
+		defer cheating.Close()
+		money := maps.Clone(actor.gameState.PlayersMoney)
+		whosTurn := actor.gameState.WhosTurn
+
+		requireOffender(t, waiting.ExecuteCommand(&RaiseCommand{Amount: 10}), ErrRejected, actor.ThisHost.ID())
+		require.Equal(t, money, actor.gameState.PlayersMoney)
+		require.Equal(t, whosTurn, actor.gameState.WhosTurn)
+		require.Len(t, drain(cheating.C()), 1)
+	})
+}
+
+// Commands that run the table are ignored from anyone but the host, however well they're signed
+func TestHostOnlyCommands(t *testing.T) {
+	tt := newTestTable(t, 3)
+	tt.initTable(tablerules.Default())
+	other, target := tt.peers[1], tt.peers[2]
+	targetID := target.ThisHost.ID()
+
+	rules := tablerules.Default()
+	rules.StartingCash *= 2
+	encoded, err := rules.Encode()
+	require.NoError(t, err)
+	cipher, err := target.tableCipher()
+	require.NoError(t, err)
+	deck := target.Deck.GenerateDeckPayload()
+
+	// Anything the host would have answered gets no answer at all
+	for _, command := range []NetworkCommand{
+		{Command: "InitTable", Payload: encoded},
+		{Command: "NewKeys", Payload: cipher.Name()},
+		{Command: "ProtocolFS", Payload: "1\n2\n3"},
+		{Command: "BroadcastNewDeck", Payload: &deckTranscript{Start: "1\n2\n3"}},
+		{Command: "ProtocolSS", Payload: "1\n2\n3"},
+		{Command: "BroadcastDeck", Payload: &deckTranscript{Start: "1\n2\n3"}},
+	} {
+		t.Run(command.Command, func(t *testing.T) {
+			other.signCommand(&command)
+			_, err := other.request(targetID, command)
+			requireOffender(t, err, ErrUnreachable, targetID)
+			require.Equal(t, tablerules.Default(), target.gameState.Rules)
+			require.Equal(t, deck, target.Deck.GenerateDeckPayload())
+		})
+	}
+
+	t.Run("PushTag", func(t *testing.T) {
+		tag := target.tag.Load()
+		command := NetworkCommand{Command: "PushTag", Tag: new(uint64)}
+		*command.Tag = tag + 1
+		other.signCommand(&command)
+		require.NoError(t, other.notify(targetID, command))
+		require.Never(t, func() bool { return target.tag.Load() != tag }, 200*time.Millisecond, 20*time.Millisecond)
+	})
+
+	t.Run("MoveToTable", func(t *testing.T) {
+		moved := target.bus.StartRound.Subscribe()
+		defer moved.Close()
+		command := NetworkCommand{Command: "MoveToTable"}
+		other.signCommand(&command)
+		require.NoError(t, other.notify(targetID, command))
+		require.Empty(t, drain(moved.C()))
+	})
+
+	// Without a hand dealt either would fail, and the failure would be published
+	for _, name := range []string{"CanRequestHand", "CanRequestPuzzle"} {
+		t.Run(name, func(t *testing.T) {
+			failed := target.bus.CommandFailed.Subscribe()
+			defer failed.Close()
+			command := NetworkCommand{Command: name}
+			other.signCommand(&command)
+			require.NoError(t, other.notify(targetID, command))
+			require.Empty(t, drain(failed.C()))
+		})
+	}
+}
+
+// The error is of the given kind and blames the given peer
+func requireOffender(t *testing.T, err error, kind error, id peer.ID) {
+	t.Helper()
+	require.ErrorIs(t, err, kind)
+	offender, ok := Offender(err)
+	require.True(t, ok)
+	require.Equal(t, id, offender)
+}
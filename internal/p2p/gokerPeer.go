@@ -3,20 +3,22 @@ package p2p
 import (
 	"crypto/rand"
 	"encoding/binary"
-	"encoding/json"
 	"fmt"
-	"goker/internal/channelmanager"
+	"goker/internal/eventbus"
 	"goker/internal/gamestate"
+	"goker/internal/identity"
 	"goker/internal/sra"
 	"log"
-	"math/big"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	libp2p "github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 )
 
 var protocolID = protocol.ID("/goker/command/1.0.0")
@@ -40,11 +42,49 @@ type GokerPeer struct {
 	River       *CardInfo
 	Keyring     *sra.Keyring // Holds all encryption logic
 
+	// Long-term keys from the players keystore, a throwaway identity is made at Init when this is nil
+	Identity *identity.Identity
+	// Addresses to listen on, libp2p's defaults when empty
+	ListenAddrs []string
+
+	// Everyones time locked puzzles this hand, see puzzle_handler.go
+	puzzles      *handPuzzles
+	puzzlesMutex sync.Mutex
+
+	// Players who dropped mid-hand and still have time to come back, see reconnect_handler.go
+	dropped        map[peer.ID]*droppedPeer
+	resyncing      chan struct{} // Closed once we've caught up after being cut off, nil if we weren't
+	droppedMutex   sync.Mutex
+	redialInterval time.Duration // Set once when the peer starts, tests hold off redialling to keep someone away
+
+	// Squaring speeds everyone else measured, sent with their nickname - the fastest decides how long puzzles are locked for
+	squaringSpeeds map[peer.ID]int64
+	speedsMutex    sync.Mutex
+
+	// Our keyring is only revealed once the hand it's for is over, see audit_handler.go
+	evaluatedRound  int           // Round of the hand we last evaluated, 0 until then
+	evaluated       chan struct{} // Closed once the hand our keys are for has been evaluated
+	evaluationMutex sync.Mutex
+
+	// Proven decks from both steps of the protocol this round
+	shuffleTranscript   *deckTranscript
+	variationTranscript *deckTranscript
+
 	// state given by the game manager
 	gameState *gamestate.GameState
 
+	// Bus given by the game manager, the network publishes its progress and GUI updates here
+	bus *eventbus.Bus
+
 	// Context (tag) for commands per betting phase
-	tag uint64
+	tag atomic.Uint64
+
+	// Envelope details, see envelope_handler.go
+	session       uint64                      // When we started, set once before anything is signed
+	seq           atomic.Uint64               // Last sequence number we signed this session
+	tableID       string                      // Same for everyone at the table, made by the host
+	sequences     map[peer.ID]*sequenceWindow // Sequence numbers seen from each peer
+	envelopeMutex sync.Mutex                  // Guards tableID and sequences
 }
 
 // Holds important information about other peers in the network
@@ -53,36 +93,60 @@ type peerInfo struct {
 	Addr multiaddr.Multiaddr
 }
 
-func (p *GokerPeer) Init(nickname string, hosting bool, givenAddr string, givenState *gamestate.GameState) {
-	// Setup deck and keyring for later
+func (p *GokerPeer) Init(nickname string, hosting bool, givenAddr string, givenState *gamestate.GameState, givenBus *eventbus.Bus) {
+	if p.Identity == nil { // Nothing to carry over to the next game
+		id, err := identity.Generate()
+		if err != nil {
+			log.Fatalf("failed to generate identity: %v", err)
+		}
+		p.Identity = id
+	}
+
+	// Setup keyring for later, it signs with the host key once the host is made
 	p.Keyring = new(sra.Keyring)
-	p.Keyring.GenerateSigningKeys()
-	p.Keyring.CalibrateSquaringSpeed()
+	p.Keyring.CalibrateSquaringSpeed(sra.DefaultCalibrationFile()) // In the background, it's only needed once a hand is dealt
 
+	opts := []libp2p.Option{libp2p.Identity(p.Identity.HostKey)}
+	if len(p.ListenAddrs) > 0 {
+		opts = append(opts, libp2p.ListenAddrStrings(p.ListenAddrs...))
+	}
+	p.start(nickname, hosting, givenAddr, givenState, givenBus, opts...)
+}
+
+// Everything in Init after the keyring is made - tests use this directly to skip calibration and listen on loopback only
+func (p *GokerPeer) start(nickname string, hosting bool, givenAddr string, givenState *gamestate.GameState, givenBus *eventbus.Bus, opts ...libp2p.Option) {
+	// Setup deck for later
 	p.Deck = new(deckInfo)
 	p.OthersHands = make(map[peer.ID][]*CardInfo)
-	// TODO: Make this decided at runtime? - Should do this more securely in the future
-	p.Deck.GenerateDecks("gokerdecksecretkeyforhashesversion1")
+	p.Deck.GenerateDecks(DeckKey)
 
-	// Set the givenState
+	// Set the givenState and givenBus
 	p.gameState = givenState
+	p.bus = givenBus
+	if p.redialInterval == 0 {
+		p.redialInterval = defaultRedialInterval
+	}
+	p.session = newSession()
 
 	// Create a new libp2p Host
-	h, err := libp2p.New()
+	h, err := libp2p.New(opts...)
 	if err != nil {
 		log.Fatalf("failed to create host: %v", err)
 	}
 	// Setup this Host
 	p.ThisHost = h
+	p.Keyring.SetSigningKey(h.Peerstore().PrivKey(h.ID())) // Commands are signed with the key peers authenticate us with
 	// Add host to state
 	p.gameState.AddPeerToState(p.ThisHost.ID(), nickname)
 	p.gameState.Me = p.ThisHost.ID()
-	// Set stream handler for this peer
+	// Set stream handlers for this peer, the binary protocol and JSON for peers that don't speak it yet
+	h.SetStreamHandler(binaryProtocolID, p.handleStream)
 	h.SetStreamHandler(protocolID, p.handleStream)
 
 	// Print the host's ID and multiaddresses
 	p.ThisHostLBAddress = h.Addrs()[0].String() + "/p2p/" + h.ID().String()
-	p.ThisHostLNAddress = h.Addrs()[4].String() + "/p2p/" + h.ID().String()
+	lanAddr := lanAddress(h.Addrs())
+	p.ThisHostLNAddress = lanAddr.String() + "/p2p/" + h.ID().String()
 	fmt.Printf("Host created. We are: %s\n", h.ID())
 	// Green console colour: 	\x1b[32m
 	// Reset console colour: 	\x1b[0m
@@ -93,7 +157,8 @@ func (p *GokerPeer) Init(nickname string, hosting bool, givenAddr string, givenS
 	if hosting { // Start as a bootstrap server
 		fmt.Println("Running as a host...")
 		// Set host at start of peerlist
-		p.peerList = append(p.peerList, peerInfo{ID: p.ThisHost.ID(), Addr: h.Addrs()[4]})
+		p.peerList = append(p.peerList, peerInfo{ID: p.ThisHost.ID(), Addr: lanAddr})
+		p.tableID = newTableID()
 	} else if givenAddr != "" { // Connect to an existing bootstrap server
 		fmt.Println("Joining host...")
 		p.connectToHost(givenAddr)
@@ -103,7 +168,20 @@ func (p *GokerPeer) Init(nickname string, hosting bool, givenAddr string, givenS
 	go p.handleNotifications()
 
 	// To tell the game mananger the network is ready to go
-	channelmanager.FNET_NetActionDoneChan <- struct{}{}
+	p.bus.NetActionDone.Publish(struct{}{})
+}
+
+// Picks the first non-loopback TCP address for others on the LAN, falling back to the first address when there is none
+func lanAddress(addrs []multiaddr.Multiaddr) multiaddr.Multiaddr {
+	for _, addr := range addrs {
+		if _, err := addr.ValueForProtocol(multiaddr.P_TCP); err != nil {
+			continue
+		}
+		if !manet.IsIPLoopback(addr) && !manet.IsIP6LinkLocal(addr) {
+			return addr
+		}
+	}
+	return addrs[0]
 }
 
 func (p *GokerPeer) SetTurnOrderWithLobby() {
@@ -117,50 +195,37 @@ func (p *GokerPeer) SetTurnOrderWithLobby() {
 	p.gameState.SetTurnOrder(IDs)
 }
 
-// Unlocks the time-locked key by performing `t` sequential squaring operations.
-func (p *GokerPeer) BreakTimeLockedPuzzle(peerID peer.ID, puzzlePayload []byte) {
-	var message sra.TimeLock
-	if err := json.Unmarshal([]byte(puzzlePayload), &message); err != nil {
-		fmt.Println("Failed to parse received time-lock puzzle:", err)
-		return
-	}
-
-	// Convert string fields to big.Int
-	puzzle, _ := new(big.Int).SetString(message.Puzzle, 10)
-	iterations, _ := new(big.Int).SetString(message.Iter, 10)
-	n, _ := new(big.Int).SetString(message.N, 10)
-
-	// Set base
-	base := big.NewInt(2)
-
-	// Perform 't' squarings of 'base' modulo 'n'
-	for i := big.NewInt(0); i.Cmp(iterations) < 0; i.Add(i, big.NewInt(1)) {
-		base.Exp(base, big.NewInt(2), n)
-	}
-
-	// Subtract `b` from the time locked puzzle to retrieve the private key
-	key := new(big.Int).Sub(puzzle, base)
-	key.Mod(key, n)
-
-	plaintextPayload, err := sra.AESToPayload(message.Payload, key)
-	if err != nil {
-		fmt.Println(err)
-	}
-	fmt.Println("PUZZLE BROKE FOR: " + peerID.String())
-	p.Keyring.BrokenPuzzlePayloads = append(p.Keyring.BrokenPuzzlePayloads, plaintextPayload)
-
-	// Signal that a puzzle was broken
-	p.gameState.NumOfPuzzlesBroken++
-	channelmanager.TGM_WaitForPuzzles <- struct{}{}
-}
-
 func (p *GokerPeer) GenerateNewTag() {
-	err := binary.Read(rand.Reader, binary.LittleEndian, &p.tag)
+	var tag uint64
+	err := binary.Read(rand.Reader, binary.LittleEndian, &tag)
 	if err != nil {
 		log.Fatalf("GenerateNewTag: failed to generate random tag: %s\n", err.Error())
 	}
+	p.tag.Store(tag)
 }
 
 func (p *GokerPeer) SetNewTag(tag uint64) {
-	p.tag = tag
+	p.tag.Store(tag)
+}
+
+// The tag for this phase, everyone has to have the same one before anyone can act in it
+func (p *GokerPeer) Tag() uint64 {
+	return p.tag.Load()
+}
+
+// The tag for this phase, for a command to carry
+func (p *GokerPeer) currentTag() *uint64 {
+	tag := p.tag.Load()
+	return &tag
+}
+
+// Whoever is first in the turn order runs the table, only they can push a new tag
+func (p *GokerPeer) tableHost() peer.ID {
+	if order := p.gameState.GetTurnOrder(); len(order) > 0 {
+		return order[0]
+	}
+	if p.sessionHost.ID != "" { // No table yet, whoever we joined through is setting it up
+		return p.sessionHost.ID
+	}
+	return p.ThisHost.ID()
 }
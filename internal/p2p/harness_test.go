@@ -0,0 +1,167 @@
+package p2p
+
+import (
+	"fmt"
+	"goker/internal/eventbus"
+	"goker/internal/gamestate"
+	"goker/internal/sra"
+	"goker/internal/tablerules"
+	"sync"
+	"testing"
+	"time"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// Harness for running a whole table of peers in one process over loopback
+// Each peer gets its own bus, with a stand in for the game manager that approves the table rules
+type testTable struct {
+	t     testing.TB
+	peers []*GokerPeer // Host first
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Starts a host and numOfPeers-1 others that join it, then waits until everyone knows each other
+func newTestTable(t testing.TB, numOfPeers int) *testTable {
+	t.Helper()
+
+	tt := &testTable{t: t, stop: make(chan struct{})}
+	t.Cleanup(tt.close)
+
+	// Real sized primes and full length shuffle proofs are only worth waiting for when benchmarking
+	_, benchmarking := t.(*testing.B)
+	sra.UseTestPrimes(!benchmarking)
+	sra.UseTestShuffleProofs(!benchmarking)
+
+	for i := 0; i < numOfPeers; i++ {
+		p := new(GokerPeer)
+		p.Keyring = new(sra.Keyring)
+		p.Keyring.SetSquaringSpeed(1) // One squaring a second, so puzzles break instantly
+		p.redialInterval = time.Hour  // Dropped players come back when the test reconnects them
+
+		bus := eventbus.New()
+		tt.wg.Add(1)
+		go tt.gameManager(p, bus.TableRules.Subscribe())
+
+		hostAddr := ""
+		if i > 0 {
+			hostAddr = tt.peers[0].ThisHostLBAddress
+		}
+		p.start(fmt.Sprintf("player%d", i), i == 0, hostAddr, gamestate.New(bus), bus, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		tt.peers = append(tt.peers, p)
+	}
+
+	require.Eventually(t, func() bool {
+		for _, p := range tt.peers {
+			p.peerListMutex.Lock()
+			numInList := len(p.peerList)
+			p.peerListMutex.Unlock()
+			if numInList != numOfPeers || p.gameState.GetNumberOfPlayers() != numOfPeers {
+				return false
+			}
+		}
+		return true
+	}, 30*time.Second, 50*time.Millisecond, "peers never finished joining the lobby")
+
+	return tt
+}
+
+func (tt *testTable) close() {
+	for _, p := range tt.peers {
+		p.ThisHost.Close()
+	}
+	close(tt.stop)
+	tt.wg.Wait()
+}
+
+func (tt *testTable) host() *GokerPeer {
+	return tt.peers[0]
+}
+
+// Returns the peer with the given ID
+func (tt *testTable) peer(id peer.ID) *GokerPeer {
+	for _, p := range tt.peers {
+		if p.ThisHost.ID() == id {
+			return p
+		}
+	}
+	tt.t.Fatalf("no peer with ID %s at the table", id)
+	return nil
+}
+
+// Stands in for the game manager approving the table rules, the rest of the game is played in the gamemanager tests
+func (tt *testTable) gameManager(p *GokerPeer, tableRules *eventbus.Subscription[tablerules.Rules]) {
+	defer tt.wg.Done()
+	defer tableRules.Close()
+	for {
+		select {
+		case <-tt.stop:
+			return
+		case <-tableRules.C():
+			p.bus.RulesAnswer.Publish(eventbus.ActionType{Action: "approveRules"})
+		}
+	}
+}
+
+// Host sets the table rules and everyone approves them
+func (tt *testTable) initTable(rules tablerules.Rules) {
+	host := tt.host()
+	host.SetTurnOrderWithLobby()
+	host.gameState.FreshState(rules)
+
+	initTable := &InitTableCommand{}
+	require.NoError(tt.t, host.ExecuteCommand(initTable))
+	require.Empty(tt.t, initTable.Rejections)
+}
+
+// Same steps as the game managers RunProtocol, then waits for everyone to have their hand
+func (tt *testTable) deal() {
+	host := tt.host()
+	require.NoError(tt.t, host.ExecuteCommand(&NewKeysCommand{}))
+	require.NoError(tt.t, host.ExecuteCommand(&ProtocolFirstStepCommand{}))
+	require.NoError(tt.t, host.ExecuteCommand(&BroadcastNewDeck{}))
+	require.NoError(tt.t, host.ExecuteCommand(&ProtocolSecondStepCommand{}))
+	require.NoError(tt.t, host.ExecuteCommand(&BroadcastDeck{}))
+	require.NoError(tt.t, host.ExecuteCommand(&PushTagCommand{}))
+
+	require.NoError(tt.t, host.ExecuteCommand(&CanRequestHand{})) // Others request their hands as soon as they get this
+	require.NoError(tt.t, host.ExecuteCommand(&RequestHandCommand{}))
+
+	require.NoError(tt.t, host.ExecuteCommand(&CanRequestPuzzle{}))
+	require.NoError(tt.t, host.ExecuteCommand(&RequestPuzzleCommand{}))
+
+	require.NoError(tt.t, host.ExecuteCommand(&MoveToTableCommand{}))
+
+	require.Eventually(tt.t, func() bool {
+		for _, p := range tt.peers {
+			if len(p.MyHand) != 2 {
+				return false
+			}
+			for _, card := range p.MyHand {
+				if _, ok := p.Deck.GetCardFromRefDeck(card.CardValue); !ok {
+					return false
+				}
+			}
+		}
+		return true
+	}, time.Minute, 50*time.Millisecond, "not everyone got their hand")
+}
+
+// Bets are handled by everyone in their own stream handlers, so wait for them to catch up before the next action
+func (tt *testTable) waitForAgreement() {
+	tt.t.Helper()
+	host := tt.host()
+	require.Eventually(tt.t, func() bool {
+		for _, p := range tt.peers[1:] {
+			gs, hostState := p.gameState, host.gameState
+			if gs.GetWhosTurn() != hostState.GetWhosTurn() || gs.GetPhase() != hostState.GetPhase() || gs.GetCurrentPot() != hostState.GetCurrentPot() || p.tag.Load() != host.tag.Load() {
+				return false
+			}
+		}
+		return true
+	}, 30*time.Second, 10*time.Millisecond, "peers disagree on whose turn it is")
+}
@@ -0,0 +1,618 @@
+package p2p
+
+import (
+	"fmt"
+	"goker/internal/sra"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Typed payloads for the binary protocol, the Transcript, ProvenDeck, SignedKeyring, Audit and Nickname messages in goker.proto
+// Numbers have to be written the way big.Int writes them and nothing is sent for empty fields, so a payload only ever
+// encodes one way - commands carrying one are signed over this encoding, see signingData
+
+// A payload with a message of its own in goker.proto
+type wireMessage interface {
+	payloadKind() payloadKind
+	appendWire(b []byte) ([]byte, error)
+	consumeWire(b []byte) error
+}
+
+func (t *deckTranscript) payloadKind() payloadKind  { return transcriptPayload }
+func (d *provenDeck) payloadKind() payloadKind      { return proofPayload }
+func (k *SignedKeyring) payloadKind() payloadKind   { return keyringPayload }
+func (a *auditPayload) payloadKind() payloadKind    { return keyringsPayload }
+func (n *nicknamePayload) payloadKind() payloadKind { return namePayload }
+
+// Something to decode a typed payload into
+func newMessage(kind payloadKind) wireMessage {
+	switch kind {
+	case transcriptPayload:
+		return new(deckTranscript)
+	case proofPayload:
+		return new(provenDeck)
+	case keyringPayload:
+		return new(SignedKeyring)
+	case keyringsPayload:
+		return new(auditPayload)
+	case namePayload:
+		return new(nicknamePayload)
+	}
+	return nil
+}
+
+// Something to decode the typed payload a command can carry into, nil if it can't carry one
+func newCommandMessage(command string) wireMessage {
+	for _, kind := range commandPayloads[command] {
+		if message := newMessage(kind); message != nil {
+			return message
+		}
+	}
+	return nil
+}
+
+// Decodes the typed payload sent in the given field of a Command
+func unmarshalMessage(num protowire.Number, b []byte) (wireMessage, error) {
+	for kind, field := range messageFields {
+		if field == num {
+			message := newMessage(kind)
+			if err := message.consumeWire(b); err != nil {
+				return nil, fmt.Errorf("failed to decode %T: %w", message, err)
+			}
+			return message, nil
+		}
+	}
+	return nil, fmt.Errorf("no message sent in field %d", num)
+}
+
+// Field numbers of the messages in goker.proto
+const (
+	fieldTranscriptStart protowire.Number = 1
+	fieldTranscriptSteps protowire.Number = 2
+
+	fieldStepPeer                  protowire.Number = 1
+	fieldStepDeck                  protowire.Number = 2
+	fieldStepShuffleProof          protowire.Number = 3
+	fieldStepVariationProof        protowire.Number = 4
+	fieldStepPermutationCommitment protowire.Number = 5
+	fieldStepPermutationSignature  protowire.Number = 6
+	fieldStepCommitmentSignature   protowire.Number = 7
+	fieldStepKeyTargets            protowire.Number = 8
+	fieldStepKeyTargetProof        protowire.Number = 9
+
+	fieldShuffleProofRounds protowire.Number = 1
+
+	fieldRoundShadow      protowire.Number = 1
+	fieldRoundPermutation protowire.Number = 2
+	fieldRoundExponent    protowire.Number = 3
+
+	fieldVariationCommitments protowire.Number = 1
+	fieldVariationResponses   protowire.Number = 2
+
+	fieldKeyTargetCommitments protowire.Number = 1
+	fieldKeyTargetResponse    protowire.Number = 2
+
+	fieldKeyringOwner       protowire.Number = 1
+	fieldKeyringTable       protowire.Number = 2
+	fieldKeyringRound       protowire.Number = 3
+	fieldKeyringKeys        protowire.Number = 4
+	fieldKeyringSignature   protowire.Number = 5
+	fieldKeyringPermutation protowire.Number = 6
+	fieldKeyringSalt        protowire.Number = 7
+
+	fieldAuditRound    protowire.Number = 1
+	fieldAuditKeyrings protowire.Number = 2
+
+	fieldNicknameName  protowire.Number = 1
+	fieldNicknameSpeed protowire.Number = 2
+)
+
+///////////////////////////////////////////// TRANSCRIPT ///////////////////////////////////////////////////
+
+func (t *deckTranscript) appendWire(b []byte) ([]byte, error) {
+	if t == nil {
+		return nil, fmt.Errorf("no transcript")
+	}
+	b, err := appendNumbers(b, fieldTranscriptStart, splitNumbers(t.Start))
+	if err != nil {
+		return nil, fmt.Errorf("transcript start: %w", err)
+	}
+	for i := range t.Steps {
+		step, err := t.Steps[i].appendWire(nil)
+		if err != nil {
+			return nil, fmt.Errorf("transcript step %d: %w", i, err)
+		}
+		b = appendMessage(b, fieldTranscriptSteps, step)
+	}
+	return b, nil
+}
+
+func (t *deckTranscript) consumeWire(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldTranscriptStart && typ == protowire.BytesType:
+			values, n, err := consumeNumbers(b)
+			t.Start = strings.Join(values, "\n")
+			return n, err
+		case num == fieldTranscriptSteps && typ == protowire.BytesType:
+			var step provenDeck
+			n, err := consumeMessage(b, step.consumeWire)
+			t.Steps = append(t.Steps, step)
+			return n, err
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+///////////////////////////////////////////// PROVEN DECK ///////////////////////////////////////////////////
+
+func (d *provenDeck) appendWire(b []byte) ([]byte, error) {
+	if d == nil {
+		return nil, fmt.Errorf("no deck")
+	}
+	b = appendBytes(b, fieldStepPeer, []byte(d.Peer))
+	b, err := appendNumbers(b, fieldStepDeck, splitNumbers(d.Deck))
+	if err != nil {
+		return nil, fmt.Errorf("deck: %w", err)
+	}
+
+	if d.ShuffleProof != nil {
+		var proof []byte
+		for i, round := range d.ShuffleProof.Rounds {
+			msg, err := appendShuffleRound(nil, round)
+			if err != nil {
+				return nil, fmt.Errorf("shuffle proof round %d: %w", i, err)
+			}
+			proof = appendMessage(proof, fieldShuffleProofRounds, msg)
+		}
+		b = appendMessage(b, fieldStepShuffleProof, proof)
+	}
+	if d.VariationProof != nil {
+		proof, err := appendNumbers(nil, fieldVariationCommitments, d.VariationProof.Commitments)
+		if err == nil {
+			proof, err = appendNumbers(proof, fieldVariationResponses, d.VariationProof.Responses)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("variation proof: %w", err)
+		}
+		b = appendMessage(b, fieldStepVariationProof, proof)
+	}
+
+	b = appendString(b, fieldStepPermutationCommitment, d.PermutationCommitment)
+	b = appendString(b, fieldStepPermutationSignature, d.PermutationSignature)
+	b = appendString(b, fieldStepCommitmentSignature, d.CommitmentSignature)
+	if b, err = appendNumbers(b, fieldStepKeyTargets, splitNumbers(d.KeyTargets)); err != nil {
+		return nil, fmt.Errorf("key targets: %w", err)
+	}
+	if d.KeyTargetProof != nil {
+		proof, err := appendKeyTargetProof(nil, d.KeyTargetProof)
+		if err != nil {
+			return nil, fmt.Errorf("key target proof: %w", err)
+		}
+		b = appendMessage(b, fieldStepKeyTargetProof, proof)
+	}
+	return b, nil
+}
+
+func appendKeyTargetProof(b []byte, proof *sra.KeyTargetProof) ([]byte, error) {
+	b, err := appendNumbers(b, fieldKeyTargetCommitments, proof.Commitments)
+	if err != nil {
+		return nil, fmt.Errorf("commitments: %w", err)
+	}
+	response, ok := parseNumber(proof.Response)
+	if !ok {
+		return nil, fmt.Errorf("response %q isn't a number", proof.Response)
+	}
+	return appendMessage(b, fieldKeyTargetResponse, response), nil // Even if it's 0, a proof without one is refused
+}
+
+func appendShuffleRound(b []byte, round sra.ShuffleProofRound) ([]byte, error) {
+	b, err := appendNumbers(b, fieldRoundShadow, round.Shadow)
+	if err != nil {
+		return nil, fmt.Errorf("shadow deck: %w", err)
+	}
+	if b, err = appendIndices(b, fieldRoundPermutation, round.Permutation); err != nil {
+		return nil, err
+	}
+	exponent, ok := parseNumber(round.Exponent)
+	if !ok {
+		return nil, fmt.Errorf("exponent %q isn't a number", round.Exponent)
+	}
+	return appendMessage(b, fieldRoundExponent, exponent), nil // Even if it's 0, a round without one is refused
+}
+
+func (d *provenDeck) consumeWire(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+
+		switch num {
+		case fieldStepPeer:
+			id, n := protowire.ConsumeBytes(b)
+			d.Peer = peer.ID(id)
+			return n, nil
+		case fieldStepDeck:
+			values, n, err := consumeNumbers(b)
+			d.Deck = strings.Join(values, "\n")
+			return n, err
+		case fieldStepShuffleProof:
+			d.ShuffleProof = new(sra.ShuffleProof)
+			return consumeMessage(b, func(b []byte) error {
+				return consumeShuffleProof(d.ShuffleProof, b)
+			})
+		case fieldStepVariationProof:
+			d.VariationProof = new(sra.VariationProof)
+			return consumeMessage(b, func(b []byte) error {
+				return consumeVariationProof(d.VariationProof, b)
+			})
+		case fieldStepPermutationCommitment:
+			return consumeString(b, &d.PermutationCommitment)
+		case fieldStepPermutationSignature:
+			return consumeString(b, &d.PermutationSignature)
+		case fieldStepCommitmentSignature:
+			return consumeString(b, &d.CommitmentSignature)
+		case fieldStepKeyTargets:
+			values, n, err := consumeNumbers(b)
+			d.KeyTargets = strings.Join(values, "\n")
+			return n, err
+		case fieldStepKeyTargetProof:
+			d.KeyTargetProof = new(sra.KeyTargetProof)
+			return consumeMessage(b, func(b []byte) error {
+				return consumeKeyTargetProof(d.KeyTargetProof, b)
+			})
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func consumeShuffleProof(proof *sra.ShuffleProof, b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != fieldShuffleProofRounds || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		var round sra.ShuffleProofRound
+		n, err := consumeMessage(b, func(b []byte) error {
+			return consumeShuffleRound(&round, b)
+		})
+		proof.Rounds = append(proof.Rounds, round)
+		return n, err
+	})
+}
+
+func consumeShuffleRound(round *sra.ShuffleProofRound, b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldRoundShadow && typ == protowire.BytesType:
+			values, n, err := consumeNumbers(b)
+			round.Shadow = values
+			return n, err
+		case num == fieldRoundPermutation:
+			return consumeIndices(num, typ, b, &round.Permutation)
+		case num == fieldRoundExponent && typ == protowire.BytesType:
+			exponent, n := protowire.ConsumeBytes(b)
+			round.Exponent = new(big.Int).SetBytes(exponent).String()
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func consumeVariationProof(proof *sra.VariationProof, b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		var values []string
+		var n int
+		var err error
+		switch {
+		case num == fieldVariationCommitments && typ == protowire.BytesType:
+			values, n, err = consumeNumbers(b)
+			proof.Commitments = values
+		case num == fieldVariationResponses && typ == protowire.BytesType:
+			values, n, err = consumeNumbers(b)
+			proof.Responses = values
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		return n, err
+	})
+}
+
+func consumeKeyTargetProof(proof *sra.KeyTargetProof, b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldKeyTargetCommitments && typ == protowire.BytesType:
+			values, n, err := consumeNumbers(b)
+			proof.Commitments = values
+			return n, err
+		case num == fieldKeyTargetResponse && typ == protowire.BytesType:
+			response, n := protowire.ConsumeBytes(b)
+			proof.Response = new(big.Int).SetBytes(response).String()
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+///////////////////////////////////////////// KEYRINGS ///////////////////////////////////////////////////
+
+func (k *SignedKeyring) appendWire(b []byte) ([]byte, error) {
+	if k == nil {
+		return nil, fmt.Errorf("no keyring")
+	}
+	if k.Round < 0 {
+		return nil, fmt.Errorf("negative round %d", k.Round)
+	}
+	b = appendBytes(b, fieldKeyringOwner, []byte(k.Owner))
+	b = appendString(b, fieldKeyringTable, k.Table)
+	if k.Round != 0 {
+		b = protowire.AppendTag(b, fieldKeyringRound, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(k.Round))
+	}
+	b, err := appendNumbers(b, fieldKeyringKeys, splitNumbers(k.Keyring))
+	if err != nil {
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+	b = appendString(b, fieldKeyringSignature, k.Signature)
+	if b, err = appendIndices(b, fieldKeyringPermutation, k.Permutation); err != nil {
+		return nil, err
+	}
+	return appendString(b, fieldKeyringSalt, k.ShuffleSalt), nil
+}
+
+func (k *SignedKeyring) consumeWire(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldKeyringOwner && typ == protowire.BytesType:
+			owner, n := protowire.ConsumeBytes(b)
+			k.Owner = peer.ID(owner)
+			return n, nil
+		case num == fieldKeyringTable && typ == protowire.BytesType:
+			return consumeString(b, &k.Table)
+		case num == fieldKeyringRound && typ == protowire.VarintType:
+			return consumeRound(b, &k.Round)
+		case num == fieldKeyringKeys && typ == protowire.BytesType:
+			values, n, err := consumeNumbers(b)
+			k.Keyring = strings.Join(values, "\n")
+			return n, err
+		case num == fieldKeyringSignature && typ == protowire.BytesType:
+			return consumeString(b, &k.Signature)
+		case num == fieldKeyringPermutation:
+			return consumeIndices(num, typ, b, &k.Permutation)
+		case num == fieldKeyringSalt && typ == protowire.BytesType:
+			return consumeString(b, &k.ShuffleSalt)
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+// Keyrings go in order of their owner, so the same audit always encodes the same way
+func (a *auditPayload) appendWire(b []byte) ([]byte, error) {
+	if a == nil {
+		return nil, fmt.Errorf("no audit")
+	}
+	if a.Round < 0 {
+		return nil, fmt.Errorf("negative round %d", a.Round)
+	}
+	if a.Round != 0 {
+		b = protowire.AppendTag(b, fieldAuditRound, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(a.Round))
+	}
+
+	owners := make([]peer.ID, 0, len(a.Keyrings))
+	for owner := range a.Keyrings {
+		owners = append(owners, owner)
+	}
+	slices.Sort(owners)
+	for _, owner := range owners {
+		keyring := a.Keyrings[owner]
+		if keyring.Owner != owner {
+			return nil, fmt.Errorf("keyring from %s filed under %s", keyring.Owner, owner)
+		}
+		msg, err := keyring.appendWire(nil)
+		if err != nil {
+			return nil, fmt.Errorf("keyring from %s: %w", owner, err)
+		}
+		b = appendMessage(b, fieldAuditKeyrings, msg)
+	}
+	return b, nil
+}
+
+func (a *auditPayload) consumeWire(b []byte) error {
+	a.Keyrings = make(map[peer.ID]SignedKeyring)
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldAuditRound && typ == protowire.VarintType:
+			return consumeRound(b, &a.Round)
+		case num == fieldAuditKeyrings && typ == protowire.BytesType:
+			var keyring SignedKeyring
+			n, err := consumeMessage(b, keyring.consumeWire)
+			if err != nil {
+				return n, err
+			}
+			if _, ok := a.Keyrings[keyring.Owner]; ok {
+				return n, fmt.Errorf("two keyrings from %s", keyring.Owner)
+			}
+			a.Keyrings[keyring.Owner] = keyring
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+///////////////////////////////////////////// NICKNAME ///////////////////////////////////////////////////
+
+func (n *nicknamePayload) appendWire(b []byte) ([]byte, error) {
+	if n == nil {
+		return nil, fmt.Errorf("no nickname")
+	}
+	if n.SquaringSpeed < 0 {
+		return nil, fmt.Errorf("negative squaring speed %d", n.SquaringSpeed)
+	}
+	b = appendString(b, fieldNicknameName, n.Nickname)
+	if n.SquaringSpeed != 0 {
+		b = protowire.AppendTag(b, fieldNicknameSpeed, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(n.SquaringSpeed))
+	}
+	return b, nil
+}
+
+func (n *nicknamePayload) consumeWire(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldNicknameName && typ == protowire.BytesType:
+			return consumeString(b, &n.Nickname)
+		case num == fieldNicknameSpeed && typ == protowire.VarintType:
+			speed, m := protowire.ConsumeVarint(b)
+			if m >= 0 && speed > math.MaxInt64 {
+				return m, fmt.Errorf("squaring speed %d is out of range", speed)
+			}
+			n.SquaringSpeed = int64(speed)
+			return m, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+///////////////////////////////////////////// HELPERS ///////////////////////////////////////////////////
+
+// Calls field for every field in a message, which returns how much of b that field took up
+// Fields it doesn't know are skipped with protowire.ConsumeFieldValue
+func consumeFields(b []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		n, err := field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// Decodes an embedded message with consume, returning how much of b it took up
+func consumeMessage(b []byte, consume func([]byte) error) (int, error) {
+	msg, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return n, nil
+	}
+	return n, consume(msg)
+}
+
+func consumeNumbers(b []byte) ([]string, int, error) {
+	msg, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return nil, n, nil
+	}
+	values, err := unmarshalNumberList(msg)
+	return values, n, err
+}
+
+func consumeString(b []byte, s *string) (int, error) {
+	var n int
+	*s, n = protowire.ConsumeString(b)
+	return n, nil
+}
+
+func consumeRound(b []byte, round *int) (int, error) {
+	value, n := protowire.ConsumeVarint(b)
+	if n >= 0 && value > math.MaxInt32 {
+		return n, fmt.Errorf("round %d is out of range", value)
+	}
+	*round = int(value)
+	return n, nil
+}
+
+// Permutations are sent packed, but either way is read as proto3 allows
+func consumeIndices(num protowire.Number, typ protowire.Type, b []byte, indices *[]int) (int, error) {
+	add := func(value uint64) error {
+		if value > math.MaxInt32 {
+			return fmt.Errorf("index %d is out of range", value)
+		}
+		*indices = append(*indices, int(value))
+		return nil
+	}
+
+	switch typ {
+	case protowire.VarintType:
+		value, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return n, nil
+		}
+		return n, add(value)
+	case protowire.BytesType:
+		packed, n := protowire.ConsumeBytes(b)
+		for len(packed) > 0 {
+			value, m := protowire.ConsumeVarint(packed)
+			if m < 0 {
+				return m, nil
+			}
+			if err := add(value); err != nil {
+				return n, err
+			}
+			packed = packed[m:]
+		}
+		return n, nil
+	}
+	return protowire.ConsumeFieldValue(num, typ, b), nil
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// Empty fields aren't sent, same as proto3 does
+func appendBytes(b []byte, num protowire.Number, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	return appendMessage(b, num, value)
+}
+
+func appendString(b []byte, num protowire.Number, value string) []byte {
+	return appendBytes(b, num, []byte(value))
+}
+
+// A Numbers message, every value has to be a decimal big.Int would write the same way
+func appendNumbers(b []byte, num protowire.Number, values []string) ([]byte, error) {
+	if len(values) == 0 {
+		return b, nil
+	}
+	numbers, ok := parseNumberList(values)
+	if !ok {
+		return nil, fmt.Errorf("not a list of numbers")
+	}
+	var msg []byte
+	for _, number := range numbers {
+		msg = protowire.AppendTag(msg, fieldNumbersValues, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, number)
+	}
+	return appendMessage(b, num, msg), nil
+}
+
+func appendIndices(b []byte, num protowire.Number, indices []int) ([]byte, error) {
+	if len(indices) == 0 {
+		return b, nil
+	}
+	var packed []byte
+	for _, index := range indices {
+		if index < 0 {
+			return nil, fmt.Errorf("negative index %d", index)
+		}
+		packed = protowire.AppendVarint(packed, uint64(index))
+	}
+	return appendMessage(b, num, packed), nil
+}
@@ -3,7 +3,6 @@ package p2p
 import (
 	"context"
 	"fmt"
-	"goker/internal/channelmanager"
 	"log"
 	"time"
 
@@ -21,6 +20,11 @@ func (p *GokerPeer) handleNotifications() {
 		ConnectedF: func(n network.Network, conn network.Conn) { // On peer connect
 			fmt.Printf("NOTIFICATION: Connection from new peer: %s\n", conn.RemotePeer()) // WHEN A NEW PEER CONNECTS TO US, IT MUST BE FROM A BROADCAST SERVER SENDING IT
 
+			// Someone already at the table, either back from dropping out or on a second connection
+			if p.peerReturned(conn.RemotePeer()) || p.gameState.GetTurnOrderIndex(conn.RemotePeer()) != nil {
+				return
+			}
+
 			// Connect to the new peer and update the peer list
 			err := p.handlePeerConnection(conn.RemotePeer(), conn.RemoteMultiaddr())
 			if err != nil {
@@ -29,38 +33,23 @@ func (p *GokerPeer) handleNotifications() {
 			}
 
 			// Update GUI
-			channelmanager.FNET_NumOfPlayersChan <- len(p.peerList)
-
-			// Exchange keys
-			p.ExecuteCommand(&PubKeyExchangeCommand{})
+			p.bus.NumOfPlayers.Publish(len(p.peerList))
 
 			// Request Nickname from new peer
-			p.ExecuteCommand(&NicknameRequestCommand{})
+			if err := p.ExecuteCommand(&NicknameRequestCommand{}); err != nil {
+				log.Println("NicknameRequest failed: ", err)
+			}
 		},
 		DisconnectedF: func(n network.Network, conn network.Conn) { // On peer disconnect
 			fmt.Printf("NOTIFICATION: Disconnected from peer: %s\n", conn.RemotePeer())
 
-			if !p.gameState.FoldedPlayers[conn.RemotePeer()] { // If the person who left hasn't folded
-				p.gameState.SomeoneLeft = true
+			if n.Connectedness(conn.RemotePeer()) == network.Connected { // Still connected to them some other way
+				return
 			}
-
-			// Check if it's currently their turn
-			if p.gameState.TurnOrder[p.gameState.WhosTurn] == conn.RemotePeer() {
-				p.gameState.RemovePeerFromState(conn.RemotePeer())
-				p.gameState.NextTurn()
-			} else {
-				p.gameState.RemovePeerFromState(conn.RemotePeer())
+			if p.peerDropped(conn.RemotePeer()) { // They might be back, see reconnect_handler.go
+				return
 			}
-
-			// Update the peers list and nicknames
-			p.handlePeerDisconnection(conn.RemotePeer())
-
-			// Update the GUI
-			channelmanager.FNET_NumOfPlayersChan <- len(p.peerList)
-
-			// Update GUI of player leaving
-			channelmanager.TGUI_PlayerInfo <- p.gameState.GetPlayerInfo()
-
+			p.peerLeft(conn.RemotePeer())
 		},
 	})
 
@@ -68,6 +57,29 @@ func (p *GokerPeer) handleNotifications() {
 	select {}
 }
 
+// Someone left the table for good, their keys now only come from their puzzle
+func (p *GokerPeer) peerLeft(peerID peer.ID) {
+	p.puzzleSolverLeft(peerID) // They can't solve anyones puzzle now
+
+	nickname := p.gameState.GetNickname(peerID)
+	inHand, theirTurn := p.gameState.PlayerLeft(peerID)
+	if inHand { // If the person who left hasn't folded
+		p.solvePuzzle(peerID, nickname) // Their keys are only in their puzzle now
+	}
+	if theirTurn {
+		p.gameState.NextTurn()
+	}
+
+	// Update the peers list and nicknames
+	p.handlePeerDisconnection(peerID)
+
+	// Update the GUI
+	p.bus.NumOfPlayers.Publish(len(p.peerList))
+
+	// Update GUI of player leaving
+	p.bus.PlayerInfo.Publish(p.gameState.GetPlayerInfo())
+}
+
 // Connect to new peers that are discovered - Called when the ConnectF NOTIFICATION has been made.
 func (p *GokerPeer) handlePeerConnection(newPeerID peer.ID, newPeerAddr multiaddr.Multiaddr) error {
 	p.peerListMutex.Lock()
@@ -25,7 +25,8 @@ func (p *GokerPeer) getPeerList() string {
 }
 
 // Set the peer list (Given the output from the getPeerList function) and connect to all new peers
-func (p *GokerPeer) setPeerListAndConnect(peerList string) {
+// Nothing is set if any of it can't be read
+func (p *GokerPeer) setPeerListAndConnect(peerList string) error {
 
 	var sentPeerList []peerInfo
 	scanner := bufio.NewScanner(strings.NewReader(peerList))
@@ -38,19 +39,19 @@ func (p *GokerPeer) setPeerListAndConnect(peerList string) {
 
 		newPeerID, err := peer.Decode(parts[0]) // make it a peerID
 		if err != nil {
-			log.Fatal("setPeerList: Unable to decode incoming peerID")
+			return fmt.Errorf("unable to decode peer ID %q: %w", parts[0], err)
 		}
 
 		addr, err := multiaddr.NewMultiaddr(parts[1]) // Make it a multiaddr
 		if err != nil {
-			log.Fatal("setPeerList: Unable to create multiaddr for incoming peerID - " + newPeerID.String())
+			return fmt.Errorf("unable to create multiaddr for %s: %w", newPeerID, err)
 		}
 
 		sentPeerList = append(sentPeerList, peerInfo{ID: newPeerID, Addr: addr})
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatalf("setPeerList: Error reading peer list - %v", err)
+		return fmt.Errorf("error reading peer list: %w", err)
 	}
 
 	// Time to set it
@@ -80,4 +81,5 @@ func (p *GokerPeer) setPeerListAndConnect(peerList string) {
 			}
 		}
 	}
+	return nil
 }
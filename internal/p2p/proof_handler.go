@@ -0,0 +1,389 @@
+package p2p
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"goker/internal/sra"
+	"math/big"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// A deck a peer passed on during the protocol, with proof it was made honestly from the deck before it
+type provenDeck struct {
+	Peer           peer.ID             `json:"peer"`
+	Deck           string              `json:"deck"`
+	ShuffleProof   *sra.ShuffleProof   `json:"shuffleProof,omitempty"`   // First step
+	VariationProof *sra.VariationProof `json:"variationProof,omitempty"` // Second step
+
+	// First step, commitment to the permutation so it can be opened when the keyring is revealed after the hand
+	PermutationCommitment string `json:"permutationCommitment,omitempty"`
+	PermutationSignature  string `json:"permutationSignature,omitempty"` // Same as CommitmentSignature
+
+	// Second step, what each variation key has to take its card back to, so every key revealed later can be checked
+	// against this peers encryption - the card going in with their global key taken off, see sra.ProveKeyTargets
+	KeyTargets          string              `json:"keyTargets,omitempty"` // Same format as Deck
+	KeyTargetProof      *sra.KeyTargetProof `json:"keyTargetProof,omitempty"`
+	CommitmentSignature string              `json:"commitmentSignature,omitempty"` // So the host can't swap in someone elses targets when relaying them
+}
+
+// Every peers contribution to one step of the protocol, in ring order starting with the host
+type deckTranscript struct {
+	Start string       `json:"start"`
+	Steps []provenDeck `json:"steps"`
+}
+
+// Deck at the end of the step
+func (t *deckTranscript) final() string {
+	if len(t.Steps) == 0 {
+		return t.Start
+	}
+	return t.Steps[len(t.Steps)-1].Deck
+}
+
+// A peers step, and the deck they made it from
+func (t *deckTranscript) step(owner peer.ID) (*provenDeck, string, error) {
+	if t == nil {
+		return nil, "", fmt.Errorf("no transcript for this hand")
+	}
+	in := t.Start
+	for i := range t.Steps {
+		if t.Steps[i].Peer == owner {
+			return &t.Steps[i], in, nil
+		}
+		in = t.Steps[i].Deck
+	}
+	return nil, "", fmt.Errorf("%s has no step in this hand", owner)
+}
+
+// The decks going into and out of a peers step
+func (t *deckTranscript) stepDecks(owner peer.ID) ([]*big.Int, []*big.Int, error) {
+	step, in, err := t.step(owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	inDeck, err := parseDeckPayload(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	outDeck, err := parseDeckPayload(step.Deck)
+	if err != nil {
+		return nil, nil, err
+	}
+	return inDeck, outDeck, nil
+}
+
+// First step - encrypt with my global key, shuffle, and prove I did both
+func (p *GokerPeer) shuffleAndProve() (provenDeck, error) {
+	in := p.Deck.cardValues()
+	p.EncryptAllWithGlobalKeys()
+	p.Deck.ShuffleRoundDeck()
+
+	proof, err := p.Keyring.ProveShuffle(in, p.Deck.cardValues(), p.Deck.Permutation, p.gameState.GetRules().ShuffleProofRounds)
+	if err != nil {
+		return provenDeck{}, fmt.Errorf("failed to prove shuffle: %w", err)
+	}
+
+	commitment := hex.EncodeToString(p.Deck.PermutationCommitment())
+	signature, err := p.Keyring.SignMessage(p.permutationSigningData(commitment))
+	if err != nil {
+		return provenDeck{}, fmt.Errorf("failed to sign permutation commitment: %w", err)
+	}
+	return provenDeck{
+		Peer:                  p.ThisHost.ID(),
+		Deck:                  p.Deck.GenerateDeckPayload(),
+		ShuffleProof:          proof,
+		PermutationCommitment: commitment,
+		PermutationSignature:  signature,
+	}, nil
+}
+
+// Second step - swap my global key for my variation keys, and prove every card kept its place
+// The deck with only my global key taken off is what each of my variation keys has to take its card back to
+func (p *GokerPeer) encryptVariationsAndProve() (provenDeck, error) {
+	shuffleIn, shuffleOut, err := p.shuffleTranscript.stepDecks(p.ThisHost.ID())
+	if err != nil {
+		return provenDeck{}, fmt.Errorf("no shuffle of ours to tie our keys to: %w", err)
+	}
+	in := p.Deck.cardValues()
+	p.DecryptAllWithGlobalKeys()
+	targets, keyTargets := p.Deck.cardValues(), p.Deck.GenerateDeckPayload()
+	p.EncryptAllWithVariation()
+
+	proof, err := p.Keyring.ProveVariations(in, p.Deck.cardValues())
+	if err != nil {
+		return provenDeck{}, fmt.Errorf("failed to prove variations: %w", err)
+	}
+	targetProof, err := p.Keyring.ProveKeyTargets(shuffleIn, shuffleOut, in, targets)
+	if err != nil {
+		return provenDeck{}, fmt.Errorf("failed to prove key targets: %w", err)
+	}
+
+	signature, err := p.Keyring.SignMessage(keyTargetSigningData(keyTargets))
+	if err != nil {
+		return provenDeck{}, fmt.Errorf("failed to sign key targets: %w", err)
+	}
+	return provenDeck{
+		Peer:                p.ThisHost.ID(),
+		Deck:                p.Deck.GenerateDeckPayload(),
+		VariationProof:      proof,
+		KeyTargets:          keyTargets,
+		KeyTargetProof:      targetProof,
+		CommitmentSignature: signature,
+	}, nil
+}
+
+func keyTargetSigningData(keyTargets string) string {
+	return "key targets\n" + keyTargets
+}
+
+// Bound to the table and round, so the host can't pass off a commitment from an earlier hand as this ones
+func (p *GokerPeer) permutationSigningData(commitment string) string {
+	return fmt.Sprintf("permutation commitment\n%s\n%d\n%s", p.currentTable(), p.gameState.Round, commitment)
+}
+
+// Checks a peers deck was made honestly from the given deck
+func (p *GokerPeer) verifyProvenDeck(in string, step provenDeck) error {
+	inDeck, err := parseDeckPayload(in)
+	if err != nil {
+		return err
+	}
+	outDeck, err := parseDeckPayload(step.Deck)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case step.ShuffleProof != nil:
+		err = p.Keyring.VerifyShuffle(inDeck, outDeck, step.ShuffleProof, p.gameState.GetRules().ShuffleProofRounds)
+		if err == nil {
+			err = p.verifyPermutationCommitment(step)
+		}
+	case step.VariationProof != nil:
+		err = p.Keyring.VerifyVariations(inDeck, outDeck, step.VariationProof)
+		if err == nil {
+			err = p.verifyKeyTargets(inDeck, step)
+		}
+	default:
+		err = fmt.Errorf("no proof attached")
+	}
+	if err != nil {
+		return fmt.Errorf("deck from %s: %w", step.Peer, err)
+	}
+	return nil
+}
+
+// Checks a peer published a key target for every card, made with the same key that undid their shuffle, and that the
+// targets really came from them - a target that's off would let them reveal a key that decrypts the card to anything
+func (p *GokerPeer) verifyKeyTargets(in []*big.Int, step provenDeck) error {
+	targets, err := parseDeckPayload(step.KeyTargets)
+	if err != nil {
+		return fmt.Errorf("key targets: %w", err)
+	}
+	shuffleIn, shuffleOut, err := p.shuffleTranscript.stepDecks(step.Peer)
+	if err != nil {
+		return err
+	}
+	if err := p.Keyring.VerifyKeyTargets(shuffleIn, shuffleOut, in, targets, step.KeyTargetProof); err != nil {
+		return err
+	}
+	if step.Peer == p.ThisHost.ID() { // Only we could have proven them
+		return nil
+	}
+	if !p.Keyring.VerifySignature(step.Peer, keyTargetSigningData(step.KeyTargets), step.CommitmentSignature) {
+		return fmt.Errorf("key targets have a bad signature")
+	}
+	return nil
+}
+
+// Checks a peer committed to their permutation, and that the commitment really came from them
+func (p *GokerPeer) verifyPermutationCommitment(step provenDeck) error {
+	if commitment, err := hex.DecodeString(step.PermutationCommitment); err != nil || len(commitment) != sha256.Size {
+		return fmt.Errorf("no commitment to their permutation")
+	}
+	if step.Peer == p.ThisHost.ID() {
+		if step.PermutationCommitment != hex.EncodeToString(p.Deck.PermutationCommitment()) {
+			return fmt.Errorf("our permutation commitment was changed")
+		}
+		return nil
+	}
+	if !p.Keyring.VerifySignature(step.Peer, p.permutationSigningData(step.PermutationCommitment), step.PermutationSignature) {
+		return fmt.Errorf("permutation commitment has a bad signature")
+	}
+	return nil
+}
+
+// Checks every step in a transcript the host broadcast, from the given starting deck
+// Everyone at the table must have contributed exactly once, with the right kind of proof
+func (p *GokerPeer) verifyTranscript(t *deckTranscript, start string, shuffled bool) error {
+	if t.Start != start {
+		return fmt.Errorf("transcript doesn't start from the expected deck")
+	}
+	if len(t.Steps) != p.gameState.GetNumberOfPlayers() {
+		return fmt.Errorf("transcript has %d steps for %d players", len(t.Steps), p.gameState.GetNumberOfPlayers())
+	}
+
+	seen := make(map[peer.ID]bool, len(t.Steps))
+	in := t.Start
+	for _, step := range t.Steps {
+		if !p.gameState.PlayerExists(step.Peer) || seen[step.Peer] {
+			return fmt.Errorf("unexpected step from %s", step.Peer)
+		}
+		seen[step.Peer] = true
+
+		if shuffled != (step.ShuffleProof != nil) {
+			return fmt.Errorf("step from %s has the wrong kind of proof", step.Peer)
+		}
+		if err := p.verifyProvenDeck(in, step); err != nil {
+			return err
+		}
+		in = step.Deck
+	}
+	return nil
+}
+
+// Verifies the first step transcript and sets the shuffled deck if it's good
+func (p *GokerPeer) acceptShuffleTranscript(t *deckTranscript) error {
+	if t == nil {
+		return fmt.Errorf("no shuffle transcript")
+	}
+	start, err := parseDeckPayload(t.Start)
+	if err != nil {
+		return err
+	}
+	if !p.Deck.isReferenceDeck(start) {
+		return fmt.Errorf("shuffle didn't start from the reference deck")
+	}
+	if err := p.verifyTranscript(t, t.Start, true); err != nil {
+		return err
+	}
+
+	p.shuffleTranscript = t
+	p.Deck.SetNewDeck(t.final())
+	return nil
+}
+
+// Verifies the second step transcript continues from the shuffled deck, and sets the final deck if it's good
+func (p *GokerPeer) acceptVariationTranscript(t *deckTranscript) error {
+	if p.shuffleTranscript == nil {
+		return fmt.Errorf("no shuffled deck to continue from")
+	}
+	if t == nil {
+		return fmt.Errorf("no variation transcript")
+	}
+	if err := p.verifyTranscript(t, p.shuffleTranscript.final(), false); err != nil {
+		return err
+	}
+
+	p.variationTranscript = t
+	p.Deck.SetDeckInPlace(t.final())
+	p.prepareHandPuzzle()
+	return nil
+}
+
+// Reads a deck payload into card values
+func parseDeckPayload(payload string) ([]*big.Int, error) {
+	d := new(deckInfo)
+	d.SetNewDeck(payload)
+	if len(d.RoundDeck) != 52 {
+		return nil, fmt.Errorf("deck has %d cards, expected 52", len(d.RoundDeck))
+	}
+	return d.cardValues(), nil
+}
+
+// Every card as it left a peers variation step, and the key target they published for it
+func (t *deckTranscript) keyTargets(owner peer.ID) ([]*big.Int, []*big.Int, error) {
+	step, _, err := t.step(owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := parseDeckPayload(step.Deck)
+	if err != nil {
+		return nil, nil, err
+	}
+	targets, err := parseDeckPayload(step.KeyTargets)
+	if err != nil {
+		return nil, nil, fmt.Errorf("key targets from %s: %w", owner, err)
+	}
+	return out, targets, nil
+}
+
+// Checks a key revealed for a card takes it back to the target its owner published
+func (p *GokerPeer) checkRevealedKey(owner peer.ID, variationIndex int, key string) error {
+	value, ok := new(big.Int).SetString(key, 10)
+	if !ok {
+		return fmt.Errorf("key for variation %d isn't a number", variationIndex)
+	}
+	out, targets, err := p.variationTranscript.keyTargets(owner)
+	if err != nil {
+		return err
+	}
+	if variationIndex < 0 || variationIndex >= len(targets) {
+		return fmt.Errorf("no key target from %s for variation %d", owner, variationIndex)
+	}
+	return p.Keyring.CheckVariationKey(variationIndex, out[variationIndex], targets[variationIndex], value)
+}
+
+// Checks the keys a peer sent for some cards, one of their own keys per card
+func (p *GokerPeer) checkRevealedKeys(sender peer.ID, cards []*CardInfo, keys []string) error {
+	if len(keys) != len(cards) {
+		return fmt.Errorf("sent %d keys for %d cards", len(keys), len(cards))
+	}
+	for i, card := range cards {
+		if err := p.checkRevealedKey(sender, card.VariationIndex, keys[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks the keys a peer sent for their hand at the showdown, which are keys from every player for each card
+func (p *GokerPeer) checkRevealedHandKeys(hand []*CardInfo, keys []string) error {
+	players := p.gameState.GetTurnOrder()
+	if len(hand) != 2 {
+		return fmt.Errorf("no hand to check keys against")
+	}
+	perCard := len(keys) / len(hand)
+	if len(keys)%len(hand) != 0 || perCard == 0 || perCard > len(players) {
+		return fmt.Errorf("sent %d keys for their hand", len(keys))
+	}
+
+	for c, card := range hand {
+		owners := make(map[peer.ID]bool, perCard)
+		for _, key := range keys[c*perCard : (c+1)*perCard] {
+			matched := false
+			for _, owner := range players {
+				if !owners[owner] && p.checkRevealedKey(owner, card.VariationIndex, key) == nil {
+					owners[owner], matched = true, true
+					break
+				}
+			}
+			if !matched {
+				return fmt.Errorf("a key for card %d doesn't match anyones key target", c)
+			}
+		}
+	}
+	return nil
+}
+
+// Checks every key in a keyring payload, from a fold or a broken puzzle, against its owners key targets in the hands transcript
+func (p *GokerPeer) checkRevealedKeyring(t *deckTranscript, owner peer.ID, payload string) error {
+	keys, err := p.Keyring.GetKeysFromPayload(payload)
+	if err != nil {
+		return err
+	}
+	if len(keys) < 52 {
+		return fmt.Errorf("keyring only has %d keys", len(keys))
+	}
+	out, targets, err := t.keyTargets(owner)
+	if err != nil {
+		return err
+	}
+	for i, key := range keys[:52] {
+		if err := p.Keyring.CheckVariationKey(i, out[i], targets[i], key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
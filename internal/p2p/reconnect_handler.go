@@ -0,0 +1,298 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"goker/internal/eventbus"
+	"log"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Players who drop mid-hand get the tables reconnect window to come back before they're treated as having left
+// Until then the table waits on them for anything it can't do without them (their turn, their keys for the board) and
+// both sides keep dialling each other. Whoever lost everyone at once was the one who dropped, so when the first peer
+// is back they ask it for the hand as it stands now, see ResyncCommand. A player who restarts the game can't come back,
+// their keys for the hand went with it.
+
+// How long to wait between dialling a dropped player again, unless the peer was started with its own interval
+const defaultRedialInterval = 2 * time.Second
+
+// How many times the peer we resync from and the host are asked for the hand before we give up on them agreeing, and
+// how long to give a move that's still going round between tries
+const (
+	resyncAttempts = 3
+	resyncPause    = 200 * time.Millisecond
+)
+
+// A player who dropped and still has time to come back
+type droppedPeer struct {
+	nickname string
+	stop     context.CancelFunc // Stops redialling them
+	timer    *time.Timer        // Fires when their window runs out
+	resolved chan struct{}      // Closed once they're back or gone
+	back     bool               // Set before resolved is closed
+}
+
+// Called when we've lost every connection to a peer, false if there's no hand to hold for them and they've left already
+func (p *GokerPeer) peerDropped(peerID peer.ID) bool {
+	window := time.Duration(p.gameState.GetRules().ReconnectWindow) * time.Second
+	if window <= 0 || p.gameState.GetTurnOrderIndex(peerID) == nil || !p.gameState.PlayerExists(peerID) {
+		return false
+	}
+	nickname := p.gameState.GetNickname(peerID)
+
+	p.droppedMutex.Lock()
+	defer p.droppedMutex.Unlock()
+
+	if p.dropped == nil {
+		p.dropped = make(map[peer.ID]*droppedPeer)
+	}
+	if _, ok := p.dropped[peerID]; ok {
+		return true
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	d := &droppedPeer{nickname: nickname, stop: stop, resolved: make(chan struct{})}
+	d.timer = time.AfterFunc(window, func() { p.reconnectExpired(peerID) })
+	p.dropped[peerID] = d
+	go p.redial(ctx, peerID)
+
+	log.Printf("%s dropped, giving them %s to reconnect", nickname, window)
+	p.bus.Connection.Publish(eventbus.ConnectionStatus{Nickname: nickname, Window: window})
+	return true
+}
+
+// Keeps dialling a dropped player until they're back or their window runs out
+func (p *GokerPeer) redial(ctx context.Context, peerID peer.ID) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.redialInterval):
+		}
+
+		dialCtx, cancel := context.WithTimeout(ctx, p.redialInterval)
+		err := p.ThisHost.Connect(dialCtx, peer.AddrInfo{ID: peerID}) // The peerstore still has their addresses
+		cancel()
+		if err == nil {
+			return // The connection notification takes it from here
+		}
+	}
+}
+
+// Called when a peer connects, true if they dropped earlier and are now back in time
+func (p *GokerPeer) peerReturned(peerID peer.ID) bool {
+	p.droppedMutex.Lock()
+	d, ok := p.dropped[peerID]
+	if !ok {
+		p.droppedMutex.Unlock()
+		return false
+	}
+	wasCutOff := p.cutOff()
+	var resynced chan struct{}
+	if wasCutOff {
+		resynced = make(chan struct{})
+		p.resyncing = resynced
+	}
+	delete(p.dropped, peerID)
+	d.timer.Stop()
+	d.stop()
+	d.back = true
+	close(d.resolved)
+	p.droppedMutex.Unlock()
+
+	log.Printf("%s reconnected", d.nickname)
+	p.bus.Connection.Publish(eventbus.ConnectionStatus{Nickname: d.nickname, Back: true})
+
+	if wasCutOff { // It was us who dropped, and they've seen everything we missed
+		go func() {
+			defer close(resynced)
+			if err := p.ExecuteCommand(&ResyncCommand{From: peerID}); err != nil {
+				p.bus.CommandFailed.Publish(err)
+			}
+		}()
+	}
+	return true
+}
+
+// Holds back incoming commands while we catch up on the hand after being cut off
+func (p *GokerPeer) awaitResync() {
+	p.droppedMutex.Lock()
+	resynced := p.resyncing
+	p.droppedMutex.Unlock()
+	if resynced != nil {
+		<-resynced
+	}
+}
+
+// Their window ran out, from here on they've left the table
+func (p *GokerPeer) reconnectExpired(peerID peer.ID) {
+	p.droppedMutex.Lock()
+	d, ok := p.dropped[peerID]
+	if ok {
+		delete(p.dropped, peerID)
+		d.stop()
+		close(d.resolved)
+	}
+	p.droppedMutex.Unlock()
+	if !ok { // They made it back just in time
+		return
+	}
+
+	log.Printf("%s didn't reconnect in time", d.nickname)
+	p.bus.Connection.Publish(eventbus.ConnectionStatus{Nickname: d.nickname, Gone: true})
+	p.peerLeft(peerID)
+}
+
+// Blocks until a peer we couldn't reach is back or their window runs out, true if they're back and worth asking again
+func (p *GokerPeer) awaitReturn(peerID peer.ID) bool {
+	if p.ThisHost.Network().Connectedness(peerID) == network.Connected {
+		return false // They're there, waiting won't change their answer
+	}
+	if !p.peerDropped(peerID) { // The disconnect notification may not have come yet
+		return false
+	}
+
+	p.droppedMutex.Lock()
+	d, ok := p.dropped[peerID]
+	p.droppedMutex.Unlock()
+	if !ok {
+		return false
+	}
+	log.Printf("Waiting for %s to reconnect", d.nickname)
+	<-d.resolved
+	return d.back
+}
+
+// If every other player at the table has dropped, it was us who lost our connection - The caller holds the dropped lock
+func (p *GokerPeer) cutOff() bool {
+	if len(p.dropped) == 0 {
+		return false
+	}
+	for _, id := range p.gameState.GetTurnOrder() {
+		if id != p.ThisHost.ID() && p.dropped[id] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// True while we've lost our connection to the whole table, nothing we do now would reach anyone
+func (p *GokerPeer) Reconnecting() bool {
+	p.droppedMutex.Lock()
+	defer p.droppedMutex.Unlock()
+	return p.cutOff()
+}
+
+// Blocks until everyone who dropped is back or gone, nobody can be dealt into a hand while they're away
+func (p *GokerPeer) WaitForDroppedPeers() {
+	for {
+		p.droppedMutex.Lock()
+		var resolved chan struct{}
+		for _, d := range p.dropped {
+			resolved = d.resolved
+			break
+		}
+		p.droppedMutex.Unlock()
+
+		if resolved == nil {
+			return
+		}
+		<-resolved
+	}
+}
+
+// Checks the host sees the hand the same way as the peer we're resyncing from, if we can reach them
+// Whoever got back to us first could send us anything. The two can be a move apart while it's being played, so they get
+// a few tries to agree, and the latest answer from the peer is the one to catch up with.
+func (p *GokerPeer) confirmResync(from peer.ID, resync resyncPayload) (resyncPayload, error) {
+	host := p.tableHost()
+	if host == from || host == p.ThisHost.ID() || p.ThisHost.Network().Connectedness(host) != network.Connected {
+		return resync, nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		hosts, err := p.fetchResync(host)
+		if err != nil {
+			return resync, err
+		}
+		if hosts.Tag == resync.Tag && reflect.DeepEqual(hosts.State, resync.State) {
+			return resync, nil
+		}
+		if attempt == resyncAttempts {
+			return resync, fmt.Errorf("Resync: %w: %s and the host see the hand differently", ErrBadResponse, p.gameState.GetNickname(from))
+		}
+
+		time.Sleep(resyncPause)
+		if resync, err = p.fetchResync(from); err != nil {
+			return resync, err
+		}
+	}
+}
+
+// Catches up on a hand from someone who stayed at the table while we were away
+func (p *GokerPeer) applyResync(from peer.ID, resync resyncPayload) error {
+	before := p.gameState.Snapshot()
+	theirs := resync.State
+	if theirs.Round < before.Round || theirs.Round == before.Round && theirs.Aborts < before.Aborts {
+		log.Printf("Resync: %s is further behind than we are, keeping our own hand", from)
+		return nil
+	}
+	if err := p.gameState.CheckSnapshot(theirs); err != nil {
+		return peerErr("Resync", from, ErrBadResponse, "%v", err)
+	}
+	newHand := theirs.Round != before.Round || theirs.Aborts != before.Aborts
+
+	// Keyrings from folds we missed are checked before anything changes, a bad one is on whoever sent it
+	if !newHand {
+		for owner, keyring := range resync.Keyrings {
+			if err := p.checkRevealedKeyring(p.variationTranscript, owner, keyring); err != nil {
+				return peerErr("Resync", from, ErrBadProof, "keyring of %s: %v", owner, err)
+			}
+		}
+	}
+
+	p.gameState.Restore(theirs)
+	p.SetNewTag(resync.Tag)
+
+	if newHand { // The hand finished without us, the next one is dealt to us like everyone else
+		p.StopSolvingPuzzles()
+		p.OthersHands = make(map[peer.ID][]*CardInfo)
+	} else {
+		for owner, keyring := range resync.Keyrings {
+			p.DecryptRoundDeckWithPayload(keyring) // Keys we'd already used are skipped
+			p.keyringRevealed(owner, keyring)
+		}
+		if err := p.catchUpBoard(before.Phase, theirs.Phase); err != nil {
+			return err
+		}
+	}
+	log.Printf("Resync: caught up with %s, round %d %s", from, theirs.Round, theirs.Phase)
+
+	p.bus.Pot.Publish(p.gameState.GetCurrentPot())
+	p.bus.PlayerInfo.Publish(p.gameState.GetPlayerInfo())
+	p.bus.Resynced.Publish(newHand)
+	return nil
+}
+
+// Decrypts the board cards that were turned over while we were away
+func (p *GokerPeer) catchUpBoard(from, to string) error {
+	phases := []string{"preflop", "flop", "turn", "river"}
+	start, end := slices.Index(phases, from), slices.Index(phases, to)
+	if start < 0 || end <= start {
+		return nil
+	}
+
+	reveals := map[string]PeerCommand{"flop": &RequestFlop{}, "turn": &RequestTurn{}, "river": &RequestRiver{}}
+	for _, phase := range phases[start+1 : end+1] {
+		if err := p.ExecuteCommand(reveals[phase]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
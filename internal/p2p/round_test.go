@@ -0,0 +1,472 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goker/internal/eventbus"
+	"goker/internal/gamestate"
+	"goker/internal/sra"
+	"goker/internal/tablerules"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// Every card cipher the table rules offer deals a hand everyone can read, with no card dealt twice
+func TestDeal(t *testing.T) {
+	if testing.Short() {
+		t.Skip("deals a hand with real keys, skipping in short mode")
+	}
+
+	for _, cipher := range []string{tablerules.PohligHellman, tablerules.Secp256k1} {
+		t.Run(cipher, func(t *testing.T) {
+			testDeal(t, cipher)
+		})
+	}
+}
+
+func testDeal(t *testing.T, cipher string) {
+	const numOfPeers = 3
+	tt := newTestTable(t, numOfPeers)
+
+	rules := tablerules.Default()
+	rules.Cipher = cipher
+	tt.initTable(rules)
+	for _, p := range tt.peers {
+		require.Equal(t, tt.host().gameState.GetTurnOrder(), p.gameState.GetTurnOrder(), "everyone should agree on the turn order")
+		require.Equal(t, tt.host().gameState.Rules, p.gameState.Rules)
+	}
+
+	tt.deal()
+
+	// The deck was encrypted in the group the rules picked
+	group, err := tt.host().tableCipher()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(group.Name(), cipher), "playing with %s", group.Name())
+	for _, p := range tt.peers {
+		require.Len(t, p.Deck.RoundDeck, 52)
+		for _, card := range p.Deck.RoundDeck {
+			require.True(t, group.Contains(card.CardValue), "a card outside the %s group", cipher)
+		}
+	}
+
+	// Everyone can read their own hand, and nobody was dealt a card someone else holds
+	seen := make(map[string]bool)
+	for _, p := range tt.peers {
+		require.Len(t, p.MyHand, 2)
+		for _, card := range p.MyHand {
+			name, ok := p.Deck.GetCardFromRefDeck(card.CardValue)
+			require.True(t, ok, "card was not fully decrypted")
+			require.False(t, seen[name], "%s was dealt twice", name)
+			seen[name] = true
+		}
+	}
+}
+
+// Everyone sends their squaring speed when they join, and puzzles are locked for the fastest of them
+func TestSquaringSpeeds(t *testing.T) {
+	const numOfPeers = 3
+	tt := newTestTable(t, numOfPeers)
+	tt.initTable(tablerules.Default())
+	for _, p := range tt.peers {
+		p.speedsMutex.Lock()
+		require.Len(t, p.squaringSpeeds, numOfPeers-1, "everyone should know everyone elses squaring speed")
+		p.speedsMutex.Unlock()
+	}
+
+	// Puzzles are locked for the fastest player still at the table
+	speedy := tt.peers[1].ThisHost.ID()
+	requireFastest := func(want int64) {
+		t.Helper()
+		fastest, err := tt.host().fastestSquaringSpeed()
+		require.NoError(t, err)
+		require.Equal(t, want, fastest)
+	}
+	tt.host().recordSquaringSpeed(speedy, 5)
+	requireFastest(5)
+	tt.host().recordSquaringSpeed(speedy, 500)
+	requireFastest(maxSpeedRatio) // Capped against our own
+	tt.host().recordSquaringSpeed(speedy, 1)
+
+	// Nobody joins without a speed, whatever their nickname looks like
+	answer, err := readNickname("NicknameRequest", speedy, &nicknamePayload{Nickname: "two\nlines", SquaringSpeed: 3})
+	require.NoError(t, err)
+	require.Equal(t, "two\nlines", answer.Nickname)
+	for _, payload := range []any{&nicknamePayload{Nickname: "slow"}, &nicknamePayload{Nickname: "slower", SquaringSpeed: -1}, "fast\n3"} {
+		_, err := readNickname("NicknameRequest", speedy, payload)
+		requireOffender(t, err, ErrBadResponse, speedy)
+	}
+
+	// Without our own speed nobodys puzzle can be checked, so the deal is called off before anyone is asked for one
+	tt.host().Keyring.SetSquaringSpeed(0)
+	_, err = tt.host().fastestSquaringSpeed()
+	require.ErrorIs(t, err, sra.ErrNoSpeed)
+	err = (&RequestPuzzleCommand{}).Execute(tt.host())
+	require.ErrorIs(t, err, ErrLocal)
+	require.ErrorIs(t, err, sra.ErrNoSpeed)
+	_, ok := Offender(err)
+	require.False(t, ok, "nobody else is to blame")
+}
+
+// Every key a peer reveals is checked against the key targets they proved when they encrypted the deck
+func TestRevealedKeysTraced(t *testing.T) {
+	if testing.Short() {
+		t.Skip("deals a hand with real keys, skipping in short mode")
+	}
+
+	tt := newTestTable(t, 3)
+	tt.initTable(tablerules.Default())
+	tt.deal()
+	host, sender, other := tt.host(), tt.peers[1], tt.peers[2]
+
+	// Key targets that don't match what a peer did to the deck get them named, even signed by them
+	step, in, err := host.variationTranscript.step(sender.ThisHost.ID())
+	require.NoError(t, err)
+	command := NetworkCommand{Command: "ProtocolSS", Payload: in}
+	require.NoError(t, host.verifyStepFrom(sender.ThisHost.ID(), command, *step))
+	tampered := *step
+	targets := strings.Split(tampered.KeyTargets, "\n")
+	targets[0], targets[1] = targets[1], targets[0] // Keys for the first two cards that take them to each others value
+	tampered.KeyTargets = strings.Join(targets, "\n")
+	tampered.CommitmentSignature, err = sender.Keyring.SignMessage(keyTargetSigningData(tampered.KeyTargets))
+	require.NoError(t, err)
+	requireOffender(t, host.verifyStepFrom(sender.ThisHost.ID(), command, tampered), ErrBadProof, sender.ThisHost.ID())
+
+	// Keys for the flop are checked against the targets their owner proved, so a bad one is traced to whoever sent it
+	payload, err := sender.GetKeyPayloadForFlop()
+	require.NoError(t, err)
+	keys := strings.Split(payload, "\n")
+	require.NoError(t, host.checkRevealedKeys(sender.ThisHost.ID(), host.Flop, keys))
+	othersPayload, err := other.GetKeyPayloadForFlop()
+	require.NoError(t, err)
+	require.Error(t, host.checkRevealedKeys(sender.ThisHost.ID(), host.Flop, strings.Split(othersPayload, "\n")))
+	require.Error(t, host.checkRevealedKeys(sender.ThisHost.ID(), host.Flop, []string{keys[0], keys[1], "12345"}))
+	require.Error(t, host.checkRevealedKeys(sender.ThisHost.ID(), host.Flop, keys[:2]))
+}
+
+// Keys are only revealed to the host once a hand is over, and a keyring that doesn't add up gets its owner accused
+func TestAudit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("deals a hand with real keys, skipping in short mode")
+	}
+
+	const numOfPeers = 3
+	tt := newTestTable(t, numOfPeers)
+	tt.initTable(tablerules.Default())
+	tt.deal()
+	host, sender, other := tt.host(), tt.peers[1], tt.peers[2]
+
+	// Nobody gives up their keys mid-hand, not even to the host
+	wait := evaluationWait
+	evaluationWait = 100 * time.Millisecond
+	reveal := &RevealKeyringCommand{}
+	require.ErrorIs(t, host.ExecuteCommand(reveal), ErrRejected)
+	require.Len(t, reveal.Keyrings, 1, "only our own")
+	evaluationWait = wait
+
+	// Once everyone has evaluated the hand only the host can have their keys, and only for that round
+	round := tt.host().gameState.Round
+	for _, p := range tt.peers {
+		p.HandEvaluated(round)
+	}
+	require.Error(t, other.checkRevealRequest(sender.ThisHost.ID(), round))
+	require.Error(t, other.checkRevealRequest(host.ThisHost.ID(), round-1))
+	require.NoError(t, other.checkRevealRequest(host.ThisHost.ID(), round))
+
+	// Everyone played honestly, so the audit after the hand finds nothing
+	reveal = &RevealKeyringCommand{}
+	require.NoError(t, tt.host().ExecuteCommand(reveal))
+	require.Len(t, reveal.Keyrings, numOfPeers)
+
+	audit := &AuditCommand{Round: round, Keyrings: reveal.Keyrings}
+	require.NoError(t, tt.host().ExecuteCommand(audit))
+	require.Empty(t, audit.Accusations)
+
+	// A keyring that doesn't match what its owner did to the deck gets them accused by everyone
+	honest := maps.Clone(reveal.Keyrings)
+	cheater := sender.ThisHost.ID()
+	lines := strings.Split(honest[cheater].Keyring, "\n")
+	lines[2], lines[3] = lines[3], lines[2] // Swap the variations for the first two cards
+	swapped, err := sender.signKeyring(round, strings.Join(lines, "\n"))
+	require.NoError(t, err)
+	reveal.Keyrings[cheater] = swapped
+
+	audit = &AuditCommand{Round: round, Keyrings: reveal.Keyrings}
+	require.NoError(t, tt.host().ExecuteCommand(audit))
+	require.Len(t, audit.Accusations, numOfPeers)
+	accusers := make(map[peer.ID]bool)
+	for _, accusation := range audit.Accusations {
+		require.Equal(t, cheater, accusation.Accused)
+		require.Equal(t, round, accusation.Round)
+		if accusation.Accuser != tt.host().ThisHost.ID() { // We don't keep our own public key
+			require.NoError(t, tt.host().verifyAccusation(accusation.Accuser, round, accusation))
+		}
+		accusers[accusation.Accuser] = true
+	}
+	require.Len(t, accusers, numOfPeers)
+
+	// The host can't pin a keyring on someone who didn't sign it, that's held against the host instead
+	forged := maps.Clone(honest)
+	forged[cheater] = SignedKeyring{Owner: cheater, Table: swapped.Table, Round: round, Keyring: swapped.Keyring, Signature: honest[cheater].Signature}
+	accusations, err := other.accuse(host.ThisHost.ID(), round, forged)
+	require.NoError(t, err)
+	require.Len(t, accusations, 1)
+	require.Equal(t, host.ThisHost.ID(), accusations[0].Accused)
+
+	// A shuffle that isn't the one committed to before the hand is caught when the commitment is opened
+	reshuffled := honest[cheater]
+	reshuffled.Permutation = slices.Clone(reshuffled.Permutation)
+	reshuffled.Permutation[0], reshuffled.Permutation[1] = reshuffled.Permutation[1], reshuffled.Permutation[0]
+	reshuffled.Signature, err = sender.Keyring.SignMessage(reshuffled.signingData())
+	require.NoError(t, err)
+	forged[cheater] = reshuffled
+	accusations, err = other.accuse(host.ThisHost.ID(), round, forged)
+	require.NoError(t, err)
+	require.Len(t, accusations, 1)
+	require.Equal(t, cheater, accusations[0].Accused)
+	require.Contains(t, accusations[0].Reason, "committed to")
+
+	// Nor can the host get someone accused by leaving their keyring out, they're treated as unreachable
+	delete(forged, cheater)
+	accusations, err = other.accuse(host.ThisHost.ID(), round, forged)
+	require.NoError(t, err)
+	require.Empty(t, accusations)
+}
+
+// A puzzle is only kept if it was built from the keys its owner committed to, and locked for as long as the table allows
+func TestPuzzleConstruction(t *testing.T) {
+	if testing.Short() {
+		t.Skip("deals a hand with real keys, skipping in short mode")
+	}
+
+	tt := newTestTable(t, 3)
+	tt.initTable(tablerules.Default())
+	tt.deal()
+	host, stayer, leaver := tt.host(), tt.peers[1], tt.peers[2]
+
+	// Puzzles are checked against who built them before they're kept
+	puzzle, err := json.Marshal(stayer.Keyring.TLP)
+	require.NoError(t, err)
+	require.ErrorIs(t, host.storePuzzle(leaver.ThisHost.ID(), string(puzzle)), sra.ErrBadPuzzle, "someone elses puzzle")
+	tampered := *stayer.Keyring.TLP
+	tampered.Payload = leaver.Keyring.TLP.Payload
+	puzzle, err = json.Marshal(tampered)
+	require.NoError(t, err)
+	require.ErrorIs(t, host.storePuzzle(stayer.ThisHost.ID(), string(puzzle)), sra.ErrBadPuzzle, "payload swapped out")
+
+	// Or locked for far longer than anyone at the table would lock theirs, even if it's built right
+	binding, err := puzzleBinding(host.variationTranscript, stayer.ThisHost.ID())
+	require.NoError(t, err)
+	slow := &sra.Keyring{KeyringPayload: "keys"}
+	require.NoError(t, slow.GenerateTimeLockedPuzzle(time.Hour, 1000, binding))
+	require.NoError(t, slow.TLP.VerifyConstruction(binding))
+	puzzle, err = json.Marshal(slow.TLP)
+	require.NoError(t, err)
+	require.ErrorIs(t, host.storePuzzle(stayer.ThisHost.ID(), string(puzzle)), sra.ErrBadPuzzle, "locked for too long")
+}
+
+// Puzzles are kept for the hand but only solved for someone who leaves before revealing their keys
+func TestPlayerLeaves(t *testing.T) {
+	if testing.Short() {
+		t.Skip("deals a hand with real keys, skipping in short mode")
+	}
+
+	tt := newTestTable(t, 3)
+	rules := tablerules.Default()
+	rules.ReconnectWindow = 2
+	tt.initTable(rules)
+	tt.deal()
+
+	host, stayer, leaver := tt.host(), tt.peers[1], tt.peers[2]
+	solved := host.bus.PuzzleBroken.Subscribe()
+	defer solved.Close()
+
+	require.Never(t, func() bool { return host.PuzzlesPending() > 0 }, 200*time.Millisecond, 10*time.Millisecond, "nobody has left yet")
+	require.Empty(t, host.Keyring.BrokenPuzzlePayloads)
+
+	// A solution has to check out before anyone takes it
+	bogus := &sra.Solution{Share: leaver.Keyring.TLP.LockedShares()[0], Value: "4", Proof: "2"}
+	require.ErrorIs(t, stayer.acceptSolution(host.ThisHost.ID(), leaver.ThisHost.ID(), bogus), ErrBadProof)
+
+	// Only the next seat after whoever left solves their puzzle, everyone else takes the solution they send
+	solver, ok := host.currentPuzzles().solverFor(leaver.ThisHost.ID())
+	require.True(t, ok)
+	require.Equal(t, host.ThisHost.ID(), solver)
+	stayerSolved := stayer.bus.PuzzleBroken.Subscribe()
+	defer stayerSolved.Close()
+	stayerProgress := stayer.bus.PuzzleProgress.Subscribe()
+	defer stayerProgress.Close()
+
+	// Nothing is solved while they still have time to come back
+	require.NoError(t, leaver.ThisHost.Close())
+	require.Never(t, func() bool { return host.PuzzlesPending() > 0 }, 500*time.Millisecond, 10*time.Millisecond, "they might still reconnect")
+	for _, solved := range []*eventbus.Subscription[struct{}]{solved, stayerSolved} {
+		select {
+		case <-solved.C():
+		case <-time.After(time.Minute):
+			t.Fatal("the puzzle of whoever left was never solved")
+		}
+	}
+	require.Zero(t, host.PuzzlesPending())
+	require.Equal(t, []string{leaver.Keyring.KeyringPayload}, host.Keyring.BrokenPuzzlePayloads)
+	require.Zero(t, stayer.PuzzlesPending())
+	require.Equal(t, []string{leaver.Keyring.KeyringPayload}, stayer.Keyring.BrokenPuzzlePayloads)
+	for _, progress := range drain(stayerProgress.C()) {
+		require.True(t, progress.Done, "only the solver squares")
+	}
+
+	// The next hand starts with no puzzles, so nothing is solved again
+	host.StopSolvingPuzzles()
+	host.solvePuzzle(leaver.ThisHost.ID(), "player2")
+	require.Zero(t, host.PuzzlesPending())
+}
+
+// Someone who drops mid-hand and comes back within the window catches up on the hand instead of leaving it
+func TestPlayerReconnects(t *testing.T) {
+	if testing.Short() {
+		t.Skip("deals a hand with real keys, skipping in short mode")
+	}
+
+	tt := newTestTable(t, 3)
+	rules := tablerules.Default()
+	tt.initTable(rules)
+	tt.deal()
+
+	// Whoever acts last preflop drops, so the other two have something to do while they're gone
+	hostState := tt.host().gameState
+	turnOrder := hostState.GetTurnOrder()
+	first := *hostState.GetTurnOrderIndex(hostState.GetWhosTurn())
+	caller := tt.peer(turnOrder[first])
+	folder := tt.peer(turnOrder[(first+1)%3])
+	dropper := tt.peer(turnOrder[(first+2)%3])
+	droppedID := dropper.ThisHost.ID()
+
+	for _, stayer := range []*GokerPeer{caller, folder} {
+		require.NoError(t, dropper.ThisHost.Network().ClosePeer(stayer.ThisHost.ID()))
+	}
+	require.Eventually(t, func() bool {
+		for _, stayer := range []*GokerPeer{caller, folder} {
+			stayer.droppedMutex.Lock()
+			_, waiting := stayer.dropped[droppedID]
+			stayer.droppedMutex.Unlock()
+			if !waiting || stayer.Reconnecting() {
+				return false
+			}
+		}
+		return dropper.Reconnecting()
+	}, 10*time.Second, 10*time.Millisecond, "nobody noticed the drop")
+	require.Zero(t, caller.PuzzlesPending(), "their puzzle waits until the window runs out")
+
+	// The table plays on up to their turn without them
+	caller.gameState.PlayerCall(caller.gameState.Me)
+	require.NoError(t, caller.ExecuteCommand(&CallCommand{}))
+	caller.gameState.NextTurn()
+	require.Eventually(t, func() bool { return folder.gameState.IsMyTurn() }, 10*time.Second, 10*time.Millisecond)
+	folder.gameState.PlayerFold(folder.gameState.Me)
+	require.NoError(t, folder.ExecuteCommand(&FoldCommand{}))
+	folder.gameState.NextTurn()
+	require.Eventually(t, func() bool {
+		return caller.gameState.GetWhosTurn() == droppedID && caller.gameState.Snapshot().FoldedPlayers[folder.ThisHost.ID()]
+	}, 10*time.Second, 10*time.Millisecond)
+	require.False(t, dropper.gameState.Snapshot().FoldedPlayers[folder.ThisHost.ID()], "they missed the fold")
+
+	// Back in time, they pick the hand up where the table is
+	for _, stayer := range []*GokerPeer{caller, folder} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		require.NoError(t, dropper.ThisHost.Connect(ctx, peer.AddrInfo{ID: stayer.ThisHost.ID(), Addrs: stayer.ThisHost.Addrs()}))
+		cancel()
+	}
+	tt.waitForAgreement()
+	require.Eventually(t, func() bool { return dropper.gameState.IsMyTurn() }, 10*time.Second, 10*time.Millisecond)
+	require.False(t, dropper.Reconnecting())
+	require.True(t, dropper.gameState.Snapshot().FoldedPlayers[folder.ThisHost.ID()])
+	require.Equal(t, caller.gameState.Snapshot().PlayersMoney, dropper.gameState.Snapshot().PlayersMoney)
+	require.Equal(t, tt.host().tag.Load(), dropper.tag.Load(), "betting needs the tag for this phase")
+	require.Zero(t, caller.PuzzlesPending())
+}
+
+// A resync is only taken if it could be the hand we were in, and the host sees it the same way
+func TestResyncChecked(t *testing.T) {
+	tt := newTestTable(t, 3)
+	tt.initTable(tablerules.Default())
+	host, sender, returning := tt.peers[0], tt.peers[1], tt.peers[2]
+	senderID := sender.ThisHost.ID()
+	money := maps.Clone(returning.gameState.PlayersMoney)
+
+	for name, change := range map[string]func(s *gamestate.Snapshot){
+		"chips for themselves": func(s *gamestate.Snapshot) { s.PlayersMoney[senderID] += 1000 },
+		"us left out": func(s *gamestate.Snapshot) {
+			s.TurnOrder = slices.DeleteFunc(s.TurnOrder, func(id peer.ID) bool { return id == returning.ThisHost.ID() })
+		},
+		"turn out of range": func(s *gamestate.Snapshot) { s.WhosTurn = len(s.TurnOrder) },
+	} {
+		tampered := sender.gameState.Snapshot()
+		change(&tampered)
+		requireOffender(t, returning.applyResync(senderID, resyncPayload{State: tampered, Tag: sender.tag.Load()}), ErrBadResponse, senderID)
+		require.Equal(t, money, returning.gameState.PlayersMoney, name)
+		require.Len(t, returning.gameState.TurnOrder, 3, name)
+	}
+
+	// A hand that checks out, but isn't the one the host sees
+	sender.gameState.WhosTurn = (host.gameState.WhosTurn + 1) % 3
+	require.ErrorIs(t, returning.ExecuteCommand(&ResyncCommand{From: senderID}), ErrBadResponse)
+	require.NotEqual(t, sender.gameState.WhosTurn, returning.gameState.WhosTurn)
+
+	sender.gameState.WhosTurn = host.gameState.WhosTurn
+	require.NoError(t, returning.ExecuteCommand(&ResyncCommand{From: senderID}))
+}
+
+// Whatever's already been delivered on a subscription, once nothing more turns up for a moment
+func drain[T any](c <-chan T) []T {
+	var events []T
+	for {
+		select {
+		case event := <-c:
+			events = append(events, event)
+		case <-time.After(100 * time.Millisecond):
+			return events
+		}
+	}
+}
+
+// Building the deck with everyones shuffles, variations and proofs, what everyone waits on before a hand can be dealt
+// Compare worker counts with e.g. go test -run ^$ -bench DeckProtocol -cpu 1,4 ./internal/p2p
+func BenchmarkDeckProtocol(b *testing.B) {
+	for _, cipher := range []string{tablerules.PohligHellman, tablerules.Secp256k1} {
+		for numOfPeers := 2; numOfPeers <= 6; numOfPeers++ {
+			b.Run(fmt.Sprintf("%s/players=%d", cipher, numOfPeers), func(b *testing.B) {
+				benchmarkDeckProtocol(b, cipher, numOfPeers)
+			})
+		}
+	}
+}
+
+func benchmarkDeckProtocol(b *testing.B, cipher string, numOfPeers int) {
+	tt := newTestTable(b, numOfPeers)
+	rules := tablerules.Default()
+	rules.Cipher = cipher
+	tt.initTable(rules)
+
+	host := tt.host()
+	require.NoError(b, host.ExecuteCommand(&NewKeysCommand{}))
+
+	b.ResetTimer()
+	for range b.N {
+		b.StopTimer()
+		for _, p := range tt.peers {
+			p.Deck.GenerateDecks(DeckKey)
+		}
+		b.StartTimer()
+
+		require.NoError(b, host.ExecuteCommand(&ProtocolFirstStepCommand{}))
+		require.NoError(b, host.ExecuteCommand(&BroadcastNewDeck{}))
+		require.NoError(b, host.ExecuteCommand(&ProtocolSecondStepCommand{}))
+		require.NoError(b, host.ExecuteCommand(&BroadcastDeck{}))
+	}
+}
@@ -0,0 +1,454 @@
+package p2p
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"goker/internal/tablerules"
+	"io"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Binary version of the command protocol, described in goker.proto
+// Encoded by hand with protowire so there's no generated code to keep in sync with the schema, TestWireSchema checks the two agree
+// Streams are opened preferring this, peers that only know the JSON protocol are still spoken to in JSON
+var binaryProtocolID = protocol.ID("/goker/command/2.0.0")
+
+const (
+	wireVersion     = 2
+	baseFrameSize   = 1 << 20 // Everything but decks and proofs, a peer list or the table rules are a few KB
+	jsonFrameFactor = 3       // JSON spells numbers out in decimal, so it gets this many times as much room
+)
+
+// Field numbers from goker.proto
+const (
+	fieldVersion    protowire.Number = 1
+	fieldCommand    protowire.Number = 2
+	fieldSignature  protowire.Number = 3
+	fieldTag        protowire.Number = 4
+	fieldText       protowire.Number = 5
+	fieldNumbers    protowire.Number = 6
+	fieldAmount     protowire.Number = 7
+	fieldTable      protowire.Number = 8
+	fieldRound      protowire.Number = 9
+	fieldPhase      protowire.Number = 10
+	fieldSeq        protowire.Number = 11
+	fieldSender     protowire.Number = 12
+	fieldTranscript protowire.Number = 13
+	fieldProof      protowire.Number = 14
+	fieldKeyring    protowire.Number = 15
+	fieldKeyrings   protowire.Number = 16
+	fieldSession    protowire.Number = 17
+	fieldNickname   protowire.Number = 18
+
+	fieldNumbersValues protowire.Number = 1
+)
+
+type payloadKind int
+
+const (
+	textPayload payloadKind = iota
+	numbersPayload
+	amountPayload
+	transcriptPayload // A *deckTranscript
+	proofPayload      // A *provenDeck
+	keyringPayload    // A *SignedKeyring
+	keyringsPayload   // An *auditPayload
+	namePayload       // A *nicknamePayload
+)
+
+// The field in Command each typed payload is sent in
+var messageFields = map[payloadKind]protowire.Number{
+	transcriptPayload: fieldTranscript,
+	proofPayload:      fieldProof,
+	keyringPayload:    fieldKeyring,
+	keyringsPayload:   fieldKeyrings,
+	namePayload:       fieldNickname,
+}
+
+// Whether a field of Command holds one of the typed payloads
+func isMessageField(num protowire.Number) bool {
+	for _, field := range messageFields {
+		if field == num {
+			return true
+		}
+	}
+	return false
+}
+
+// The payloads each command (and its response) is allowed to carry, any command can also carry nothing
+// Decks and keys go as numbers, which is about half the size of the decimal text and can't be anything but numbers when decoded
+// Proofs, transcripts and revealed keyrings have messages of their own, see message_handler.go
+var commandPayloads = map[string][]payloadKind{
+	"GetPeers":           {textPayload},
+	"NicknameRequest":    {namePayload},
+	"InitTable":          {textPayload},
+	"NewKeys":            {textPayload},
+	"ProtocolFS":         {numbersPayload},
+	"ProtocolFirstStep":  {proofPayload},
+	"BroadcastNewDeck":   {transcriptPayload, textPayload},
+	"ProtocolSS":         {numbersPayload},
+	"ProtocolSecondStep": {proofPayload},
+	"BroadcastDeck":      {transcriptPayload, textPayload},
+	"PushTag":            {},
+	"CanRequestHand":     {},
+	"RequestHand":        {numbersPayload},
+	"MoveToTable":        {},
+	"Raise":              {amountPayload, textPayload},
+	"Fold":               {numbersPayload, textPayload},
+	"Call":               {textPayload},
+	"Check":              {textPayload},
+	"RequestFlop":        {numbersPayload},
+	"RequestTurn":        {numbersPayload},
+	"RequestRiver":       {numbersPayload},
+	"RequestOthersHand":  {numbersPayload},
+	"RevealKeyring":      {keyringPayload, textPayload},
+	"Audit":              {keyringsPayload, textPayload},
+	"AbortRound":         {textPayload},
+	"CanRequestPuzzle":   {},
+	"PuzzleExchange":     {textPayload},
+	"PuzzleSolution":     {textPayload},
+	"Resync":             {textPayload},
+}
+
+func allowsPayload(command string, kind payloadKind) bool {
+	for _, allowed := range commandPayloads[command] {
+		if allowed == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Encodes a command for the binary protocol
+func marshalCommand(nCmd NetworkCommand) ([]byte, error) {
+	if _, ok := commandPayloads[nCmd.Command]; !ok {
+		return nil, fmt.Errorf("no wire schema for command %q", nCmd.Command)
+	}
+
+	b := protowire.AppendTag(nil, fieldVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, wireVersion)
+	b = protowire.AppendTag(b, fieldCommand, protowire.BytesType)
+	b = protowire.AppendString(b, nCmd.Command)
+
+	if nCmd.Signature != "" {
+		signature, err := base64.StdEncoding.DecodeString(nCmd.Signature)
+		if err != nil {
+			return nil, fmt.Errorf("%s: signature isn't base64: %w", nCmd.Command, err)
+		}
+		b = protowire.AppendTag(b, fieldSignature, protowire.BytesType)
+		b = protowire.AppendBytes(b, signature)
+	}
+
+	if nCmd.Tag != nil {
+		b = protowire.AppendTag(b, fieldTag, protowire.VarintType)
+		b = protowire.AppendVarint(b, *nCmd.Tag)
+	}
+
+	if nCmd.Round < 0 {
+		return nil, fmt.Errorf("%s: negative round %d", nCmd.Command, nCmd.Round)
+	}
+	b = protowire.AppendTag(b, fieldTable, protowire.BytesType)
+	b = protowire.AppendString(b, nCmd.Table)
+	b = protowire.AppendTag(b, fieldRound, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(nCmd.Round))
+	b = protowire.AppendTag(b, fieldPhase, protowire.BytesType)
+	b = protowire.AppendString(b, nCmd.Phase)
+	b = protowire.AppendTag(b, fieldSession, protowire.VarintType)
+	b = protowire.AppendVarint(b, nCmd.Session)
+	b = protowire.AppendTag(b, fieldSeq, protowire.VarintType)
+	b = protowire.AppendVarint(b, nCmd.Seq)
+	b = protowire.AppendTag(b, fieldSender, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte(nCmd.Sender))
+
+	switch payload := nCmd.Payload.(type) {
+	case nil:
+	case float64:
+		if !allowsPayload(nCmd.Command, amountPayload) {
+			return nil, fmt.Errorf("%s can't carry an amount", nCmd.Command)
+		}
+		b = protowire.AppendTag(b, fieldAmount, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(payload))
+	case string:
+		if numbers, ok := parseNumbers(payload); ok && allowsPayload(nCmd.Command, numbersPayload) {
+			var values []byte
+			for _, number := range numbers {
+				values = protowire.AppendTag(values, fieldNumbersValues, protowire.BytesType)
+				values = protowire.AppendBytes(values, number)
+			}
+			b = protowire.AppendTag(b, fieldNumbers, protowire.BytesType)
+			b = protowire.AppendBytes(b, values)
+		} else if allowsPayload(nCmd.Command, textPayload) {
+			b = protowire.AppendTag(b, fieldText, protowire.BytesType)
+			b = protowire.AppendString(b, payload)
+		} else {
+			return nil, fmt.Errorf("%s can only carry numbers", nCmd.Command)
+		}
+	case wireMessage:
+		kind := payload.payloadKind()
+		if !allowsPayload(nCmd.Command, kind) {
+			return nil, fmt.Errorf("%s can't carry a %T", nCmd.Command, payload)
+		}
+		msg, err := payload.appendWire(nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", nCmd.Command, err)
+		}
+		b = protowire.AppendTag(b, messageFields[kind], protowire.BytesType)
+		b = protowire.AppendBytes(b, msg)
+	default:
+		return nil, fmt.Errorf("%s: can't encode a %T payload", nCmd.Command, nCmd.Payload)
+	}
+
+	return b, nil
+}
+
+// Decodes a command from the binary protocol, the payload is checked against what that command is allowed to carry
+func unmarshalCommand(b []byte) (NetworkCommand, error) {
+	var nCmd NetworkCommand
+	var version uint64
+	var kind *payloadKind
+	setPayload := func(k payloadKind, payload any) {
+		kind = &k
+		nCmd.Payload = payload
+	}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return NetworkCommand{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == fieldVersion && typ == protowire.VarintType:
+			version, n = protowire.ConsumeVarint(b)
+		case num == fieldCommand && typ == protowire.BytesType:
+			nCmd.Command, n = protowire.ConsumeString(b)
+		case num == fieldSignature && typ == protowire.BytesType:
+			var signature []byte
+			signature, n = protowire.ConsumeBytes(b)
+			nCmd.Signature = base64.StdEncoding.EncodeToString(signature)
+		case num == fieldTag && typ == protowire.VarintType:
+			var tag uint64
+			tag, n = protowire.ConsumeVarint(b)
+			nCmd.Tag = &tag
+		case num == fieldText && typ == protowire.BytesType:
+			var text string
+			text, n = protowire.ConsumeString(b)
+			setPayload(textPayload, text)
+		case num == fieldNumbers && typ == protowire.BytesType:
+			var values []byte
+			values, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				numbers, err := unmarshalNumbers(values)
+				if err != nil {
+					return NetworkCommand{}, err
+				}
+				setPayload(numbersPayload, numbers)
+			}
+		case num == fieldAmount && typ == protowire.Fixed64Type:
+			var amount uint64
+			amount, n = protowire.ConsumeFixed64(b)
+			setPayload(amountPayload, math.Float64frombits(amount))
+		case isMessageField(num) && typ == protowire.BytesType:
+			var msg []byte
+			msg, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				message, err := unmarshalMessage(num, msg)
+				if err != nil {
+					return NetworkCommand{}, err
+				}
+				setPayload(message.payloadKind(), message)
+			}
+		case num == fieldTable && typ == protowire.BytesType:
+			nCmd.Table, n = protowire.ConsumeString(b)
+		case num == fieldRound && typ == protowire.VarintType:
+			var round uint64
+			round, n = protowire.ConsumeVarint(b)
+			if round > math.MaxInt32 {
+				return NetworkCommand{}, fmt.Errorf("round %d is out of range", round)
+			}
+			nCmd.Round = int(round)
+		case num == fieldPhase && typ == protowire.BytesType:
+			nCmd.Phase, n = protowire.ConsumeString(b)
+		case num == fieldSession && typ == protowire.VarintType:
+			nCmd.Session, n = protowire.ConsumeVarint(b)
+		case num == fieldSeq && typ == protowire.VarintType:
+			nCmd.Seq, n = protowire.ConsumeVarint(b)
+		case num == fieldSender && typ == protowire.BytesType:
+			var sender []byte
+			sender, n = protowire.ConsumeBytes(b)
+			nCmd.Sender = peer.ID(sender) // Checked against who actually sent it when verifying
+		default: // From a newer version, skip it
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return NetworkCommand{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+
+	if version != wireVersion {
+		return NetworkCommand{}, fmt.Errorf("unsupported wire version %d", version)
+	}
+	if _, ok := commandPayloads[nCmd.Command]; !ok {
+		return NetworkCommand{}, fmt.Errorf("no wire schema for command %q", nCmd.Command)
+	}
+	if kind != nil && !allowsPayload(nCmd.Command, *kind) {
+		return NetworkCommand{}, fmt.Errorf("%s can't carry that payload", nCmd.Command)
+	}
+	return nCmd, nil
+}
+
+// Checks a command carries a payload its command is allowed to, whichever protocol it came in on
+// Typed payloads have to encode too, so one read from JSON is held to the same rules as one read off the wire
+func checkPayload(nCmd NetworkCommand) error {
+	switch payload := nCmd.Payload.(type) {
+	case nil:
+		return nil
+	case float64:
+		if allowsPayload(nCmd.Command, amountPayload) {
+			return nil
+		}
+	case string:
+		if allowsPayload(nCmd.Command, textPayload) {
+			return nil
+		}
+		if _, ok := parseNumbers(payload); ok && allowsPayload(nCmd.Command, numbersPayload) {
+			return nil
+		}
+	case wireMessage:
+		if allowsPayload(nCmd.Command, payload.payloadKind()) {
+			if _, err := payload.appendWire(nil); err != nil {
+				return fmt.Errorf("%s: %w", nCmd.Command, err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%s can't carry a %T payload", nCmd.Command, nCmd.Payload)
+}
+
+// Splits newline separated decimals into their bytes, only if they'd come back out exactly the same
+func parseNumbers(payload string) ([][]byte, bool) {
+	return parseNumberList(splitNumbers(payload))
+}
+
+// Newline separated decimals as a list, nothing at all is no numbers rather than one empty one
+func splitNumbers(payload string) []string {
+	if payload == "" {
+		return nil
+	}
+	return strings.Split(payload, "\n")
+}
+
+func parseNumberList(values []string) ([][]byte, bool) {
+	var numbers [][]byte
+	for _, value := range values {
+		number, ok := parseNumber(value)
+		if !ok {
+			return nil, false
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, true
+}
+
+// A single decimal as bytes, only if it's written the one way big.Int writes it
+func parseNumber(value string) ([]byte, bool) {
+	n, ok := new(big.Int).SetString(value, 10)
+	if !ok || n.Sign() < 0 || n.String() != value {
+		return nil, false
+	}
+	return n.Bytes(), true
+}
+
+// Turns a Numbers message back into newline separated decimals
+func unmarshalNumbers(b []byte) (string, error) {
+	values, err := unmarshalNumberList(b)
+	return strings.Join(values, "\n"), err
+}
+
+func unmarshalNumberList(b []byte) ([]string, error) {
+	var values []string
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if num == fieldNumbersValues && typ == protowire.BytesType {
+			var value []byte
+			value, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				values = append(values, new(big.Int).SetBytes(value).String())
+			}
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return values, nil
+}
+
+// Biggest command a peer at a table with these rules has reason to send, the shuffle transcript with every players proof
+// Anything bigger is refused before it's read, so nobody can make us hold more than this per stream
+func maxFrameSize(rules tablerules.Rules) int {
+	cardBytes := rules.PrimeBits/8 + 1
+	if rules.Cipher == tablerules.Secp256k1 {
+		cardBytes = 33 // A compressed point
+	}
+	// Each step is its deck plus a shadow deck, permutation and exponent per proof round, the extra bytes cover the framing
+	step := (rules.ShuffleProofRounds + 1) * 52 * (cardBytes + 16)
+	return baseFrameSize + rules.MaxPlayers*step
+}
+
+// Writes a command as a uvarint length followed by the encoded command
+func writeCommand(w io.Writer, nCmd NetworkCommand) error {
+	msg, err := marshalCommand(nCmd)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(protowire.AppendVarint(nil, uint64(len(msg))), msg...))
+	return err
+}
+
+// Reads one length prefixed command of at most limit bytes, without reading past it
+func readCommand(r io.Reader, limit int) (NetworkCommand, error) {
+	size, err := binary.ReadUvarint(byteReader{r})
+	if err != nil {
+		return NetworkCommand{}, err
+	}
+	if size > uint64(limit) {
+		return NetworkCommand{}, fmt.Errorf("command of %d bytes is too big", size)
+	}
+
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF { // They started a command and didn't finish it
+			err = io.ErrUnexpectedEOF
+		}
+		return NetworkCommand{}, err
+	}
+	return unmarshalCommand(msg)
+}
+
+// Reads the length prefix a byte at a time, so nothing after it is buffered away
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r.Reader, b[:])
+	return b[0], err
+}
@@ -0,0 +1,540 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"goker/internal/sra"
+	"goker/internal/tablerules"
+	"math/big"
+	mathrand "math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Commands come back out of the binary protocol exactly as they went in, so signatures still check out
+func TestWireRoundTrip(t *testing.T) {
+	tag := uint64(7)
+	commands := []NetworkCommand{
+		{Command: "ProtocolFS", Payload: "12345678901234567890\n0\n42"},
+		{Command: "RequestHand", Payload: ""},
+		{Command: "Raise", Payload: 12.5, Tag: &tag, Signature: "c2lnbmVk"},
+		{Command: "Raise", Payload: "APPROVED"},
+		{Command: "NicknameRequest"},
+		{Command: "PushTag", Tag: &tag},
+		{Command: "Fold", Payload: "1\n2", Envelope: Envelope{Table: "c0ffee", Round: 3, Phase: "flop", Session: 1700000000, Seq: 41, Sender: peer.ID("someone")}},
+	}
+
+	var buf bytes.Buffer
+	for _, nCmd := range commands {
+		require.NoError(t, writeCommand(&buf, nCmd))
+	}
+	for _, want := range commands {
+		got, err := readCommand(&buf, baseFrameSize)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+	require.Zero(t, buf.Len(), "reading a command shouldn't read past it")
+}
+
+func TestWireRejects(t *testing.T) {
+	t.Run("payload the command can't carry", func(t *testing.T) {
+		_, err := marshalCommand(NetworkCommand{Command: "ProtocolFS", Payload: "not a deck"})
+		require.Error(t, err)
+		_, err = marshalCommand(NetworkCommand{Command: "Call", Payload: 10.0})
+		require.Error(t, err)
+
+		// Decoding checks too, a keyring sent as text is refused
+		msg := mustMarshal(t, NetworkCommand{Command: "RequestHand"})
+		msg = protowire.AppendString(protowire.AppendTag(msg, fieldText, protowire.BytesType), "not numbers")
+		_, err = unmarshalCommand(msg)
+		require.Error(t, err)
+	})
+
+	t.Run("unknown command", func(t *testing.T) {
+		_, err := marshalCommand(NetworkCommand{Command: "Teleport"})
+		require.Error(t, err)
+	})
+
+	t.Run("wrong version", func(t *testing.T) {
+		msg := protowire.AppendVarint(protowire.AppendTag(nil, fieldVersion, protowire.VarintType), 3)
+		msg = protowire.AppendString(protowire.AppendTag(msg, fieldCommand, protowire.BytesType), "GetPeers")
+		_, err := unmarshalCommand(msg)
+		require.ErrorContains(t, err, "unsupported wire version")
+	})
+
+	t.Run("unknown fields are skipped", func(t *testing.T) {
+		msg := mustMarshal(t, NetworkCommand{Command: "GetPeers"})
+		msg = protowire.AppendString(protowire.AppendTag(msg, 99, protowire.BytesType), "from the future")
+		nCmd, err := unmarshalCommand(msg)
+		require.NoError(t, err)
+		require.Equal(t, "GetPeers", nCmd.Command)
+	})
+
+	t.Run("oversized frame", func(t *testing.T) {
+		_, err := readCommand(bytes.NewReader(protowire.AppendVarint(nil, baseFrameSize+1)), baseFrameSize)
+		require.ErrorContains(t, err, "too big")
+	})
+}
+
+// Proofs, transcripts and keyrings come back out of both protocols as the same typed payload, and sign the same way
+func TestWireMessages(t *testing.T) {
+	for _, want := range wireMessageCommands(t) {
+		var buf bytes.Buffer
+		require.NoError(t, writeCommand(&buf, want))
+		got, err := readCommand(&buf, baseFrameSize)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+		require.Equal(t, want.signingData(), got.signingData())
+
+		encoded, err := json.Marshal(want)
+		require.NoError(t, err)
+		got = NetworkCommand{}
+		require.NoError(t, json.Unmarshal(encoded, &got))
+		require.Equal(t, want, got)
+		require.NoError(t, checkPayload(got))
+	}
+}
+
+// A command carrying each typed payload, with every field of it filled in
+func wireMessageCommands(t *testing.T) []NetworkCommand {
+	host, other := realPeerID(t), realPeerID(t)
+	shuffled := provenDeck{
+		Peer: host,
+		Deck: "5\n0\n7",
+		ShuffleProof: &sra.ShuffleProof{Rounds: []sra.ShuffleProofRound{
+			{Shadow: []string{"11", "12", "13"}, Permutation: []int{2, 0, 1}, Exponent: "99"},
+			{Shadow: []string{"21", "22", "23"}, Permutation: []int{0, 1, 2}, Exponent: "0"},
+		}},
+		PermutationCommitment: "c0ffee",
+		PermutationSignature:  "c2lnbmVk",
+	}
+	varied := provenDeck{
+		Peer:                other,
+		Deck:                "8\n9\n10",
+		VariationProof:      &sra.VariationProof{Commitments: []string{"1", "2", "3"}, Responses: []string{"4", "5", "6"}},
+		KeyTargets:          "11\n12\n13",
+		KeyTargetProof:      &sra.KeyTargetProof{Commitments: []string{"14", "15"}, Response: "0"},
+		CommitmentSignature: "c2lnbmVk",
+	}
+	keyring := SignedKeyring{Owner: other, Table: "c0ffee", Round: 3, Keyring: "3\n5\n7", Signature: "c2lnbmVk", Permutation: []int{1, 0, 2}, ShuffleSalt: "5a17"}
+	commands := []NetworkCommand{
+		{Command: "BroadcastNewDeck", Payload: &deckTranscript{Start: "1\n2\n3", Steps: []provenDeck{shuffled, varied}}},
+		{Command: "ProtocolFirstStep", Payload: &shuffled},
+		{Command: "ProtocolSecondStep", Payload: &varied},
+		{Command: "RevealKeyring", Payload: &keyring},
+		{Command: "RevealKeyring", Payload: "REJECTED: not the host"},
+		{Command: "Audit", Payload: &auditPayload{Round: 3, Keyrings: map[peer.ID]SignedKeyring{keyring.Owner: keyring}}},
+		{Command: "NicknameRequest", Payload: &nicknamePayload{Nickname: "007\nnot a number", SquaringSpeed: 1 << 40}},
+	}
+	for i := range commands {
+		commands[i].Envelope = Envelope{Table: "c0ffee", Round: 3, Seq: 1, Sender: host}
+	}
+	return commands
+}
+
+func TestWireMessageRejects(t *testing.T) {
+	t.Run("wrong payload type", func(t *testing.T) {
+		// A proof that comes as text instead of a ProvenDeck is refused, not read as an empty proof
+		require.Error(t, checkPayload(NetworkCommand{Command: "ProtocolFirstStep", Payload: `{"deck":"1"}`}))
+		require.Error(t, checkPayload(NetworkCommand{Command: "Fold", Payload: &deckTranscript{}}))
+		require.Error(t, checkPayload(NetworkCommand{Command: "Audit", Payload: &SignedKeyring{}}))
+		require.Error(t, checkPayload(NetworkCommand{Command: "RequestFlop", Payload: []any{"1"}}))
+
+		msg := mustMarshal(t, NetworkCommand{Command: "RequestHand"})
+		msg = protowire.AppendBytes(protowire.AppendTag(msg, fieldProof, protowire.BytesType), nil)
+		_, err := unmarshalCommand(msg)
+		require.Error(t, err)
+
+		var nCmd NetworkCommand
+		require.Error(t, json.Unmarshal([]byte(`{"command":"Fold","payload":{"start":"1"}}`), &nCmd))
+	})
+
+	t.Run("numbers that don't encode one way", func(t *testing.T) {
+		for _, payload := range []wireMessage{
+			&deckTranscript{Start: "007"},
+			&provenDeck{Deck: "1\n-2"},
+			&provenDeck{ShuffleProof: &sra.ShuffleProof{Rounds: []sra.ShuffleProofRound{{Exponent: ""}}}},
+			&SignedKeyring{Permutation: []int{-1}},
+			&auditPayload{Keyrings: map[peer.ID]SignedKeyring{"someone": {Owner: "someone else"}}},
+			&nicknamePayload{Nickname: "someone", SquaringSpeed: -1},
+		} {
+			nCmd := NetworkCommand{Command: "BroadcastNewDeck", Payload: payload}
+			if _, ok := payload.(*deckTranscript); !ok {
+				nCmd.Command = newMessageCommand(t, payload)
+			}
+			require.Error(t, checkPayload(nCmd), "%#v", payload)
+			_, err := marshalCommand(nCmd)
+			require.Error(t, err)
+		}
+	})
+
+	t.Run("two keyrings from one owner", func(t *testing.T) {
+		keyring, err := (&SignedKeyring{Owner: "someone"}).appendWire(nil)
+		require.NoError(t, err)
+		var audit []byte
+		audit = protowire.AppendBytes(protowire.AppendTag(audit, fieldAuditKeyrings, protowire.BytesType), keyring)
+		audit = protowire.AppendBytes(protowire.AppendTag(audit, fieldAuditKeyrings, protowire.BytesType), keyring)
+		require.ErrorContains(t, new(auditPayload).consumeWire(audit), "two keyrings")
+	})
+}
+
+// The command that carries a typed payload
+func newMessageCommand(t *testing.T, payload wireMessage) string {
+	t.Helper()
+	for command := range commandPayloads {
+		if allowsPayload(command, payload.payloadKind()) {
+			return command
+		}
+	}
+	t.Fatalf("no command carries a %T", payload)
+	return ""
+}
+
+// The frame limit fits the biggest command a table with the default rules sends, a shuffle transcript with everyones proof
+func TestMaxFrameSize(t *testing.T) {
+	rules := tablerules.Default()
+	cardBytes := rules.PrimeBits / 8
+	randomNumbers := func(n int) []string {
+		numbers := make([]string, n)
+		for i := range numbers {
+			b := make([]byte, cardBytes)
+			rand.Read(b)
+			numbers[i] = new(big.Int).SetBytes(b).String()
+		}
+		return numbers
+	}
+
+	transcript := &deckTranscript{Start: strings.Join(randomNumbers(52), "\n")}
+	for range rules.MaxPlayers {
+		proof := &sra.ShuffleProof{Rounds: make([]sra.ShuffleProofRound, rules.ShuffleProofRounds)}
+		for i := range proof.Rounds {
+			proof.Rounds[i] = sra.ShuffleProofRound{Shadow: randomNumbers(52), Permutation: mathrand.Perm(52), Exponent: randomNumbers(1)[0]}
+		}
+		transcript.Steps = append(transcript.Steps, provenDeck{
+			Peer:                  peer.ID("a peer ID is about this long, give or take"),
+			Deck:                  strings.Join(randomNumbers(52), "\n"),
+			ShuffleProof:          proof,
+			PermutationCommitment: strings.Repeat("c0", 32),
+			PermutationSignature:  strings.Repeat("c2lnbmVk", 12),
+		})
+	}
+
+	msg := mustMarshal(t, NetworkCommand{Command: "BroadcastNewDeck", Payload: transcript, Signature: "c2lnbmVk"})
+	require.Less(t, len(msg), maxFrameSize(rules))
+	require.Less(t, maxFrameSize(rules), 2*len(msg), "the limit should stay near the real size")
+
+	encoded, err := json.Marshal(NetworkCommand{Command: "BroadcastNewDeck", Payload: transcript})
+	require.NoError(t, err)
+	require.Less(t, len(encoded), maxFrameSize(rules)*jsonFrameFactor)
+
+	require.Equal(t, baseFrameSize, maxFrameSize(tablerules.Rules{}), "before there are rules there's no deck to send")
+}
+
+// Peer IDs have to be real ones to go through JSON
+func realPeerID(t *testing.T) peer.ID {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	return id
+}
+
+func mustMarshal(t *testing.T, nCmd NetworkCommand) []byte {
+	t.Helper()
+	msg, err := marshalCommand(nCmd)
+	require.NoError(t, err)
+	return msg
+}
+
+// Peers speak the binary protocol to each other, and fall back to JSON for peers that don't know it
+func TestWireNegotiation(t *testing.T) {
+	tt := newTestTable(t, 2)
+	host, other := tt.host(), tt.peers[1]
+	otherID := other.ThisHost.ID()
+
+	request := func() NetworkCommand {
+		nCmd := NetworkCommand{Command: "NicknameRequest"}
+		host.signCommand(&nCmd)
+		return nCmd
+	}
+
+	stream, err := host.sendTo(otherID, request())
+	require.NoError(t, err)
+	require.Equal(t, binaryProtocolID, stream.Protocol())
+	stream.Close()
+
+	other.ThisHost.RemoveStreamHandler(binaryProtocolID) // Now it's an older peer
+	// Until identify tells the host it's gone
+	require.Eventually(t, func() bool {
+		stream, err := host.sendTo(otherID, request())
+		if err != nil {
+			return false
+		}
+		defer stream.Close()
+		return stream.Protocol() == protocolID
+	}, 10*time.Second, 50*time.Millisecond)
+
+	response, err := host.request(otherID, request())
+	require.NoError(t, err)
+	require.Equal(t, &nicknamePayload{Nickname: other.gameState.GetNickname(otherID), SquaringSpeed: 1}, response.Payload)
+}
+
+// Every field number and wire type the codec uses is the one goker.proto gives it, and every field in goker.proto is used
+func TestWireSchema(t *testing.T) {
+	schema := loadWireSchema(t)
+	fields := []struct {
+		message, field string
+		num            protowire.Number
+		typ            protowire.Type
+	}{
+		{"Numbers", "values", fieldNumbersValues, protowire.BytesType},
+
+		{"Command", "version", fieldVersion, protowire.VarintType},
+		{"Command", "command", fieldCommand, protowire.BytesType},
+		{"Command", "signature", fieldSignature, protowire.BytesType},
+		{"Command", "tag", fieldTag, protowire.VarintType},
+		{"Command", "text", fieldText, protowire.BytesType},
+		{"Command", "numbers", fieldNumbers, protowire.BytesType},
+		{"Command", "amount", fieldAmount, protowire.Fixed64Type},
+		{"Command", "transcript", fieldTranscript, protowire.BytesType},
+		{"Command", "proof", fieldProof, protowire.BytesType},
+		{"Command", "keyring", fieldKeyring, protowire.BytesType},
+		{"Command", "keyrings", fieldKeyrings, protowire.BytesType},
+		{"Command", "nickname", fieldNickname, protowire.BytesType},
+		{"Command", "table", fieldTable, protowire.BytesType},
+		{"Command", "round", fieldRound, protowire.VarintType},
+		{"Command", "phase", fieldPhase, protowire.BytesType},
+		{"Command", "session", fieldSession, protowire.VarintType},
+		{"Command", "seq", fieldSeq, protowire.VarintType},
+		{"Command", "sender", fieldSender, protowire.BytesType},
+
+		{"Transcript", "start", fieldTranscriptStart, protowire.BytesType},
+		{"Transcript", "steps", fieldTranscriptSteps, protowire.BytesType},
+
+		{"ProvenDeck", "peer", fieldStepPeer, protowire.BytesType},
+		{"ProvenDeck", "deck", fieldStepDeck, protowire.BytesType},
+		{"ProvenDeck", "shuffle_proof", fieldStepShuffleProof, protowire.BytesType},
+		{"ProvenDeck", "variation_proof", fieldStepVariationProof, protowire.BytesType},
+		{"ProvenDeck", "permutation_commitment", fieldStepPermutationCommitment, protowire.BytesType},
+		{"ProvenDeck", "permutation_signature", fieldStepPermutationSignature, protowire.BytesType},
+		{"ProvenDeck", "commitment_signature", fieldStepCommitmentSignature, protowire.BytesType},
+		{"ProvenDeck", "key_targets", fieldStepKeyTargets, protowire.BytesType},
+		{"ProvenDeck", "key_target_proof", fieldStepKeyTargetProof, protowire.BytesType},
+
+		{"ShuffleProof", "rounds", fieldShuffleProofRounds, protowire.BytesType},
+
+		{"ShuffleProofRound", "shadow", fieldRoundShadow, protowire.BytesType},
+		{"ShuffleProofRound", "permutation", fieldRoundPermutation, protowire.BytesType}, // Packed
+		{"ShuffleProofRound", "exponent", fieldRoundExponent, protowire.BytesType},
+
+		{"VariationProof", "commitments", fieldVariationCommitments, protowire.BytesType},
+		{"VariationProof", "responses", fieldVariationResponses, protowire.BytesType},
+
+		{"KeyTargetProof", "commitments", fieldKeyTargetCommitments, protowire.BytesType},
+		{"KeyTargetProof", "response", fieldKeyTargetResponse, protowire.BytesType},
+
+		{"SignedKeyring", "owner", fieldKeyringOwner, protowire.BytesType},
+		{"SignedKeyring", "table", fieldKeyringTable, protowire.BytesType},
+		{"SignedKeyring", "round", fieldKeyringRound, protowire.VarintType},
+		{"SignedKeyring", "keyring", fieldKeyringKeys, protowire.BytesType},
+		{"SignedKeyring", "signature", fieldKeyringSignature, protowire.BytesType},
+		{"SignedKeyring", "permutation", fieldKeyringPermutation, protowire.BytesType}, // Packed
+		{"SignedKeyring", "shuffle_salt", fieldKeyringSalt, protowire.BytesType},
+
+		{"Audit", "round", fieldAuditRound, protowire.VarintType},
+		{"Audit", "keyrings", fieldAuditKeyrings, protowire.BytesType},
+
+		{"Nickname", "nickname", fieldNicknameName, protowire.BytesType},
+		{"Nickname", "squaring_speed", fieldNicknameSpeed, protowire.VarintType},
+	}
+
+	used := make(map[protoreflect.FullName]bool)
+	for _, f := range fields {
+		message := schema.Messages().ByName(protoreflect.Name(f.message))
+		require.NotNil(t, message, "no message %s in goker.proto", f.message)
+		field := message.Fields().ByName(protoreflect.Name(f.field))
+		require.NotNil(t, field, "no field %s.%s in goker.proto", f.message, f.field)
+		require.Equal(t, f.num, field.Number(), "field number of %s", field.FullName())
+		require.Equal(t, f.typ, schemaWireType(field), "wire type of %s", field.FullName())
+		used[field.FullName()] = true
+	}
+
+	for i := range schema.Messages().Len() {
+		message := schema.Messages().Get(i)
+		for j := range message.Fields().Len() {
+			field := message.Fields().Get(j)
+			require.True(t, used[field.FullName()], "%s isn't checked against the codec", field.FullName())
+		}
+	}
+}
+
+// Commands the codec writes are read by a generic protobuf decoder for goker.proto without any unknown fields,
+// and what that decoder writes back is read by the codec as the same command
+func TestWireMatchesSchema(t *testing.T) {
+	command := loadWireSchema(t).Messages().ByName("Command")
+	tag := uint64(0)
+	commands := append(wireMessageCommands(t),
+		NetworkCommand{Command: "ProtocolFS", Payload: "12345678901234567890\n0\n42"},
+		NetworkCommand{Command: "RequestHand", Payload: ""},
+		NetworkCommand{Command: "Raise", Payload: 12.5, Tag: &tag, Signature: "c2lnbmVk"},
+		NetworkCommand{Command: "NicknameRequest"},
+		NetworkCommand{Command: "Fold", Payload: "1\n2", Envelope: Envelope{Table: "c0ffee", Round: 3, Phase: "flop", Session: 1700000000, Seq: 41, Sender: peer.ID("someone")}},
+	)
+
+	for _, want := range commands {
+		decoded := dynamicpb.NewMessage(command)
+		require.NoError(t, proto.Unmarshal(mustMarshal(t, want), decoded), want.Command)
+		requireNoUnknownFields(t, decoded)
+
+		encoded, err := proto.Marshal(decoded)
+		require.NoError(t, err)
+		got, err := unmarshalCommand(encoded)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+}
+
+func requireNoUnknownFields(t *testing.T, message protoreflect.Message) {
+	t.Helper()
+	require.Empty(t, message.GetUnknown(), "unknown fields in %s", message.Descriptor().FullName())
+	message.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		switch {
+		case field.Message() == nil:
+		case field.IsList():
+			for i := range value.List().Len() {
+				requireNoUnknownFields(t, value.List().Get(i).Message())
+			}
+		default:
+			requireNoUnknownFields(t, value.Message())
+		}
+		return true
+	})
+}
+
+// How a field is sent, packed lists go as one length delimited field
+func schemaWireType(field protoreflect.FieldDescriptor) protowire.Type {
+	switch {
+	case field.IsPacked():
+		return protowire.BytesType
+	case field.Kind() == protoreflect.DoubleKind:
+		return protowire.Fixed64Type
+	case field.Kind() == protoreflect.Uint32Kind, field.Kind() == protoreflect.Uint64Kind:
+		return protowire.VarintType
+	}
+	return protowire.BytesType
+}
+
+var protoScalars = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"double": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"uint32": descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"uint64": descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bytes":  descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+}
+
+// Reads goker.proto into a descriptor, there's no protoc here so this understands just as much of the language as it uses:
+// top level messages of scalar and message fields, repeated, optional and oneof
+func loadWireSchema(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	src, err := os.ReadFile("goker.proto")
+	require.NoError(t, err)
+
+	var tokens []string
+	for _, line := range strings.Split(string(src), "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		for _, c := range []string{"{", "}", ";", "="} {
+			line = strings.ReplaceAll(line, c, " "+c+" ")
+		}
+		tokens = append(tokens, strings.Fields(line)...)
+	}
+	next := func() string {
+		require.NotEmpty(t, tokens, "goker.proto ends early")
+		token := tokens[0]
+		tokens = tokens[1:]
+		return token
+	}
+	expect := func(want string) {
+		require.Equal(t, want, next(), "goker.proto doesn't parse")
+	}
+
+	file := &descriptorpb.FileDescriptorProto{Name: proto.String("goker.proto")}
+	field := func(message *descriptorpb.DescriptorProto, token string, oneof *int32) {
+		f := &descriptorpb.FieldDescriptorProto{Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), OneofIndex: oneof}
+		switch token {
+		case "repeated":
+			f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			token = next()
+		case "optional":
+			f.Proto3Optional = proto.Bool(true)
+			token = next()
+		}
+		if scalar, ok := protoScalars[token]; ok {
+			f.Type = scalar.Enum()
+		} else {
+			f.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			f.TypeName = proto.String("." + file.GetPackage() + "." + token)
+		}
+		f.Name = proto.String(next())
+		expect("=")
+		num, err := strconv.Atoi(next())
+		require.NoError(t, err)
+		f.Number = proto.Int32(int32(num))
+		expect(";")
+		message.Field = append(message.Field, f)
+	}
+
+	for len(tokens) > 0 {
+		switch token := next(); token {
+		case "syntax":
+			expect("=")
+			file.Syntax = proto.String(strings.Trim(next(), `"`))
+			expect(";")
+		case "package":
+			file.Package = proto.String(next())
+			expect(";")
+		case "message":
+			message := &descriptorpb.DescriptorProto{Name: proto.String(next())}
+			expect("{")
+			for token := next(); token != "}"; token = next() {
+				if token != "oneof" {
+					field(message, token, nil)
+					continue
+				}
+				oneof := int32(len(message.OneofDecl))
+				message.OneofDecl = append(message.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(next())})
+				expect("{")
+				for token := next(); token != "}"; token = next() {
+					field(message, token, &oneof)
+				}
+			}
+			// Optional fields each get a oneof of their own, after the real ones
+			for _, f := range message.Field {
+				if f.GetProto3Optional() {
+					f.OneofIndex = proto.Int32(int32(len(message.OneofDecl)))
+					message.OneofDecl = append(message.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String("_" + f.GetName())})
+				}
+			}
+			file.MessageType = append(file.MessageType, message)
+		default:
+			t.Fatalf("goker.proto doesn't parse at %q", token)
+		}
+	}
+
+	schema, err := protodesc.NewFile(file, nil)
+	require.NoError(t, err)
+	return schema
+}
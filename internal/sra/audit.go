@@ -0,0 +1,77 @@
+package sra
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Someones keyring for a hand, as they revealed it in their KeyringPayload
+type RevealedKeyring struct {
+	PublicKey, PrivateKey *big.Int
+	Variations            []*big.Int // The r values, one per card
+}
+
+// Reads a KeyringPayload and checks the global keys it holds are a real key pair for this rounds modulus
+func (k *Keyring) ParseKeyringPayload(payload string) (*RevealedKeyring, error) {
+	if k.globalPHI == nil {
+		return nil, fmt.Errorf("no modulus to check the keyring against")
+	}
+
+	lines := strings.Split(payload, "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("keyring payload is missing its global keys")
+	}
+
+	values, err := stringsToInts(lines)
+	if err != nil {
+		return nil, fmt.Errorf("keyring payload: %w", err)
+	}
+	r := &RevealedKeyring{PublicKey: values[0], PrivateKey: values[1], Variations: values[2:]}
+
+	product := new(big.Int).Mul(r.PublicKey, r.PrivateKey)
+	if product.Mod(product, k.globalPHI).Cmp(big.NewInt(1)) != 0 {
+		return nil, fmt.Errorf("global keys in payload are not a key pair")
+	}
+	for i, v := range r.Variations {
+		if new(big.Int).GCD(nil, nil, v, k.globalPHI).Cmp(big.NewInt(1)) != 0 {
+			return nil, fmt.Errorf("variation number %d has no inverse", i)
+		}
+	}
+	return r, nil
+}
+
+// Checks out[j] is in[permutation[j]] encrypted with the revealed global key - what the first step of the protocol does
+func (k *Keyring) CheckRevealedPermutation(r *RevealedKeyring, in, out []*big.Int, permutation []int) error {
+	if len(in) != len(out) || len(permutation) != len(out) {
+		return fmt.Errorf("permutation of %d positions for a deck of %d cards shuffled into %d", len(permutation), len(in), len(out))
+	}
+
+	return forEach(len(out), func(j int) error {
+		from := permutation[j]
+		if from < 0 || from >= len(in) {
+			return fmt.Errorf("permutation sends card %d to position %d, which doesn't exist", j, from)
+		}
+		if k.cipher.Encrypt(in[from], r.PublicKey).Cmp(out[j]) != 0 {
+			return fmt.Errorf("card %d of the shuffled deck isn't card %d of the deck before it", j, from)
+		}
+		return nil
+	})
+}
+
+// Checks out[i] is in[i] with the revealed variation i in place of the global key - what the second step of the protocol does
+func (k *Keyring) CheckRevealedVariations(r *RevealedKeyring, in, out []*big.Int) error {
+	if len(in) != len(out) {
+		return fmt.Errorf("deck changed size from %d to %d cards", len(in), len(out))
+	}
+	if len(r.Variations) < len(in) {
+		return fmt.Errorf("keyring has %d variations for %d cards", len(r.Variations), len(in))
+	}
+
+	return forEach(len(in), func(i int) error {
+		if k.cipher.Encrypt(in[i], r.Variations[i]).Cmp(out[i]) != 0 {
+			return fmt.Errorf("card %d wasn't encrypted with variation %d", i, i)
+		}
+		return nil
+	})
+}
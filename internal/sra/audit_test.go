@@ -0,0 +1,84 @@
+package sra
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A second player at the same table as k, with their own keys in the same group
+func newTableMate(t *testing.T, k *Keyring) *Keyring {
+	t.Helper()
+	mate := &Keyring{cipher: k.cipher}
+	require.NoError(t, mate.GenerateKeys())
+	return mate
+}
+
+func TestParseKeyringPayload(t *testing.T) {
+	k := newProofKeyring(t)
+	auditor := newTableMate(t, k)
+
+	revealed, err := auditor.ParseKeyringPayload(k.KeyringPayload)
+	require.NoError(t, err)
+	require.Equal(t, 0, revealed.PublicKey.Cmp(k.globalPublicKey))
+	require.Len(t, revealed.Variations, 52)
+
+	t.Run("keys that don't pair up", func(t *testing.T) {
+		lines := strings.Split(k.KeyringPayload, "\n")
+		lines[1] = new(big.Int).Add(k.globalPrivateKey, big.NewInt(2)).String()
+		_, err := auditor.ParseKeyringPayload(strings.Join(lines, "\n"))
+		require.Error(t, err)
+	})
+
+	t.Run("garbage", func(t *testing.T) {
+		_, err := auditor.ParseKeyringPayload("not a keyring")
+		require.Error(t, err)
+	})
+}
+
+func TestCheckRevealedSteps(t *testing.T) {
+	k := newProofKeyring(t)
+	auditor := newTableMate(t, k)
+	revealed, err := auditor.ParseKeyringPayload(k.KeyringPayload)
+	require.NoError(t, err)
+
+	in := testDeck(k.cipher, 52)
+	shuffled, permutation := shuffleDeck(t, k, in)
+	require.NoError(t, auditor.CheckRevealedPermutation(revealed, in, shuffled, permutation))
+
+	varied := make([]*big.Int, len(shuffled))
+	for i := range shuffled {
+		varied[i] = new(big.Int).Set(shuffled[i])
+		k.DecryptWithGlobalKeys(varied[i])
+		require.NoError(t, k.EncryptWithVariation(varied[i], i))
+	}
+	require.NoError(t, auditor.CheckRevealedVariations(revealed, shuffled, varied))
+
+	t.Run("someone elses keys", func(t *testing.T) {
+		other, err := auditor.ParseKeyringPayload(auditor.KeyringPayload)
+		require.NoError(t, err)
+		require.Error(t, auditor.CheckRevealedPermutation(other, in, shuffled, permutation))
+		require.Error(t, auditor.CheckRevealedVariations(other, shuffled, varied))
+	})
+
+	t.Run("duplicated card", func(t *testing.T) {
+		cheat := append([]*big.Int(nil), shuffled...)
+		cheat[0] = cheat[1]
+		require.Error(t, auditor.CheckRevealedPermutation(revealed, in, cheat, permutation))
+	})
+
+	t.Run("not the permutation they committed to", func(t *testing.T) {
+		swapped := append([]int(nil), permutation...)
+		swapped[0], swapped[1] = swapped[1], swapped[0]
+		require.Error(t, auditor.CheckRevealedPermutation(revealed, in, shuffled, swapped))
+		require.Error(t, auditor.CheckRevealedPermutation(revealed, in, shuffled, permutation[1:]))
+	})
+
+	t.Run("swapped cards", func(t *testing.T) {
+		cheat := append([]*big.Int(nil), varied...)
+		cheat[0], cheat[1] = cheat[1], cheat[0]
+		require.Error(t, auditor.CheckRevealedVariations(revealed, shuffled, cheat))
+	})
+}
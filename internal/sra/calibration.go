@@ -0,0 +1,139 @@
+package sra
+
+import (
+	"encoding/json"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// How long measuring the squaring speed takes, long enough to smooth out noise without holding anything up
+const calibrationTime = time.Second
+
+// Saved speeds older than this are measured again, in case the machine or what else it runs has changed
+const calibrationMaxAge = 30 * 24 * time.Hour
+
+// A measured squaring speed as it's saved on disk
+type calibration struct {
+	Speed     int64     `json:"speed"`     // Squarings a second
+	PrimeBits int       `json:"primeBits"` // TimeLockPrimeBits it was measured with, a different size needs measuring again
+	Arch      string    `json:"arch"`      // So a profile copied to another kind of machine isn't trusted
+	Measured  time.Time `json:"measured"`
+}
+
+// Where the squaring speed is saved between launches, "" when there's no cache directory to put it in
+func DefaultCalibrationFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "goker", "calibration.json")
+}
+
+// Works out how fast this machine squares in the background, reusing the speed saved in cacheFile if it's recent
+// SquaringSpeed waits for it to finish. An empty cacheFile measures every time and saves nothing.
+func (k *Keyring) CalibrateSquaringSpeed(cacheFile string) {
+	k.calibrating.Add(1)
+	go func() {
+		defer k.calibrating.Done()
+		k.squaringSpeed.Store(calibrate(cacheFile))
+	}()
+}
+
+// Squarings a second this machine manages, 0 if it was never calibrated
+func (k *Keyring) SquaringSpeed() int64 {
+	k.calibrating.Wait()
+	return k.squaringSpeed.Load()
+}
+
+// Sets the squaring speed without measuring it, for tests that want puzzles small enough to solve straight away
+func (k *Keyring) SetSquaringSpeed(speed int64) {
+	k.squaringSpeed.Store(speed)
+}
+
+func calibrate(cacheFile string) int64 {
+	if saved, ok := loadCalibration(cacheFile); ok {
+		log.Printf("Using the squaring speed measured %s: %d a second", saved.Measured.Format(time.DateOnly), saved.Speed)
+		return saved.Speed
+	}
+
+	speed, err := measureSquaringSpeed(calibrationTime)
+	if err != nil {
+		log.Printf("CalibrateSquaringSpeed: %v", err)
+		return 0
+	}
+	log.Printf("Calibration complete: Estimated squaring speed is %d operations per second", speed)
+
+	if cacheFile != "" {
+		if err := saveCalibration(cacheFile, calibration{Speed: speed, PrimeBits: TimeLockPrimeBits, Arch: runtime.GOARCH, Measured: time.Now()}); err != nil {
+			log.Printf("CalibrateSquaringSpeed: couldn't save the speed: %v", err)
+		}
+	}
+	return speed
+}
+
+// Squares mod a time lock sized modulus for about the given time, the same way a solver does
+func measureSquaringSpeed(duration time.Duration) (int64, error) {
+	p, q, err := generateLargePrime(TimeLockPrimeBits) // Not from the pool, the lobby wants those for puzzles
+	if err != nil {
+		return 0, err
+	}
+	n := new(big.Int).Mul(p, q)
+	value := shareBase(n, 0)
+
+	squarings := 0
+	start := time.Now()
+	for time.Since(start) < duration {
+		for range 1 << 10 {
+			value.Mul(value, value)
+			value.Mod(value, n)
+		}
+		squarings += 1 << 10
+	}
+	return int64(float64(squarings) / time.Since(start).Seconds()), nil
+}
+
+// A saved speed, if there is one still good for this machine and modulus size
+func loadCalibration(cacheFile string) (calibration, bool) {
+	if cacheFile == "" {
+		return calibration{}, false
+	}
+	data, err := os.ReadFile(cacheFile)
+	if err != nil {
+		return calibration{}, false
+	}
+	var saved calibration
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("CalibrateSquaringSpeed: ignoring unreadable %s: %v", cacheFile, err)
+		return calibration{}, false
+	}
+	fresh := time.Since(saved.Measured) < calibrationMaxAge && !saved.Measured.After(time.Now())
+	return saved, fresh && saved.Speed > 0 && saved.PrimeBits == TimeLockPrimeBits && saved.Arch == runtime.GOARCH
+}
+
+// Written to a temporary file first, so two copies of the game starting at once can't leave half a file
+func saveCalibration(cacheFile string, c calibration) error {
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cacheFile), ".calibration-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once it's been renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cacheFile)
+}
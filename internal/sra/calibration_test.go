@@ -0,0 +1,57 @@
+package sra
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalibrateSquaringSpeed(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "goker", "calibration.json")
+
+	t.Run("never calibrated", func(t *testing.T) {
+		require.Zero(t, new(Keyring).SquaringSpeed())
+	})
+
+	k := new(Keyring)
+	k.CalibrateSquaringSpeed(cacheFile)
+	speed := k.SquaringSpeed()
+	require.Positive(t, speed)
+
+	t.Run("saved for next time", func(t *testing.T) {
+		saved, ok := loadCalibration(cacheFile)
+		require.True(t, ok)
+		require.Equal(t, speed, saved.Speed)
+
+		start := time.Now()
+		again := new(Keyring)
+		again.CalibrateSquaringSpeed(cacheFile)
+		require.Equal(t, speed, again.SquaringSpeed())
+		require.Less(t, time.Since(start), calibrationTime, "shouldn't have measured again")
+	})
+
+	t.Run("measured again", func(t *testing.T) {
+		for name, c := range map[string]calibration{
+			"stale":             {Speed: 5, PrimeBits: TimeLockPrimeBits, Arch: runtime.GOARCH, Measured: time.Now().Add(-2 * calibrationMaxAge)},
+			"from the future":   {Speed: 5, PrimeBits: TimeLockPrimeBits, Arch: runtime.GOARCH, Measured: time.Now().Add(time.Hour)},
+			"other modulus":     {Speed: 5, PrimeBits: TimeLockPrimeBits * 2, Arch: runtime.GOARCH, Measured: time.Now()},
+			"other machine":     {Speed: 5, PrimeBits: TimeLockPrimeBits, Arch: "z80", Measured: time.Now()},
+			"nonsensical speed": {Speed: -5, PrimeBits: TimeLockPrimeBits, Arch: runtime.GOARCH, Measured: time.Now()},
+		} {
+			data, err := json.Marshal(c)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(cacheFile, data, 0o600))
+			_, ok := loadCalibration(cacheFile)
+			require.False(t, ok, name)
+		}
+
+		require.NoError(t, os.WriteFile(cacheFile, []byte("{not json"), 0o600))
+		_, ok := loadCalibration(cacheFile)
+		require.False(t, ok)
+	})
+}
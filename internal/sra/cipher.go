@@ -0,0 +1,41 @@
+package sra
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// The group operations the card protocol is built on, each backend is a group of prime order where encrypting a card
+// is raising it to a key. EncodeCard puts every card in that group: for Pohlig-Hellman it's the quadratic residues
+// mod a safe prime p, the subgroup of order q = (p-1)/2, and for secp256k1 it's the curve's points. Keys are inverted
+// mod Order, a multiple of the group's order (p-1 for Pohlig-Hellman), so decrypting is encrypting with the inverse,
+// and any two keys commute - that's all the protocol and its proofs need.
+//
+// Cards cross the network as big integers whatever the backend, so the deck payloads, proofs and keyring payloads
+// don't change shape, they just get shorter with a smaller group.
+type CardCipher interface {
+	Name() string                        // Tells the group apart in proof challenges and NewKeys, e.g. "pohlig-hellman-2048"
+	Order() *big.Int                     // Keys are inverted mod this
+	Encrypt(card, key *big.Int) *big.Int // card^key, a new value
+	Combine(a, b *big.Int) *big.Int      // The group operation, the proofs compare products of cards
+	Contains(card *big.Int) bool         // Whether a value is an element someone could have encrypted
+	EncodeCard(hash *big.Int) *big.Int   // Turns a cards hash into a group element
+}
+
+// Card ciphers the table rules can pick
+const (
+	PohligHellman = "pohlig-hellman"
+	Secp256k1     = "secp256k1"
+)
+
+// The cipher a table plays with, primeBits only matters for Pohlig-Hellman
+func NewCardCipher(name string, primeBits int) (CardCipher, error) {
+	switch name {
+	case PohligHellman:
+		return NewPrimeCipher(primeBits)
+	case Secp256k1:
+		return NewCurveCipher(), nil
+	default:
+		return nil, fmt.Errorf("unknown card cipher %q", name)
+	}
+}
@@ -0,0 +1,76 @@
+package sra
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Everything the card protocol leans on, for every backend
+func TestCardCiphers(t *testing.T) {
+	for _, c := range testCiphers(t) {
+		t.Run(c.Name(), func(t *testing.T) {
+			card := c.EncodeCard(big.NewInt(1234))
+			require.True(t, c.Contains(card))
+			require.Equal(t, card, c.EncodeCard(big.NewInt(1234)), "every peer encodes a card the same")
+			require.NotEqual(t, card, c.EncodeCard(big.NewInt(1235)))
+
+			a, err := generateRandomCoPrime(c.Order())
+			require.NoError(t, err)
+			b, err := generateRandomCoPrime(c.Order())
+			require.NoError(t, err)
+
+			t.Run("keys commute", func(t *testing.T) {
+				require.Equal(t, c.Encrypt(c.Encrypt(card, a), b), c.Encrypt(c.Encrypt(card, b), a))
+			})
+
+			t.Run("inverse key decrypts", func(t *testing.T) {
+				encrypted := c.Encrypt(card, a)
+				require.True(t, c.Contains(encrypted))
+				require.NotEqual(t, card, encrypted)
+				require.Equal(t, card, c.Encrypt(encrypted, new(big.Int).ModInverse(a, c.Order())))
+			})
+
+			t.Run("combine adds keys", func(t *testing.T) {
+				sum := new(big.Int).Add(a, b)
+				require.Equal(t, c.Encrypt(card, sum), c.Combine(c.Encrypt(card, a), c.Encrypt(card, b)))
+			})
+
+			t.Run("not cards", func(t *testing.T) {
+				for _, v := range []*big.Int{big.NewInt(0), big.NewInt(-4), c.Order()} {
+					require.False(t, c.Contains(v), v)
+				}
+			})
+		})
+	}
+}
+
+func TestCurveCipher(t *testing.T) {
+	c := NewCurveCipher()
+	card := c.EncodeCard(big.NewInt(1234))
+	require.LessOrEqual(t, len(card.Bytes()), pointBytes, "a card is one compressed point")
+
+	require.False(t, c.Contains(big.NewInt(42)), "no prefix byte")
+	offCurve := new(big.Int).Add(card, big.NewInt(1))
+	for c.Contains(offCurve) {
+		offCurve.Add(offCurve, big.NewInt(1))
+	}
+	require.Zero(t, c.Encrypt(offCurve, big.NewInt(3)).Sign(), "only points can be encrypted")
+
+	// Multiplying by the order lands on the point at infinity, which isn't a card
+	require.Zero(t, c.Encrypt(card, c.Order()).Sign())
+}
+
+func TestNewCardCipher(t *testing.T) {
+	c, err := NewCardCipher(PohligHellman, 2048)
+	require.NoError(t, err)
+	require.Equal(t, "pohlig-hellman-2048", c.Name())
+
+	c, err = NewCardCipher(Secp256k1, 0)
+	require.NoError(t, err)
+	require.Equal(t, Secp256k1, c.Name())
+
+	_, err = NewCardCipher("rot13", 2048)
+	require.Error(t, err)
+}
@@ -0,0 +1,106 @@
+package sra
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// Elliptic curve masking - cards are points on secp256k1, encrypting multiplies a point by the key
+//
+// The curve has prime order and no cofactor, so every point on it is a card someone could have encrypted and every key
+// below the order has an inverse. A card goes over the network as its 33 byte compressed point instead of a few hundred
+// bytes for a residue mod p, and a scalar multiplication is much cheaper than a 2048 bit exponentiation.
+type curveCipher struct{}
+
+// Compressed points are a prefix byte followed by X
+const pointBytes = 33
+
+// Card cipher over secp256k1
+func NewCurveCipher() CardCipher {
+	return curveCipher{}
+}
+
+func (curveCipher) Name() string {
+	return Secp256k1
+}
+
+// Number of points on the curve, a prime
+func (curveCipher) Order() *big.Int {
+	return new(big.Int).Set(secp256k1.Params().N)
+}
+
+// Anything that isn't a point on the curve encrypts to 0, which is never a card
+func (curveCipher) Encrypt(card, key *big.Int) *big.Int {
+	point, ok := decodePoint(card)
+	if !ok {
+		return new(big.Int)
+	}
+
+	var scalar secp256k1.ModNScalar
+	scalar.SetByteSlice(new(big.Int).Mod(key, secp256k1.Params().N).Bytes())
+	var result secp256k1.JacobianPoint
+	secp256k1.ScalarMultNonConst(&scalar, point, &result)
+	return encodePoint(&result)
+}
+
+func (curveCipher) Combine(a, b *big.Int) *big.Int {
+	p1, ok1 := decodePoint(a)
+	p2, ok2 := decodePoint(b)
+	if !ok1 || !ok2 {
+		return new(big.Int)
+	}
+
+	var sum secp256k1.JacobianPoint
+	secp256k1.AddNonConst(p1, p2, &sum)
+	return encodePoint(&sum)
+}
+
+func (curveCipher) Contains(card *big.Int) bool {
+	_, ok := decodePoint(card)
+	return ok
+}
+
+// Try-and-increment, hashes the card with a counter until the result is the X of a point
+// About half of all X values are on the curve, so this takes two tries on average
+func (curveCipher) EncodeCard(hash *big.Int) *big.Int {
+	for counter := uint32(0); ; counter++ {
+		sum := sha256.Sum256(binary.BigEndian.AppendUint32(hash.Bytes(), counter))
+
+		var x, y secp256k1.FieldVal
+		if overflow := x.SetByteSlice(sum[:]); overflow {
+			continue
+		}
+		if !secp256k1.DecompressY(&x, false, &y) {
+			continue
+		}
+		y.Normalize()
+		return new(big.Int).SetBytes(secp256k1.NewPublicKey(&x, &y).SerializeCompressed())
+	}
+}
+
+// The point a card stands for, if it is one
+func decodePoint(card *big.Int) (*secp256k1.JacobianPoint, bool) {
+	if card.Sign() <= 0 || card.BitLen() > pointBytes*8 {
+		return nil, false
+	}
+	key, err := secp256k1.ParsePubKey(card.FillBytes(make([]byte, pointBytes)))
+	if err != nil {
+		return nil, false
+	}
+
+	var point secp256k1.JacobianPoint
+	key.AsJacobian(&point)
+	return &point, true
+}
+
+// The point at infinity becomes 0, which decodePoint turns away
+func encodePoint(point *secp256k1.JacobianPoint) *big.Int {
+	point.ToAffine()
+	if point.X.IsZero() && point.Y.IsZero() {
+		return new(big.Int)
+	}
+	return new(big.Int).SetBytes(secp256k1.NewPublicKey(&point.X, &point.Y).SerializeCompressed())
+}
@@ -0,0 +1,131 @@
+package sra
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Pohlig-Hellman - cards are encrypted as c = m^e mod p, with a safe prime p = 2q + 1 every player shares
+//
+// Unlike SRA with a modulus made by the host there is no factorization for anyone to know, so each players exponents
+// are only ever known to them. The primes are the well known Diffie-Hellman groups, made from the digits of pi so nobody
+// could have picked one with a trapdoor, and they're fixed so a hand never waits on a safe prime being generated.
+//
+// Every card is a quadratic residue (see EncodeCard), encrypting with an odd exponent keeps it one, so a ciphertext
+// says nothing about which card it is through its Legendre symbol.
+
+// The safe primes by size in bits
+var groupPrimes = map[int]*big.Int{
+	768: mustParseHex( // RFC 2409 group 1, only used by tests
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74" +
+			"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437" +
+			"4FE1356D6D51C245E485B576625E7EC6F44C42E9A63A3620FFFFFFFFFFFFFFFF"),
+	1024: mustParseHex( // RFC 2409 group 2
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74" +
+			"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437" +
+			"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+			"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381FFFFFFFFFFFFFFFF"),
+	2048: mustParseHex( // RFC 3526 group 14
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74" +
+			"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437" +
+			"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+			"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05" +
+			"98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB" +
+			"9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B" +
+			"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718" +
+			"3995497CEA956AE515D2261898FA051015728E5A8AACAA68FFFFFFFFFFFFFFFF"),
+	3072: mustParseHex( // RFC 3526 group 15
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74" +
+			"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437" +
+			"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+			"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05" +
+			"98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB" +
+			"9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B" +
+			"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718" +
+			"3995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33" +
+			"A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7" +
+			"ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864" +
+			"D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E2" +
+			"08E24FA074E5AB3143DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF"),
+}
+
+// Size of the group used while test primes are on, whatever size the table asked for
+const testGroupBits = 768
+
+func mustParseHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("sra: invalid group prime")
+	}
+	return n
+}
+
+// The shared safe prime of the given size
+func groupPrime(bits int) (*big.Int, error) {
+	if testPrimes.Load() {
+		bits = testGroupBits
+	}
+	p, ok := groupPrimes[bits]
+	if !ok {
+		return nil, fmt.Errorf("no %d bit group", bits)
+	}
+	return p, nil
+}
+
+// Pohlig-Hellman in the shared safe prime group
+type primeCipher struct {
+	p, order *big.Int
+}
+
+// Card cipher over the shared safe prime of the given size (the table rules PrimeBits)
+// Every player picks the same one, nobody has to generate or send it.
+func NewPrimeCipher(bits int) (CardCipher, error) {
+	p, err := groupPrime(bits)
+	if err != nil {
+		return nil, err
+	}
+	return &primeCipher{p: p, order: new(big.Int).Sub(p, big.NewInt(1))}, nil
+}
+
+func (c *primeCipher) Name() string {
+	return fmt.Sprintf("%s-%d", PohligHellman, c.p.BitLen())
+}
+
+// ϕ(p) = p−1
+func (c *primeCipher) Order() *big.Int {
+	return new(big.Int).Set(c.order)
+}
+
+func (c *primeCipher) Encrypt(card, key *big.Int) *big.Int {
+	return expModPrime(card, key, c.p)
+}
+
+func (c *primeCipher) Combine(a, b *big.Int) *big.Int {
+	product := new(big.Int).Mul(a, b)
+	return product.Mod(product, c.p)
+}
+
+// Every card is a quadratic residue mod p, anything else isn't a card anyone could have encrypted
+// 1 is one too, but every key leaves it alone so it's never a card
+func (c *primeCipher) Contains(card *big.Int) bool {
+	return card.Cmp(big.NewInt(1)) > 0 && card.Cmp(c.p) < 0 && big.Jacobi(card, c.p) == 1
+}
+
+// Squared so every card is a quadratic residue, otherwise encrypting would leave whether it is one showing
+func (c *primeCipher) EncodeCard(hash *big.Int) *big.Int {
+	card := new(big.Int).Mul(hash, hash)
+	return card.Mod(card, c.p)
+}
+
+// base^exponent mod a prime, reducing the exponent by Fermat's little theorem
+func expModPrime(base, exponent, prime *big.Int) *big.Int {
+	b := new(big.Int).Mod(base, prime)
+	if b.Sign() == 0 {
+		if exponent.Sign() == 0 {
+			return big.NewInt(1)
+		}
+		return b
+	}
+	e := new(big.Int).Mod(exponent, new(big.Int).Sub(prime, big.NewInt(1)))
+	return b.Exp(b, e, prime)
+}
@@ -1,101 +1,54 @@
 package sra
 
 import (
-	"crypto"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
-	"crypto/x509"
 	"encoding/base64"
-	"encoding/pem"
 	"fmt"
 	"log"
 	"math/big"
 	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
 type Keyring struct {
 	// Signature information //
-	signingPrivKey *rsa.PrivateKey
-	signingPubKey  *rsa.PublicKey
-	Otherskeys     map[peer.ID]*rsa.PublicKey
+	signingKey crypto.PrivKey // The libp2p host key, peers already know it's ours from the secure channel
 
-	// SRA infomation //
-	sharedP, sharedQ *big.Int
+	// Card encryption infomation //
+	cipher CardCipher // Group every player encrypts with this hand, see cipher.go
 
-	// Global keys (one set for encrypting every card)
-	globalPrivateKey, globalPublicKey, globalN, globalPHI *big.Int
+	// Global keys (one set for encrypting every card), both are secret - PHI is the order of the group
+	globalPrivateKey, globalPublicKey, globalPHI *big.Int
 
 	// Variations of the global keys
 	keyVariations []*KeyVariation
 
 	// Time locking
-	TLP            *TimeLock
+	TLP            *TimeLock // This hands puzzle, see HandPuzzle
+	tlpBinding     string    // What TLP was bound to when HandPuzzle built it
+	tlpMutex       sync.Mutex
 	KeyringPayload string
-	squaringSpeed  int64
+	squaringSpeed  atomic.Int64   // Ours, see calibration.go
+	calibrating    sync.WaitGroup // Done once squaringSpeed is known
 
 	BrokenPuzzlePayloads []string // Save all broken puzzles from others for when eval happens
 }
 
-// Generate RSA keys for signatures
-func (k *Keyring) GenerateSigningKeys() error {
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return fmt.Errorf("failed to generate signing keys: %v", err)
-	}
-
-	k.signingPrivKey = privateKey
-	k.signingPubKey = &privateKey.PublicKey
-	return nil
-}
-
-// Export the public key as a PEM-encoded string -- will be sent through the network
-func (k *Keyring) ExportPublicKey() (string, error) {
-	pubASN1, err := x509.MarshalPKIXPublicKey(k.signingPubKey)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal public key: %v", err)
-	}
-
-	pubPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: pubASN1,
-	})
-	return string(pubPEM), nil
-}
-
-// DecodePEMPublicKey converts a PEM-encoded string into an *rsa.PublicKey
-func DecodePEMPublicKey(pemStr string) (*rsa.PublicKey, error) {
-	block, _ := pem.Decode([]byte(pemStr))
-	if block == nil {
-		return nil, fmt.Errorf("invalid PEM data")
-	}
-
-	pubKey, err := x509.ParsePKIXPublicKey(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse public key: %v", err)
-	}
-
-	rsaPubKey, ok := pubKey.(*rsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("key is not an RSA public key")
-	}
-
-	return rsaPubKey, nil
+// Sign with the libp2p host key, so a signature checks out against the peer ID it came from
+func (k *Keyring) SetSigningKey(hostKey crypto.PrivKey) {
+	k.signingKey = hostKey
 }
 
-// Sign a message using RSA private key
+// Sign a message with the host key
 func (k *Keyring) SignMessage(message string) (string, error) {
-	if k.signingPrivKey == nil {
+	if k.signingKey == nil {
 		return "", fmt.Errorf("signing key not initialized")
 	}
 
-	// Hash the message
-	hash := sha256.Sum256([]byte(message))
-
-	// Sign the hash
-	signature, err := rsa.SignPKCS1v15(rand.Reader, k.signingPrivKey, crypto.SHA256, hash[:])
+	signature, err := k.signingKey.Sign([]byte(message))
 	if err != nil {
 		return "", fmt.Errorf("failed to sign message: %v", err)
 	}
@@ -104,11 +57,12 @@ func (k *Keyring) SignMessage(message string) (string, error) {
 	return base64.StdEncoding.EncodeToString(signature), nil
 }
 
-// Verify a message's signature using the sender's public key
+// Verify a message's signature against the public key inside the sender's peer ID
 func (k *Keyring) VerifySignature(sendingPeer peer.ID, message string, signature string) bool {
-	peerPubKey, exists := k.Otherskeys[sendingPeer]
-	if !exists {
-		log.Fatalf("VerifySignature: missing public key for peer %s\n", sendingPeer)
+	peerPubKey, err := sendingPeer.ExtractPublicKey()
+	if err != nil { // Only small keys like Ed25519 are kept in the ID, every peer made by goker has one
+		log.Printf("VerifySignature: no public key in peer ID %s: %v\n", sendingPeer, err)
+		return false
 	}
 
 	// Decode base64 signature
@@ -117,57 +71,27 @@ func (k *Keyring) VerifySignature(sendingPeer peer.ID, message string, signature
 		return false
 	}
 
-	// Hash the message
-	hash := sha256.Sum256([]byte(message))
-
-	// Verify the signature
-	err = rsa.VerifyPKCS1v15(peerPubKey, crypto.SHA256, hash[:], sigBytes)
-	return err == nil
-}
-
-// Function to store a peer's public key in Keyring
-func (k *Keyring) SetPeerPublicKey(peerID peer.ID, publicKey string) {
-	if k.Otherskeys == nil {
-		k.Otherskeys = make(map[peer.ID]*rsa.PublicKey)
-	}
-
-	// Decode the PEM public key
-	decodedKey, err := DecodePEMPublicKey(publicKey)
-	if err != nil {
-		log.Printf("Failed to decode public key for peer %s: %v", peerID, err)
-		return
-	}
-
-	k.Otherskeys[peerID] = decodedKey
-	log.Printf("Stored public key for peer: %s", peerID)
+	valid, err := peerPubKey.Verify([]byte(message), sigBytes)
+	return err == nil && valid
 }
 
-// Set p & q to a randomly generated 2048 bit prime number.
-// Used for when a user hosts the game.
-func (k *Keyring) GeneratePQ() {
-	p, q, err := generateLargePrime(2048)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	k.sharedP = p
-	k.sharedQ = q
+// Use the card cipher the table rules picked for the next keys.
+// Every player sets the same one, nobody has to generate or send it.
+func (k *Keyring) SetCipher(c CardCipher) {
+	k.cipher = c
 }
 
-// Key generation - returns: private key, public key, modulus
-// The given p and q are two large primes the players have agreed on - this will create keys that are commutative
+// Key generation - returns: private key, public key
+// The cipher is the group the players have agreed on - this will create keys that are commutative
 // This function also sets the needed 52 Variation keys
 func (k *Keyring) GenerateKeys() error {
-	if k.sharedP == nil || k.sharedQ == nil {
-		return fmt.Errorf("p and q not set")
+	if k.cipher == nil {
+		return fmt.Errorf("group not set")
 	}
-	// n = p * q
-	n := new(big.Int).Mul(k.sharedP, k.sharedQ)
 
-	// Eulers Totient -- ϕ(n) = (p−1)(q−1)
+	// Keys are inverted mod the order of the group
 	// Used for caluclating private keys
-	phi := new(big.Int).Mul(new(big.Int).Sub(k.sharedP, big.NewInt(1)), new(big.Int).Sub(k.sharedQ, big.NewInt(1)))
+	phi := k.cipher.Order()
 
 	var publicKey *big.Int
 
@@ -181,44 +105,54 @@ func (k *Keyring) GenerateKeys() error {
 		log.Fatalf("Modular inverse does not exist")
 	}
 
-	k.globalPrivateKey, k.globalPublicKey, k.globalN, k.globalPHI = privateKey, publicKey, n, phi
+	k.globalPrivateKey, k.globalPublicKey, k.globalPHI = privateKey, publicKey, phi
 	k.GenerateKeyVariations(52) // We need to create variations each round, so we will do this on Generate Keys
 	k.GenerateKeyringPayload()  // Get time locked puzzle setup
+
+	k.tlpMutex.Lock()
+	k.TLP = nil // Last hands puzzle locks the old keys
+	k.tlpMutex.Unlock()
 	return nil
 }
 
 // Encrypts data with the global keys inside the keyring.
 func (k *Keyring) EncryptWithGlobalKeys(data *big.Int) {
-	data.Exp(data, k.globalPublicKey, k.globalN)
+	data.Set(k.cipher.Encrypt(data, k.globalPublicKey))
 }
 
 // Decyrpts data with the global keys inside the keyring.
 func (k *Keyring) DecryptWithGlobalKeys(data *big.Int) {
-	data.Exp(data, k.globalPrivateKey, k.globalN)
+	data.Set(k.cipher.Encrypt(data, k.globalPrivateKey))
 }
 
-// Returns P&Q as a string - meant for being sent over a stream for a PQRequest
-func (k *Keyring) GetPQString() string {
-	return fmt.Sprintf("%s\n%s\n", k.sharedP, k.sharedQ)
+// Encrypts every value in place with the global keys, spread across CPU cores
+func (k *Keyring) EncryptAllWithGlobalKeys(values []*big.Int) {
+	forEach(len(values), func(i int) error {
+		k.EncryptWithGlobalKeys(values[i])
+		return nil
+	})
 }
 
-// Set p and q
-func (k *Keyring) SetPQ(p string, q string) {
-	k.sharedP = new(big.Int)
-	k.sharedQ = new(big.Int)
-	k.sharedP.SetString(p, 10)
-	k.sharedQ.SetString(q, 10)
+// Decrypts every value in place with the global keys, spread across CPU cores
+func (k *Keyring) DecryptAllWithGlobalKeys(values []*big.Int) {
+	forEach(len(values), func(i int) error {
+		k.DecryptWithGlobalKeys(values[i])
+		return nil
+	})
 }
 
 // Given a payload for someones entire keyring, give me the actual private keys (for decryption)
-func (k *Keyring) GetKeysFromPayload(payload string) []*big.Int {
+func (k *Keyring) GetKeysFromPayload(payload string) ([]*big.Int, error) {
 	keyList := strings.Split(payload, "\n")
-	var keys []*big.Int
+	if len(keyList) < 2 {
+		return nil, fmt.Errorf("keyring payload is missing its global keys")
+	}
 	privKey, ok := new(big.Int).SetString(keyList[1], 10)
 	if !ok {
-		log.Fatalf("Couldn't convert private key from payload")
+		return nil, fmt.Errorf("couldn't convert private key from payload")
 	}
 
+	var keys []*big.Int
 	for i, key := range keyList {
 		if i == 0 || i == 1 { // Don't care about the global keys right now
 			continue
@@ -226,12 +160,15 @@ func (k *Keyring) GetKeysFromPayload(payload string) []*big.Int {
 
 		r, ok := new(big.Int).SetString(key, 10)
 		if !ok {
-			log.Fatalf("Cannot set variation number")
+			return nil, fmt.Errorf("cannot set variation number %d", i-2)
 		}
 
 		rInv := new(big.Int).ModInverse(r, k.globalPHI)
+		if rInv == nil {
+			return nil, fmt.Errorf("variation number %d has no inverse", i-2)
+		}
 		keys = append(keys, new(big.Int).Mod(new(big.Int).Mul(privKey, rInv), k.globalPHI))
 	}
 
-	return keys
+	return keys, nil
 }
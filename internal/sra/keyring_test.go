@@ -1,42 +1,42 @@
 package sra
 
 import (
+	"crypto/rand"
+	"fmt"
 	"math/big"
-	"strings"
 	"testing"
 
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/stretchr/testify/require"
 )
 
 func TestKeyringSigningOperations(t *testing.T) {
-	t.Run("generate and export keys", func(t *testing.T) {
-		k := &Keyring{}
-		err := k.GenerateSigningKeys()
+	newSigner := func(t *testing.T) (*Keyring, peer.ID) {
+		t.Helper()
+		hostKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
 		require.NoError(t, err)
-		require.NotNil(t, k.signingPrivKey)
-		require.NotNil(t, k.signingPubKey)
-
-		pem, err := k.ExportPublicKey()
+		id, err := peer.IDFromPrivateKey(hostKey)
 		require.NoError(t, err)
-		require.Contains(t, pem, "PUBLIC KEY")
+		k := &Keyring{}
+		k.SetSigningKey(hostKey)
+		return k, id
+	}
+
+	t.Run("unset key", func(t *testing.T) {
+		_, err := new(Keyring).SignMessage("test message")
+		require.Error(t, err)
 	})
 
 	t.Run("sign and verify message", func(t *testing.T) {
-		k := &Keyring{}
-		k.GenerateSigningKeys()
-		peerID := peer.ID("test-peer")
-
-		// Create and register peer key
-		peerK := &Keyring{}
-		peerK.GenerateSigningKeys()
-		pem, _ := peerK.ExportPublicKey()
-		k.SetPeerPublicKey(peerID, pem)
+		k, _ := newSigner(t)
+		peerK, peerID := newSigner(t)
 
 		msg := "test message"
 		sig, err := peerK.SignMessage(msg)
 		require.NoError(t, err)
 
+		// Nothing to exchange first, the key is in the peer ID
 		valid := k.VerifySignature(peerID, msg, sig)
 		require.True(t, valid)
 
@@ -49,51 +49,49 @@ func TestKeyringSigningOperations(t *testing.T) {
 			valid := k.VerifySignature(peerID, "wrong message", sig)
 			require.False(t, valid)
 		})
+
+		t.Run("someone else's key", func(t *testing.T) {
+			_, otherID := newSigner(t)
+			require.False(t, k.VerifySignature(otherID, msg, sig))
+		})
+
+		t.Run("peer ID without a key", func(t *testing.T) {
+			require.False(t, k.VerifySignature(peer.ID("test-peer"), msg, sig))
+		})
 	})
 }
 
-func TestPrimeOperations(t *testing.T) {
-	t.Run("generate and set primes", func(t *testing.T) {
-		k := &Keyring{}
-		k.GeneratePQ()
-		require.NotNil(t, k.sharedP)
-		require.NotNil(t, k.sharedQ)
-		require.True(t, k.sharedP.ProbablyPrime(20))
-		require.True(t, k.sharedQ.ProbablyPrime(20))
-		require.NotEqual(t, k.sharedP, k.sharedQ)
-
-		// Test serialization
-		pqStr := k.GetPQString()
-		parts := strings.Split(strings.TrimSpace(pqStr), "\n")
-		require.Len(t, parts, 2)
-
-		// Test deserialization
-		newK := &Keyring{}
-		newK.SetPQ(parts[0], parts[1])
-		require.Equal(t, k.sharedP.String(), newK.sharedP.String())
-		require.Equal(t, k.sharedQ.String(), newK.sharedQ.String())
-	})
+func TestGroups(t *testing.T) {
+	for bits, p := range groupPrimes {
+		t.Run(fmt.Sprintf("%d bits", bits), func(t *testing.T) {
+			require.Equal(t, bits, p.BitLen())
+			require.True(t, p.ProbablyPrime(20))
+			q := new(big.Int).Rsh(p, 1) // (p-1)/2
+			require.True(t, q.ProbablyPrime(20), "p should be a safe prime")
+			require.EqualValues(t, 3, new(big.Int).Mod(p, big.NewInt(4)).Int64(), "p = 3 mod 4, so -1 isn't a residue")
+
+			c, err := NewPrimeCipher(bits)
+			require.NoError(t, err)
+			require.Equal(t, p, c.(*primeCipher).p)
+		})
+	}
+
+	_, err := NewPrimeCipher(4000)
+	require.Error(t, err)
 }
 
 func TestSRAOperations(t *testing.T) {
-	k := &Keyring{}
-	k.GeneratePQ()
-	require.NoError(t, k.GenerateKeys())
+	c, err := NewPrimeCipher(1024)
+	require.NoError(t, err)
+	k := newCipherKeyring(t, c)
 
 	t.Run("key generation", func(t *testing.T) {
 		require.NotNil(t, k.globalPublicKey)
 		require.NotNil(t, k.globalPrivateKey)
-		require.NotNil(t, k.globalN)
 		require.NotNil(t, k.globalPHI)
 
-		// Verify n = p*q
-		expectedN := new(big.Int).Mul(k.sharedP, k.sharedQ)
-		require.Equal(t, 0, k.globalN.Cmp(expectedN))
-
-		// Verify phi = (p-1)(q-1)
-		pMinus1 := new(big.Int).Sub(k.sharedP, big.NewInt(1))
-		qMinus1 := new(big.Int).Sub(k.sharedQ, big.NewInt(1))
-		expectedPhi := new(big.Int).Mul(pMinus1, qMinus1)
+		// Verify phi = p-1
+		expectedPhi := new(big.Int).Sub(c.(*primeCipher).p, big.NewInt(1))
 		require.Equal(t, 0, k.globalPHI.Cmp(expectedPhi))
 
 		// Verify e*d ≡ 1 mod phi
@@ -115,35 +113,9 @@ func TestSRAOperations(t *testing.T) {
 		require.Equal(t, 0, original.Cmp(decrypted))
 	})
 
-	t.Run("generate keys without primes", func(t *testing.T) {
+	t.Run("generate keys without a group", func(t *testing.T) {
 		k := &Keyring{}
 		err := k.GenerateKeys()
 		require.Error(t, err)
 	})
 }
-
-func TestPeerKeyManagement(t *testing.T) {
-	t.Run("set valid peer key", func(t *testing.T) {
-		k := &Keyring{}
-		peerK := &Keyring{}
-		peerK.GenerateSigningKeys()
-		pem, _ := peerK.ExportPublicKey()
-
-		peerID := peer.ID("test-peer")
-		k.SetPeerPublicKey(peerID, pem)
-
-		storedKey, exists := k.Otherskeys[peerID]
-		require.True(t, exists)
-		require.Equal(t, peerK.signingPubKey.E, storedKey.E)
-		require.Equal(t, peerK.signingPubKey.N, storedKey.N)
-	})
-
-	t.Run("set invalid peer key", func(t *testing.T) {
-		k := &Keyring{}
-		peerID := peer.ID("test-peer")
-		k.SetPeerPublicKey(peerID, "invalid-pem")
-
-		_, exists := k.Otherskeys[peerID]
-		require.False(t, exists)
-	})
-}
@@ -0,0 +1,33 @@
+package sra
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Runs fn for every index in [0, n) on a pool of one worker per CPU, returning the error with the lowest index
+// Every card is its own modular exponentiation, so a deck splits up evenly without any coordination between cards
+func forEach(n int, fn func(i int) error) error {
+	errs := make([]error, n)
+	var next atomic.Int64
+	var wg sync.WaitGroup
+
+	for range min(runtime.GOMAXPROCS(0), n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < n; i = int(next.Add(1) - 1) {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package sra
+
+import (
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEach(t *testing.T) {
+	var calls atomic.Int64
+	seen := make([]bool, 100)
+	require.NoError(t, forEach(len(seen), func(i int) error {
+		calls.Add(1)
+		seen[i] = true
+		return nil
+	}))
+	require.EqualValues(t, len(seen), calls.Load(), "every index runs exactly once")
+	require.NotContains(t, seen, false)
+
+	// Whichever worker finishes first, the error for the earliest card is the one reported
+	err := forEach(52, func(i int) error {
+		if i%10 == 3 {
+			return fmt.Errorf("card %d", i)
+		}
+		return nil
+	})
+	require.EqualError(t, err, "card 3")
+
+	require.NoError(t, forEach(0, func(i int) error { return fmt.Errorf("shouldn't run") }))
+}
+
+// Encrypting in parallel gives the same deck as encrypting one card at a time
+func TestEncryptAllMatchesSequential(t *testing.T) {
+	k := newProofKeyring(t)
+	deck := testDeck(k.cipher, 52)
+
+	sequential := make([]*big.Int, len(deck))
+	for i, card := range deck {
+		sequential[i] = new(big.Int).Set(card)
+		k.EncryptWithGlobalKeys(sequential[i])
+		require.NoError(t, k.EncryptWithVariation(sequential[i], i))
+	}
+
+	parallel := make([]*big.Int, len(deck))
+	for i, card := range deck {
+		parallel[i] = new(big.Int).Set(card)
+	}
+	k.EncryptAllWithGlobalKeys(parallel)
+	require.NoError(t, k.EncryptAllWithVariations(parallel))
+	require.Equal(t, sequential, parallel)
+
+	k.DecryptAllWithGlobalKeys(parallel)
+	k.EncryptAllWithGlobalKeys(parallel)
+	require.Equal(t, sequential, parallel)
+
+	require.Error(t, k.EncryptAllWithVariations(testDeck(k.cipher, 53)), "only 52 variations to go around")
+}
+
+// Keyring in the same size group as a real game
+func newGameKeyring(b *testing.B) *Keyring {
+	b.Helper()
+	c, err := NewPrimeCipher(2048)
+	require.NoError(b, err)
+	return newCipherKeyring(b, c)
+}
+
+// One pass over the deck for each step a peer takes in the protocol, with each card cipher
+// Compare worker counts with e.g. go test -run ^$ -bench EncryptDeck -cpu 1,2,4,8 ./internal/sra
+func BenchmarkEncryptDeck(b *testing.B) {
+	for _, k := range []*Keyring{newGameKeyring(b), newCipherKeyring(b, NewCurveCipher())} {
+		deck := testDeck(k.cipher, 52)
+
+		b.Run(k.cipher.Name()+"/sequential", func(b *testing.B) {
+			for range b.N {
+				for i, card := range deck {
+					k.EncryptWithGlobalKeys(card)
+					k.DecryptWithGlobalKeys(card)
+					k.EncryptWithVariation(card, i)
+				}
+			}
+		})
+
+		b.Run(k.cipher.Name()+"/pool", func(b *testing.B) {
+			for range b.N {
+				k.EncryptAllWithGlobalKeys(deck)
+				k.DecryptAllWithGlobalKeys(deck)
+				k.EncryptAllWithVariations(deck)
+			}
+		})
+	}
+}
+
+// Proving and checking a peers shuffle, the bulk of the work in the first step of the protocol
+func BenchmarkShuffleProof(b *testing.B) {
+	k := newGameKeyring(b)
+	in := testDeck(k.cipher, 52)
+	out := make([]*big.Int, len(in))
+	permutation, err := randomPermutation(len(in))
+	require.NoError(b, err)
+	for j, from := range permutation {
+		out[j] = new(big.Int).Set(in[from])
+	}
+	k.EncryptAllWithGlobalKeys(out)
+
+	const rounds = 8
+	b.ResetTimer()
+	for range b.N {
+		proof, err := k.ProveShuffle(in, out, permutation, rounds)
+		require.NoError(b, err)
+		require.NoError(b, k.VerifyShuffle(in, out, proof, rounds))
+	}
+}
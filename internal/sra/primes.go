@@ -0,0 +1,86 @@
+package sra
+
+import (
+	"log"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Bits in each prime of a time lock puzzles moduli, the squaring speed is measured with the same size so lock times hold
+// Every share needs a fresh pair, which is slow to make at this size - PregeneratePrimes keeps a puzzles worth ready
+const TimeLockPrimeBits = 2048
+
+// Prime pairs made ahead of time for each size, so a hand doesn't have to wait on them - enough for a puzzle with every share
+const primesAhead = TimeLockShares
+
+// Size of every prime while test primes are on, small enough that tests don't spend their time in rand.Prime
+const testPrimeBits = 256
+
+var (
+	primePoolsMutex sync.Mutex
+	primePools      = make(map[int]chan [2]*big.Int)
+
+	testPrimes atomic.Bool
+)
+
+// Starts making prime pairs of the given size in the background, calling it again for the same size does nothing
+// Meant for idle time like the lobby, one worker per CPU tops the pool back up whenever a pair is taken. A puzzle takes
+// the whole pool, and one worker alone would take most of a hand to make it again.
+func PregeneratePrimes(bits int) {
+	if testPrimes.Load() { // Test primes are made when they're needed, nothing would take from the pool
+		return
+	}
+
+	primePoolsMutex.Lock()
+	defer primePoolsMutex.Unlock()
+	if _, ok := primePools[bits]; ok {
+		return
+	}
+
+	pool := make(chan [2]*big.Int, primesAhead)
+	primePools[bits] = pool
+	for range runtime.GOMAXPROCS(0) {
+		go func() {
+			for {
+				p, q, err := generateLargePrime(bits)
+				if err != nil {
+					log.Printf("PregeneratePrimes: %v", err)
+					continue
+				}
+				pool <- [2]*big.Int{p, q}
+			}
+		}()
+	}
+}
+
+// Swaps every prime pair for a small one so tests run quickly - never turn this on in a real game
+func UseTestPrimes(on bool) {
+	testPrimes.Store(on)
+}
+
+// Size of every prime in a time lock puzzles moduli, with test primes on or off
+func timeLockPrimeBits() int {
+	if testPrimes.Load() {
+		return testPrimeBits
+	}
+	return TimeLockPrimeBits
+}
+
+// Two distinct primes of the given size, from the pool if that size is being made ahead of time
+func primePair(bits int) (*big.Int, *big.Int, error) {
+	if testPrimes.Load() {
+		return generateLargePrime(testPrimeBits)
+	}
+
+	primePoolsMutex.Lock()
+	pool, ok := primePools[bits]
+	primePoolsMutex.Unlock()
+	if !ok {
+		return generateLargePrime(bits)
+	}
+
+	pair := <-pool // Even if it's empty the workers are already partway through the next pairs
+	return pair[0], pair[1], nil
+}
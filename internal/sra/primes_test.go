@@ -0,0 +1,51 @@
+package sra
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPregeneratePrimes(t *testing.T) {
+	const bits = 384 // A size nothing else asks for, so the pool is ours
+	PregeneratePrimes(bits)
+	PregeneratePrimes(bits) // Already running, shouldn't start more workers
+
+	primePoolsMutex.Lock()
+	pool := primePools[bits]
+	primePoolsMutex.Unlock()
+	require.Eventually(t, func() bool { return len(pool) == primesAhead }, 30*time.Second, 10*time.Millisecond, "pool never filled up")
+
+	p, q, err := primePair(bits)
+	require.NoError(t, err)
+	require.Equal(t, bits, p.BitLen())
+	require.Equal(t, bits, q.BitLen())
+	require.NotEqual(t, p, q)
+	require.True(t, p.ProbablyPrime(20))
+	require.True(t, q.ProbablyPrime(20))
+
+	// The workers top the pool back up after a pair is taken
+	require.Eventually(t, func() bool { return len(pool) == primesAhead }, 30*time.Second, 10*time.Millisecond, "pool wasn't topped up")
+}
+
+func TestUseTestPrimes(t *testing.T) {
+	UseTestPrimes(true)
+	t.Cleanup(func() { UseTestPrimes(false) })
+
+	p, _, err := primePair(TimeLockPrimeBits)
+	require.NoError(t, err)
+	require.Equal(t, testPrimeBits, p.BitLen(), "test primes are small whatever size is asked for")
+
+	c, err := NewPrimeCipher(3072)
+	require.NoError(t, err)
+	require.Equal(t, testGroupBits, c.(*primeCipher).p.BitLen(), "and so is the group")
+	k := newCipherKeyring(t, c)
+
+	card := testDeck(c, 1)[0]
+	encrypted := new(big.Int).Set(card)
+	k.EncryptWithGlobalKeys(encrypted)
+	k.DecryptWithGlobalKeys(encrypted)
+	require.Equal(t, card, encrypted, "keys still work with test primes")
+}
@@ -0,0 +1,533 @@
+package sra
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+)
+
+// Zero knowledge proofs that a peer transformed the deck honestly during the protocol
+//
+// First step (shuffle): out[j] = in[perm[j]]^e mod n for a secret permutation and global key e.
+// This is proven with cut-and-choose, each round builds a shadow deck that is a permutation of the output raised to a
+// random exponent, then (depending on a Fiat-Shamir challenge bit) opens it against either the input or the output deck.
+// Someone who could answer both openings knows a permutation and exponent linking the input to the output, so a cheater
+// who duplicates or swaps cards has to guess every challenge bit. The bits come from a hash, so they can regenerate shadow
+// decks offline until the hash happens to suit them, about 2^rounds attempts - the round count is a grinding cost and has to
+// be large enough that this is out of reach.
+//
+// Second step (variations): out[i] = in[i]^r_i mod n for every card with the card's secret variation value r_i.
+// There is no shuffle, so each card gets a Schnorr style proof of knowledge of r_i, a cheater who swaps a card for another
+// would need a discrete log between the two cards.
+//
+// Key targets: with the second step every peer publishes target[i] = in[i]^d for their global private key d, the value a
+// variation key for card i has to take out[i] back to. The d is tied to the first step, the product of a peers shuffled
+// deck is the product of the deck they were given raised to e, so A = B^d for A and B the products going in and out.
+// One Chaum-Pedersen proof covers every card, the targets are folded into one with weights from a hash of them, and
+// log_B(A) = log_in*(target*) for the folded card and target. A target that's off for even one card would need the
+// weights to cancel it out, and they're only known once the targets are.
+
+// Bits of the challenge used in variation proofs, and the extra random bits to hide the secret behind
+const (
+	challengeBits = 128
+	hidingBits    = 128
+)
+
+// Rounds in every shuffle proof while test shuffle proofs are on, whatever the table rules ask for
+const testShuffleProofRounds = 2
+
+var testShuffleProofs atomic.Bool
+
+// Cuts every shuffle proof down to a couple of rounds so tests run quickly - never turn this on in a real game
+// Proofs are still made and checked the same way, they're just easy to forge
+func UseTestShuffleProofs(on bool) {
+	testShuffleProofs.Store(on)
+}
+
+// Rounds a shuffle proof is made or checked with, with test shuffle proofs on or off
+func shuffleProofRounds(rounds int) int {
+	if testShuffleProofs.Load() {
+		return min(rounds, testShuffleProofRounds)
+	}
+	return rounds
+}
+
+// Proof that a deck is a shuffle of another deck encrypted with a single key
+type ShuffleProof struct {
+	Rounds []ShuffleProofRound `json:"rounds"`
+}
+
+// One cut-and-choose round, the shadow deck is opened against the input deck if its challenge bit is 0, otherwise the output deck
+type ShuffleProofRound struct {
+	Shadow      []string `json:"shadow"`
+	Permutation []int    `json:"permutation"` // Shadow[j] = deck[Permutation[j]]^Exponent
+	Exponent    string   `json:"exponent"`
+}
+
+// Proof that every card in a deck was encrypted with its own variation key, and the deck order didn't change
+type VariationProof struct {
+	Commitments []string `json:"commitments"` // in[i]^k_i for random k_i
+	Responses   []string `json:"responses"`   // k_i + c*r_i
+}
+
+// Proof that every key target is its card raised to the global private key that undoes the peers shuffle
+type KeyTargetProof struct {
+	Commitments []string `json:"commitments"` // B^k and in*^k for a random k
+	Response    string   `json:"response"`    // k + c*d
+}
+
+// Proves out is in encrypted with my global key and shuffled by permutation (out[j] came from in[permutation[j]])
+func (k *Keyring) ProveShuffle(in, out []*big.Int, permutation []int, rounds int) (*ShuffleProof, error) {
+	if k.globalPublicKey == nil || k.globalPHI == nil {
+		return nil, fmt.Errorf("global keys not generated")
+	}
+	if len(in) != len(out) || len(permutation) != len(out) {
+		return nil, fmt.Errorf("deck sizes don't match (in: %d, out: %d, permutation: %d)", len(in), len(out), len(permutation))
+	}
+
+	rounds = shuffleProofRounds(rounds)
+	proof := &ShuffleProof{Rounds: make([]ShuffleProofRound, rounds)}
+	type opening struct {
+		fromOut, fromIn         []int
+		outExponent, inExponent *big.Int
+	}
+	openings := make([]opening, rounds)
+	shadows := make([][]*big.Int, rounds)
+
+	for t := range rounds {
+		// Shadow deck built from the output, shadow[j] = out[rho[j]]^u
+		rho, err := randomPermutation(len(out))
+		if err != nil {
+			return nil, err
+		}
+		u, err := generateRandomCoPrime(k.globalPHI) // Full size so revealed exponents say nothing about the global key
+		if err != nil {
+			return nil, err
+		}
+		u.Mod(u, k.globalPHI) // Still coprime, the verifier refuses anything that isn't
+
+		shadows[t] = make([]*big.Int, len(out))
+		sigma := make([]int, len(out))
+		forEach(len(out), func(j int) error {
+			shadows[t][j] = k.cipher.Encrypt(out[rho[j]], u)
+			sigma[j] = permutation[rho[j]] // out[rho[j]] came from in[permutation[rho[j]]]
+			return nil
+		})
+
+		// The same shadow deck from the input, shadow[j] = in[sigma[j]]^(e*u)
+		s := new(big.Int).Mul(k.globalPublicKey, u)
+		s.Mod(s, k.globalPHI)
+		openings[t] = opening{fromOut: rho, fromIn: sigma, outExponent: u, inExponent: s}
+	}
+
+	bits := shuffleChallenge(k.cipher.Name(), in, out, shadows, rounds)
+	for t := range rounds {
+		proof.Rounds[t].Shadow = intsToStrings(shadows[t])
+		if bits[t] {
+			proof.Rounds[t].Permutation = openings[t].fromOut
+			proof.Rounds[t].Exponent = openings[t].outExponent.String()
+		} else {
+			proof.Rounds[t].Permutation = openings[t].fromIn
+			proof.Rounds[t].Exponent = openings[t].inExponent.String()
+		}
+	}
+	return proof, nil
+}
+
+// Checks a shuffle proof made by someone else, it needs at least the given number of rounds to be accepted
+func (k *Keyring) VerifyShuffle(in, out []*big.Int, proof *ShuffleProof, rounds int) error {
+	if k.cipher == nil {
+		return fmt.Errorf("group not set")
+	}
+	rounds = shuffleProofRounds(rounds)
+	if proof == nil || len(proof.Rounds) < rounds {
+		return fmt.Errorf("shuffle proof needs at least %d rounds", rounds)
+	}
+	if len(in) != len(out) {
+		return fmt.Errorf("deck sizes don't match (in: %d, out: %d)", len(in), len(out))
+	}
+	if err := k.checkInGroup(out); err != nil {
+		return fmt.Errorf("output deck: %w", err)
+	}
+
+	shadows := make([][]*big.Int, len(proof.Rounds))
+	for t, round := range proof.Rounds {
+		shadow, err := stringsToInts(round.Shadow)
+		if err != nil {
+			return fmt.Errorf("round %d: %w", t, err)
+		}
+		if len(shadow) != len(out) {
+			return fmt.Errorf("round %d: shadow deck has %d cards, expected %d", t, len(shadow), len(out))
+		}
+		if err := k.checkInGroup(shadow); err != nil {
+			return fmt.Errorf("round %d: %w", t, err)
+		}
+		shadows[t] = shadow
+	}
+
+	bits := shuffleChallenge(k.cipher.Name(), in, out, shadows, len(proof.Rounds))
+	return forEach(len(proof.Rounds), func(t int) error {
+		round := proof.Rounds[t]
+		deck := in
+		if bits[t] {
+			deck = out
+		}
+		if !isPermutation(round.Permutation, len(deck)) {
+			return fmt.Errorf("round %d: opening is not a permutation", t)
+		}
+		exponent, ok := new(big.Int).SetString(round.Exponent, 10)
+		if !ok {
+			return fmt.Errorf("round %d: invalid exponent", t)
+		}
+		if err := k.checkExponent(exponent); err != nil {
+			return fmt.Errorf("round %d: %w", t, err)
+		}
+
+		permuted := make([]*big.Int, len(deck))
+		for j, from := range round.Permutation {
+			permuted[j] = deck[from]
+		}
+		if !k.batchCheckPower(shadows[t], permuted, exponent) {
+			return fmt.Errorf("round %d: shadow deck doesn't match its opening", t)
+		}
+		return nil
+	})
+}
+
+// Proves out[i] = in[i]^r_i where r_i is my variation value for card i
+func (k *Keyring) ProveVariations(in, out []*big.Int) (*VariationProof, error) {
+	if len(in) != len(out) || len(in) > len(k.keyVariations) {
+		return nil, fmt.Errorf("deck sizes don't match (in: %d, out: %d, variations: %d)", len(in), len(out), len(k.keyVariations))
+	}
+
+	nonces := make([]*big.Int, len(in))
+	commitments := make([]*big.Int, len(in))
+	err := forEach(len(in), func(i int) error {
+		r := k.keyVariations[i].variationValue
+		// The response is computed over the integers, so the nonce has to be big enough to hide c*r_i
+		nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), uint(r.BitLen()+challengeBits+hidingBits)))
+		if err != nil {
+			return err
+		}
+		nonces[i] = nonce
+		commitments[i] = k.cipher.Encrypt(in[i], nonce)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c := variationChallenge(k.cipher.Name(), in, out, commitments)
+	responses := make([]*big.Int, len(in))
+	for i := range in {
+		responses[i] = new(big.Int).Mul(c, k.keyVariations[i].variationValue)
+		responses[i].Add(responses[i], nonces[i])
+	}
+
+	return &VariationProof{Commitments: intsToStrings(commitments), Responses: intsToStrings(responses)}, nil
+}
+
+// Checks a variation proof made by someone else
+func (k *Keyring) VerifyVariations(in, out []*big.Int, proof *VariationProof) error {
+	if k.cipher == nil {
+		return fmt.Errorf("group not set")
+	}
+	if proof == nil || len(in) != len(out) || len(proof.Commitments) != len(in) || len(proof.Responses) != len(in) {
+		return fmt.Errorf("variation proof doesn't cover the whole deck")
+	}
+	if err := k.checkInGroup(out); err != nil {
+		return fmt.Errorf("output deck: %w", err)
+	}
+	commitments, err := stringsToInts(proof.Commitments)
+	if err != nil {
+		return err
+	}
+	responses, err := stringsToInts(proof.Responses)
+	if err != nil {
+		return err
+	}
+	if err := k.checkInGroup(commitments); err != nil {
+		return err
+	}
+
+	c := variationChallenge(k.cipher.Name(), in, out, commitments)
+	return forEach(len(in), func(i int) error {
+		if responses[i].Sign() < 0 {
+			return fmt.Errorf("card %d: negative response", i)
+		}
+		// Honest responses are random mod the order, so they can't be held to being invertible, but one that's 0 would
+		// make the left side the identity whatever the card
+		if new(big.Int).Mod(responses[i], k.cipher.Order()).Sign() == 0 {
+			return fmt.Errorf("card %d: response is 0 in the group", i)
+		}
+		// in^z = commitment * out^c
+		lhs := k.cipher.Encrypt(in[i], responses[i])
+		rhs := k.cipher.Encrypt(out[i], c)
+		rhs = k.cipher.Combine(rhs, commitments[i])
+		if lhs.Cmp(rhs) != 0 {
+			return fmt.Errorf("card %d was not encrypted with its variation key", i)
+		}
+		return nil
+	})
+}
+
+// Proves targets[i] = in[i]^d for my global private key d, where shuffleIn and shuffleOut are my first step
+func (k *Keyring) ProveKeyTargets(shuffleIn, shuffleOut, in, targets []*big.Int) (*KeyTargetProof, error) {
+	if k.globalPrivateKey == nil {
+		return nil, fmt.Errorf("global keys not generated")
+	}
+	a, b, foldedIn, foldedTargets, err := k.foldKeyTargets(shuffleIn, shuffleOut, in, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	// The response is computed over the integers, so the nonce has to be big enough to hide c*d
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), uint(k.cipher.Order().BitLen()+challengeBits+hidingBits)))
+	if err != nil {
+		return nil, err
+	}
+	commitments := []*big.Int{k.cipher.Encrypt(b, nonce), k.cipher.Encrypt(foldedIn, nonce)}
+
+	c := keyTargetChallenge(k.cipher.Name(), a, b, foldedIn, foldedTargets, commitments)
+	response := new(big.Int).Mul(c, k.globalPrivateKey)
+	response.Add(response, nonce)
+	return &KeyTargetProof{Commitments: intsToStrings(commitments), Response: response.String()}, nil
+}
+
+// Checks a key target proof made by someone else, against the decks going into and out of both their steps
+func (k *Keyring) VerifyKeyTargets(shuffleIn, shuffleOut, in, targets []*big.Int, proof *KeyTargetProof) error {
+	if k.cipher == nil {
+		return fmt.Errorf("group not set")
+	}
+	if proof == nil || len(proof.Commitments) != 2 {
+		return fmt.Errorf("no key target proof")
+	}
+	if err := k.checkInGroup(targets); err != nil {
+		return fmt.Errorf("key targets: %w", err)
+	}
+	commitments, err := stringsToInts(proof.Commitments)
+	if err != nil {
+		return err
+	}
+	if err := k.checkInGroup(commitments); err != nil {
+		return err
+	}
+	response, ok := new(big.Int).SetString(proof.Response, 10)
+	if !ok || response.Sign() <= 0 || new(big.Int).Mod(response, k.cipher.Order()).Sign() == 0 {
+		return fmt.Errorf("key target proof has no usable response") // 0 in the group would make both sides the identity
+	}
+	a, b, foldedIn, foldedTargets, err := k.foldKeyTargets(shuffleIn, shuffleOut, in, targets)
+	if err != nil {
+		return err
+	}
+
+	// B^z = commitment * A^c and in*^z = commitment * target*^c
+	c := keyTargetChallenge(k.cipher.Name(), a, b, foldedIn, foldedTargets, commitments)
+	if k.cipher.Encrypt(b, response).Cmp(k.cipher.Combine(commitments[0], k.cipher.Encrypt(a, c))) != 0 {
+		return fmt.Errorf("key targets weren't made with the key that undoes their shuffle")
+	}
+	if k.cipher.Encrypt(foldedIn, response).Cmp(k.cipher.Combine(commitments[1], k.cipher.Encrypt(foldedTargets, c))) != 0 {
+		return fmt.Errorf("key targets weren't all made with the same key")
+	}
+	return nil
+}
+
+// The products of the shuffle decks, and the cards and targets folded into one each with weights from a hash of them all
+func (k *Keyring) foldKeyTargets(shuffleIn, shuffleOut, in, targets []*big.Int) (a, b, foldedIn, foldedTargets *big.Int, err error) {
+	if len(shuffleIn) == 0 || len(shuffleIn) != len(shuffleOut) {
+		return nil, nil, nil, nil, fmt.Errorf("shuffle deck sizes don't match (in: %d, out: %d)", len(shuffleIn), len(shuffleOut))
+	}
+	if len(in) == 0 || len(in) != len(targets) {
+		return nil, nil, nil, nil, fmt.Errorf("key targets don't cover the deck (cards: %d, targets: %d)", len(in), len(targets))
+	}
+	a, b = k.product(shuffleIn), k.product(shuffleOut)
+
+	weights := keyTargetWeights(k.cipher.Name(), a, b, in, targets)
+	weightedIn, weightedTargets := make([]*big.Int, len(in)), make([]*big.Int, len(in))
+	forEach(len(in), func(i int) error {
+		weightedIn[i] = k.cipher.Encrypt(in[i], weights[i])
+		weightedTargets[i] = k.cipher.Encrypt(targets[i], weights[i])
+		return nil
+	})
+	return a, b, k.product(weightedIn), k.product(weightedTargets), nil
+}
+
+// Every value combined with the group operation
+func (k *Keyring) product(values []*big.Int) *big.Int {
+	product := values[0]
+	for _, v := range values[1:] {
+		product = k.cipher.Combine(product, v)
+	}
+	return product
+}
+
+// Checks lhs[j] = rhs[j]^exponent for every j with one big exponentiation, by comparing random products of both sides
+func (k *Keyring) batchCheckPower(lhs, rhs []*big.Int, exponent *big.Int) bool {
+	if len(lhs) == 0 {
+		return true
+	}
+	var left, right *big.Int
+	for j := range lhs {
+		weight, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+		if err != nil {
+			return false
+		}
+		weight.Add(weight, big.NewInt(1)) // A zero weight would be the identity, which not every cipher can write down
+		l, r := k.cipher.Encrypt(lhs[j], weight), k.cipher.Encrypt(rhs[j], weight)
+		if j == 0 {
+			left, right = l, r
+		} else {
+			left, right = k.cipher.Combine(left, l), k.cipher.Combine(right, r)
+		}
+	}
+	return left.Cmp(k.cipher.Encrypt(right, exponent)) == 0
+}
+
+// Exponents revealed in a proof have to be invertible in the group
+// One that's 0 or shares a factor with the order sends different cards to the same value (everything to 1 for p-1), so
+// a shadow deck could be opened against any deck at all
+func (k *Keyring) checkExponent(exponent *big.Int) error {
+	if exponent.Sign() <= 0 || new(big.Int).GCD(nil, nil, exponent, k.cipher.Order()).Cmp(big.NewInt(1)) != 0 {
+		return fmt.Errorf("exponent isn't invertible in the group")
+	}
+	return nil
+}
+
+// Every value has to be in the group, otherwise it's not a card anyone could have encrypted
+func (k *Keyring) checkInGroup(values []*big.Int) error {
+	for i, v := range values {
+		if !k.cipher.Contains(v) {
+			return fmt.Errorf("value %d is not in the group", i)
+		}
+	}
+	return nil
+}
+
+// One bit per round, derived from everything the prover committed to
+func shuffleChallenge(group string, in, out []*big.Int, shadows [][]*big.Int, rounds int) []bool {
+	h := sha256.New()
+	h.Write([]byte("goker shuffle proof"))
+	writeString(h, group)
+	writeInts(h, in)
+	writeInts(h, out)
+	for _, shadow := range shadows {
+		writeInts(h, shadow)
+	}
+	seed := h.Sum(nil)
+
+	bits := make([]bool, rounds)
+	var block []byte
+	for t := range rounds {
+		if t%256 == 0 { // Each hash gives 256 bits
+			counter := sha256.Sum256(binary.BigEndian.AppendUint32(append([]byte(nil), seed...), uint32(t/256)))
+			block = counter[:]
+		}
+		bits[t] = block[(t%256)/8]>>(t%8)&1 == 1
+	}
+	return bits
+}
+
+func variationChallenge(group string, in, out, commitments []*big.Int) *big.Int {
+	h := sha256.New()
+	h.Write([]byte("goker variation proof"))
+	writeString(h, group)
+	writeInts(h, in)
+	writeInts(h, out)
+	writeInts(h, commitments)
+	return new(big.Int).SetBytes(h.Sum(nil)[:challengeBits/8])
+}
+
+// One weight per card, never 0 so no card can drop out of the fold
+func keyTargetWeights(group string, a, b *big.Int, in, targets []*big.Int) []*big.Int {
+	h := sha256.New()
+	h.Write([]byte("goker key target weights"))
+	writeString(h, group)
+	writeInts(h, []*big.Int{a, b})
+	writeInts(h, in)
+	writeInts(h, targets)
+	seed := h.Sum(nil)
+
+	weights := make([]*big.Int, len(in))
+	for i := range weights {
+		sum := sha256.Sum256(binary.BigEndian.AppendUint32(append([]byte(nil), seed...), uint32(i)))
+		weights[i] = new(big.Int).SetBytes(sum[:challengeBits/8])
+		weights[i].Add(weights[i], big.NewInt(1))
+	}
+	return weights
+}
+
+func keyTargetChallenge(group string, a, b, foldedIn, foldedTargets *big.Int, commitments []*big.Int) *big.Int {
+	h := sha256.New()
+	h.Write([]byte("goker key target proof"))
+	writeString(h, group)
+	writeInts(h, []*big.Int{a, b, foldedIn, foldedTargets})
+	writeInts(h, commitments)
+	return new(big.Int).SetBytes(h.Sum(nil)[:challengeBits/8])
+}
+
+// Length prefixed so different decks can't hash the same
+func writeInts(h interface{ Write([]byte) (int, error) }, values []*big.Int) {
+	h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(values))))
+	for _, v := range values {
+		b := v.Bytes()
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(b))))
+		h.Write(b)
+	}
+}
+
+func writeString(h interface{ Write([]byte) (int, error) }, s string) {
+	h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(s))))
+	h.Write([]byte(s))
+}
+
+func randomPermutation(size int) ([]int, error) {
+	permutation := make([]int, size)
+	for i := range permutation {
+		permutation[i] = i
+	}
+	for i := size - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return nil, err
+		}
+		permutation[i], permutation[j.Int64()] = permutation[j.Int64()], permutation[i]
+	}
+	return permutation, nil
+}
+
+func isPermutation(permutation []int, size int) bool {
+	if len(permutation) != size {
+		return false
+	}
+	seen := make([]bool, size)
+	for _, from := range permutation {
+		if from < 0 || from >= size || seen[from] {
+			return false
+		}
+		seen[from] = true
+	}
+	return true
+}
+
+func intsToStrings(values []*big.Int) []string {
+	strs := make([]string, len(values))
+	for i, v := range values {
+		strs[i] = v.String()
+	}
+	return strs
+}
+
+func stringsToInts(strs []string) ([]*big.Int, error) {
+	values := make([]*big.Int, len(strs))
+	for i, s := range strs {
+		v, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid number at %d", i)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
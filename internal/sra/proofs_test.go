@@ -0,0 +1,226 @@
+package sra
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Keyring in the small test group so the proofs run quickly
+func newProofKeyring(t testing.TB) *Keyring {
+	t.Helper()
+	c, err := NewPrimeCipher(testGroupBits)
+	require.NoError(t, err)
+	return newCipherKeyring(t, c)
+}
+
+func newCipherKeyring(t testing.TB, c CardCipher) *Keyring {
+	t.Helper()
+	k := &Keyring{}
+	k.SetCipher(c)
+	require.NoError(t, k.GenerateKeys())
+	return k
+}
+
+// Every card cipher, the prime one in the small test group
+func testCiphers(t testing.TB) []CardCipher {
+	t.Helper()
+	prime, err := NewPrimeCipher(testGroupBits)
+	require.NoError(t, err)
+	return []CardCipher{prime, NewCurveCipher()}
+}
+
+// Encoded like real cards, so they're in the group
+func testDeck(c CardCipher, size int) []*big.Int {
+	deck := make([]*big.Int, size)
+	for i := range deck {
+		deck[i] = c.EncodeCard(big.NewInt(int64(1000 + i*7)))
+	}
+	return deck
+}
+
+// Encrypts and shuffles the way the first step of the protocol does
+func shuffleDeck(t *testing.T, k *Keyring, in []*big.Int) ([]*big.Int, []int) {
+	t.Helper()
+	permutation, err := randomPermutation(len(in))
+	require.NoError(t, err)
+
+	out := make([]*big.Int, len(in))
+	for j, from := range permutation {
+		out[j] = new(big.Int).Set(in[from])
+		k.EncryptWithGlobalKeys(out[j])
+	}
+	return out, permutation
+}
+
+func TestExpMatchesBigInt(t *testing.T) {
+	k := newProofKeyring(t)
+	p := k.cipher.(*primeCipher).p
+	base := big.NewInt(123456789)
+	for _, exponent := range []*big.Int{big.NewInt(0), big.NewInt(1), big.NewInt(65537), k.globalPublicKey, k.globalPHI} {
+		require.Equal(t, 0, new(big.Int).Exp(base, exponent, p).Cmp(k.cipher.Encrypt(base, exponent)))
+	}
+
+	// A base bigger than the prime still works
+	bigBase := new(big.Int).Add(p, base)
+	require.Equal(t, 0, new(big.Int).Exp(bigBase, k.globalPublicKey, p).Cmp(k.cipher.Encrypt(bigBase, k.globalPublicKey)))
+}
+
+func TestShuffleProof(t *testing.T) {
+	for _, c := range testCiphers(t) {
+		t.Run(c.Name(), func(t *testing.T) {
+			testShuffleProof(t, c)
+		})
+	}
+}
+
+func testShuffleProof(t *testing.T, c CardCipher) {
+	prover := newCipherKeyring(t, c)
+	verifier := &Keyring{cipher: c}
+
+	in := testDeck(c, 10)
+	out, permutation := shuffleDeck(t, prover, in)
+
+	proof, err := prover.ProveShuffle(in, out, permutation, 8)
+	require.NoError(t, err)
+	require.NoError(t, verifier.VerifyShuffle(in, out, proof, 8))
+
+	t.Run("too few rounds", func(t *testing.T) {
+		require.Error(t, verifier.VerifyShuffle(in, out, proof, 9))
+	})
+
+	// A cheater passes a round only if they guess its challenge bit, so use enough rounds that they never do
+	const cheatRounds = 64
+
+	t.Run("duplicated card", func(t *testing.T) {
+		cheat := append([]*big.Int(nil), out...)
+		cheat[0] = cheat[1]
+		require.Error(t, verifier.VerifyShuffle(in, cheat, proof, 8))
+
+		// Even with a fresh proof made for the cheating deck
+		cheatProof, err := prover.ProveShuffle(in, cheat, permutation, cheatRounds)
+		require.NoError(t, err)
+		require.Error(t, verifier.VerifyShuffle(in, cheat, cheatProof, cheatRounds))
+	})
+
+	t.Run("substituted card", func(t *testing.T) {
+		cheat := append([]*big.Int(nil), out...)
+		cheat[3] = big.NewInt(42)
+		cheatProof, err := prover.ProveShuffle(in, cheat, permutation, cheatRounds)
+		require.NoError(t, err)
+		require.Error(t, verifier.VerifyShuffle(in, cheat, cheatProof, cheatRounds))
+	})
+
+	t.Run("card outside the group", func(t *testing.T) {
+		cheat := append([]*big.Int(nil), out...)
+		cheat[0] = big.NewInt(2)
+		for c.Contains(cheat[0]) {
+			cheat[0].Add(cheat[0], big.NewInt(1))
+		}
+		require.ErrorContains(t, verifier.VerifyShuffle(in, cheat, proof, 8), "not in the group")
+	})
+
+	t.Run("exponent that isn't invertible", func(t *testing.T) {
+		tampered := *proof
+		tampered.Rounds = append([]ShuffleProofRound(nil), proof.Rounds...)
+		tampered.Rounds[0].Exponent = c.Order().String()
+		require.ErrorContains(t, verifier.VerifyShuffle(in, out, &tampered, 8), "invertible")
+	})
+
+	t.Run("tampered shadow", func(t *testing.T) {
+		tampered := *proof
+		tampered.Rounds = append([]ShuffleProofRound(nil), proof.Rounds...)
+		tampered.Rounds[0].Shadow = append([]string(nil), proof.Rounds[0].Shadow...)
+		tampered.Rounds[0].Shadow[0] = "7"
+		require.Error(t, verifier.VerifyShuffle(in, out, &tampered, 8))
+	})
+}
+
+func TestUseTestShuffleProofs(t *testing.T) {
+	UseTestShuffleProofs(true)
+	t.Cleanup(func() { UseTestShuffleProofs(false) })
+
+	k := newProofKeyring(t)
+	in := testDeck(k.cipher, 10)
+	out, permutation := shuffleDeck(t, k, in)
+	proof, err := k.ProveShuffle(in, out, permutation, 80)
+	require.NoError(t, err)
+	require.Len(t, proof.Rounds, testShuffleProofRounds, "whatever the table asked for")
+	require.NoError(t, k.VerifyShuffle(in, out, proof, 80))
+
+	UseTestShuffleProofs(false)
+	require.Error(t, k.VerifyShuffle(in, out, proof, 80), "too short for a real game")
+}
+
+// Shadow decks of 1s opened with p-1 used to check 1^0 == 1 whatever the output deck was
+func TestForgedShuffleProof(t *testing.T) {
+	c, err := NewPrimeCipher(testGroupBits)
+	require.NoError(t, err)
+	verifier := &Keyring{cipher: c}
+
+	in := testDeck(c, 52)
+	out := make([]*big.Int, len(in))
+	for j := range out {
+		out[j] = in[0] // Every card the same
+	}
+	const rounds = 80
+	forged := &ShuffleProof{Rounds: make([]ShuffleProofRound, rounds)}
+	identity := make([]int, len(in))
+	for j := range identity {
+		identity[j] = j
+	}
+	for t := range forged.Rounds {
+		shadow := make([]string, len(in))
+		for j := range shadow {
+			shadow[j] = "1"
+		}
+		forged.Rounds[t] = ShuffleProofRound{Shadow: shadow, Permutation: identity, Exponent: c.Order().String()}
+	}
+	require.Error(t, verifier.VerifyShuffle(in, out, forged, rounds))
+
+	// Nor does 1 get through as a card on its own
+	require.False(t, c.Contains(big.NewInt(1)))
+}
+
+func TestVariationProof(t *testing.T) {
+	for _, c := range testCiphers(t) {
+		t.Run(c.Name(), func(t *testing.T) {
+			testVariationProof(t, c)
+		})
+	}
+}
+
+func testVariationProof(t *testing.T, c CardCipher) {
+	prover := newCipherKeyring(t, c)
+	verifier := &Keyring{cipher: c}
+
+	in := testDeck(c, 10)
+	out := make([]*big.Int, len(in))
+	for i := range in {
+		// Second step of the protocol, take off the global key then add the variation
+		out[i] = new(big.Int).Set(in[i])
+		prover.DecryptWithGlobalKeys(out[i])
+		require.NoError(t, prover.EncryptWithVariation(out[i], i))
+	}
+
+	proof, err := prover.ProveVariations(in, out)
+	require.NoError(t, err)
+	require.NoError(t, verifier.VerifyVariations(in, out, proof))
+
+	t.Run("swapped cards", func(t *testing.T) {
+		cheat := append([]*big.Int(nil), out...)
+		cheat[0], cheat[1] = cheat[1], cheat[0]
+		require.Error(t, verifier.VerifyVariations(in, cheat, proof))
+
+		cheatProof, err := prover.ProveVariations(in, cheat)
+		require.NoError(t, err)
+		require.Error(t, verifier.VerifyVariations(in, cheat, cheatProof))
+	})
+
+	t.Run("missing cards", func(t *testing.T) {
+		short := *proof
+		short.Responses = proof.Responses[1:]
+		require.Error(t, verifier.VerifyVariations(in, out, &short))
+	})
+}
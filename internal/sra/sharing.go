@@ -0,0 +1,112 @@
+package sra
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// Shamir secret sharing over the order of secp256k1, with Feldman commitments so each share can be checked on its own
+//
+// The secret is f(0) of a random polynomial f of degree threshold-1, share i is f(i+1), and the commitments are each
+// coefficient times the curve's generator. Anyone can work out f(i+1)*G from the commitments and compare it against a
+// share, without learning anything about the secret.
+
+// A random polynomial with the secret at 0, returns the shares and commitments to its coefficients
+func splitSecret(secret *big.Int, threshold, shares int) ([]*big.Int, []*big.Int, error) {
+	order := secp256k1.Params().N
+	coefficients := []*big.Int{secret}
+	for len(coefficients) < threshold {
+		a, err := rand.Int(rand.Reader, order)
+		if err != nil {
+			return nil, nil, err
+		}
+		coefficients = append(coefficients, a)
+	}
+
+	commitments := make([]*big.Int, threshold)
+	for j, a := range coefficients {
+		commitments[j] = commitToScalar(a)
+	}
+
+	values := make([]*big.Int, shares)
+	for i := range values {
+		x := big.NewInt(int64(i + 1))
+		value := new(big.Int)
+		for j := threshold - 1; j >= 0; j-- { // Horner's rule
+			value.Mul(value, x)
+			value.Add(value, coefficients[j])
+			value.Mod(value, order)
+		}
+		values[i] = value
+	}
+	return values, commitments, nil
+}
+
+// Checks share i lies on the committed polynomial
+func checkShare(commitments []*big.Int, i int, share *big.Int) error {
+	if share.Sign() < 0 || share.Cmp(secp256k1.Params().N) >= 0 {
+		return fmt.Errorf("share %d is out of range", i)
+	}
+
+	// f(i+1)*G by Horner's rule over the commitments
+	x := scalarOf(big.NewInt(int64(i + 1)))
+	var expected secp256k1.JacobianPoint
+	for j := len(commitments) - 1; j >= 0; j-- {
+		commitment, ok := decodePoint(commitments[j])
+		if !ok {
+			return fmt.Errorf("commitment %d isn't a point", j)
+		}
+		if j == len(commitments)-1 {
+			expected = *commitment
+			continue
+		}
+		var scaled secp256k1.JacobianPoint
+		secp256k1.ScalarMultNonConst(&x, &expected, &scaled)
+		secp256k1.AddNonConst(&scaled, commitment, &expected)
+	}
+
+	if encodePoint(&expected).Cmp(commitToScalar(share)) != 0 {
+		return fmt.Errorf("share %d isn't on the committed polynomial", i)
+	}
+	return nil
+}
+
+// Lagrange interpolation at 0, shares maps each share number to its value
+func combineShares(shares map[int]*big.Int) *big.Int {
+	order := secp256k1.Params().N
+	secret := new(big.Int)
+	for i, share := range shares {
+		numerator, denominator := big.NewInt(1), big.NewInt(1)
+		for j := range shares {
+			if i == j {
+				continue
+			}
+			numerator.Mul(numerator, big.NewInt(int64(j+1)))
+			numerator.Mod(numerator, order)
+			denominator.Mul(denominator, big.NewInt(int64(j-i)))
+			denominator.Mod(denominator, order)
+		}
+		term := new(big.Int).ModInverse(denominator, order)
+		term.Mul(term, numerator)
+		term.Mul(term, share)
+		secret.Add(secret, term)
+	}
+	return secret.Mod(secret, order)
+}
+
+// a*G as a compressed point
+func commitToScalar(a *big.Int) *big.Int {
+	scalar := scalarOf(a)
+	var point secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&scalar, &point)
+	return encodePoint(&point)
+}
+
+func scalarOf(a *big.Int) secp256k1.ModNScalar {
+	var scalar secp256k1.ModNScalar
+	scalar.SetByteSlice(new(big.Int).Mod(a, secp256k1.Params().N).Bytes())
+	return scalar
+}
@@ -0,0 +1,38 @@
+package sra
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretSharing(t *testing.T) {
+	secret := big.NewInt(424242)
+	shares, commitments, err := splitSecret(secret, 3, 5)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+	require.Len(t, commitments, 3)
+
+	for i, share := range shares {
+		require.NoError(t, checkShare(commitments, i, share))
+	}
+
+	t.Run("any threshold of shares", func(t *testing.T) {
+		require.Equal(t, secret, combineShares(map[int]*big.Int{0: shares[0], 1: shares[1], 2: shares[2]}))
+		require.Equal(t, secret, combineShares(map[int]*big.Int{4: shares[4], 1: shares[1], 3: shares[3]}))
+		require.Equal(t, secret, combineShares(map[int]*big.Int{0: shares[0], 1: shares[1], 2: shares[2], 3: shares[3]}))
+	})
+
+	t.Run("too few shares", func(t *testing.T) {
+		require.NotEqual(t, secret, combineShares(map[int]*big.Int{0: shares[0], 1: shares[1]}))
+	})
+
+	t.Run("bad shares", func(t *testing.T) {
+		require.Error(t, checkShare(commitments, 0, new(big.Int).Add(shares[0], big.NewInt(1))))
+		require.Error(t, checkShare(commitments, 1, shares[0]), "share for another x")
+		require.Error(t, checkShare(commitments, 0, secp256k1.Params().N), "out of range")
+		require.Error(t, checkShare([]*big.Int{big.NewInt(5)}, 0, shares[0]), "commitment isn't a point")
+	})
+}
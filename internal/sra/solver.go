@@ -0,0 +1,143 @@
+package sra
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+)
+
+// How many squarings are done between checking for cancellation and the clock
+const solveBatch = 1 << 12
+
+// Defaults for how often a solver reports back
+const (
+	defaultProgressInterval   = time.Second
+	defaultCheckpointInterval = 10 * time.Second
+)
+
+// How far a solve has got
+type SolveProgress struct {
+	Done, Total uint64
+	ETA         time.Duration // From how fast it's gone so far, 0 until it's known
+}
+
+func (sp SolveProgress) Percent() float64 {
+	if sp.Total == 0 {
+		return 100
+	}
+	return 100 * float64(sp.Done) / float64(sp.Total)
+}
+
+// Where a solve got to, enough to carry on from there later
+type Checkpoint struct {
+	Share     int    `json:"share"`
+	Iteration uint64 `json:"iteration"`
+	Value     string `json:"value"` // x^(2^Iteration) mod N
+
+	powers []*big.Int // Kept for the proof so far, so only checkpoints from this solve's process can be carried on from
+}
+
+// Solves one share of a time locked puzzle by doing its squarings one after another
+// Everything but the puzzle and share is optional.
+type PuzzleSolver struct {
+	Puzzle *TimeLock
+	Share  int         // One of Puzzle.LockedShares()
+	From   *Checkpoint // Carry on from here instead of the start
+
+	OnProgress   func(SolveProgress) // Called about once every ProgressInterval
+	OnCheckpoint func(Checkpoint)    // Called about once every CheckpointInterval, and when cancelled
+
+	ProgressInterval   time.Duration // Defaults to a second
+	CheckpointInterval time.Duration // Defaults to ten seconds
+}
+
+// Squares until the share is solved and returns it with a proof, TimeLock.Unlock opens the puzzle with it
+// Stops with ctx's error when it's cancelled, after handing OnCheckpoint where it got to.
+func (s *PuzzleSolver) Solve(ctx context.Context) (*Solution, error) {
+	total, err := s.Puzzle.iterations()
+	if err != nil {
+		return nil, err
+	}
+	if s.Share < 0 || s.Share >= len(s.Puzzle.Shares) {
+		return nil, fmt.Errorf("puzzle has no share %d", s.Share)
+	}
+	n, _, err := s.Puzzle.Shares[s.Share].parse()
+	if err != nil {
+		return nil, fmt.Errorf("share %d: %w", s.Share, err)
+	}
+
+	// The proof is for the squarings up to the last one, which Unlock does itself
+	x := shareBase(n, s.Share)
+	squarings := total - 1
+	plan := newProofPlan(squarings)
+
+	start := uint64(0)
+	value := new(big.Int).Set(x)
+	var powers []*big.Int
+	if s.From != nil {
+		if s.From.Share != s.Share {
+			return nil, fmt.Errorf("checkpoint is for share %d, not %d", s.From.Share, s.Share)
+		}
+		if s.From.Iteration > squarings {
+			return nil, fmt.Errorf("checkpoint at %d is past the shares %d squarings", s.From.Iteration, squarings)
+		}
+		if _, ok := value.SetString(s.From.Value, 10); !ok || value.Sign() <= 0 || value.Cmp(n) >= 0 {
+			return nil, fmt.Errorf("checkpoint value isn't a number mod N")
+		}
+		if len(s.From.powers) != plan.kept(s.From.Iteration) {
+			return nil, fmt.Errorf("checkpoint doesn't have what's needed for the proof")
+		}
+		start, powers = s.From.Iteration, s.From.powers
+	}
+
+	progressInterval, checkpointInterval := s.ProgressInterval, s.CheckpointInterval
+	if progressInterval <= 0 {
+		progressInterval = defaultProgressInterval
+	}
+	if checkpointInterval <= 0 {
+		checkpointInterval = defaultCheckpointInterval
+	}
+
+	began := time.Now()
+	lastProgress, lastCheckpoint := began, began
+	checkpoint := func(i uint64) {
+		if s.OnCheckpoint != nil {
+			s.OnCheckpoint(Checkpoint{Share: s.Share, Iteration: i, Value: value.String(), powers: slices.Clip(powers)})
+		}
+	}
+
+	for i := start; i < squarings; {
+		end := min(i+solveBatch, squarings)
+		for ; i < end; i++ {
+			if i%plan.every() == 0 {
+				powers = append(powers, new(big.Int).Set(value))
+			}
+			value.Mul(value, value)
+			value.Mod(value, n)
+		}
+
+		if err := ctx.Err(); err != nil {
+			checkpoint(i)
+			return nil, err
+		}
+
+		now := time.Now()
+		if s.OnProgress != nil && now.Sub(lastProgress) >= progressInterval {
+			lastProgress = now
+			rate := float64(i-start) / now.Sub(began).Seconds() // Squarings a second
+			s.OnProgress(SolveProgress{Done: i, Total: total, ETA: time.Duration(float64(total-i) / rate * float64(time.Second))})
+		}
+		if now.Sub(lastCheckpoint) >= checkpointInterval {
+			lastCheckpoint = now
+			checkpoint(i)
+		}
+	}
+
+	proof := plan.prove(n, x, value, powers)
+	if s.OnProgress != nil {
+		s.OnProgress(SolveProgress{Done: total, Total: total})
+	}
+	return &Solution{Share: s.Share, Value: value.String(), Proof: proof.String()}, nil
+}
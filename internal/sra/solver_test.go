@@ -0,0 +1,91 @@
+package sra
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPuzzleSolver(t *testing.T) {
+	UseTestPrimes(true)
+	t.Cleanup(func() { UseTestPrimes(false) })
+	k := &Keyring{KeyringPayload: "locked keys"}
+	require.NoError(t, k.GenerateTimeLockedPuzzle(time.Second, 30_000, testBinding))
+	share := k.TLP.LockedShares()[0]
+	unlock := func(t *testing.T, solution *Solution) {
+		payload, err := k.TLP.Unlock(testBinding, solution)
+		require.NoError(t, err)
+		require.Equal(t, k.KeyringPayload, payload)
+	}
+
+	t.Run("solves", func(t *testing.T) {
+		var last SolveProgress
+		solver := &PuzzleSolver{
+			Puzzle:           k.TLP,
+			Share:            share,
+			OnProgress:       func(progress SolveProgress) { last = progress },
+			ProgressInterval: time.Nanosecond,
+		}
+		solution, err := solver.Solve(context.Background())
+		require.NoError(t, err)
+		unlock(t, solution)
+		require.Equal(t, float64(100), last.Percent(), "finishing is always reported")
+	})
+
+	t.Run("cancelled and carried on from a checkpoint", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var checkpoints []Checkpoint
+		solver := &PuzzleSolver{
+			Puzzle: k.TLP,
+			Share:  share,
+			OnCheckpoint: func(checkpoint Checkpoint) {
+				checkpoints = append(checkpoints, checkpoint)
+				cancel() // Stop after the first one
+			},
+			CheckpointInterval: time.Nanosecond,
+		}
+		_, err := solver.Solve(ctx)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Len(t, checkpoints, 2, "one on the interval, one when cancelled")
+		last := checkpoints[len(checkpoints)-1]
+		require.Greater(t, last.Iteration, checkpoints[0].Iteration)
+
+		resumed := &PuzzleSolver{Puzzle: k.TLP, Share: share, From: &last}
+		solution, err := resumed.Solve(context.Background())
+		require.NoError(t, err)
+		unlock(t, solution)
+	})
+
+	t.Run("bad checkpoints", func(t *testing.T) {
+		for _, checkpoint := range []Checkpoint{
+			{Share: share, Iteration: 1 << 40, Value: "4"}, // Past the end
+			{Share: share, Iteration: 1, Value: "0"},
+			{Share: share, Iteration: 1, Value: k.TLP.Shares[share].N},
+			{Share: share, Iteration: 1, Value: "four"},
+			{Share: share, Iteration: 1, Value: "4"},     // Without the powers kept for the proof, like one read back from JSON
+			{Share: share + 1, Iteration: 0, Value: "4"}, // Another share
+		} {
+			solver := &PuzzleSolver{Puzzle: k.TLP, Share: share, From: &checkpoint}
+			_, err := solver.Solve(context.Background())
+			require.Error(t, err, "%+v", checkpoint)
+		}
+	})
+
+	t.Run("bad puzzles", func(t *testing.T) {
+		n := k.TLP.Shares[share].N
+		for _, puzzle := range []TimeLock{
+			{Iter: "1", Shares: []LockedShare{{N: "1", Locked: "0"}}},
+			{Iter: "-1", Shares: []LockedShare{{N: n, Locked: "1"}}},
+			{Iter: "0", Shares: []LockedShare{{N: n, Locked: "1"}}},
+			{Iter: "99999999999999999999999", Shares: []LockedShare{{N: n, Locked: "1"}}},
+			{Iter: "1", Shares: []LockedShare{{N: n, Locked: "x"}}},
+			{Iter: "1"}, // No share to solve
+		} {
+			solver := &PuzzleSolver{Puzzle: &puzzle}
+			_, err := solver.Solve(context.Background())
+			require.Error(t, err, "%+v", puzzle)
+		}
+	})
+}
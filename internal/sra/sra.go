@@ -38,7 +38,7 @@ func gcd(a, b *big.Int) *big.Int {
 func generateRandomCoPrime(x *big.Int) (*big.Int, error) {
 	for {
 		// Generate a random number in the range [2, max)
-		randomNum, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), uint(2048))) // Limit size is 2048 bits
+		randomNum, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), uint(min(x.BitLen(), 2048)))) // Limit size is 2048 bits, or x's size when smaller
 		if err != nil {
 			return nil, err
 		}
@@ -4,139 +4,417 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
+	"strconv"
 	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// How a time locked puzzle is built, so everyone can check it without solving it
+//
+// The keyring payload is AES encrypted with a key derived from a random secret, and the secret is split into
+// TimeLockShares Shamir shares. Each share is locked with x^(2^Iter) under its own modulus, where only the builder
+// knows the factors and so only they can skip the squarings. Which half of the shares get opened (their factors
+// published) is decided by a hash of the whole puzzle, and the opened shares are checked against Feldman
+// commitments to the sharing polynomial. Any one more share is enough to get the secret back, so a builder hiding
+// garbage would have to get every share that wasn't opened wrong, without knowing which ones those would be.
+//
+// The secret and the challenge are both bound to the builders variation key targets, so a puzzle can't be moved
+// to another hand or player. Whether the payload holds the right keys is only known once it's opened, but then the
+// keys are checked against the same targets and a bad one is on the builder.
+const (
+	TimeLockShares    = 48                 // Locked shares in every puzzle
+	timeLockOpened    = TimeLockShares / 2 // Shares opened for checking
+	timeLockThreshold = timeLockOpened + 1 // Shares it takes to get the secret back, one more than are opened
+)
+
+var (
+	ErrBadPuzzle   = errors.New("time locked puzzle wasn't built right") // The builders fault
+	ErrBadSolution = errors.New("time locked puzzle solution is wrong")  // The solvers fault
+	ErrBadShare    = errors.New("locked share isn't on the polynomial")  // The builders fault, but another share might still open it
+	ErrNoSpeed     = errors.New("no squaring speed to lock against")     // Nobodys fault, but no puzzle can be trusted to hold
 )
 
 // Structure for Time locked puzzle payload
 type TimeLock struct {
-	Puzzle  string `json:"puzzle"`
-	Payload string `json:"payload"`
-	Iter    string `json:"iterations"`
-	N       string `json:"n"`
+	Payload     string        `json:"payload"`     // The keyring payload, encrypted with a key from the secret
+	Iter        string        `json:"iterations"`  // Squarings it takes to unlock a share
+	Commitments []string      `json:"commitments"` // Feldman commitments to the polynomial the secret was shared with
+	Shares      []LockedShare `json:"shares"`
 }
 
-// Time locking functions for private keys - Uses a 2^2^t mod n - Need to change this to be symmetric encrypted...?
+// One share of the secret, locked under its own modulus
+type LockedShare struct {
+	N      string `json:"n"`
+	Locked string `json:"locked"`           // The share plus x^(2^Iter) mod N
+	Factor string `json:"factor,omitempty"` // One of N's primes, only on opened shares
+}
 
-// GenerateTimeLockedPrivateKey locks a big int for a specified amount of time
-// In this context, it should be used on the symmetric key that locks all xn's (which hold the details for the variations of the global keyring)
-func (k *Keyring) GenerateTimeLockedPuzzle(seconds int64) {
-	k.TLP = new(TimeLock)
+// A solved share, with a proof anyone can check much faster than solving it again
+type Solution struct {
+	Share int    `json:"share"`
+	Value string `json:"value"` // x^(2^(Iter-1)) mod N, squared once more it takes the share out of Locked
+	Proof string `json:"proof"` // Wesolowski proof for Value
+}
 
-	// Encrypt Payload with AES to lock key
-	encryptedPayload, key, err := PayloadToAES(k.KeyringPayload)
+// Locks the keyring payload for lockTime against someone squaring at the given speed, bound to the variation key targets
+func (k *Keyring) GenerateTimeLockedPuzzle(lockTime time.Duration, squaringSpeed int64, binding string) error {
+	iterations, err := LockIterations(lockTime, squaringSpeed)
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
 
-	// P&Q - will be burned
-	p, q, err := generateLargePrime(2048)
+	secret, err := rand.Int(rand.Reader, secp256k1.Params().N)
 	if err != nil {
-		fmt.Println(err)
+		return err
+	}
+	shares, commitments, err := splitSecret(secret, timeLockThreshold, TimeLockShares)
+	if err != nil {
+		return err
+	}
+	payload, err := sealAES(k.KeyringPayload, timeLockKey(binding, secret))
+	if err != nil {
+		return err
 	}
 
-	// mod n - THIS WILL NEED TO BE KEPT
-	n := new(big.Int).Mul(p, q)
-
-	// Calculate φ(n) = (p-1)(q-1) - THIS WILL NEED TO BE KEPT
-	phi := new(big.Int).Mul(new(big.Int).Sub(p, big.NewInt(1)), new(big.Int).Sub(q, big.NewInt(1)))
+	tl := &TimeLock{
+		Payload:     payload,
+		Iter:        strconv.FormatUint(iterations, 10),
+		Commitments: intsToStrings(commitments),
+		Shares:      make([]LockedShare, TimeLockShares),
+	}
+	factors := make([]*big.Int, TimeLockShares)
+	err = forEach(TimeLockShares, func(i int) error {
+		p, q, err := primePair(TimeLockPrimeBits)
+		if err != nil {
+			return err
+		}
+		n := new(big.Int).Mul(p, q)
+		locked := new(big.Int).Add(shares[i], lockOf(n, p, q, i, iterations))
+		tl.Shares[i] = LockedShare{N: n.String(), Locked: locked.Mod(locked, n).String()}
+		factors[i] = p
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-	// Determine `t` based on desired decryption delay (T in seconds)
-	squaringSpeed := k.squaringSpeed
-	iterations := new(big.Int).Mul(big.NewInt(seconds), big.NewInt(squaringSpeed))
+	for _, i := range tl.opened(binding) {
+		tl.Shares[i].Factor = factors[i].String()
+	}
+	k.TLP = tl
+	return nil
+}
 
-	// Calculate `e = 2^t mod φ(n)`
-	e := new(big.Int).Exp(big.NewInt(2), iterations, phi)
+// Squarings it takes to hold out for lockTime against someone squaring at the given speed
+// Without a speed there's no telling how long any puzzle holds, so there's no answer rather than a guess.
+func LockIterations(lockTime time.Duration, squaringSpeed int64) (uint64, error) {
+	if squaringSpeed <= 0 {
+		return 0, fmt.Errorf("%w: %d squarings a second", ErrNoSpeed, squaringSpeed)
+	}
+	return uint64(max(lockTime.Seconds()*float64(squaringSpeed), 1)), nil
+}
 
-	// Initial base of 2
-	a := big.NewInt(2)
+// This hands puzzle, only built the first time anyone asks for it
+// One built for other commitments, like a last hand build that finished late, is built again.
+func (k *Keyring) HandPuzzle(lockTime time.Duration, squaringSpeed int64, binding string) (*TimeLock, error) {
+	k.tlpMutex.Lock()
+	defer k.tlpMutex.Unlock()
+	if k.TLP == nil || k.tlpBinding != binding {
+		if err := k.GenerateTimeLockedPuzzle(lockTime, squaringSpeed, binding); err != nil {
+			return nil, err
+		}
+		k.tlpBinding = binding
+	}
+	return k.TLP, nil
+}
 
-	// Compute `b = a^e mod n`
-	b := new(big.Int).Exp(a, e, n)
+// Checks the shares that were opened were built right, so the rest almost certainly were too
+func (tl *TimeLock) VerifyConstruction(binding string) error {
+	iterations, err := tl.iterations()
+	if err != nil {
+		return err
+	}
+	if len(tl.Shares) != TimeLockShares {
+		return fmt.Errorf("%w: %d shares, expected %d", ErrBadPuzzle, len(tl.Shares), TimeLockShares)
+	}
+	commitments, err := tl.commitments()
+	if err != nil {
+		return err
+	}
 
-	// Encrypt the global private key with `b`
-	lockedPuzzle := new(big.Int).Add(key, b)
-	lockedPuzzle.Mod(lockedPuzzle, n)
+	opened := make(map[int]bool, timeLockOpened)
+	for _, i := range tl.opened(binding) {
+		opened[i] = true
+	}
+	for i, share := range tl.Shares {
+		if opened[i] != (share.Factor != "") {
+			return fmt.Errorf("%w: share %d wasn't the one to open", ErrBadPuzzle, i)
+		}
+		if !opened[i] {
+			if _, _, err := share.parse(); err != nil {
+				return fmt.Errorf("%w: share %d: %v", ErrBadPuzzle, i, err)
+			}
+			continue
+		}
+
+		value, err := share.open(i, iterations)
+		if err != nil {
+			return fmt.Errorf("%w: share %d: %v", ErrBadPuzzle, i, err)
+		}
+		if err := checkShare(commitments, i, value); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadPuzzle, err)
+		}
+	}
+	return nil
+}
 
-	// Return made puzzle
-	k.TLP.Puzzle = lockedPuzzle.String()
-	k.TLP.Payload = encryptedPayload
-	k.TLP.Iter = iterations.String()
-	k.TLP.N = n.String()
+// Shares that weren't opened, any one of them solved unlocks the puzzle
+func (tl *TimeLock) LockedShares() []int {
+	var locked []int
+	for i, share := range tl.Shares {
+		if share.Factor == "" {
+			locked = append(locked, i)
+		}
+	}
+	return locked
 }
 
-func (k *Keyring) CalibrateSquaringSpeed() {
-	p, q, err := generateLargePrime(2048)
+// Checks a solution and opens the keyring payload with it
+// Fails with ErrBadSolution if the solution is wrong, or ErrBadPuzzle if it's right but the puzzle doesn't open (also
+// ErrBadShare if it's just that share)
+func (tl *TimeLock) Unlock(binding string, solution *Solution) (string, error) {
+	iterations, err := tl.iterations()
+	if err != nil {
+		return "", err
+	}
+	i := solution.Share
+	if i < 0 || i >= len(tl.Shares) || tl.Shares[i].Factor != "" {
+		return "", fmt.Errorf("%w: share %d isn't one of the locked shares", ErrBadSolution, i)
+	}
+	n, locked, err := tl.Shares[i].parse()
 	if err != nil {
-		fmt.Println(err)
+		return "", fmt.Errorf("%w: share %d: %v", ErrBadPuzzle, i, err)
 	}
-	n := new(big.Int).Mul(p, q)
 
-	// Set a large number of squarings to get an accurate measure.
-	const numSquarings = 1_000_000 // Adjust as needed for precision and timing.
+	value, ok := new(big.Int).SetString(solution.Value, 10)
+	proof, ok2 := new(big.Int).SetString(solution.Proof, 10)
+	if !ok || !ok2 || !verifySquarings(n, shareBase(n, i), value, proof, iterations-1) {
+		return "", fmt.Errorf("%w: share %d", ErrBadSolution, i)
+	}
 
-	// Use a random large integer as the base to square.
-	base := big.NewInt(2) // Use a larger, more realistic base.
+	// Squared once more so it doesn't matter which square root of the answer the solver sent
+	share := new(big.Int).Sub(locked, value.Mul(value, value).Mod(value, n))
+	share.Mod(share, n)
 
-	// Start measuring the time.
-	start := time.Now()
+	commitments, err := tl.commitments()
+	if err != nil {
+		return "", err
+	}
+	if err := checkShare(commitments, i, share); err != nil {
+		return "", fmt.Errorf("%w: %w: %v", ErrBadPuzzle, ErrBadShare, err)
+	}
 
-	// Perform the squaring operations.
-	temp := new(big.Int).Set(base)
-	for i := int64(0); i < numSquarings; i++ {
-		temp.Exp(temp, big.NewInt(2), n) // Squaring operation.
+	shares := map[int]*big.Int{i: share}
+	for j, opened := range tl.Shares {
+		if opened.Factor == "" {
+			continue
+		}
+		if shares[j], err = opened.open(j, iterations); err != nil {
+			return "", fmt.Errorf("%w: share %d: %v", ErrBadPuzzle, j, err)
+		}
 	}
 
-	// Measure the elapsed time.
-	elapsed := time.Since(start).Seconds()
+	payload, err := openAES(tl.Payload, timeLockKey(binding, combineShares(shares)))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrBadPuzzle, err)
+	}
+	return payload, nil
+}
 
-	// Calculate the squaring speed (operations per second).
-	squaringSpeed := int64(float64(numSquarings) / elapsed)
+// Which shares get opened, from a hash of everything in the puzzle but the factors
+func (tl *TimeLock) opened(binding string) []int {
+	h := sha256.New()
+	h.Write([]byte("goker time lock"))
+	writeString(h, binding)
+	writeString(h, tl.Iter)
+	writeString(h, tl.Payload)
+	h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(tl.Commitments))))
+	for _, commitment := range tl.Commitments {
+		writeString(h, commitment)
+	}
+	h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(tl.Shares))))
+	for _, share := range tl.Shares {
+		writeString(h, share.N)
+		writeString(h, share.Locked)
+	}
+	seed := h.Sum(nil)
+
+	// Fisher-Yates on a stream of hashes, the first timeLockOpened picked are opened
+	order := make([]int, len(tl.Shares))
+	for i := range order {
+		order[i] = i
+	}
+	for i := 0; i < min(timeLockOpened, len(order)); i++ {
+		sum := sha256.Sum256(binary.BigEndian.AppendUint32(append([]byte(nil), seed...), uint32(i)))
+		j := i + int(new(big.Int).Mod(new(big.Int).SetBytes(sum[:]), big.NewInt(int64(len(order)-i))).Int64())
+		order[i], order[j] = order[j], order[i]
+	}
+	return order[:min(timeLockOpened, len(order))]
+}
 
-	fmt.Printf("Calibration complete: Estimated squaring speed is %d operations per second\n", squaringSpeed)
-	k.squaringSpeed = squaringSpeed
+// Squarings it takes to unlock a share
+func (tl *TimeLock) Iterations() (uint64, error) {
+	return tl.iterations()
+}
+
+func (tl *TimeLock) iterations() (uint64, error) {
+	iterations, err := strconv.ParseUint(tl.Iter, 10, 64)
+	if err != nil || iterations == 0 {
+		return 0, fmt.Errorf("%w: iterations %q out of range", ErrBadPuzzle, tl.Iter)
+	}
+	return iterations, nil
+}
+
+func (tl *TimeLock) commitments() ([]*big.Int, error) {
+	if len(tl.Commitments) != timeLockThreshold {
+		return nil, fmt.Errorf("%w: %d commitments, expected %d", ErrBadPuzzle, len(tl.Commitments), timeLockThreshold)
+	}
+	commitments, err := stringsToInts(tl.Commitments)
+	if err != nil {
+		return nil, fmt.Errorf("%w: commitment %v", ErrBadPuzzle, err)
+	}
+	return commitments, nil
+}
+
+// The shares numbers, checked enough that solving it can't go wrong
+func (ls LockedShare) parse() (n, locked *big.Int, err error) {
+	n, ok := new(big.Int).SetString(ls.N, 10)
+	if !ok || n.Cmp(big.NewInt(3)) < 0 {
+		return nil, nil, fmt.Errorf("modulus isn't a usable number")
+	}
+	if bits := 2 * timeLockPrimeBits(); n.BitLen() != bits { // Too small is easy to factor, too big takes forever to square
+		return nil, nil, fmt.Errorf("modulus is %d bits, expected %d", n.BitLen(), bits)
+	}
+	locked, ok = new(big.Int).SetString(ls.Locked, 10)
+	if !ok || locked.Sign() < 0 || locked.Cmp(n) >= 0 {
+		return nil, nil, fmt.Errorf("locked share isn't a number mod N")
+	}
+	return n, locked, nil
+}
+
+// The share under an opened lock, worked out the quick way with its factors
+func (ls LockedShare) open(i int, iterations uint64) (*big.Int, error) {
+	n, locked, err := ls.parse()
+	if err != nil {
+		return nil, err
+	}
+	p, ok := new(big.Int).SetString(ls.Factor, 10)
+	if !ok || p.Cmp(big.NewInt(1)) <= 0 || p.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("factor isn't a number")
+	}
+	q, r := new(big.Int).QuoRem(n, p, new(big.Int))
+	if r.Sign() != 0 || p.Cmp(q) == 0 || !p.ProbablyPrime(20) || !q.ProbablyPrime(20) {
+		return nil, fmt.Errorf("modulus isn't the product of two primes")
+	}
+
+	share := new(big.Int).Sub(locked, lockOf(n, p, q, i, iterations))
+	return share.Mod(share, n), nil
+}
+
+// x^(2^iterations) mod n = p*q, without doing the squarings
+func lockOf(n, p, q *big.Int, i int, iterations uint64) *big.Int {
+	one := big.NewInt(1)
+	phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+	e := new(big.Int).Exp(big.NewInt(2), new(big.Int).SetUint64(iterations), phi)
+	return e.Exp(shareBase(n, i), e, n)
+}
+
+// What gets squared for share i, a square derived from its modulus so the builder can't pick it
+func shareBase(n *big.Int, i int) *big.Int {
+	seed := sha256.Sum256(fmt.Appendf(nil, "goker time lock base\n%d\n%s", i, n))
+	x := new(big.Int)
+	for counter := uint32(0); x.BitLen() < n.BitLen()+64; counter++ {
+		sum := sha256.Sum256(binary.BigEndian.AppendUint32(seed[:], counter))
+		x.Lsh(x, 256)
+		x.Or(x, new(big.Int).SetBytes(sum[:]))
+	}
+	x.Mod(x, n)
+	return x.Mul(x, x).Mod(x, n)
+}
+
+// The AES key the payload is locked with
+func timeLockKey(binding string, secret *big.Int) []byte {
+	key := sha256.Sum256(fmt.Appendf(nil, "goker time lock key\n%s\n%s", binding, secret))
+	return key[:]
+}
+
+// About how long solving one share of a puzzle takes at our squaring speed, 0 if it hasn't been measured
+func (k *Keyring) SolveTime(tl *TimeLock) time.Duration {
+	iterations, err := tl.iterations()
+	speed := k.squaringSpeed.Load() // Doesn't wait for calibration, this is only a guess
+	if err != nil || speed <= 0 {
+		return 0
+	}
+	return time.Duration(float64(iterations) / float64(speed) * float64(time.Second))
 }
 
 // Encrypts plaintext with AES-256-GCM and returns the ciphertext in base64 format and key
 func PayloadToAES(plaintext string) (string, *big.Int, error) {
 	key := make([]byte, 32) // AES-256 requires a 32-byte key
 	if _, err := rand.Read(key); err != nil {
-		fmt.Println("Error generating key:", err)
-		return "", nil, nil
+		return "", nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	ciphertext, err := sealAES(plaintext, key)
+	if err != nil {
+		return "", nil, err
+	}
+	return ciphertext, new(big.Int).SetBytes(key), nil
+}
+
+// Decrypts a base64-encoded ciphertext using AES-256-GCM
+func AESToPayload(ciphertextBase64 string, key *big.Int) (string, error) {
+	if key.Sign() < 0 || key.BitLen() > 256 {
+		return "", fmt.Errorf("key is too big for AES-256")
 	}
+	return openAES(ciphertextBase64, key.FillBytes(make([]byte, 32))) // Keeps any leading zero bytes the key had
+}
 
+func sealAES(plaintext string, key []byte) (string, error) {
 	// Generate a new AES cipher
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to create cipher: %w", err)
+		return "", fmt.Errorf("failed to create cipher: %w", err)
 	}
 
 	// Create a GCM cipher mode instance
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to create GCM: %w", err)
+		return "", fmt.Errorf("failed to create GCM: %w", err)
 	}
 
 	// Generate a nonce (unique for each encryption operation)
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", nil, fmt.Errorf("failed to generate nonce: %w", err)
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
 	// Encrypt the plaintext
 	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
 
 	// Return the ciphertext as a base64-encoded string
-	return base64.StdEncoding.EncodeToString(ciphertext), new(big.Int).SetBytes(key), nil
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
-// Decrypts a base64-encoded ciphertext using AES-256-GCM
-func AESToPayload(ciphertextBase64 string, key *big.Int) (string, error) {
-	keyBytes := key.Bytes()
-
+func openAES(ciphertextBase64 string, key []byte) (string, error) {
 	// Decode the base64-encoded ciphertext
 	ciphertext, err := base64.StdEncoding.DecodeString(ciphertextBase64)
 	if err != nil {
@@ -144,7 +422,7 @@ func AESToPayload(ciphertextBase64 string, key *big.Int) (string, error) {
 	}
 
 	// Generate a new AES cipher using the provided key
-	block, err := aes.NewCipher(keyBytes)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", fmt.Errorf("failed to create cipher: %w", err)
 	}
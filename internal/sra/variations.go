@@ -12,7 +12,7 @@ type KeyVariation struct {
 }
 
 func (k *Keyring) GenerateKeyVariations(count int) error {
-	if k.globalPrivateKey == nil || k.globalPublicKey == nil || k.globalN == nil {
+	if k.globalPrivateKey == nil || k.globalPublicKey == nil || k.globalPHI == nil {
 		return fmt.Errorf("global keys not generated")
 	}
 
@@ -44,21 +44,28 @@ func (k *Keyring) EncryptWithVariation(data *big.Int, index int) error {
 		return fmt.Errorf("invalid key variation index")
 	}
 
-	data.Exp(data, k.keyVariations[index].publicKey, k.globalN)
+	data.Set(k.cipher.Encrypt(data, k.keyVariations[index].publicKey))
 	return nil
 }
 
+// Encrypts every value in place with the variation of the same index, spread across CPU cores
+func (k *Keyring) EncryptAllWithVariations(values []*big.Int) error {
+	return forEach(len(values), func(i int) error {
+		return k.EncryptWithVariation(values[i], i)
+	})
+}
+
 func (k *Keyring) DecryptWithVariation(data *big.Int, index int) error {
 	if index >= len(k.keyVariations) {
 		return fmt.Errorf("invalid key variation index")
 	}
 
-	data.Exp(data, k.keyVariations[index].privateKey, k.globalN)
+	data.Set(k.cipher.Encrypt(data, k.keyVariations[index].privateKey))
 	return nil
 }
 
 func (k *Keyring) DecryptWithKey(data *big.Int, key *big.Int) {
-	data.Exp(data, key, k.globalN)
+	data.Set(k.cipher.Encrypt(data, key))
 }
 
 func (k *Keyring) GetVariationKeyForCard(variationIndex int) *big.Int {
@@ -84,3 +91,18 @@ func (k *Keyring) GenerateKeyringPayload() error {
 	k.KeyringPayload = strings.Join(payload, "\n")
 	return nil
 }
+
+// Checks a private key someone revealed for a variation takes their card back to the target they published for it
+// out is the card as it left their second step, the target is its card going in raised to their global private key.
+func (k *Keyring) CheckVariationKey(index int, out, target, privateKey *big.Int) error {
+	if k.cipher == nil {
+		return fmt.Errorf("group not set")
+	}
+	if privateKey.Sign() <= 0 {
+		return fmt.Errorf("key for variation %d isn't positive", index)
+	}
+	if k.cipher.Encrypt(out, privateKey).Cmp(target) != 0 {
+		return fmt.Errorf("key for variation %d doesn't take the card back to its target", index)
+	}
+	return nil
+}
@@ -1,8 +1,10 @@
 package sra
 
 import (
+	"context"
 	"encoding/base64"
 	"math/big"
+	"slices"
 	"testing"
 	"time"
 
@@ -47,88 +49,125 @@ func TestAESEncryption(t *testing.T) {
 	})
 }
 
+const testBinding = "key targets\n1\n2\n3"
+
 func TestTimeLockPuzzle(t *testing.T) {
-	k := &Keyring{
-		KeyringPayload: "sensitive data",
+	UseTestPrimes(true)
+	t.Cleanup(func() { UseTestPrimes(false) })
+	k := &Keyring{KeyringPayload: "sensitive data"}
+	require.NoError(t, k.GenerateTimeLockedPuzzle(time.Second, 1000, testBinding))
+	require.NoError(t, k.TLP.VerifyConstruction(testBinding))
+
+	solve := func(t *testing.T, tl *TimeLock) *Solution {
+		solver := &PuzzleSolver{Puzzle: tl, Share: tl.LockedShares()[0]}
+		solution, err := solver.Solve(context.Background())
+		require.NoError(t, err)
+		return solution
 	}
+	solution := solve(t, k.TLP)
 
 	t.Run("puzzle generation and solving", func(t *testing.T) {
-		k.GenerateTimeLockedPuzzle(1)
-		require.NotNil(t, k.TLP)
-		require.NotEmpty(t, k.TLP.Puzzle)
-		require.NotEmpty(t, k.TLP.Payload)
-		require.NotEmpty(t, k.TLP.Iter)
-		require.NotEmpty(t, k.TLP.N)
-
-		// Reconstruct parameters with error checking
-		puzzleInt, ok := new(big.Int).SetString(k.TLP.Puzzle, 10)
-		require.True(t, ok, "Failed to parse puzzle")
-
-		iterations, ok := new(big.Int).SetString(k.TLP.Iter, 10)
-		require.True(t, ok, "Failed to parse iterations")
-
-		n, ok := new(big.Int).SetString(k.TLP.N, 10)
-		require.True(t, ok, "Failed to parse modulus")
-
-		// Break the puzzle
-		start := time.Now()
-		base := big.NewInt(2)
-		for i := big.NewInt(0); i.Cmp(iterations) < 0; i.Add(i, big.NewInt(1)) {
-			base.Exp(base, big.NewInt(2), n)
-		}
-
-		// Retrieve AES key
-		key := new(big.Int).Sub(puzzleInt, base)
-		key.Mod(key, n)
+		require.Equal(t, "1000", k.TLP.Iter, "a second at 1000 squarings a second")
+		require.Len(t, k.TLP.Shares, TimeLockShares)
+		require.Len(t, k.TLP.LockedShares(), TimeLockShares-timeLockOpened)
 
-		// Decrypt payload
-		result, err := AESToPayload(k.TLP.Payload, key)
+		payload, err := k.TLP.Unlock(testBinding, solution)
 		require.NoError(t, err)
-		require.Equal(t, k.KeyringPayload, result)
-
-		t.Logf("Puzzle solved in %v", time.Since(start))
+		require.Equal(t, k.KeyringPayload, payload)
 	})
 
-	t.Run("invalid parameters", func(t *testing.T) {
-		k := &Keyring{KeyringPayload: "test"}
-		k.GenerateTimeLockedPuzzle(1)
+	t.Run("any locked share unlocks it", func(t *testing.T) {
+		solver := &PuzzleSolver{Puzzle: k.TLP, Share: k.TLP.LockedShares()[1]}
+		other, err := solver.Solve(context.Background())
+		require.NoError(t, err)
+		payload, err := k.TLP.Unlock(testBinding, other)
+		require.NoError(t, err)
+		require.Equal(t, k.KeyringPayload, payload)
+	})
 
-		// Parse original puzzle
-		originalPuzzle, ok := new(big.Int).SetString(k.TLP.Puzzle, 10)
-		require.True(t, ok, "Invalid puzzle format")
+	t.Run("bound to the commitments", func(t *testing.T) {
+		require.ErrorIs(t, k.TLP.VerifyConstruction("key targets\n4\n5\n6"), ErrBadPuzzle)
+		_, err := k.TLP.Unlock("key targets\n4\n5\n6", solution)
+		require.ErrorIs(t, err, ErrBadPuzzle)
+	})
 
-		// Create corrupted puzzle (already a *big.Int, no need to parse)
-		corruptedPuzzle := new(big.Int).Add(originalPuzzle, big.NewInt(1))
+	t.Run("bad solutions", func(t *testing.T) {
+		value, _ := new(big.Int).SetString(solution.Value, 10)
+		proof, _ := new(big.Int).SetString(solution.Proof, 10)
+		for name, bad := range map[string]Solution{
+			"wrong value":  {Share: solution.Share, Value: value.Add(value, big.NewInt(1)).String(), Proof: solution.Proof},
+			"wrong proof":  {Share: solution.Share, Value: solution.Value, Proof: proof.Add(proof, big.NewInt(1)).String()},
+			"other share":  {Share: k.TLP.LockedShares()[1], Value: solution.Value, Proof: solution.Proof},
+			"opened share": {Share: slices.IndexFunc(k.TLP.Shares, func(s LockedShare) bool { return s.Factor != "" })},
+			"no share":     {Share: TimeLockShares, Value: solution.Value, Proof: solution.Proof},
+		} {
+			_, err := k.TLP.Unlock(testBinding, &bad)
+			require.ErrorIs(t, err, ErrBadSolution, name)
+		}
+	})
 
-		// Parse other components
-		n, ok := new(big.Int).SetString(k.TLP.N, 10)
-		require.True(t, ok, "Invalid modulus format")
+	t.Run("tampering moves the challenge", func(t *testing.T) {
+		tamper := func(change func(tl *TimeLock)) *TimeLock {
+			tl := *k.TLP
+			tl.Shares = slices.Clone(k.TLP.Shares)
+			tl.Commitments = slices.Clone(k.TLP.Commitments)
+			change(&tl)
+			return &tl
+		}
+		locked := k.TLP.LockedShares()[0]
+		for name, tl := range map[string]*TimeLock{
+			"payload":      tamper(func(tl *TimeLock) { tl.Payload, _, _ = PayloadToAES("garbage") }),
+			"locked share": tamper(func(tl *TimeLock) { tl.Shares[locked].Locked = "12345" }),
+			"commitment":   tamper(func(tl *TimeLock) { tl.Commitments[0] = tl.Commitments[1] }),
+			"iterations":   tamper(func(tl *TimeLock) { tl.Iter = "1" }),
+			"hidden factor": tamper(func(tl *TimeLock) {
+				opened := slices.IndexFunc(tl.Shares, func(s LockedShare) bool { return s.Factor != "" })
+				tl.Shares[opened].Factor = ""
+			}),
+			"missing share": tamper(func(tl *TimeLock) { tl.Shares = tl.Shares[1:] }),
+		} {
+			require.ErrorIs(t, tl.VerifyConstruction(testBinding), ErrBadPuzzle, name)
+		}
 
-		iterations, ok := new(big.Int).SetString(k.TLP.Iter, 10)
-		require.True(t, ok, "Invalid iteration format")
+		// Moduli have to be the size the table uses, a huge one would take forever to square
+		UseTestPrimes(false)
+		defer UseTestPrimes(true)
+		require.ErrorIs(t, k.TLP.VerifyConstruction(testBinding), ErrBadPuzzle, "moduli the wrong size")
 
-		// Break puzzle with wrong parameters
-		base := big.NewInt(2)
-		for i := big.NewInt(0); i.Cmp(iterations) < 0; i.Add(i, big.NewInt(1)) {
-			base.Exp(base, big.NewInt(2), n)
-		}
+		// Unlock doesn't check the construction again, but still won't open a payload the secret doesn't
+		garbage := tamper(func(tl *TimeLock) { tl.Payload, _, _ = PayloadToAES(k.KeyringPayload) })
+		_, err := garbage.Unlock(testBinding, solution)
+		require.ErrorIs(t, err, ErrBadPuzzle)
+	})
 
-		// Use corrupted puzzle directly (already a *big.Int)
-		key := new(big.Int).Sub(corruptedPuzzle, base)
-		key.Mod(key, n)
+	t.Run("built once a hand", func(t *testing.T) {
+		k := &Keyring{KeyringPayload: "hand one"}
+		first, err := k.HandPuzzle(time.Second, 1, testBinding)
+		require.NoError(t, err)
+		again, err := k.HandPuzzle(time.Second, 1, testBinding)
+		require.NoError(t, err)
+		require.Same(t, first, again)
 
-		_, err := AESToPayload(k.TLP.Payload, key)
-		require.Error(t, err, "Should fail with corrupted puzzle")
+		// One built for other commitments is no good for this hand
+		other, err := k.HandPuzzle(time.Second, 1, "other commitments")
+		require.NoError(t, err)
+		require.NotSame(t, first, other)
+		require.NoError(t, other.VerifyConstruction("other commitments"))
 	})
 }
 
 func TestPuzzleProperties(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a puzzle with real sized primes, skipping in short mode")
+	}
 	k := &Keyring{KeyringPayload: "test payload"}
-	k.GenerateTimeLockedPuzzle(1)
+	require.NoError(t, k.GenerateTimeLockedPuzzle(time.Second, 1, testBinding))
 
 	t.Run("prime properties", func(t *testing.T) {
-		n, _ := new(big.Int).SetString(k.TLP.N, 10)
-		require.Greater(t, n.BitLen(), 2048, "modulus too small")
+		for _, share := range k.TLP.Shares {
+			n, _ := new(big.Int).SetString(share.N, 10)
+			require.GreaterOrEqual(t, n.BitLen(), 2*TimeLockPrimeBits-1, "modulus too small")
+		}
 	})
 
 	t.Run("iteration calculation", func(t *testing.T) {
@@ -140,18 +179,113 @@ func TestPuzzleProperties(t *testing.T) {
 		_, err := base64.StdEncoding.DecodeString(k.TLP.Payload)
 		require.NoError(t, err, "invalid base64 payload")
 	})
+
+	t.Run("half the shares opened", func(t *testing.T) {
+		require.NoError(t, k.TLP.VerifyConstruction(testBinding))
+		require.Len(t, k.TLP.LockedShares(), TimeLockShares-timeLockOpened)
+	})
 }
 
 func TestErrorHandling(t *testing.T) {
 	t.Run("empty keyring payload", func(t *testing.T) {
+		UseTestPrimes(true)
+		t.Cleanup(func() { UseTestPrimes(false) })
 		k := &Keyring{KeyringPayload: ""}
 		require.NotPanics(t, func() {
-			k.GenerateTimeLockedPuzzle(1)
+			k.GenerateTimeLockedPuzzle(time.Second, 1, testBinding)
 		})
 	})
 
+	t.Run("no squaring speed", func(t *testing.T) {
+		k := &Keyring{KeyringPayload: "keys"}
+		for _, speed := range []int64{0, -1} {
+			require.ErrorIs(t, k.GenerateTimeLockedPuzzle(time.Second, speed, testBinding), ErrNoSpeed)
+			_, err := k.HandPuzzle(time.Second, speed, testBinding)
+			require.ErrorIs(t, err, ErrNoSpeed)
+		}
+		require.Nil(t, k.TLP, "nothing should be locked against no speed at all")
+	})
+
 	t.Run("invalid AES parameters", func(t *testing.T) {
 		_, err := AESToPayload("invalid", big.NewInt(0))
 		require.Error(t, err)
 	})
 }
+
+func TestKeyTargets(t *testing.T) {
+	for _, c := range testCiphers(t) {
+		t.Run(c.Name(), func(t *testing.T) {
+			testKeyTargets(t, c)
+		})
+	}
+}
+
+func testKeyTargets(t *testing.T, c CardCipher) {
+	prover := newCipherKeyring(t, c)
+	verifier := &Keyring{cipher: c}
+
+	// Both steps of the protocol at a table of one, the targets are the cards with the global key taken off
+	shuffleIn := testDeck(c, 10)
+	in, _ := shuffleDeck(t, prover, shuffleIn)
+	targets, out := make([]*big.Int, len(in)), make([]*big.Int, len(in))
+	for i := range in {
+		targets[i] = new(big.Int).Set(in[i])
+		prover.DecryptWithGlobalKeys(targets[i])
+		out[i] = new(big.Int).Set(targets[i])
+		require.NoError(t, prover.EncryptWithVariation(out[i], i))
+	}
+
+	proof, err := prover.ProveKeyTargets(shuffleIn, in, in, targets)
+	require.NoError(t, err)
+	require.NoError(t, verifier.VerifyKeyTargets(shuffleIn, in, in, targets, proof))
+	for i := range in {
+		require.NoError(t, verifier.CheckVariationKey(i, out[i], targets[i], prover.GetVariationKeyForCard(i)))
+	}
+
+	t.Run("key for another card", func(t *testing.T) {
+		require.Error(t, verifier.CheckVariationKey(0, out[0], targets[0], prover.GetVariationKeyForCard(1)))
+	})
+
+	t.Run("corrupt key", func(t *testing.T) {
+		corrupt := new(big.Int).Add(prover.GetVariationKeyForCard(0), big.NewInt(2))
+		require.Error(t, verifier.CheckVariationKey(0, out[0], targets[0], corrupt))
+		require.Error(t, verifier.CheckVariationKey(0, out[0], targets[0], big.NewInt(0)))
+	})
+
+	// A target that doesn't match the encryption would let its owner hand out a key that decrypts the card to anything
+	t.Run("target for another key", func(t *testing.T) {
+		other := newCipherKeyring(t, c)
+		cheat := append([]*big.Int(nil), targets...)
+		cheat[3] = new(big.Int).Set(in[3])
+		other.DecryptWithGlobalKeys(cheat[3])
+		cheatProof, err := prover.ProveKeyTargets(shuffleIn, in, in, cheat)
+		require.NoError(t, err)
+		require.Error(t, verifier.VerifyKeyTargets(shuffleIn, in, in, cheat, cheatProof))
+		require.Error(t, verifier.VerifyKeyTargets(shuffleIn, in, in, cheat, proof))
+
+		// Nor can every target be made with a key that didn't do the shuffle
+		for i := range cheat {
+			cheat[i] = new(big.Int).Set(in[i])
+			other.DecryptWithGlobalKeys(cheat[i])
+		}
+		cheatProof, err = other.ProveKeyTargets(shuffleIn, in, in, cheat)
+		require.NoError(t, err)
+		require.Error(t, verifier.VerifyKeyTargets(shuffleIn, in, in, cheat, cheatProof))
+	})
+
+	t.Run("swapped targets", func(t *testing.T) {
+		cheat := append([]*big.Int(nil), targets...)
+		cheat[0], cheat[1] = cheat[1], cheat[0]
+		cheatProof, err := prover.ProveKeyTargets(shuffleIn, in, in, cheat)
+		require.NoError(t, err)
+		require.Error(t, verifier.VerifyKeyTargets(shuffleIn, in, in, cheat, cheatProof))
+	})
+
+	t.Run("missing targets", func(t *testing.T) {
+		require.Error(t, verifier.VerifyKeyTargets(shuffleIn, in, in, targets[1:], proof))
+		require.Error(t, verifier.VerifyKeyTargets(shuffleIn, in, in, targets, nil))
+		zero := *proof
+		zero.Response = c.Order().String()
+		require.Error(t, verifier.VerifyKeyTargets(shuffleIn, in, in, targets, &zero))
+	})
+}
@@ -0,0 +1,136 @@
+package sra
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+)
+
+// Wesolowski's proof that y = x^(2^t) mod n, checked with two small exponentiations instead of t squarings
+//
+// The verifier (here a hash of everything, Fiat-Shamir style) picks a prime l, the prover sends pi = x^floor(2^t/l),
+// and y = pi^l * x^(2^t mod l) holds only if the squarings were done right. Writing out floor(2^t/l) would take as
+// much memory as t bits, so the prover keeps every (kappa*gamma)-th power while squaring and builds pi from those.
+
+// Bits in the challenge prime
+const challengePrimeBits = 128
+
+// Most powers a prover keeps while squaring, a few megabytes at the time lock modulus size
+const maxProofPowers = 1 << 16
+
+// A prime derived from the statement being proven, so the prover can't choose it
+func challengePrime(n, x, y *big.Int, t uint64) *big.Int {
+	h := sha256.New()
+	h.Write([]byte("goker squaring proof"))
+	writeInts(h, []*big.Int{n, x, y})
+	h.Write(binary.BigEndian.AppendUint64(nil, t))
+	seed := h.Sum(nil)
+
+	for counter := uint32(0); ; counter++ {
+		sum := sha256.Sum256(binary.BigEndian.AppendUint32(append([]byte(nil), seed...), counter))
+		l := new(big.Int).SetBytes(sum[:challengePrimeBits/8])
+		l.SetBit(l, challengePrimeBits-1, 1)
+		l.SetBit(l, 0, 1)
+		if l.ProbablyPrime(20) {
+			return l
+		}
+	}
+}
+
+// Checks pi proves y = x^(2^t) mod n
+func verifySquarings(n, x, y, pi *big.Int, t uint64) bool {
+	for _, v := range []*big.Int{x, y, pi} {
+		if v.Sign() <= 0 || v.Cmp(n) >= 0 {
+			return false
+		}
+	}
+
+	l := challengePrime(n, x, y, t)
+	r := new(big.Int).Exp(big.NewInt(2), new(big.Int).SetUint64(t), l)
+	lhs := new(big.Int).Exp(pi, l, n)
+	lhs.Mul(lhs, new(big.Int).Exp(x, r, n))
+	lhs.Mod(lhs, n)
+	return lhs.Cmp(y) == 0
+}
+
+// Which powers a prover keeps while doing t squarings, and how it puts them together afterwards
+// floor(2^t/l) is split into kappa bit digits, and the powers kept are x^(2^(i*kappa*gamma)).
+type proofPlan struct {
+	t            uint64
+	kappa, gamma uint64
+}
+
+// Picks kappa and gamma for the least work after the squarings, keeping no more than maxProofPowers
+func newProofPlan(t uint64) proofPlan {
+	best, bestCost := proofPlan{t: t, kappa: 1, gamma: 1}, uint64(0)
+	for kappa := uint64(1); kappa <= 16; kappa++ {
+		gamma := max(1, (t+kappa*maxProofPowers-1)/(kappa*maxProofPowers))
+		cost := t/kappa + gamma<<(kappa+1) // Multiplications into the buckets, then folding them together
+		if bestCost == 0 || cost < bestCost {
+			best, bestCost = proofPlan{t: t, kappa: kappa, gamma: gamma}, cost
+		}
+	}
+	return best
+}
+
+// Squarings between each power the prover keeps
+func (pp proofPlan) every() uint64 {
+	return pp.kappa * pp.gamma
+}
+
+// How many powers have been kept after the given number of squarings
+func (pp proofPlan) kept(squarings uint64) int {
+	return int((squarings + pp.every() - 1) / pp.every())
+}
+
+// The proof for y = x^(2^t), from the powers kept while squaring
+func (pp proofPlan) prove(n, x, y *big.Int, powers []*big.Int) *big.Int {
+	l := challengePrime(n, x, y, pp.t)
+	digits := (pp.t + pp.kappa - 1) / pp.kappa
+
+	// Digit k of floor(2^t/l) in base 2^kappa, without ever writing the whole quotient down
+	digit := func(k uint64) int {
+		shift := pp.t - pp.kappa*k
+		if shift < pp.kappa {
+			return int(new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), uint(shift)), l).Int64())
+		}
+		r := new(big.Int).Exp(big.NewInt(2), new(big.Int).SetUint64(shift-pp.kappa), l)
+		r.Lsh(r, uint(pp.kappa))
+		return int(r.Div(r, l).Int64())
+	}
+
+	pi := big.NewInt(1)
+	buckets := make([]*big.Int, 1<<pp.kappa)
+	for j := int64(pp.gamma) - 1; j >= 0; j-- {
+		for range pp.kappa {
+			pi.Mul(pi, pi)
+			pi.Mod(pi, n)
+		}
+
+		for d := range buckets {
+			buckets[d] = big.NewInt(1)
+		}
+		for i, power := range powers {
+			k := uint64(i)*pp.gamma + uint64(j)
+			if k >= digits {
+				continue
+			}
+			if d := digit(k); d != 0 {
+				buckets[d].Mul(buckets[d], power)
+				buckets[d].Mod(buckets[d], n)
+			}
+		}
+
+		// The product of every bucket to the power of its digit
+		running, product := big.NewInt(1), big.NewInt(1)
+		for d := len(buckets) - 1; d > 0; d-- {
+			running.Mul(running, buckets[d])
+			running.Mod(running, n)
+			product.Mul(product, running)
+			product.Mod(product, n)
+		}
+		pi.Mul(pi, product)
+		pi.Mod(pi, n)
+	}
+	return pi
+}
@@ -0,0 +1,54 @@
+package sra
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSquaringProof(t *testing.T) {
+	p, q, err := generateLargePrime(testPrimeBits)
+	require.NoError(t, err)
+	n := new(big.Int).Mul(p, q)
+	x := shareBase(n, 0)
+
+	// Squares t times keeping the powers the plan asks for, like the solver does
+	square := func(plan proofPlan) (*big.Int, []*big.Int) {
+		y := new(big.Int).Set(x)
+		var powers []*big.Int
+		for i := uint64(0); i < plan.t; i++ {
+			if i%plan.every() == 0 {
+				powers = append(powers, new(big.Int).Set(y))
+			}
+			y.Mul(y, y).Mod(y, n)
+		}
+		require.Len(t, powers, plan.kept(plan.t))
+		return y, powers
+	}
+
+	plans := []proofPlan{
+		{t: 0, kappa: 1, gamma: 1},
+		{t: 5000, kappa: 3, gamma: 4}, // Fewer powers kept than digits
+		{t: 4099, kappa: 5, gamma: 1},
+	}
+	for _, squarings := range []uint64{1, 2, 127, 10_000} {
+		plans = append(plans, newProofPlan(squarings))
+	}
+	for _, plan := range plans {
+		y, powers := square(plan)
+		pi := plan.prove(n, x, y, powers)
+		require.True(t, verifySquarings(n, x, y, pi, plan.t), "%+v", plan)
+
+		require.False(t, verifySquarings(n, x, new(big.Int).Add(y, big.NewInt(1)), pi, plan.t), "wrong answer %+v", plan)
+		require.False(t, verifySquarings(n, x, y, new(big.Int).Add(pi, big.NewInt(1)), plan.t), "wrong proof %+v", plan)
+		require.False(t, verifySquarings(n, x, y, pi, plan.t+1), "wrong number of squarings %+v", plan)
+	}
+
+	t.Run("keeps the powers it's allowed", func(t *testing.T) {
+		for _, squarings := range []uint64{1, 1 << 20, 1 << 30, 1 << 40} {
+			plan := newProofPlan(squarings)
+			require.LessOrEqual(t, plan.kept(squarings), maxProofPowers, "%d squarings", squarings)
+		}
+	})
+}
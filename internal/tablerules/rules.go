@@ -0,0 +1,259 @@
+package tablerules
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version of the rules schema this build understands - bump it when fields change meaning
+const Version = 5
+
+// Betting structures
+const (
+	NoLimit    = "no-limit"
+	PotLimit   = "pot-limit"
+	FixedLimit = "fixed-limit"
+)
+
+// Ciphers cards can be encrypted with
+const (
+	PohligHellman = "pohlig-hellman" // Exponents mod a shared safe prime, see PrimeBits
+	Secp256k1     = "secp256k1"      // Points on an elliptic curve, much smaller and faster than any prime
+)
+
+// Bounds on shuffle proof rounds, fewer than 80 leaves forging a proof within reach of a single machine
+const (
+	MinShuffleProofRounds = 80
+	MaxShuffleProofRounds = 256
+)
+
+// Sizes the shared safe prime cards are encrypted with can be, each is a fixed well known group
+var PrimeSizes = []int{1024, 2048, 3072}
+
+// Rules for a table, set by the host and approved by every peer before play
+type Rules struct {
+	Version          int          `json:"version"`
+	StartingCash     float64      `json:"startingCash"`            // Starting stack for all players
+	SmallBlind       float64      `json:"smallBlind"`              // Blinds for the first round, the schedule can raise them later
+	BigBlind         float64      `json:"bigBlind"`                // Also the minimum bet
+	Ante             float64      `json:"ante"`                    // Paid by everyone each round
+	BlindSchedule    []BlindLevel `json:"blindSchedule,omitempty"` // Sorted by the round they start on
+	TurnTimer        int          `json:"turnTimer"`               // Seconds a player has to act before they auto fold
+	MaxPlayers       int          `json:"maxPlayers"`
+	TimeLockMargin   int          `json:"timeLockMargin"` // Extra seconds added to time locked puzzles to account for slow peers
+	BettingStructure string       `json:"bettingStructure"`
+
+	// Seconds a player who drops mid-hand has to reconnect before they're treated as having left
+	ReconnectWindow int `json:"reconnectWindow"`
+
+	// Cut-and-choose rounds in every shuffle proof. The challenge comes from a hash, so a cheating shuffler can keep
+	// trying offline and gets a bad shuffle through after about 2^rounds attempts - this is a work factor, not a probability
+	ShuffleProofRounds int `json:"shuffleProofRounds"`
+
+	// How cards are encrypted during the deal
+	Cipher string `json:"cipher"`
+
+	// Bits in the shared safe prime, bigger is harder to break but makes every hand slower to deal (Pohlig-Hellman only)
+	PrimeBits int `json:"primeBits"`
+}
+
+// Blinds and ante starting from a specific round
+type BlindLevel struct {
+	Round      int     `json:"round"`
+	SmallBlind float64 `json:"smallBlind"`
+	BigBlind   float64 `json:"bigBlind"`
+	Ante       float64 `json:"ante"`
+}
+
+// Rules used when the host doesn't change anything
+func Default() Rules {
+	return Rules{
+		Version:          Version,
+		StartingCash:     100,
+		SmallBlind:       1,
+		BigBlind:         2,
+		Ante:             0,
+		TurnTimer:        15,
+		MaxPlayers:       6,
+		TimeLockMargin:   30,
+		BettingStructure: NoLimit,
+		ReconnectWindow:  60,
+
+		ShuffleProofRounds: MinShuffleProofRounds,
+		Cipher:             PohligHellman,
+		PrimeBits:          2048,
+	}
+}
+
+// Checks every field is sensible, every peer runs this before agreeing to play
+func (r Rules) Validate() error {
+	if r.Version != Version {
+		return fmt.Errorf("unsupported table rules version %d (expected %d)", r.Version, Version)
+	}
+	if r.StartingCash <= 0 {
+		return fmt.Errorf("starting cash must be above 0")
+	}
+	if err := validateBlinds(r.SmallBlind, r.BigBlind, r.Ante, r.StartingCash); err != nil {
+		return err
+	}
+
+	lastRound := 1
+	for _, level := range r.BlindSchedule {
+		if level.Round <= lastRound {
+			return fmt.Errorf("blind schedule rounds must be above 1 and increasing, got %d after %d", level.Round, lastRound)
+		}
+		if err := validateBlinds(level.SmallBlind, level.BigBlind, level.Ante, r.StartingCash); err != nil {
+			return fmt.Errorf("blind level for round %d: %w", level.Round, err)
+		}
+		lastRound = level.Round
+	}
+
+	if r.TurnTimer < 5 || r.TurnTimer > 300 {
+		return fmt.Errorf("turn timer must be between 5 and 300 seconds, got %d", r.TurnTimer)
+	}
+	// Every player needs 2 cards, plus a burn card and the board - 10 is plenty for a table
+	if r.MaxPlayers < 2 || r.MaxPlayers > 10 {
+		return fmt.Errorf("max players must be between 2 and 10, got %d", r.MaxPlayers)
+	}
+	if r.TimeLockMargin < 0 || r.TimeLockMargin > 600 {
+		return fmt.Errorf("time lock margin must be between 0 and 600 seconds, got %d", r.TimeLockMargin)
+	}
+	if r.ReconnectWindow < 0 || r.ReconnectWindow > 600 {
+		return fmt.Errorf("reconnect window must be between 0 and 600 seconds, got %d", r.ReconnectWindow)
+	}
+
+	switch r.BettingStructure {
+	case NoLimit, PotLimit, FixedLimit:
+	default:
+		return fmt.Errorf("unknown betting structure %q", r.BettingStructure)
+	}
+
+	if r.ShuffleProofRounds < MinShuffleProofRounds || r.ShuffleProofRounds > MaxShuffleProofRounds {
+		return fmt.Errorf("shuffle proof rounds must be between %d and %d, got %d", MinShuffleProofRounds, MaxShuffleProofRounds, r.ShuffleProofRounds)
+	}
+	switch r.Cipher {
+	case PohligHellman, Secp256k1:
+	default:
+		return fmt.Errorf("unknown card cipher %q", r.Cipher)
+	}
+	if !slices.Contains(PrimeSizes, r.PrimeBits) {
+		return fmt.Errorf("prime size must be one of %v bits, got %d", PrimeSizes, r.PrimeBits)
+	}
+	return nil
+}
+
+func validateBlinds(smallBlind, bigBlind, ante, startingCash float64) error {
+	if smallBlind <= 0 || bigBlind < smallBlind {
+		return fmt.Errorf("blinds must be above 0 and the big blind at least the small blind")
+	}
+	if bigBlind > startingCash {
+		return fmt.Errorf("big blind can't be more than the starting cash")
+	}
+	if ante < 0 {
+		return fmt.Errorf("ante can't be negative")
+	}
+	return nil
+}
+
+// Blinds and ante for a given round (starting at 1)
+func (r Rules) BlindsForRound(round int) (smallBlind, bigBlind, ante float64) {
+	smallBlind, bigBlind, ante = r.SmallBlind, r.BigBlind, r.Ante
+	for _, level := range r.BlindSchedule {
+		if level.Round > round {
+			break
+		}
+		smallBlind, bigBlind, ante = level.SmallBlind, level.BigBlind, level.Ante
+	}
+	return smallBlind, bigBlind, ante
+}
+
+// Payload to be sent to other peers
+func (r Rules) Encode() (string, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode table rules: %w", err)
+	}
+	return string(payload), nil
+}
+
+// Reads and validates rules sent by the host
+func Decode(payload string) (Rules, error) {
+	// Check the version first, so a newer host gets a clear error instead of half understood rules
+	var versioned struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal([]byte(payload), &versioned); err != nil {
+		return Rules{}, fmt.Errorf("failed to decode table rules: %w", err)
+	}
+	if versioned.Version != Version {
+		return Rules{}, fmt.Errorf("unsupported table rules version %d (expected %d)", versioned.Version, Version)
+	}
+
+	var r Rules
+	if err := json.Unmarshal([]byte(payload), &r); err != nil {
+		return Rules{}, fmt.Errorf("failed to decode table rules: %w", err)
+	}
+	if err := r.Validate(); err != nil {
+		return Rules{}, err
+	}
+	return r, nil
+}
+
+// Parses a blind schedule with a level per line: `round smallBlind bigBlind [ante]`
+func ParseBlindSchedule(schedule string) ([]BlindLevel, error) {
+	var levels []BlindLevel
+	for _, line := range strings.Split(schedule, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 && len(fields) != 4 {
+			return nil, fmt.Errorf("blind level %q should be `round smallBlind bigBlind [ante]`", line)
+		}
+
+		round, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid round in blind level %q", line)
+		}
+		var amounts [3]float64
+		for i, field := range fields[1:] {
+			amounts[i], err = strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid amount in blind level %q", line)
+			}
+		}
+		levels = append(levels, BlindLevel{Round: round, SmallBlind: amounts[0], BigBlind: amounts[1], Ante: amounts[2]})
+	}
+
+	sort.Slice(levels, func(i, j int) bool { return levels[i].Round < levels[j].Round })
+	return levels, nil
+}
+
+// Human readable rules for the lobby
+func (r Rules) String() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("Starting cash: $%.0f", r.StartingCash))
+	lines = append(lines, fmt.Sprintf("Blinds: $%g/$%g", r.SmallBlind, r.BigBlind))
+	if r.Ante > 0 {
+		lines = append(lines, fmt.Sprintf("Ante: $%g", r.Ante))
+	}
+	for _, level := range r.BlindSchedule {
+		lines = append(lines, fmt.Sprintf("  From round %d: $%g/$%g ante $%g", level.Round, level.SmallBlind, level.BigBlind, level.Ante))
+	}
+	lines = append(lines, fmt.Sprintf("Betting: %s", r.BettingStructure))
+	lines = append(lines, fmt.Sprintf("Turn timer: %ds", r.TurnTimer))
+	lines = append(lines, fmt.Sprintf("Max players: %d", r.MaxPlayers))
+	lines = append(lines, fmt.Sprintf("Time lock margin: %ds", r.TimeLockMargin))
+	lines = append(lines, fmt.Sprintf("Reconnect window: %ds", r.ReconnectWindow))
+	lines = append(lines, fmt.Sprintf("Shuffle proof rounds: %d", r.ShuffleProofRounds))
+	if r.Cipher == PohligHellman {
+		lines = append(lines, fmt.Sprintf("Card cipher: %s, %d bit prime", r.Cipher, r.PrimeBits))
+	} else {
+		lines = append(lines, fmt.Sprintf("Card cipher: %s", r.Cipher))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,114 @@
+package tablerules
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultRulesAreValid(t *testing.T) {
+	require.NoError(t, Default().Validate())
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(r *Rules)
+	}{
+		{"unsupported version", func(r *Rules) { r.Version = Version + 1 }},
+		{"no starting cash", func(r *Rules) { r.StartingCash = 0 }},
+		{"big blind below small blind", func(r *Rules) { r.BigBlind = r.SmallBlind / 2 }},
+		{"big blind above starting cash", func(r *Rules) { r.BigBlind = r.StartingCash + 1 }},
+		{"negative ante", func(r *Rules) { r.Ante = -1 }},
+		{"turn timer too short", func(r *Rules) { r.TurnTimer = 1 }},
+		{"too many players", func(r *Rules) { r.MaxPlayers = 11 }},
+		{"negative time lock margin", func(r *Rules) { r.TimeLockMargin = -1 }},
+		{"reconnect window too long", func(r *Rules) { r.ReconnectWindow = 601 }},
+		{"unknown betting structure", func(r *Rules) { r.BettingStructure = "spread-limit" }},
+		{"no shuffle proof rounds", func(r *Rules) { r.ShuffleProofRounds = 0 }},
+		{"too few shuffle proof rounds", func(r *Rules) { r.ShuffleProofRounds = MinShuffleProofRounds - 1 }},
+		{"unsupported prime size", func(r *Rules) { r.PrimeBits = 512 }},
+		{"unknown card cipher", func(r *Rules) { r.Cipher = "rot13" }},
+		{"schedule out of order", func(r *Rules) {
+			r.BlindSchedule = []BlindLevel{{Round: 5, SmallBlind: 2, BigBlind: 4}, {Round: 3, SmallBlind: 5, BigBlind: 10}}
+		}},
+		{"schedule with bad blinds", func(r *Rules) {
+			r.BlindSchedule = []BlindLevel{{Round: 5, SmallBlind: 0, BigBlind: 4}}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := Default()
+			tt.change(&rules)
+			require.Error(t, rules.Validate())
+		})
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	rules := Default()
+	rules.Ante = 0.5
+	rules.BettingStructure = PotLimit
+	rules.Cipher = Secp256k1
+	rules.BlindSchedule = []BlindLevel{{Round: 10, SmallBlind: 2, BigBlind: 4, Ante: 1}}
+
+	payload, err := rules.Encode()
+	require.NoError(t, err)
+
+	decoded, err := Decode(payload)
+	require.NoError(t, err)
+	require.Equal(t, rules, decoded)
+
+	t.Run("newer version is rejected", func(t *testing.T) {
+		_, err := Decode(strings.Replace(payload, fmt.Sprintf(`"version":%d`, Version), fmt.Sprintf(`"version":%d`, Version+1), 1))
+		require.ErrorContains(t, err, "unsupported table rules version")
+	})
+
+	t.Run("invalid rules are rejected", func(t *testing.T) {
+		_, err := Decode(strings.Replace(payload, `"maxPlayers":6`, `"maxPlayers":1`, 1))
+		require.Error(t, err)
+	})
+
+	t.Run("garbage is rejected", func(t *testing.T) {
+		_, err := Decode("100\n2\n")
+		require.Error(t, err)
+	})
+}
+
+func TestBlindsForRound(t *testing.T) {
+	rules := Default()
+	rules.BlindSchedule = []BlindLevel{
+		{Round: 5, SmallBlind: 2, BigBlind: 4},
+		{Round: 10, SmallBlind: 5, BigBlind: 10, Ante: 1},
+	}
+
+	smallBlind, bigBlind, ante := rules.BlindsForRound(4)
+	require.Equal(t, []float64{1, 2, 0}, []float64{smallBlind, bigBlind, ante})
+
+	smallBlind, bigBlind, ante = rules.BlindsForRound(5)
+	require.Equal(t, []float64{2, 4, 0}, []float64{smallBlind, bigBlind, ante})
+
+	smallBlind, bigBlind, ante = rules.BlindsForRound(42)
+	require.Equal(t, []float64{5, 10, 1}, []float64{smallBlind, bigBlind, ante})
+}
+
+func TestParseBlindSchedule(t *testing.T) {
+	levels, err := ParseBlindSchedule("10 5 10 1\n\n5 2 4\n")
+	require.NoError(t, err)
+	require.Equal(t, []BlindLevel{
+		{Round: 5, SmallBlind: 2, BigBlind: 4},
+		{Round: 10, SmallBlind: 5, BigBlind: 10, Ante: 1},
+	}, levels)
+
+	levels, err = ParseBlindSchedule("")
+	require.NoError(t, err)
+	require.Empty(t, levels)
+
+	_, err = ParseBlindSchedule("5 2")
+	require.Error(t, err)
+	_, err = ParseBlindSchedule("five 2 4")
+	require.Error(t, err)
+}
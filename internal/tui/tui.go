@@ -0,0 +1,333 @@
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"goker/internal/eventbus"
+	"goker/internal/tablerules"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2/canvas"
+)
+
+// Text front end for playing without a display (e.g. over SSH)
+type terminal struct {
+	mu  sync.Mutex // Both the input loop and the gm listener print
+	out io.Writer
+	bus *eventbus.Bus
+
+	isHost     bool
+	addresses  []string
+	rules      tablerules.Rules // Rules the host will send when play is pressed
+	playerInfo eventbus.PlayerInfo
+	pot        float64
+	hand       []string
+	board      []string
+}
+
+// Runner for the terminal, blocks until the player quits or input ends
+func Init(bus *eventbus.Bus) {
+	t := &terminal{out: os.Stdout, bus: bus, rules: tablerules.Default()}
+
+	// Listen for updates from GameManager, subscribing first so nothing sent after Init is missed
+	events := bus.SubscribeFrontEnd()
+	go t.gmListener(events)
+
+	// Init everything on the GM side
+	t.bus.Actions.Publish(eventbus.ActionType{Action: "Init"})
+
+	t.printf("Welcome to Goker! Type `help` for a list of commands.\n")
+	t.run(os.Stdin)
+}
+
+// Reads commands line by line and turns them into the same actions the GUI sends
+func (t *terminal) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "exit" {
+			return
+		}
+
+		action, err := t.parseCommand(fields)
+		if err != nil {
+			t.printf("%v\n", err)
+			continue
+		}
+		if action != nil {
+			t.bus.Actions.Publish(*action)
+		}
+	}
+}
+
+// Returns the action to send to the game manager, or nil if the command was handled here
+func (t *terminal) parseCommand(fields []string) (*eventbus.ActionType, error) {
+	args := fields[1:]
+
+	switch fields[0] {
+	case "help":
+		t.printf("%s", helpText)
+	case "host":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("usage: host <nickname>")
+		}
+		t.isHost = true
+		return &eventbus.ActionType{Action: "hostOrConnectPressed", DataS: []string{args[0]}}, nil
+	case "join":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("usage: join <nickname> <host address>")
+		}
+		return &eventbus.ActionType{Action: "hostOrConnectPressed", DataS: []string{args[0], args[1]}}, nil
+	case "address":
+		t.printf("Loopback address: %s\nLAN address: %s\n", t.address(0), t.address(1))
+	case "rules":
+		t.printf("%s\n", t.rules)
+	case "set":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("usage: set <rule> <value>")
+		}
+		if err := setRule(&t.rules, args[0], strings.Join(args[1:], " ")); err != nil {
+			return nil, err
+		}
+		t.printf("%s\n", t.rules)
+	case "play":
+		if !t.isHost {
+			return nil, fmt.Errorf("only the host can start the game")
+		}
+		if err := t.rules.Validate(); err != nil {
+			return nil, err
+		}
+		rules := t.rules
+		t.printf("Waiting for everyone to approve the rules...\n")
+		return &eventbus.ActionType{Action: "startRound", Rules: &rules}, nil
+	case "approve":
+		return &eventbus.ActionType{Action: "approveRules"}, nil
+	case "reject":
+		return &eventbus.ActionType{Action: "rejectRules"}, nil
+	case "raise":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("usage: raise <amount>")
+		}
+		amount, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount: %q", args[0])
+		}
+		return &eventbus.ActionType{Action: "Raise", DataF: amount}, nil
+	case "call":
+		return &eventbus.ActionType{Action: "Call"}, nil
+	case "check":
+		return &eventbus.ActionType{Action: "Check"}, nil
+	case "fold":
+		return &eventbus.ActionType{Action: "Fold"}, nil
+	case "table", "status":
+		t.printTable()
+	default:
+		return nil, fmt.Errorf("unknown command %q, type `help` for a list of commands", fields[0])
+	}
+	return nil, nil
+}
+
+// Since we want to keep logic out of the front end, this will listen for any updates from specific parts of the state
+func (t *terminal) gmListener(events *eventbus.FrontEnd) {
+	for {
+		select {
+		case hand := <-events.Hand.C():
+			t.mu.Lock()
+			t.hand = cardNames(hand)
+			t.mu.Unlock()
+			t.printf("Your hand: %s\n", strings.Join(t.hand, " "))
+		case board := <-events.Board.C():
+			t.mu.Lock()
+			t.board = cardNames(board)
+			t.mu.Unlock()
+		case pot := <-events.Pot.C():
+			t.mu.Lock()
+			t.pot = pot
+			t.mu.Unlock()
+		case numOfPlayers := <-events.NumOfPlayers.C():
+			t.printf("# of players: %d\n", numOfPlayers)
+		case addresses := <-events.Addresses.C():
+			t.mu.Lock()
+			t.addresses = addresses
+			t.mu.Unlock()
+		case playerInfo := <-events.PlayerInfo.C():
+			t.mu.Lock()
+			t.playerInfo = playerInfo
+			t.mu.Unlock()
+			t.printTable()
+		case <-events.StartRound.C():
+			t.printf("The round has started!\n")
+			t.printTable()
+		case <-events.EndRound.C():
+			t.printf("Back in the lobby.\n")
+		case <-events.ShowLoading.C():
+			t.printf("Dealing...\n")
+		case rules := <-events.TableRules.C():
+			t.printf("The host wants to play with these rules:\n%s\nType `approve` or `reject`.\n", rules)
+		case message := <-events.LobbyMessage.C():
+			t.printf("%s\n", message)
+		case accusation := <-events.Cheating.C():
+			t.printf("CHEATING: %s\n", accusation)
+		case abort := <-events.RoundAborted.C():
+			t.printf("ABORTED: %s, bets were refunded.\n", abort)
+		case progress := <-events.PuzzleProgress.C():
+			t.printf("%s\n", progress)
+		case status := <-events.Connection.C():
+			t.printf("%s\n", status)
+		case host := <-events.MoveToLobby.C():
+			if host {
+				t.printf("Hosting! Give others your address (type `address`), check the `rules`, then type `play`.\n")
+				t.printf("LAN address: %s\n", t.address(1))
+			} else {
+				t.printf("Connected! Waiting for host to begin game.\n")
+			}
+		}
+	}
+}
+
+// Prints the players, board, hand, and pot
+func (t *terminal) printTable() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info := t.playerInfo
+	var b strings.Builder
+	b.WriteString("----------------------------------------\n")
+	for i, nickname := range info.Players {
+		marker := "  "
+		if nickname == info.WhosTurn {
+			marker = "> "
+		}
+		title := nickname
+		if nickname == info.Dealer {
+			title += " (D)"
+		}
+		if nickname == info.Me {
+			title += " (you)"
+		}
+		fmt.Fprintf(&b, "%s%-24s $%.0f\n", marker, title, info.Money[i])
+	}
+	fmt.Fprintf(&b, "Board: %s\n", strings.Join(t.board, " "))
+	fmt.Fprintf(&b, "Hand:  %s\n", strings.Join(t.hand, " "))
+	fmt.Fprintf(&b, "Pot: $%.0f\n", t.pot)
+
+	if info.Me != "" && info.Me == info.WhosTurn {
+		b.WriteString("Your turn: fold")
+		if info.HighestBet != 0 {
+			fmt.Fprintf(&b, ", call $%.0f", info.HighestBet-info.MyBetsForThisPhase)
+		} else {
+			b.WriteString(", check")
+		}
+		if info.MaxRaise > 0 {
+			fmt.Fprintf(&b, ", raise $%.0f-$%.0f", info.MinRaise, info.MaxRaise)
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprint(t.out, b.String())
+}
+
+func (t *terminal) printf(format string, a ...any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.out, format, a...)
+}
+
+func (t *terminal) address(i int) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if i >= len(t.addresses) {
+		return "(not connected)"
+	}
+	return t.addresses[i]
+}
+
+// Card images are named after the card (e.g. hearts_ace.png), backs mean the card hasn't been revealed
+func cardNames(images []*canvas.Image) []string {
+	names := make([]string, 0, len(images))
+	for _, image := range images {
+		names = append(names, cardName(image.File))
+	}
+	return names
+}
+
+var rankNames = map[string]string{"ace": "A", "jack": "J", "queen": "Q", "king": "K"}
+var suitSymbols = map[string]string{"hearts": "♥", "diamonds": "♦", "clubs": "♣", "spades": "♠"}
+
+func cardName(file string) string {
+	if strings.Contains(file, "/backs/") {
+		return "[??]"
+	}
+
+	suit, rank, found := strings.Cut(strings.TrimSuffix(filepath.Base(file), ".png"), "_")
+	if !found {
+		return "[??]"
+	}
+	if short, ok := rankNames[rank]; ok {
+		rank = short
+	}
+	return "[" + rank + suitSymbols[suit] + "]"
+}
+
+// Updates a single table rule from the `set` command
+func setRule(rules *tablerules.Rules, name string, value string) error {
+	var err error
+	switch name {
+	case "cash":
+		rules.StartingCash, err = strconv.ParseFloat(value, 64)
+	case "sb":
+		rules.SmallBlind, err = strconv.ParseFloat(value, 64)
+	case "bb":
+		rules.BigBlind, err = strconv.ParseFloat(value, 64)
+	case "ante":
+		rules.Ante, err = strconv.ParseFloat(value, 64)
+	case "timer":
+		rules.TurnTimer, err = strconv.Atoi(value)
+	case "players":
+		rules.MaxPlayers, err = strconv.Atoi(value)
+	case "margin":
+		rules.TimeLockMargin, err = strconv.Atoi(value)
+	case "reconnect":
+		rules.ReconnectWindow, err = strconv.Atoi(value)
+	case "proofs":
+		rules.ShuffleProofRounds, err = strconv.Atoi(value)
+	case "primes":
+		rules.PrimeBits, err = strconv.Atoi(value)
+	case "cipher":
+		rules.Cipher = value
+	case "betting":
+		rules.BettingStructure = value
+	case "schedule": // Levels are separated by commas on the command line
+		rules.BlindSchedule, err = tablerules.ParseBlindSchedule(strings.ReplaceAll(value, ",", "\n"))
+	default:
+		return fmt.Errorf("unknown rule %q, type `help` for a list of rules", name)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", name, err)
+	}
+	return nil
+}
+
+const helpText = `Lobby:
+  host <nickname>               Host a new table
+  join <nickname> <address>     Join a table
+  address                       Show the addresses others can join with
+  rules                         Show the table rules
+  set <rule> <value>            Change a rule (host only) - cash, sb, bb, ante, timer, players, margin,
+                                reconnect, proofs, cipher (pohlig-hellman, secp256k1), primes (1024, 2048,
+                                3072), betting (no-limit, pot-limit, fixed-limit), schedule (e.g. "5 2 4, 10 5 10 1")
+  play                          Send the rules to everyone and start (host only)
+  approve / reject              Answer the hosts table rules
+Table:
+  table                         Show the table
+  raise <amount>                Raise by putting in the amount (including what you need to call)
+  call, check, fold
+  quit
+`
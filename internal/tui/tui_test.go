@@ -0,0 +1,62 @@
+package tui
+
+import (
+	"bytes"
+	"goker/internal/tablerules"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCardName(t *testing.T) {
+	require.Equal(t, "[A♥]", cardName("media/svg_playing_cards/fronts/png_96_dpi/hearts_ace.png"))
+	require.Equal(t, "[10♠]", cardName("media/svg_playing_cards/fronts/png_96_dpi/spades_10.png"))
+	require.Equal(t, "[??]", cardName("media/svg_playing_cards/backs/png_96_dpi/red.png"))
+}
+
+func TestSetRule(t *testing.T) {
+	rules := tablerules.Default()
+
+	require.NoError(t, setRule(&rules, "bb", "10"))
+	require.NoError(t, setRule(&rules, "betting", tablerules.FixedLimit))
+	require.NoError(t, setRule(&rules, "schedule", "5 2 4, 10 5 10 1"))
+	require.NoError(t, setRule(&rules, "primes", "1024"))
+	require.NoError(t, setRule(&rules, "cipher", tablerules.Secp256k1))
+	require.Equal(t, 10.0, rules.BigBlind)
+	require.Equal(t, tablerules.FixedLimit, rules.BettingStructure)
+	require.Len(t, rules.BlindSchedule, 2)
+	require.Equal(t, 1024, rules.PrimeBits)
+	require.Equal(t, tablerules.Secp256k1, rules.Cipher)
+
+	require.Error(t, setRule(&rules, "timer", "soon"))
+	require.Error(t, setRule(&rules, "colour", "red"))
+}
+
+func TestParseCommand(t *testing.T) {
+	term := &terminal{out: new(bytes.Buffer), rules: tablerules.Default()}
+
+	action, err := term.parseCommand([]string{"raise", "12"})
+	require.NoError(t, err)
+	require.Equal(t, "Raise", action.Action)
+	require.Equal(t, 12.0, action.DataF)
+
+	action, err = term.parseCommand([]string{"join", "bob", "/ip4/127.0.0.1/tcp/1234"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"bob", "/ip4/127.0.0.1/tcp/1234"}, action.DataS)
+
+	_, err = term.parseCommand([]string{"play"})
+	require.Error(t, err, "only the host can start")
+
+	action, err = term.parseCommand([]string{"host", "alice"})
+	require.NoError(t, err)
+	require.Equal(t, "hostOrConnectPressed", action.Action)
+
+	action, err = term.parseCommand([]string{"play"})
+	require.NoError(t, err)
+	require.Equal(t, "startRound", action.Action)
+	require.Equal(t, tablerules.Default(), *action.Rules)
+
+	action, err = term.parseCommand([]string{"table"})
+	require.NoError(t, err)
+	require.Nil(t, action)
+}
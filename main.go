@@ -1,8 +1,54 @@
 package main
 
-import "goker/internal/gamemanager"
+import (
+	"flag"
+	"goker/internal/eventbus"
+	"goker/internal/gamemanager"
+	"goker/internal/identity"
+	"goker/internal/tui"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Set by main_gui.go, builds with the headless tag leave out Fyne's window system completely
+var runGUI func(*eventbus.Bus)
+
+// Environment variable the keystore passphrase is read from
+const passphraseEnv = "GOKER_PASSPHRASE"
 
 func main() {
-	manager := new(gamemanager.GameManager)
-	manager.StartGame()
+	headless := flag.Bool("headless", false, "Play in the terminal instead of opening a window (e.g. over SSH)")
+	profile := flag.String("profile", defaultProfile(), "Directory holding your keystore and the players you've met, use a different one for each copy running on the same machine")
+	ephemeral := flag.Bool("ephemeral", false, "Play with a throwaway identity that nobody will recognize next time")
+	flag.Parse()
+
+	frontEnd := tui.Init
+	if !*headless && runGUI != nil {
+		frontEnd = runGUI
+	}
+
+	var id *identity.Identity
+	if !*ephemeral {
+		passphrase := os.Getenv(passphraseEnv)
+		if passphrase == "" {
+			log.Printf("%s is not set, your keystore is only protected by its file permissions", passphraseEnv)
+		}
+		var err error
+		if id, err = identity.Open(*profile, passphrase); err != nil {
+			log.Fatalf("Couldn't open your identity in %s: %v", *profile, err)
+		}
+	}
+
+	manager := gamemanager.New(eventbus.New(), id)
+	manager.StartGame(frontEnd)
+}
+
+// goker under the users config directory, or the working directory when there isn't one
+func defaultProfile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "goker-profile"
+	}
+	return filepath.Join(dir, "goker")
 }
@@ -0,0 +1,9 @@
+//go:build !headless
+
+package main
+
+import "goker/internal/gui"
+
+func init() {
+	runGUI = gui.Init
+}